@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"backend/context"
+	stdcontext "context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// APIトークンのスコープ
+// トークンは、ルートに設定されたスコープを持つ場合だけ使える
+const (
+	ScopeRequestsRead    = "requests:read"
+	ScopeSubmissionsRead = "submissions:read"
+	ScopeExport          = "export"
+)
+
+var Scopes = []string{ScopeRequestsRead, ScopeSubmissionsRead, ScopeExport}
+
+func IsValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// APIトークンの先頭に付ける文字列
+// ログやソースコードに紛れ込んだ場合に見つけやすくするため
+const apiTokenPrefix = "swt_"
+
+// 最終使用日時を更新する間隔
+// 使うたびに書き込むとDBの負荷になるので、この間隔より短い場合は更新しない
+const apiTokenTouchInterval = time.Minute
+
+// APIトークンを発行する
+// tokenは利用者に渡し、hashだけをDBに保存する
+func NewAPIToken() (token string, hash string, err error) {
+	s, err := newCSRFToken()
+	if err != nil {
+		return "", "", err
+	}
+	token = apiTokenPrefix + s
+	return token, hashToken(token), nil
+}
+
+type requiredScopeKey struct{}
+
+// ルートで必要なスコープをリクエストのcontextに設定する
+// 設定されていないルートでは、APIトークンでの認証は行わない
+func WithRequiredScope(ctx stdcontext.Context, scope string) stdcontext.Context {
+	return stdcontext.WithValue(ctx, requiredScopeKey{}, scope)
+}
+
+func requiredScope(r *http.Request) string {
+	scope, _ := r.Context().Value(requiredScopeKey{}).(string)
+	return scope
+}
+
+// AuthorizationヘッダーのBearerトークンを返す
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// APIトークンに対応するユーザーIDを返す
+// 失効済み、期限切れ、ルートのスコープを持たない場合はfalseを返す
+func getUserIDFromAPIToken(ctx *context.AppContext, r *http.Request, token string) (int, bool) {
+	scope := requiredScope(r)
+	if scope == "" || token == "" {
+		return -1, false
+	}
+
+	apiToken, err := ctx.GetDB().GetAPITokenByHash(ctx.Context(), hashToken(token))
+	if err != nil {
+		return -1, false
+	}
+
+	current := now()
+	if apiToken.RevokedAt != 0 || (apiToken.ExpiresAt != 0 && !current.Before(time.Unix(apiToken.ExpiresAt, 0))) {
+		return -1, false
+	}
+	if !slices.Contains(strings.Fields(apiToken.Scopes), scope) {
+		return -1, false
+	}
+
+	// 発行後に削除、または無効にされたユーザーのトークンは使えない
+	user, err := ctx.GetDB().GetUserByID(ctx.Context(), apiToken.UserID)
+	if err != nil || user.DeactivatedAt != 0 {
+		return -1, false
+	}
+
+	// 最終使用日時の更新に失敗しても、認証自体は成功させる
+	if current.Sub(time.Unix(apiToken.LastUsedAt, 0)) >= apiTokenTouchInterval {
+		ctx.GetDB().TouchAPIToken(ctx.Context(), apiToken.ID, current.Unix())
+	}
+	return apiToken.UserID, true
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"backend/db"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetUserIDWithAPIToken(t *testing.T) {
+	current := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	setNow(t, &current)
+
+	ctx := newTestContext(db.User{ID: 42, Role: RoleEmployee}, nil)
+	issue := func(scopes string, expiresAt int64) string {
+		token, hash, err := NewAPIToken()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ctx.GetDB().CreateAPIToken(ctx.Context(), db.APIToken{UserID: 42, CreatorID: 1, Name: "test", TokenHash: hash, Scopes: scopes, ExpiresAt: expiresAt})
+		return token
+	}
+	getUserID := func(token string, scope string) (int, bool) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if scope != "" {
+			req = req.WithContext(WithRequiredScope(req.Context(), scope))
+		}
+		return GetUserID(ctx, req)
+	}
+
+	token := issue(ScopeRequestsRead+" "+ScopeExport, 0)
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		t.Errorf("token should start with %q, got %q", apiTokenPrefix, token)
+	}
+
+	// --- 正常系: スコープを持つルート ---
+	if userID, ok := getUserID(token, ScopeRequestsRead); !ok || userID != 42 {
+		t.Errorf("want ok=true, userID=42, got ok=%v, userID=%v", ok, userID)
+	}
+	apiToken, _ := ctx.GetDB().GetAPITokenByHash(ctx.Context(), hashToken(token))
+	if apiToken.LastUsedAt != current.Unix() {
+		t.Errorf("last used should be updated, got %d", apiToken.LastUsedAt)
+	}
+
+	// --- 異常系: スコープを持たないルート、スコープが設定されていないルート ---
+	if _, ok := getUserID(token, ScopeSubmissionsRead); ok {
+		t.Errorf("token without the scope should be rejected")
+	}
+	if _, ok := getUserID(token, ""); ok {
+		t.Errorf("token should be rejected on a route without scope")
+	}
+
+	// --- 異常系: 不明なトークン ---
+	if _, ok := getUserID("swt_unknown", ScopeRequestsRead); ok {
+		t.Errorf("unknown token should be rejected")
+	}
+
+	// --- 異常系: 期限切れ ---
+	expiring := issue(ScopeRequestsRead, current.Add(time.Hour).Unix())
+	if _, ok := getUserID(expiring, ScopeRequestsRead); !ok {
+		t.Errorf("token should be valid before expiry")
+	}
+	current = current.Add(time.Hour)
+	if _, ok := getUserID(expiring, ScopeRequestsRead); ok {
+		t.Errorf("expired token should be rejected")
+	}
+
+	// --- 異常系: 失効済み ---
+	ctx.GetDB().RevokeAPIToken(ctx.Context(), apiToken.ID, current.Unix())
+	if _, ok := getUserID(token, ScopeRequestsRead); ok {
+		t.Errorf("revoked token should be rejected")
+	}
+}
@@ -3,38 +3,140 @@ package auth
 import (
 	"backend/context"
 	"backend/db"
+	"backend/metrics"
 	"errors"
 	"net/http"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 // TODO: sessionをメモリに保存する
 
-func Login(ctx *context.AppContext, w http.ResponseWriter, r *http.Request, loginID string, password string) error {
+// セッションにユーザーのsession_versionを保存するキー
+// パスワード変更でDBの値が増えると、それ以前のセッションは無効になる
+const sessionVersionKey = "session_version"
+
+// ログインセッションの有効期間の既定値
+const DefaultSessionLifetime = 3 * time.Hour
+
+var sessionLifetime = DefaultSessionLifetime
+
+// ログインセッションの有効期間を変更する
+// サーバー起動時に呼び出す
+func SetSessionLifetime(lifetime time.Duration) {
+	sessionLifetime = lifetime
+}
+
+// ログインに成功した場合はユーザーIDを返す
+// 失敗が続いている場合は*ThrottledErrorを返す
+// 二要素認証が必要な場合はユーザーIDと*SecondFactorRequiredErrorを返す。VerifySecondFactorでログインを完了する
+// パスワードが一致しない場合はErrIncorrectAuthを返す。ログインIDのユーザーがいる場合は、監査ログのためにそのIDも返す
+func Login(ctx *context.AppContext, w http.ResponseWriter, r *http.Request, loginID string, password string) (int, error) {
+	// 失敗が続いているIPアドレス、ログインIDからの試行は受け付けない
+	if err := checkThrottle(ctx, loginID); err != nil {
+		return -1, err
+	}
+
 	// login_idとpasswordを比較
-	user, err := ctx.GetDB().GetUserByLoginID(loginID)
+	user, err := ctx.GetDB().GetUserByLoginID(ctx.Context(), loginID)
 	if err != nil {
 		if errors.Is(err, db.ErrUserNotFound) {
-			return ErrIncorrectAuth
+			return -1, failLogin(ctx, loginID)
 		}
-		return err
+		return -1, err
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return ErrIncorrectAuth
+	// 無効なユーザーは存在しないユーザーと同じ扱いにする
+	if !ComparePassword(user.Password, password) || user.DeactivatedAt != 0 {
+		return user.ID, failLogin(ctx, loginID)
+	}
+
+	// 成功したらログインIDの失敗回数をリセット
+	// IPアドレスの失敗回数は、別のアカウントへの試行を続けられないようにリセットしない
+	if err := Unlock(ctx, loginID); err != nil {
+		return -1, err
 	}
 
-	// 成功時はセッションを作成し、Cookieに保存
+	if err := startLoginSession(ctx, w, r, user); err != nil {
+		return user.ID, err
+	}
+	return user.ID, nil
+}
+
+// 一段階目の認証に成功したユーザーのセッションを作成する
+// 二要素認証が必要な場合は、二段階目の認証が終わるまでログイン済みにせず、SecondFactorRequiredErrorを返す
+func startLoginSession(ctx *context.AppContext, w http.ResponseWriter, r *http.Request, user db.User) error {
+	_, err := getEnabledTOTP(ctx, user.ID)
+	if err != nil && !errors.Is(err, ErrTOTPNotEnrolled) {
+		return err
+	}
+	enrolled := err == nil
+	if enrolled || requiresTOTP(user) {
+		if err := startPendingLogin(ctx, w, r, user.ID); err != nil {
+			return err
+		}
+		return &SecondFactorRequiredError{Enrolled: enrolled}
+	}
+
+	return saveLoginSession(ctx, w, r, user)
+}
+
+// セッションを作成し、Cookieに保存
+func saveLoginSession(ctx *context.AppContext, w http.ResponseWriter, r *http.Request, user db.User) error {
 	session, _ := ctx.GetSessionStore().Get(r, "login_session")
 	if session == nil {
 		return errors.New("session is nil")
 	}
 
+	// ログインごとにCSRFトークンを発行し直す
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	delete(session.Values, pendingUserIDKey)
+	delete(session.Values, pendingAtKey)
 	session.Values["user_id"] = user.ID
-	session.Options.MaxAge = int((time.Hour * 3).Seconds())
-	return session.Save(r, w)
+	session.Values[sessionVersionKey] = user.SessionVersion
+	session.Values[csrfTokenKey] = csrfToken
+	expiresAt := now().Add(sessionLifetime)
+	session.Values[sessionExpiresAtKey] = expiresAt.Unix()
+	session.Options.MaxAge = int(sessionLifetime.Seconds())
+	if err := session.Save(r, w); err != nil {
+		return err
+	}
+	activeSessions.touch(csrfToken, expiresAt)
+	metrics.Logins.Inc("success")
+
+	// アクセスログに出すために記録する
+	if info := context.RequestInfoFrom(r.Context()); info != nil {
+		info.UserID = user.ID
+	}
+	return nil
+}
+
+// ログイン済みのユーザーにパスワードを入力し直させる場合に確認する
+// 総当たりを防ぐため、Loginと同じくログインIDごとの失敗回数で制限する
+// 一致しない場合はErrIncorrectAuth、制限中の場合は*ThrottledErrorを返す
+func VerifyPassword(ctx *context.AppContext, user db.User, password string) error {
+	if err := checkThrottle(ctx, user.LoginID); err != nil {
+		return err
+	}
+	if !ComparePassword(user.Password, password) {
+		if err := recordFailure(ctx, user.LoginID); err != nil {
+			return err
+		}
+		return ErrIncorrectAuth
+	}
+	return Unlock(ctx, user.LoginID)
+}
+
+// ログインの失敗を記録してErrIncorrectAuthを返す
+func failLogin(ctx *context.AppContext, loginID string) error {
+	metrics.Logins.Inc("failure")
+	if err := recordFailure(ctx, loginID); err != nil {
+		return err
+	}
+	return ErrIncorrectAuth
 }
 
 func Logout(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) error {
@@ -45,52 +147,65 @@ func Logout(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) err
 	if session == nil {
 		return errors.New("session is nil")
 	}
+	sessionID, _ := session.Values[csrfTokenKey].(string)
+	activeSessions.remove(sessionID)
 	session.Options.MaxAge = -1
 	return session.Save(r, w)
 }
 
 // get user id from session.
 // return false if user is not logged in or invalid cookie.
+// パスワード変更前に作られたセッションも無効として扱う
+// Authorization: Bearer ヘッダーがある場合は、セッションではなくAPIトークンで認証する
 func GetUserID(ctx *context.AppContext, r *http.Request) (int, bool) {
+	userID, ok := getUserID(ctx, r)
+	// アクセスログに出すために記録する
+	if info := context.RequestInfoFrom(r.Context()); ok && info != nil {
+		info.UserID = userID
+	}
+	return userID, ok
+}
+
+func getUserID(ctx *context.AppContext, r *http.Request) (int, bool) {
+	if token, ok := bearerToken(r); ok {
+		return getUserIDFromAPIToken(ctx, r, token)
+	}
+
 	session, _ := ctx.GetSessionStore().Get(r, "login_session")
 	if session == nil || session.IsNew {
 		return -1, false
 	}
 
-	userID, ok := session.Values["user_id"]
+	userID, ok := session.Values["user_id"].(int)
 	if !ok {
 		return -1, false
 	}
 
-	return userID.(int), true
+	// session_versionを持たないセッションは、パスワードを一度も変更していないユーザーのものとみなす
+	version, _ := session.Values[sessionVersionKey].(int)
+	sessionID, _ := session.Values[csrfTokenKey].(string)
+	user, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil || user.SessionVersion != version || user.DeactivatedAt != 0 {
+		activeSessions.remove(sessionID)
+		return -1, false
+	}
+
+	// expires_atを持たないセッションは、有効期限を最大とみなす
+	expiresAt := now().Add(sessionLifetime)
+	if v, ok := session.Values[sessionExpiresAtKey].(int64); ok {
+		expiresAt = time.Unix(v, 0)
+	}
+	activeSessions.touch(sessionID, expiresAt)
+
+	return userID, true
 }
 
 // 権限ビット定数
+// 各ロールが持つ権限はpermission.goで定義する
 const (
 	RoleEmployee = 1 << iota
 	RoleManager
+	RoleShiftLeader
+	// 全ての組織(店舗)を管理する
+	RoleAdmin
 )
-
-// check if user is employee
-func IsEmployee(ctx *context.AppContext, userID int) (bool, error) {
-	user, err := ctx.GetDB().GetUserByID(userID)
-	if err != nil {
-		if err == db.ErrUserNotFound {
-			return false, nil
-		}
-		return false, err
-	}
-	return (user.Role & RoleEmployee) != 0, nil
-}
-
-// check if user is manager
-func IsManager(ctx *context.AppContext, userID int) (bool, error) {
-	user, err := ctx.GetDB().GetUserByID(userID)
-	if err != nil {
-		if err == db.ErrUserNotFound {
-			return false, nil
-		}
-		return false, err
-	}
-	return (user.Role & RoleManager) != 0, nil
-}
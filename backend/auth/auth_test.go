@@ -3,8 +3,12 @@ package auth
 import (
 	"backend/context"
 	"backend/db"
+	"backend/metrics"
+	"errors"
+	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/bcrypt"
@@ -12,7 +16,7 @@ import (
 
 func TestGetUserID(t *testing.T) {
 	store := sessions.NewCookieStore([]byte("test-secret"))
-	ctx := context.NewAppContext(nil, store)
+	ctx := newTestContext(db.User{ID: 42, Role: RoleEmployee}, store)
 
 	// --- 正常系: セッションにuser_idが入っている場合 ---
 	req := httptest.NewRequest("GET", "/", nil)
@@ -49,42 +53,6 @@ func newTestContext(user db.User, store *sessions.CookieStore) *context.AppConte
 	return context.NewAppContext(db.NewMockDB(nil, []db.User{user}, nil, nil), store)
 }
 
-func TestIsEmployee(t *testing.T) {
-	// 従業員権限を持つユーザー
-	user := db.User{ID: 10, Role: RoleEmployee}
-	ctx := newTestContext(user, nil)
-	ok, err := IsEmployee(ctx, 10)
-	if err != nil || !ok {
-		t.Errorf("want employee, got ok=%v, err=%v", ok, err)
-	}
-
-	// 権限なしユーザー
-	user2 := db.User{ID: 11, Role: 0}
-	ctx2 := newTestContext(user2, nil)
-	ok2, err2 := IsEmployee(ctx2, 11)
-	if err2 != nil || ok2 {
-		t.Errorf("want not employee, got ok=%v, err=%v", ok2, err2)
-	}
-}
-
-func TestIsManager(t *testing.T) {
-	// マネージャー権限を持つユーザー
-	user := db.User{ID: 20, Role: RoleManager}
-	ctx := newTestContext(user, nil)
-	ok, err := IsManager(ctx, 20)
-	if err != nil || !ok {
-		t.Errorf("want manager, got ok=%v, err=%v", ok, err)
-	}
-
-	// 権限なしユーザー
-	user2 := db.User{ID: 21, Role: 0}
-	ctx2 := newTestContext(user2, nil)
-	ok2, err2 := IsManager(ctx2, 21)
-	if err2 != nil || ok2 {
-		t.Errorf("want not manager, got ok=%v, err=%v", ok2, err2)
-	}
-}
-
 func TestLogout(t *testing.T) {
 	store := sessions.NewCookieStore([]byte("test-secret"))
 	ctx := context.NewAppContext(nil, store)
@@ -126,10 +94,13 @@ func TestLogin(t *testing.T) {
 	// --- 正常系: 正しいloginIDとpassword ---
 	req := httptest.NewRequest("POST", "/login", nil)
 	rr := httptest.NewRecorder()
-	err := Login(ctx, rr, req, "testuser", "pass123")
+	loggedInID, err := Login(ctx, rr, req, "testuser", "pass123")
 	if err != nil {
 		t.Errorf("Login returned error: %v", err)
 	}
+	if loggedInID != 42 {
+		t.Errorf("Login should return user id 42, got %v", loggedInID)
+	}
 
 	// セッションにuser_idがセットされているか確認
 	req2 := httptest.NewRequest("GET", "/", nil)
@@ -144,15 +115,130 @@ func TestLogin(t *testing.T) {
 
 	// --- 異常系: loginIDが存在しない ---
 	rr2 := httptest.NewRecorder()
-	err2 := Login(ctx, rr2, req, "notfound", "pass123")
+	_, err2 := Login(ctx, rr2, req, "notfound", "pass123")
 	if err2 == nil {
 		t.Errorf("want error for invalid loginID, got nil")
 	}
 
 	// --- 異常系: パスワードが間違っている ---
 	rr3 := httptest.NewRecorder()
-	err3 := Login(ctx, rr3, req, "testuser", "wrongpass")
+	_, err3 := Login(ctx, rr3, req, "testuser", "wrongpass")
 	if err3 == nil {
 		t.Errorf("want error for wrong password, got nil")
 	}
 }
+
+func TestGetUserIDAfterPasswordChange(t *testing.T) {
+	store := sessions.NewCookieStore([]byte("test-secret"))
+	ctx := newTestContext(db.User{ID: 42, Role: RoleEmployee}, store)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	session, _ := store.Get(req, "login_session")
+	session.Values["user_id"] = 42
+	session.Values[sessionVersionKey] = 0
+	rr := httptest.NewRecorder()
+	session.Save(req, rr)
+
+	req2 := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		req2.AddCookie(cookie)
+	}
+
+	// パスワードを変更すると、それ以前のセッションは無効になる
+	ctx.GetDB().UpdateUserPassword(ctx.Context(), 42, "new-hash")
+	if _, ok := GetUserID(ctx, req2); ok {
+		t.Errorf("session before password change should be invalid")
+	}
+
+	// RenewSessionで更新したセッションは引き続き使える
+	rr2 := httptest.NewRecorder()
+	if err := RenewSession(ctx, rr2, req2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req3 := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range rr2.Result().Cookies() {
+		req3.AddCookie(cookie)
+	}
+	if userID, ok := GetUserID(ctx, req3); !ok || userID != 42 {
+		t.Errorf("want ok=true, userID=42, got ok=%v, userID=%v", ok, userID)
+	}
+}
+
+func TestDeactivatedUser(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("pass123"), bcrypt.DefaultCost)
+	store := sessions.NewCookieStore([]byte("test-secret"))
+	ctx := newTestContext(db.User{ID: 42, LoginID: "testuser", Password: string(hashedPassword), Role: RoleEmployee}, store)
+
+	rr := httptest.NewRecorder()
+	if _, err := Login(ctx, rr, httptest.NewRequest("POST", "/login", nil), "testuser", "pass123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	if _, ok := GetUserID(ctx, req); !ok {
+		t.Fatalf("session should be valid before deactivation")
+	}
+
+	// 無効にすると、既存のセッションは使えず、正しいパスワードでもログインできない
+	ctx.GetDB().DeactivateUser(ctx.Context(), 42, 1)
+	if _, ok := GetUserID(ctx, req); ok {
+		t.Errorf("session of deactivated user should be invalid")
+	}
+	if _, err := Login(ctx, httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil), "testuser", "pass123"); !errors.Is(err, ErrIncorrectAuth) {
+		t.Errorf("want ErrIncorrectAuth for deactivated user, got %v", err)
+	}
+}
+
+func TestActiveSessionCount(t *testing.T) {
+	current := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	setNow(t, &current)
+	activeSessions = &sessionTracker{expiresAt: make(map[string]time.Time)}
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("pass123"), bcrypt.MinCost)
+	store := sessions.NewCookieStore([]byte("test-secret"))
+	ctx := newTestContext(db.User{ID: 42, LoginID: "testuser", Password: string(hashedPassword), Role: RoleEmployee}, store)
+
+	login := func() *http.Request {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		if _, err := Login(ctx, rr, httptest.NewRequest("POST", "/login", nil), "testuser", "pass123"); err != nil {
+			t.Fatalf("Login returned error: %v", err)
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	// ログインの成功と失敗を数える
+	successes, failures := metrics.Logins.Value("success"), metrics.Logins.Value("failure")
+	req := login()
+	login()
+	Login(ctx, httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil), "testuser", "wrong")
+	if got := metrics.Logins.Value("success") - successes; got != 2 {
+		t.Errorf("want 2 successful logins, got %v", got)
+	}
+	if got := metrics.Logins.Value("failure") - failures; got != 1 {
+		t.Errorf("want 1 failed login, got %v", got)
+	}
+	if got := ActiveSessionCount(); got != 2 {
+		t.Errorf("want 2 active sessions, got %d", got)
+	}
+
+	// ログアウトしたセッションは数えない
+	if err := Logout(ctx, httptest.NewRecorder(), req); err != nil {
+		t.Fatalf("Logout returned error: %v", err)
+	}
+	if got := ActiveSessionCount(); got != 1 {
+		t.Errorf("want 1 active session, got %d", got)
+	}
+
+	// 有効期限が切れたセッションは数えない
+	current = current.Add(sessionLifetime)
+	if got := ActiveSessionCount(); got != 0 {
+		t.Errorf("want no active sessions, got %d", got)
+	}
+}
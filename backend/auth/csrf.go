@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"backend/context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+)
+
+// CSRFトークンを受け取るリクエストヘッダー
+const CSRFTokenHeader = "X-CSRF-Token"
+
+// セッションにCSRFトークンを保存するキー
+const csrfTokenKey = "csrf_token"
+
+var ErrInvalidCSRFToken = errors.New("invalid csrf token")
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ログイン中、または二段階目の認証待ちのセッションのCSRFトークンを返す
+// トークンを持たない古いセッションの場合は新しく発行して保存する
+func GetCSRFToken(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) (string, error) {
+	session, _ := ctx.GetSessionStore().Get(r, "login_session")
+	if session == nil {
+		return "", errors.New("session not found")
+	}
+
+	// ログイン処理中のリクエストでは、作成したばかりのセッションのトークンを返す
+	if token, ok := session.Values[csrfTokenKey].(string); ok && token != "" {
+		return token, nil
+	}
+	if session.IsNew {
+		return "", errors.New("session not found")
+	}
+
+	token, err := newCSRFToken()
+	if err != nil {
+		return "", err
+	}
+	session.Values[csrfTokenKey] = token
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// リクエストヘッダーのCSRFトークンがセッションのものと一致するか確認する
+// セッションCookieを持たないリクエストはCookieによる認証が行われないので検証しない
+func ValidateCSRFToken(ctx *context.AppContext, r *http.Request) error {
+	session, _ := ctx.GetSessionStore().Get(r, "login_session")
+	if session == nil || session.IsNew {
+		return nil
+	}
+
+	token, ok := session.Values[csrfTokenKey].(string)
+	if !ok || token == "" {
+		return ErrInvalidCSRFToken
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(r.Header.Get(CSRFTokenHeader))) != 1 {
+		return ErrInvalidCSRFToken
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+package auth
+
+import (
+	"backend/context"
+	"backend/db"
+	stdcontext "context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenID Connectでログインするための設定
+type OIDCConfig struct {
+	// IDプロバイダーのIssuer URL。{Issuer}/.well-known/openid-configuration から設定を取得する
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// IDプロバイダーに登録したコールバックURL(/api/auth/oidc/callback)
+	RedirectURL string
+	// ログイン後にリダイレクトするフロントエンドのURL
+	PostLoginURL string
+	// 二要素認証が必要な場合にリダイレクトするフロントエンドのURL。クエリにmfa=pendingを付ける
+	SecondFactorURL string
+	// 省略時は openid, email, profile
+	Scopes []string
+	// 省略時はタイムアウト10秒のクライアントを使う
+	HTTPClient *http.Client
+}
+
+// IDプロバイダーのエンドポイントと署名鍵を保持する
+type OIDCProvider struct {
+	config                OIDCConfig
+	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+var (
+	ErrOIDCNotConfigured = errors.New("oidc is not configured")
+	ErrInvalidOIDCState  = errors.New("invalid oidc state")
+	ErrOIDCDenied        = errors.New("oidc authorization denied")
+	ErrInvalidIDToken    = errors.New("invalid id token")
+	ErrOIDCUserNotFound  = errors.New("no user for oidc identity")
+)
+
+// IDトークンの有効期限などの比較で許容する時刻のずれ
+const oidcClockSkew = time.Minute
+
+// ログイン開始からコールバックまでの状態を保存するセッション
+const (
+	oidcSessionName = "oidc_session"
+	oidcMaxAge      = 10 * 60
+)
+
+var oidcProvider *OIDCProvider
+
+// OIDCでのログインを有効にする
+// サーバー起動時に呼び出す。nilの場合は無効
+func SetOIDCProvider(provider *OIDCProvider) {
+	oidcProvider = provider
+}
+
+func OIDCEnabled() bool {
+	return oidcProvider != nil
+}
+
+// ログイン後にリダイレクトするURL
+func OIDCPostLoginURL() string {
+	if oidcProvider == nil {
+		return ""
+	}
+	return oidcProvider.config.PostLoginURL
+}
+
+// 二要素認証が必要な場合にリダイレクトするURL
+func OIDCSecondFactorURL() string {
+	if oidcProvider == nil {
+		return ""
+	}
+	return oidcProvider.config.SecondFactorURL + "?mfa=pending"
+}
+
+// IDプロバイダーの設定を取得してOIDCProviderを作成する
+func NewOIDCProvider(ctx stdcontext.Context, config OIDCConfig) (*OIDCProvider, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc issuer, client id and redirect url are required")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	p := &OIDCProvider{config: config}
+
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, &discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	// なりすましを防ぐため、設定したIssuerと一致しなければいけない(OpenID Connect Discovery 4.3)
+	if discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch: %q", discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+
+	p.authorizationEndpoint = discovery.AuthorizationEndpoint
+	p.tokenEndpoint = discovery.TokenEndpoint
+	p.jwksURI = discovery.JWKSURI
+	return p, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx stdcontext.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func randomURLToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ログインを開始し、IDプロバイダーの認可エンドポイントのURLを返す
+// state, nonce, PKCEのcode_verifierはコールバックで検証するためセッションに保存する
+func StartOIDCLogin(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) (string, error) {
+	p := oidcProvider
+	if p == nil {
+		return "", ErrOIDCNotConfigured
+	}
+
+	state, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomURLToken()
+	if err != nil {
+		return "", err
+	}
+
+	session, _ := ctx.GetSessionStore().Get(r, oidcSessionName)
+	if session == nil {
+		return "", errors.New("session is nil")
+	}
+	session.Values["state"] = state
+	session.Values["nonce"] = nonce
+	session.Values["verifier"] = verifier
+	session.Options.MaxAge = oidcMaxAge
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(p.authorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// IDプロバイダーからのコールバックを検証し、対応するユーザーでログインする
+// 作成するセッションはLoginと同じ。二要素認証が必要なユーザーはSecondFactorRequiredErrorを返し、二段階目の認証待ちにする
+func FinishOIDCLogin(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	p := oidcProvider
+	if p == nil {
+		return -1, ErrOIDCNotConfigured
+	}
+
+	session, _ := ctx.GetSessionStore().Get(r, oidcSessionName)
+	if session == nil {
+		return -1, errors.New("session is nil")
+	}
+	state, _ := session.Values["state"].(string)
+	nonce, _ := session.Values["nonce"].(string)
+	verifier, _ := session.Values["verifier"].(string)
+
+	// 同じstateを使い回せないように、結果に関わらずセッションを削除する
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		return -1, err
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		return -1, fmt.Errorf("%w: %s", ErrOIDCDenied, errCode)
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		return -1, ErrInvalidOIDCState
+	}
+
+	rawIDToken, err := p.exchangeCode(ctx.Context(), query.Get("code"), verifier)
+	if err != nil {
+		return -1, err
+	}
+	claims, err := p.verifyIDToken(ctx.Context(), rawIDToken, nonce)
+	if err != nil {
+		return -1, err
+	}
+
+	user, err := findOIDCUser(ctx, claims)
+	if err != nil {
+		return -1, err
+	}
+
+	if err := startLoginSession(ctx, w, r, user); err != nil {
+		return user.ID, err
+	}
+	return user.ID, nil
+}
+
+// 認可コードをIDトークンと交換する
+func (p *OIDCProvider) exchangeCode(ctx stdcontext.Context, code string, verifier string) (string, error) {
+	if code == "" {
+		return "", fmt.Errorf("%w: missing code", ErrInvalidOIDCState)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.ClientSecret != "" {
+		// RFC 6749 2.3.1 client_secret_basic
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	res, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		err := fmt.Errorf("oidc token endpoint: status %d: %s", res.StatusCode, body)
+		// 使用済みや期限切れの認可コード(invalid_grant)は、ログインをやり直せばよい
+		if res.StatusCode == http.StatusBadRequest {
+			return "", fmt.Errorf("%w: %w", ErrOIDCDenied, err)
+		}
+		return "", err
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.IDToken == "" {
+		return "", fmt.Errorf("%w: missing id_token", ErrInvalidIDToken)
+	}
+	return token.IDToken, nil
+}
+
+// IDトークンのクレームのうち、使うもの
+type idTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+}
+
+// audは文字列と文字列の配列のどちらの場合もある
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// IDトークン(RS256で署名されたJWT)の署名とクレームを検証する
+// OpenID Connect Core 3.1.3.7
+func (p *OIDCProvider) verifyIDToken(ctx stdcontext.Context, rawIDToken string, nonce string) (idTokenClaims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return idTokenClaims{}, fmt.Errorf("%w: malformed jwt", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return idTokenClaims{}, err
+	}
+	// alg=noneやHMACへのすり替えを防ぐため、RS256以外は受け付けない
+	if header.Alg != "RS256" {
+		return idTokenClaims{}, fmt.Errorf("%w: unsupported alg %q", ErrInvalidIDToken, header.Alg)
+	}
+
+	key, err := p.publicKey(ctx, header.Kid)
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return idTokenClaims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	var claims idTokenClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return idTokenClaims{}, err
+	}
+
+	current := now()
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return idTokenClaims{}, fmt.Errorf("%w: issuer mismatch", ErrInvalidIDToken)
+	case !slices.Contains(claims.Audience, p.config.ClientID):
+		return idTokenClaims{}, fmt.Errorf("%w: audience mismatch", ErrInvalidIDToken)
+	case current.After(time.Unix(claims.Expiry, 0).Add(oidcClockSkew)):
+		return idTokenClaims{}, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case time.Unix(claims.IssuedAt, 0).After(current.Add(oidcClockSkew)):
+		return idTokenClaims{}, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return idTokenClaims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return idTokenClaims{}, fmt.Errorf("%w: missing sub", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	return nil
+}
+
+// kidに対応する公開鍵を返す
+// 鍵のローテーションに対応するため、知らないkidの場合はJWKSを取得し直す
+func (p *OIDCProvider) publicKey(ctx stdcontext.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown kid %q", ErrInvalidIDToken, kid)
+	}
+	return key, nil
+}
+
+// IDプロバイダーのアカウントに対応するユーザーを探す
+// 紐づけがない場合は、確認済みのメールアドレスが一致するユーザーに紐づける
+// ユーザーは自動で作成しない. 無効なユーザーは見つからないものとして扱う
+func findOIDCUser(ctx *context.AppContext, claims idTokenClaims) (db.User, error) {
+	user, err := ctx.GetDB().GetUserByIdentity(ctx.Context(), claims.Issuer, claims.Subject)
+	if err == nil {
+		if user.DeactivatedAt != 0 {
+			return db.User{}, ErrOIDCUserNotFound
+		}
+		return user, nil
+	}
+	if !errors.Is(err, db.ErrUserNotFound) {
+		return db.User{}, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return db.User{}, ErrOIDCUserNotFound
+	}
+	user, err = ctx.GetDB().GetUserByEmail(ctx.Context(), claims.Email)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return db.User{}, ErrOIDCUserNotFound
+	}
+	if err != nil {
+		return db.User{}, err
+	}
+	if user.DeactivatedAt != 0 {
+		return db.User{}, ErrOIDCUserNotFound
+	}
+
+	if err := ctx.GetDB().CreateUserIdentity(ctx.Context(), claims.Issuer, claims.Subject, user.ID); err != nil {
+		return db.User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"backend/context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// パスワードの条件
+type PasswordPolicy struct {
+	MinLength int
+	// bcryptは72バイトを超える部分を無視するので、それ以下にする
+	MaxLength     int
+	RequireLetter bool
+	RequireDigit  bool
+
+	// パスワードリセット用トークンの有効期限
+	ResetTokenTTL time.Duration
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:     8,
+	MaxLength:     72,
+	RequireLetter: true,
+	RequireDigit:  true,
+	ResetTokenTTL: 24 * time.Hour,
+}
+
+var passwordPolicy = DefaultPasswordPolicy
+
+// パスワードの条件を変更する
+// サーバー起動時に呼び出す
+func SetPasswordPolicy(policy PasswordPolicy) {
+	passwordPolicy = policy
+}
+
+// パスワードが条件を満たさない場合のエラー
+// Violationsには満たしていない条件を利用者向けの文言で入れる
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not satisfy the policy: " + strings.Join(e.Violations, ", ")
+}
+
+// パスワードが条件を満たすか確認する
+// 満たさない場合は*PasswordPolicyErrorを返す
+func ValidatePassword(password string) error {
+	var violations []string
+
+	if len([]rune(password)) < passwordPolicy.MinLength {
+		violations = append(violations, "パスワードが短すぎます")
+	}
+	if passwordPolicy.MaxLength > 0 && len(password) > passwordPolicy.MaxLength {
+		violations = append(violations, "パスワードが長すぎます")
+	}
+
+	var hasLetter, hasDigit bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLetter(c):
+			hasLetter = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+	if passwordPolicy.RequireLetter && !hasLetter {
+		violations = append(violations, "英字を含めてください")
+	}
+	if passwordPolicy.RequireDigit && !hasDigit {
+		violations = append(violations, "数字を含めてください")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// パスワードをDBに保存する形式に変換する
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// パスワードがハッシュ値と一致するか確認する
+func ComparePassword(hash string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// パスワードリセット用のトークンを発行する
+// tokenは利用者に渡し、hashだけをDBに保存する
+func NewResetToken() (token string, hash string, expiresAt time.Time, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", time.Time{}, err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashResetToken(token), now().Add(passwordPolicy.ResetTokenTTL), nil
+}
+
+// パスワードリセット用トークンのハッシュ値を返す
+func HashResetToken(token string) string {
+	return hashToken(token)
+}
+
+// 十分な長さの乱数から作ったトークンは総当たりできないので、bcryptではなくSHA-256で足りる
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// パスワード変更後、現在のセッションだけを引き続き使えるようにする
+// 他のセッションはsession_versionが合わなくなり無効になる
+func RenewSession(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) error {
+	session, _ := ctx.GetSessionStore().Get(r, "login_session")
+	if session == nil || session.IsNew {
+		return errors.New("session not found")
+	}
+
+	userID, ok := session.Values["user_id"].(int)
+	if !ok {
+		return errors.New("session has no user_id")
+	}
+	user, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	// 念のためCSRFトークンも発行し直す
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	session.Values[sessionVersionKey] = user.SessionVersion
+	session.Values[csrfTokenKey] = csrfToken
+	return session.Save(r, w)
+}
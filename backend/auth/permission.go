@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"backend/context"
+	"backend/db"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 操作ごとの権限
+// ロールは権限の集合で、ユーザーは複数のロールを持てる
+type Permission string
+
+const (
+	PermissionRequestCreate    Permission = "request.create"
+	PermissionSubmissionCreate Permission = "submission.create"
+	// 他のユーザーの提出内容も閲覧できる
+	PermissionSubmissionViewAll Permission = "submission.view_all"
+	// 未提出者を含む、シフトリクエストごとの提出状況を閲覧できる
+	PermissionSubmissionViewStatus Permission = "submission.view_status"
+	// 他のユーザーの提出を変更できる
+	PermissionSubmissionEditAll Permission = "submission.edit_all"
+	// ロックの解除、パスワードリセット用トークンの発行
+	PermissionUserManage     Permission = "user.manage"
+	PermissionAuditView      Permission = "audit.view"
+	PermissionAPITokenManage Permission = "api_token.manage"
+	// 組織内のグループの作成、メンバーの変更
+	PermissionGroupManage Permission = "group.manage"
+	// Webhookの登録、削除、送信状況の閲覧
+	PermissionWebhookManage Permission = "webhook.manage"
+	// 組織(店舗)の作成、所属の変更。他の組織の情報も扱える
+	PermissionOrganizationManage Permission = "organization.manage"
+)
+
+var Permissions = []Permission{
+	PermissionRequestCreate,
+	PermissionSubmissionCreate,
+	PermissionSubmissionViewAll,
+	PermissionSubmissionViewStatus,
+	PermissionSubmissionEditAll,
+	PermissionUserManage,
+	PermissionAuditView,
+	PermissionAPITokenManage,
+	PermissionGroupManage,
+	PermissionWebhookManage,
+	PermissionOrganizationManage,
+}
+
+// ロールの定義
+// Bitはusers.roleのビット、Nameは画面やAPIで使う名前
+type RoleDefinition struct {
+	Bit         int
+	Name        string
+	Permissions []Permission
+}
+
+// シフト表は全員で共有するので、従業員も他のユーザーの提出内容を閲覧できる
+var DefaultRoles = []RoleDefinition{
+	{
+		Bit:         RoleEmployee,
+		Name:        "employee",
+		Permissions: []Permission{PermissionSubmissionCreate, PermissionSubmissionViewAll},
+	},
+	{
+		Bit:  RoleManager,
+		Name: "manager",
+		Permissions: []Permission{
+			PermissionRequestCreate,
+			PermissionSubmissionViewAll,
+			PermissionSubmissionViewStatus,
+			PermissionSubmissionEditAll,
+			PermissionUserManage,
+			PermissionAuditView,
+			PermissionAPITokenManage,
+			PermissionGroupManage,
+			PermissionWebhookManage,
+		},
+	},
+	{
+		Bit:         RoleShiftLeader,
+		Name:        "shift_leader",
+		Permissions: []Permission{PermissionRequestCreate, PermissionSubmissionCreate, PermissionSubmissionViewAll, PermissionSubmissionViewStatus, PermissionSubmissionEditAll},
+	},
+	{
+		Bit:         RoleAdmin,
+		Name:        "admin",
+		Permissions: []Permission{PermissionOrganizationManage},
+	},
+}
+
+var roles = DefaultRoles
+
+// ロールの定義を変更する
+// サーバー起動時に呼び出す
+func SetRoles(definitions []RoleDefinition) {
+	roles = definitions
+}
+
+// ロールの権限を変更したロールの定義を返す
+// specは "ロール名=権限,権限;ロール名=権限" の形式。指定しなかったロールは変更しない
+func ParseRolePermissions(base []RoleDefinition, spec string) ([]RoleDefinition, error) {
+	definitions := slices.Clone(base)
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid role permissions: %q", item)
+		}
+		i := slices.IndexFunc(definitions, func(d RoleDefinition) bool { return d.Name == strings.TrimSpace(name) })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown role: %q", name)
+		}
+
+		permissions := []Permission{}
+		for _, p := range strings.Split(value, ",") {
+			permission := Permission(strings.TrimSpace(p))
+			if permission == "" {
+				continue
+			}
+			if !slices.Contains(Permissions, permission) {
+				return nil, fmt.Errorf("unknown permission: %q", permission)
+			}
+			permissions = append(permissions, permission)
+		}
+		definitions[i].Permissions = permissions
+	}
+	return definitions, nil
+}
+
+// ロールのビットが権限を持つか確認する
+// いずれかのロールが権限を持っていればよい
+func HasPermission(role int, permission Permission) bool {
+	for _, definition := range roles {
+		if role&definition.Bit != 0 && slices.Contains(definition.Permissions, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// ロールのビットに対応するロール名を返す
+func RoleNames(role int) []string {
+	names := []string{}
+	for _, definition := range roles {
+		if role&definition.Bit != 0 {
+			names = append(names, definition.Name)
+		}
+	}
+	return names
+}
+
+// ロール名に対応するロールのビットを返す. RoleNamesの逆
+func RoleBits(names []string) (int, error) {
+	role := 0
+	for _, name := range names {
+		i := slices.IndexFunc(roles, func(d RoleDefinition) bool { return d.Name == name })
+		if i < 0 {
+			return 0, fmt.Errorf("unknown role: %q", name)
+		}
+		role |= roles[i].Bit
+	}
+	return role, nil
+}
+
+// ロールのビットが持つ権限を返す
+func PermissionsOf(role int) []Permission {
+	permissions := []Permission{}
+	for _, permission := range Permissions {
+		if HasPermission(role, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+// 自分自身に対する操作の権限. 他のユーザーを管理できるかの比較には含めない
+var selfServicePermissions = []Permission{PermissionSubmissionCreate}
+
+// actorRoleのユーザーがtargetRoleのユーザーを管理できるか確認する
+// 自分自身に対する操作を除いて、targetRoleの権限をすべて持つ場合に管理できる
+func CanManageRole(actorRole int, targetRole int) bool {
+	for _, permission := range PermissionsOf(targetRole) {
+		if !slices.Contains(selfServicePermissions, permission) && !HasPermission(actorRole, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// ユーザーが権限を持つか確認する
+// 存在しないユーザーは権限を持たないものとして扱う
+func Can(ctx *context.AppContext, userID int, permission Permission) (bool, error) {
+	user, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return HasPermission(user.Role, permission), nil
+}
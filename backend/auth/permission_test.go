@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"backend/db"
+	"slices"
+	"testing"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		role       int
+		permission Permission
+		want       bool
+	}{
+		{RoleEmployee, PermissionSubmissionCreate, true},
+		{RoleEmployee, PermissionRequestCreate, false},
+		{RoleManager, PermissionRequestCreate, true},
+		{RoleManager, PermissionSubmissionCreate, false},
+		// 複数のロールを持つ場合は、いずれかのロールが権限を持っていればよい
+		{RoleEmployee | RoleManager, PermissionSubmissionCreate, true},
+		{RoleEmployee | RoleManager, PermissionUserManage, true},
+		{RoleShiftLeader, PermissionRequestCreate, true},
+		{RoleShiftLeader, PermissionUserManage, false},
+		{0, PermissionSubmissionViewAll, false},
+	}
+	for _, test := range tests {
+		if got := HasPermission(test.role, test.permission); got != test.want {
+			t.Errorf("role=%b permission=%s: want %v, got %v", test.role, test.permission, test.want, got)
+		}
+	}
+
+	if got := RoleNames(RoleEmployee | RoleShiftLeader); !slices.Equal(got, []string{"employee", "shift_leader"}) {
+		t.Errorf("unexpected role names: %v", got)
+	}
+	if got, err := RoleBits([]string{"employee", "shift_leader"}); err != nil || got != RoleEmployee|RoleShiftLeader {
+		t.Errorf("unexpected role bits: %b, %v", got, err)
+	}
+	if _, err := RoleBits([]string{"owner"}); err == nil {
+		t.Errorf("want error for unknown role")
+	}
+}
+
+func TestCanManageRole(t *testing.T) {
+	tests := []struct {
+		actor  int
+		target int
+		want   bool
+	}{
+		// 自分の提出に関する権限は比較しない
+		{RoleManager, RoleEmployee, true},
+		{RoleManager, RoleManager, true},
+		{RoleManager, RoleShiftLeader, true},
+		{RoleManager, RoleAdmin, false},
+		{RoleManager, RoleManager | RoleAdmin, false},
+		{RoleManager | RoleAdmin, RoleAdmin, true},
+		{RoleShiftLeader, RoleManager, false},
+	}
+	for _, test := range tests {
+		if got := CanManageRole(test.actor, test.target); got != test.want {
+			t.Errorf("actor=%b target=%b: want %v, got %v", test.actor, test.target, test.want, got)
+		}
+	}
+}
+
+func TestParseRolePermissions(t *testing.T) {
+	definitions, err := ParseRolePermissions(DefaultRoles, "shift_leader=submission.create, submission.view_all; employee=submission.create")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	SetRoles(definitions)
+	t.Cleanup(func() { SetRoles(DefaultRoles) })
+
+	if HasPermission(RoleShiftLeader, PermissionRequestCreate) || !HasPermission(RoleShiftLeader, PermissionSubmissionViewAll) {
+		t.Errorf("shift leader permissions should be replaced: %v", PermissionsOf(RoleShiftLeader))
+	}
+	if HasPermission(RoleEmployee, PermissionSubmissionViewAll) {
+		t.Errorf("employee permissions should be replaced: %v", PermissionsOf(RoleEmployee))
+	}
+	// 指定しなかったロールと元の定義は変更しない
+	if !HasPermission(RoleManager, PermissionUserManage) || !slices.Contains(DefaultRoles[0].Permissions, PermissionSubmissionViewAll) {
+		t.Errorf("other definitions should not be changed")
+	}
+
+	for _, spec := range []string{"owner=request.create", "employee=request.delete", "employee"} {
+		if _, err := ParseRolePermissions(DefaultRoles, spec); err == nil {
+			t.Errorf("want error for %q", spec)
+		}
+	}
+}
+
+func TestCan(t *testing.T) {
+	ctx := newTestContext(db.User{ID: 10, Role: RoleShiftLeader}, nil)
+
+	if ok, err := Can(ctx, 10, PermissionRequestCreate); err != nil || !ok {
+		t.Errorf("want allowed, got ok=%v, err=%v", ok, err)
+	}
+	if ok, err := Can(ctx, 10, PermissionAuditView); err != nil || ok {
+		t.Errorf("want not allowed, got ok=%v, err=%v", ok, err)
+	}
+	// 存在しないユーザーは権限を持たない
+	if ok, err := Can(ctx, 999, PermissionRequestCreate); err != nil || ok {
+		t.Errorf("want not allowed for unknown user, got ok=%v, err=%v", ok, err)
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// セッションに有効期限を保存するキー
+const sessionExpiresAtKey = "expires_at"
+
+// 有効期限内のログインセッションを数える
+// セッションはCookieにだけ保存しているので、このプロセスでログインまたは認証に使われたセッションだけを数える
+// セッションはログインごとに発行し直すCSRFトークンで識別する
+type sessionTracker struct {
+	mu        sync.Mutex
+	expiresAt map[string]time.Time
+}
+
+var activeSessions = &sessionTracker{expiresAt: make(map[string]time.Time)}
+
+func (t *sessionTracker) touch(id string, expiresAt time.Time) {
+	if id == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expiresAt[id] = expiresAt
+}
+
+func (t *sessionTracker) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.expiresAt, id)
+}
+
+// 有効期限内のセッションの数を返す。期限切れのセッションは忘れる
+func (t *sessionTracker) count(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, expiresAt := range t.expiresAt {
+		if !now.Before(expiresAt) {
+			delete(t.expiresAt, id)
+		}
+	}
+	return len(t.expiresAt)
+}
+
+// 有効期限内のログインセッションの数
+// サーバーの起動後に使われていないセッションは含まない
+func ActiveSessionCount() int {
+	return activeSessions.count(now())
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"backend/context"
+	"backend/db"
+	"backend/metrics"
+	"errors"
+	"time"
+)
+
+// ログイン試行の制限方法
+type ThrottlePolicy struct {
+	// 同じログインIDでの失敗がこの回数に達すると、次の試行まで待ち時間を設ける
+	BackoffAfter int
+	// 同じIPアドレスからの失敗がこの回数に達すると、次の試行まで待ち時間を設ける
+	// 同じネットワークから複数の利用者がログインするので、BackoffAfterより多くする
+	IPBackoffAfter int
+	// 最初の待ち時間。以降は失敗のたびに2倍になる
+	BaseDelay time.Duration
+	// 待ち時間の上限
+	MaxDelay time.Duration
+
+	// 同じログインIDでの失敗がこの回数に達するとアカウントをロックする
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+
+	// 最後の失敗からこの時間が経つと失敗回数をリセットする
+	FailureWindow time.Duration
+}
+
+var DefaultThrottlePolicy = ThrottlePolicy{
+	BackoffAfter:     3,
+	IPBackoffAfter:   30,
+	BaseDelay:        time.Second,
+	MaxDelay:         5 * time.Minute,
+	LockoutThreshold: 10,
+	LockoutDuration:  30 * time.Minute,
+	FailureWindow:    time.Hour,
+}
+
+var throttlePolicy = DefaultThrottlePolicy
+
+// ログイン試行の制限方法を変更する
+// サーバー起動時に呼び出す
+func SetThrottlePolicy(policy ThrottlePolicy) {
+	throttlePolicy = policy
+}
+
+// テストで時刻を差し替えるため
+var now = time.Now
+
+// ログイン試行が制限されている場合のエラー
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return "too many login attempts, retry after " + e.RetryAfter.String()
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func loginIDThrottleKey(loginID string) string {
+	return "login_id:" + loginID
+}
+
+// IPアドレスとログインIDのどちらかが制限中であれば*ThrottledErrorを返す
+func checkThrottle(ctx *context.AppContext, loginID string) error {
+	var retryAfter time.Duration
+	for _, key := range []string{ipThrottleKey(ctx.ClientIP()), loginIDThrottleKey(loginID)} {
+		throttle, err := ctx.GetDB().GetLoginThrottle(ctx.Context(), key)
+		if errors.Is(err, db.ErrThrottleNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		wait := time.Unix(throttle.BlockedUntil, 0).Sub(now())
+		if wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		metrics.Logins.Inc("throttled")
+		return &ThrottledError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// ログインの失敗を記録し、次の試行までの待ち時間を設定する
+func recordFailure(ctx *context.AppContext, loginID string) error {
+	if err := recordFailureForKey(ctx, ipThrottleKey(ctx.ClientIP()), throttlePolicy.IPBackoffAfter, false); err != nil {
+		return err
+	}
+	return recordFailureForKey(ctx, loginIDThrottleKey(loginID), throttlePolicy.BackoffAfter, true)
+}
+
+// 失敗回数はDBで増やし、増やした後の回数で待ち時間を決める
+// 同時に失敗した場合でも、それぞれ異なる回数になる
+func recordFailureForKey(ctx *context.AppContext, key string, backoffAfter int, lockout bool) error {
+	current := now()
+	failures, err := ctx.GetDB().IncrementLoginFailures(ctx.Context(), key, current.Unix(), current.Add(-throttlePolicy.FailureWindow).Unix())
+	if err != nil {
+		return err
+	}
+
+	delay := throttleDelay(failures, backoffAfter, lockout)
+	if delay == 0 {
+		return nil
+	}
+	return ctx.GetDB().ExtendLoginBlock(ctx.Context(), key, current.Add(delay).Unix())
+}
+
+// 失敗回数に応じた待ち時間を返す
+// backoffAfter回目の失敗から待ち時間を設ける
+func throttleDelay(failures int, backoffAfter int, lockout bool) time.Duration {
+	if lockout && failures >= throttlePolicy.LockoutThreshold {
+		return throttlePolicy.LockoutDuration
+	}
+	if failures < backoffAfter {
+		return 0
+	}
+
+	delay := throttlePolicy.BaseDelay
+	for i := backoffAfter; i < failures && delay < throttlePolicy.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, throttlePolicy.MaxDelay)
+}
+
+// ログインIDのロックと失敗回数を解除する
+func Unlock(ctx *context.AppContext, loginID string) error {
+	return ctx.GetDB().DeleteLoginThrottle(ctx.Context(), loginIDThrottleKey(loginID))
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"backend/db"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 時刻を固定し、テスト終了時に元に戻す
+func setNow(t *testing.T, current *time.Time) {
+	t.Helper()
+	now = func() time.Time { return *current }
+	t.Cleanup(func() { now = time.Now })
+}
+
+func TestThrottleDelay(t *testing.T) {
+	tests := []struct {
+		failures     int
+		backoffAfter int
+		lockout      bool
+		want         time.Duration
+	}{
+		{1, 3, true, 0},
+		{2, 3, true, 0},
+		{3, 3, true, time.Second},
+		{4, 3, true, 2 * time.Second},
+		{5, 3, false, 4 * time.Second},
+		{9, 3, true, 64 * time.Second},
+		{10, 3, true, 30 * time.Minute},
+		{10, 3, false, 128 * time.Second},
+		{20, 3, false, 5 * time.Minute},
+		// IPアドレスの制限は、より多くの失敗から始まる
+		{10, 30, false, 0},
+		{30, 30, false, time.Second},
+		{31, 30, false, 2 * time.Second},
+	}
+	for _, test := range tests {
+		got := throttleDelay(test.failures, test.backoffAfter, test.lockout)
+		if got != test.want {
+			t.Errorf("failures=%d backoffAfter=%d lockout=%v: want %v, got %v", test.failures, test.backoffAfter, test.lockout, test.want, got)
+		}
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("pass123"), bcrypt.MinCost)
+	user := db.User{ID: 42, LoginID: "testuser", Password: string(hashedPassword)}
+	store := sessions.NewCookieStore([]byte("test-secret"))
+
+	current := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	setNow(t, &current)
+
+	ctx := newTestContext(user, store).WithClientIP("192.0.2.1")
+	tryLogin := func(loginID string, password string) error {
+		_, err := Login(ctx, httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil), loginID, password)
+		return err
+	}
+
+	// 2回までは待ち時間なし
+	for i := 0; i < 2; i++ {
+		if err := tryLogin("testuser", "wrong"); !errors.Is(err, ErrIncorrectAuth) {
+			t.Fatalf("want ErrIncorrectAuth, got %v", err)
+		}
+	}
+
+	// 3回目の失敗で1秒待つ必要がある
+	if err := tryLogin("testuser", "wrong"); !errors.Is(err, ErrIncorrectAuth) {
+		t.Fatalf("want ErrIncorrectAuth, got %v", err)
+	}
+	var throttledErr *ThrottledError
+	if err := tryLogin("testuser", "pass123"); !errors.As(err, &throttledErr) || throttledErr.RetryAfter != time.Second {
+		t.Fatalf("want ThrottledError with 1s, got %v", err)
+	}
+
+	// 同じIPアドレスからでも、IPアドレスの上限に達するまでは別のログインIDは制限されない
+	if err := tryLogin("other", "pass123"); !errors.Is(err, ErrIncorrectAuth) {
+		t.Fatalf("want ErrIncorrectAuth for other login id, got %v", err)
+	}
+
+	// 待ち時間が過ぎればログインできる
+	current = current.Add(time.Second)
+	if err := tryLogin("testuser", "pass123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// ログインIDの失敗回数はリセットされる
+	if _, err := ctx.GetDB().GetLoginThrottle(ctx.Context(), "login_id:testuser"); !errors.Is(err, db.ErrThrottleNotFound) {
+		t.Errorf("login_id throttle should be reset, got %v", err)
+	}
+
+	// 別のIPアドレスから失敗を続けるとアカウントがロックされる
+	for i := 0; i < DefaultThrottlePolicy.LockoutThreshold; i++ {
+		ctx = ctx.WithClientIP(fmt.Sprintf("203.0.113.%d", i))
+		tryLogin("testuser", "wrong")
+		current = current.Add(10 * time.Minute)
+	}
+	ctx = ctx.WithClientIP("198.51.100.1")
+	if err := tryLogin("testuser", "pass123"); !errors.As(err, &throttledErr) || throttledErr.RetryAfter != 20*time.Minute {
+		t.Fatalf("want ThrottledError with 20m, got %v", err)
+	}
+
+	// ロックを解除すればログインできる
+	if err := Unlock(ctx, "testuser"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tryLogin("testuser", "pass123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 同じIPアドレスからの失敗がIPアドレスの上限に達すると、別のログインIDも制限される
+	ctx = ctx.WithClientIP("192.0.2.2")
+	for i := 0; i < DefaultThrottlePolicy.IPBackoffAfter; i++ {
+		tryLogin(fmt.Sprintf("user%d", i), "wrong")
+	}
+	if err := tryLogin("testuser", "pass123"); !errors.As(err, &throttledErr) || throttledErr.RetryAfter != time.Second {
+		t.Fatalf("want ThrottledError for same IP, got %v", err)
+	}
+	current = current.Add(time.Second)
+
+	// 最後の失敗から時間が経つと失敗回数はリセットされる
+	ctx = ctx.WithClientIP("192.0.2.1")
+	current = current.Add(DefaultThrottlePolicy.FailureWindow + time.Second)
+	if err := tryLogin("testuser", "wrong"); !errors.Is(err, ErrIncorrectAuth) {
+		t.Fatalf("want ErrIncorrectAuth, got %v", err)
+	}
+	throttle, _ := ctx.GetDB().GetLoginThrottle(ctx.Context(), "ip:192.0.2.1")
+	if throttle.Failures != 1 {
+		t.Errorf("ip failures should be reset to 1, got %d", throttle.Failures)
+	}
+}
@@ -0,0 +1,415 @@
+package auth
+
+import (
+	"backend/context"
+	"backend/db"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 二要素認証(TOTP, RFC 6238)の設定
+type TwoFactorPolicy struct {
+	// trueの場合、マネージャーは二要素認証を登録しないとログインできない
+	RequireForManager bool
+	// 認証アプリに表示される発行者名
+	Issuer string
+	// パスワード認証から二段階目の認証までの制限時間
+	PendingTimeout time.Duration
+	// 一度に発行するリカバリーコードの数
+	RecoveryCodeCount int
+}
+
+var DefaultTwoFactorPolicy = TwoFactorPolicy{
+	RequireForManager: false,
+	Issuer:            "shift_webapp",
+	PendingTimeout:    5 * time.Minute,
+	RecoveryCodeCount: 10,
+}
+
+var twoFactorPolicy = DefaultTwoFactorPolicy
+
+// 二要素認証の設定を変更する
+// サーバー起動時に呼び出す
+func SetTwoFactorPolicy(policy TwoFactorPolicy) {
+	twoFactorPolicy = policy
+}
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+	ErrTOTPNotEnrolled    = errors.New("totp not enrolled")
+	ErrTOTPRequired       = errors.New("totp is required by policy")
+	ErrInvalidTOTPCode    = errors.New("invalid totp code")
+	ErrNoPendingLogin     = errors.New("no pending login")
+)
+
+// パスワード認証には成功したが、二段階目の認証が必要な場合のエラー
+// Enrolledがfalseの場合は、先に二要素認証を登録する必要がある
+type SecondFactorRequiredError struct {
+	Enrolled bool
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return "second factor required"
+}
+
+// セッションに二段階目の認証待ちのユーザーを保存するキー
+const (
+	pendingUserIDKey = "pending_user_id"
+	pendingAtKey     = "pending_at"
+)
+
+// RFC 6238の既定値。多くの認証アプリはこれ以外に対応していない
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// 端末の時刻のずれを考慮して、前後何ステップまで受け付けるか
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// 認証アプリに登録するためのURI
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func totpURI(secret string, accountName string) string {
+	label := url.PathEscape(twoFactorPolicy.Issuer + ":" + accountName)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", twoFactorPolicy.Issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// 時間ステップに対応するワンタイムパスワードを計算する
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// RFC 4226 5.3 Dynamic Truncation
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range totpDigits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, bin%mod), nil
+}
+
+// コードを検証し、一致した時間ステップを返す
+// 同じコードの再利用を防ぐため、lastUsedStep以前のステップは受け付けない
+func verifyTOTP(secret string, code string, lastUsedStep int64) (int64, bool) {
+	current := now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		want, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// 登録が完了した二要素認証の設定を返す
+// 登録していない場合はErrTOTPNotEnrolledを返す
+func getEnabledTOTP(ctx *context.AppContext, userID int) (db.UserTOTP, error) {
+	totp, err := ctx.GetDB().GetUserTOTP(ctx.Context(), userID)
+	if errors.Is(err, db.ErrTOTPNotFound) || (err == nil && totp.ConfirmedAt == 0) {
+		return db.UserTOTP{}, ErrTOTPNotEnrolled
+	}
+	return totp, err
+}
+
+// ユーザーが二要素認証を使わなければいけないか
+func requiresTOTP(user db.User) bool {
+	return twoFactorPolicy.RequireForManager && (user.Role&RoleManager) != 0
+}
+
+// ワンタイムパスワードかリカバリーコードを検証する
+// リカバリーコードは一度使うと使えなくなる
+func verifySecondFactor(ctx *context.AppContext, totp db.UserTOTP, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		step, ok := verifyTOTP(totp.Secret, code, totp.LastUsedStep)
+		if !ok {
+			return false, nil
+		}
+		totp.LastUsedStep = step
+		return true, ctx.GetDB().SaveUserTOTP(ctx.Context(), totp)
+	}
+
+	err := ctx.GetDB().UseRecoveryCode(ctx.Context(), totp.UserID, hashRecoveryCode(code), now().Unix())
+	if errors.Is(err, db.ErrRecoveryCodeNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// パスワード認証に成功したユーザーを、二段階目の認証待ちとしてセッションに保存する
+// 二要素認証の登録に使うため、CSRFトークンも発行する
+func startPendingLogin(ctx *context.AppContext, w http.ResponseWriter, r *http.Request, userID int) error {
+	session, _ := ctx.GetSessionStore().Get(r, "login_session")
+	if session == nil {
+		return errors.New("session is nil")
+	}
+
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	delete(session.Values, "user_id")
+	session.Values[pendingUserIDKey] = userID
+	session.Values[pendingAtKey] = now().Unix()
+	session.Values[csrfTokenKey] = csrfToken
+	session.Options.MaxAge = int(twoFactorPolicy.PendingTimeout.Seconds())
+	return session.Save(r, w)
+}
+
+// 二段階目の認証待ちのユーザーIDを返す
+// 制限時間を過ぎている場合はfalseを返す
+func GetPendingUserID(ctx *context.AppContext, r *http.Request) (int, bool) {
+	session, _ := ctx.GetSessionStore().Get(r, "login_session")
+	if session == nil || session.IsNew {
+		return -1, false
+	}
+
+	userID, ok := session.Values[pendingUserIDKey].(int)
+	if !ok {
+		return -1, false
+	}
+	pendingAt, _ := session.Values[pendingAtKey].(int64)
+	if now().Sub(time.Unix(pendingAt, 0)) > twoFactorPolicy.PendingTimeout {
+		return -1, false
+	}
+	return userID, true
+}
+
+// 二段階目の認証待ちのユーザーが、二要素認証を登録済みかどうかを返す
+// OIDCでのログインのように、リダイレクトの後で次の手順を決める場合に使う
+func PendingLoginEnrolled(ctx *context.AppContext, r *http.Request) (bool, error) {
+	userID, ok := GetPendingUserID(ctx, r)
+	if !ok {
+		return false, ErrNoPendingLogin
+	}
+
+	_, err := getEnabledTOTP(ctx, userID)
+	if errors.Is(err, ErrTOTPNotEnrolled) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 二段階目の認証を行い、成功したらログインを完了する
+// codeにはワンタイムパスワードかリカバリーコードを指定する
+func VerifySecondFactor(ctx *context.AppContext, w http.ResponseWriter, r *http.Request, code string) (int, error) {
+	userID, ok := GetPendingUserID(ctx, r)
+	if !ok {
+		return -1, ErrNoPendingLogin
+	}
+
+	user, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return -1, err
+	}
+
+	// パスワードと同じく、失敗が続いている場合は受け付けない
+	if err := checkThrottle(ctx, user.LoginID); err != nil {
+		return -1, err
+	}
+
+	totp, err := getEnabledTOTP(ctx, user.ID)
+	if err != nil {
+		return -1, err
+	}
+
+	ok, err = verifySecondFactor(ctx, totp, code)
+	if err != nil {
+		return -1, err
+	}
+	if !ok {
+		return -1, failLogin(ctx, user.LoginID)
+	}
+
+	if err := Unlock(ctx, user.LoginID); err != nil {
+		return -1, err
+	}
+	if err := saveLoginSession(ctx, w, r, user); err != nil {
+		return -1, err
+	}
+	return user.ID, nil
+}
+
+// 二要素認証の登録を完了した認証待ちのユーザーをログイン済みにする
+func CompletePendingLogin(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) (int, error) {
+	userID, ok := GetPendingUserID(ctx, r)
+	if !ok {
+		return -1, ErrNoPendingLogin
+	}
+
+	user, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return -1, err
+	}
+	if _, err := getEnabledTOTP(ctx, user.ID); err != nil {
+		return -1, err
+	}
+
+	if err := saveLoginSession(ctx, w, r, user); err != nil {
+		return -1, err
+	}
+	return user.ID, nil
+}
+
+// 二要素認証の登録を開始し、シークレットと認証アプリ用のURIを返す
+// 登録を完了するまでは有効にならない
+func BeginTOTPEnrollment(ctx *context.AppContext, userID int) (secret string, uri string, err error) {
+	user, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := getEnabledTOTP(ctx, userID); err == nil {
+		return "", "", ErrTOTPAlreadyEnabled
+	} else if !errors.Is(err, ErrTOTPNotEnrolled) {
+		return "", "", err
+	}
+
+	secret, err = newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := ctx.GetDB().SaveUserTOTP(ctx.Context(), db.UserTOTP{UserID: userID, Secret: secret}); err != nil {
+		return "", "", err
+	}
+	return secret, totpURI(secret, user.LoginID), nil
+}
+
+// 認証アプリに表示されたコードを確認して二要素認証の登録を完了し、リカバリーコードを返す
+func ConfirmTOTPEnrollment(ctx *context.AppContext, userID int, code string) ([]string, error) {
+	totp, err := ctx.GetDB().GetUserTOTP(ctx.Context(), userID)
+	if errors.Is(err, db.ErrTOTPNotFound) {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if totp.ConfirmedAt != 0 {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	step, ok := verifyTOTP(totp.Secret, strings.TrimSpace(code), totp.LastUsedStep)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	totp.ConfirmedAt = now().Unix()
+	totp.LastUsedStep = step
+	if err := ctx.GetDB().SaveUserTOTP(ctx.Context(), totp); err != nil {
+		return nil, err
+	}
+	return replaceRecoveryCodes(ctx, userID)
+}
+
+// ワンタイムパスワードかリカバリーコードを確認して、リカバリーコードを発行し直す
+func RegenerateRecoveryCodes(ctx *context.AppContext, userID int, code string) ([]string, error) {
+	totp, err := getEnabledTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := verifySecondFactor(ctx, totp, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	return replaceRecoveryCodes(ctx, userID)
+}
+
+// ワンタイムパスワードかリカバリーコードを確認して、二要素認証を解除する
+// 二要素認証が必須のユーザーは解除できない
+func DisableTOTP(ctx *context.AppContext, userID int, code string) error {
+	user, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if requiresTOTP(user) {
+		return ErrTOTPRequired
+	}
+
+	totp, err := getEnabledTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	ok, err := verifySecondFactor(ctx, totp, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+	return ctx.GetDB().DeleteUserTOTP(ctx.Context(), userID)
+}
+
+// リカバリーコードを発行してハッシュ値だけを保存する
+// 発行したコードはこの時にしか取得できない
+func replaceRecoveryCodes(ctx *context.AppContext, userID int) ([]string, error) {
+	codes := make([]string, twoFactorPolicy.RecoveryCodeCount)
+	hashes := make([]string, len(codes))
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		// 読み間違いを減らすため、4文字ずつ区切る
+		s := totpEncoding.EncodeToString(b)
+		codes[i] = s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := ctx.GetDB().ReplaceRecoveryCodes(ctx.Context(), userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// 区切り文字と大文字小文字の違いを無視してハッシュ値を計算する
+func hashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
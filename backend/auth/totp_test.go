@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"backend/db"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 Appendix B のテストベクター(SHA1)の下6桁
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, test := range tests {
+		got, err := totpCode(secret, test.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != test.want {
+			t.Errorf("T=%d: want %s, got %s", test.unix, test.want, got)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	current := time.Unix(1234567890, 0)
+	setNow(t, &current)
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	step := current.Unix() / totpPeriod
+
+	code, _ := totpCode(secret, step)
+	if got, ok := verifyTOTP(secret, code, 0); !ok || got != step {
+		t.Errorf("want step %d, got %d ok=%v", step, got, ok)
+	}
+
+	// 前後1ステップまでは受け付ける
+	prev, _ := totpCode(secret, step-1)
+	if _, ok := verifyTOTP(secret, prev, 0); !ok {
+		t.Errorf("previous step should be accepted")
+	}
+	old, _ := totpCode(secret, step-2)
+	if _, ok := verifyTOTP(secret, old, 0); ok {
+		t.Errorf("code 2 steps ago should be rejected")
+	}
+
+	// 使用済みのステップは受け付けない
+	if _, ok := verifyTOTP(secret, code, step); ok {
+		t.Errorf("used step should be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("SECRET", "manager1")
+	if !strings.HasPrefix(uri, "otpauth://totp/shift_webapp:manager1?") || !strings.Contains(uri, "secret=SECRET") {
+		t.Errorf("unexpected uri: %s", uri)
+	}
+}
+
+func TestLoginWithTOTP(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("pass123"), bcrypt.MinCost)
+	user := db.User{ID: 42, LoginID: "testuser", Password: string(hashedPassword), Role: RoleManager}
+	store := sessions.NewCookieStore([]byte("test-secret"))
+	ctx := newTestContext(user, store)
+
+	current := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	setNow(t, &current)
+
+	// 前のレスポンスのCookieを付けたリクエストを作る
+	withCookies := func(w *httptest.ResponseRecorder) *http.Request {
+		req := httptest.NewRequest("POST", "/", nil)
+		for _, cookie := range w.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	// 登録する
+	secret, _, err := BeginTOTPEnrollment(ctx, 42)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	code, _ := totpCode(secret, current.Unix()/totpPeriod)
+	if _, err := ConfirmTOTPEnrollment(ctx, 42, "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("want ErrInvalidTOTPCode, got %v", err)
+	}
+	recoveryCodes, err := ConfirmTOTPEnrollment(ctx, 42, code)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recoveryCodes) != DefaultTwoFactorPolicy.RecoveryCodeCount {
+		t.Errorf("want %d recovery codes, got %d", DefaultTwoFactorPolicy.RecoveryCodeCount, len(recoveryCodes))
+	}
+	if _, _, err := BeginTOTPEnrollment(ctx, 42); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Errorf("want ErrTOTPAlreadyEnabled, got %v", err)
+	}
+
+	// パスワードだけではログイン済みにならない
+	w := httptest.NewRecorder()
+	_, err = Login(ctx, w, httptest.NewRequest("POST", "/login", nil), "testuser", "pass123")
+	var secondFactorErr *SecondFactorRequiredError
+	if !errors.As(err, &secondFactorErr) || !secondFactorErr.Enrolled {
+		t.Fatalf("want SecondFactorRequiredError, got %v", err)
+	}
+	if _, ok := GetUserID(ctx, withCookies(w)); ok {
+		t.Errorf("user should not be logged in before second factor")
+	}
+
+	// 登録時に使ったコードは再利用できない
+	if _, err := VerifySecondFactor(ctx, httptest.NewRecorder(), withCookies(w), code); !errors.Is(err, ErrIncorrectAuth) {
+		t.Errorf("want ErrIncorrectAuth for reused code, got %v", err)
+	}
+
+	// リカバリーコードでログインできる。区切り文字と大文字小文字は問わない
+	recoveryCode := strings.ToLower(strings.ReplaceAll(recoveryCodes[0], "-", ""))
+	w2 := httptest.NewRecorder()
+	if userID, err := VerifySecondFactor(ctx, w2, withCookies(w), recoveryCode); err != nil || userID != 42 {
+		t.Fatalf("want userID=42, got %d, err=%v", userID, err)
+	}
+	if userID, ok := GetUserID(ctx, withCookies(w2)); !ok || userID != 42 {
+		t.Errorf("want logged in, got ok=%v, userID=%v", ok, userID)
+	}
+
+	// 使用済みのリカバリーコードは使えない
+	if _, err := VerifySecondFactor(ctx, httptest.NewRecorder(), withCookies(w), recoveryCodes[0]); !errors.Is(err, ErrIncorrectAuth) {
+		t.Errorf("want ErrIncorrectAuth for used recovery code, got %v", err)
+	}
+
+	// 次のステップのコードでログインできる
+	current = current.Add(totpPeriod * time.Second)
+	code2, _ := totpCode(secret, current.Unix()/totpPeriod)
+	if _, err := VerifySecondFactor(ctx, httptest.NewRecorder(), withCookies(w), code2); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// 制限時間を過ぎると、パスワードからやり直す
+	current = current.Add(DefaultTwoFactorPolicy.PendingTimeout)
+	if _, err := VerifySecondFactor(ctx, httptest.NewRecorder(), withCookies(w), code2); !errors.Is(err, ErrNoPendingLogin) {
+		t.Errorf("want ErrNoPendingLogin, got %v", err)
+	}
+}
+
+func TestRequireTOTPForManager(t *testing.T) {
+	SetTwoFactorPolicy(TwoFactorPolicy{RequireForManager: true, Issuer: "test", PendingTimeout: time.Minute, RecoveryCodeCount: 1})
+	t.Cleanup(func() { SetTwoFactorPolicy(DefaultTwoFactorPolicy) })
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("pass123"), bcrypt.MinCost)
+	manager := db.User{ID: 42, LoginID: "manager", Password: string(hashedPassword), Role: RoleManager}
+	store := sessions.NewCookieStore([]byte("test-secret"))
+	ctx := newTestContext(manager, store)
+
+	// 未登録のマネージャーは登録を求められる
+	_, err := Login(ctx, httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil), "manager", "pass123")
+	var secondFactorErr *SecondFactorRequiredError
+	if !errors.As(err, &secondFactorErr) || secondFactorErr.Enrolled {
+		t.Fatalf("want SecondFactorRequiredError with Enrolled=false, got %v", err)
+	}
+
+	// 必須の場合は解除できない
+	secret, _, _ := BeginTOTPEnrollment(ctx, 42)
+	code, _ := totpCode(secret, now().Unix()/totpPeriod)
+	if _, err := ConfirmTOTPEnrollment(ctx, 42, code); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := DisableTOTP(ctx, 42, code); !errors.Is(err, ErrTOTPRequired) {
+		t.Errorf("want ErrTOTPRequired, got %v", err)
+	}
+
+	// 従業員は対象外
+	employee := db.User{ID: 43, LoginID: "employee", Password: string(hashedPassword), Role: RoleEmployee}
+	ctx2 := newTestContext(employee, store)
+	if _, err := Login(ctx2, httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil), "employee", "pass123"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
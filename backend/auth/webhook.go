@@ -0,0 +1,14 @@
+package auth
+
+// Webhookの署名用の秘密鍵の先頭に付ける文字列
+const webhookSecretPrefix = "whsec_"
+
+// Webhookの署名に使う秘密鍵を作成する
+// 受け取る側で署名を確認するので、APIトークンと違いハッシュ化せずに保存する
+func NewWebhookSecret() (string, error) {
+	s, err := newCSRFToken()
+	if err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + s, nil
+}
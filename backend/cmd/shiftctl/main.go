@@ -0,0 +1,348 @@
+// shiftctlはサーバーの管理者がDBを直接操作するためのコマンド
+// 設定はサーバーと同じ方法(環境変数、.env、設定ファイル)で読み込み、DB_PATHのDBを操作する
+//
+//	shiftctl [-config <path>] <command> [arguments]
+package main
+
+import (
+	"backend/auth"
+	"backend/config"
+	"backend/context"
+	"backend/db"
+	"backend/model"
+	"bufio"
+	stdcontext "context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `使い方: shiftctl [-config <path>] <command> [arguments]
+
+commands:
+  migrate                        DBのスキーマを最新にする. DBがない場合は作成する
+  user create -login <id> -name <name> -role <role,...> [-org <id>] [-email <email>]
+                                 ユーザーを作成する. パスワードは標準入力から読む
+  user passwd <login_id>         パスワードを設定し直す. パスワードは標準入力から読む
+  user deactivate <login_id>     ユーザーを無効にする
+  request list [-org <id>] [-status open|closed]
+                                 シフトリクエストの一覧を表示する
+  request close <request_id>     シフトリクエストの締め切りを現在時刻にする
+  export -request <id> [-o <path>]
+                                 シフトリクエストへの提出をCSVで出力する
+  backup <path>                  DBをpathにコピーする. サーバーの実行中でもよい
+`
+
+// 引数の誤り. 終了コード2で終了する
+var errUsage = errors.New("usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(stdcontext.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// コマンドを実行して終了コードを返す
+func run(ctx stdcontext.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("shiftctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	configPath := flags.String("config", "", "設定ファイルのパス(省略時は環境変数CONFIG_FILE)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	err := runCommand(ctx, *configPath, flags.Args(), stdin, stdout)
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "エラー: "+errorMessage(err))
+		return 1
+	}
+	return 0
+}
+
+func runCommand(ctx stdcontext.Context, configPath string, args []string, stdin io.Reader, stdout io.Writer) error {
+	// サーバーの起動に必要な設定はなくてもよいので、検証はしない
+	cfg, err := config.Read(configPath)
+	if err != nil {
+		return fmt.Errorf("設定に問題があります:\n%w", err)
+	}
+	if cfg.Mode == config.ModeTest {
+		return errors.New("MODE=testではモックDBを使うので、操作できるDBがありません")
+	}
+	if cfg.DBPath == "" {
+		return errors.New("DB_PATHが設定されていません")
+	}
+
+	passwordPolicy := auth.DefaultPasswordPolicy
+	passwordPolicy.MinLength = cfg.PasswordMinLength
+	auth.SetPasswordPolicy(passwordPolicy)
+
+	command, args := args[0], args[1:]
+	if command != "migrate" && command != "user" && command != "request" && command != "export" && command != "backup" {
+		return errUsage
+	}
+
+	// DBがない場合、sql.Openは空のDBを作成してしまうので、migrate以外では先に確認する
+	if command != "migrate" {
+		if _, err := os.Stat(cfg.DBPath); err != nil {
+			return fmt.Errorf("DBを開けません: %w", err)
+		}
+	}
+	database, err := db.NewSqlite3DB(cfg.DBPath)
+	if err != nil {
+		return fmt.Errorf("DBを開けません: %w", err)
+	}
+	defer database.Close()
+
+	switch command {
+	case "migrate":
+		return migrate(ctx, database, stdout)
+	case "backup":
+		// スキーマが古い場合も、migrateの前にバックアップできるようにする
+		if len(args) != 1 {
+			return errUsage
+		}
+		if err := database.Backup(ctx, args[0]); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%sにバックアップしました\n", args[0])
+		return nil
+	}
+
+	if err := db.CheckReady(ctx, database); err != nil {
+		if errors.Is(err, db.ErrSchemaVersionMismatch) {
+			return fmt.Errorf("DBのスキーマが最新ではありません. shiftctl migrateを実行してください: %w", err)
+		}
+		return err
+	}
+	appCtx := context.NewAppContext(database, nil).WithContext(ctx)
+
+	switch command {
+	case "user":
+		return userCommand(appCtx, args, stdin, stdout)
+	case "request":
+		return requestCommand(appCtx, args, stdout)
+	default:
+		return exportCommand(appCtx, args, stdout)
+	}
+}
+
+func migrate(ctx stdcontext.Context, database *db.Sqlite3DB, stdout io.Writer) error {
+	applied, err := database.Migrate(ctx)
+	for _, version := range applied {
+		fmt.Fprintf(stdout, "スキーマのバージョン%dを適用しました\n", version)
+	}
+	if errors.Is(err, db.ErrUnknownSchema) {
+		return fmt.Errorf("バージョンが記録されておらず、最初のcreate.sqlの状態でもないDBです. 更新できません: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Fprintf(stdout, "スキーマは最新です(バージョン%d)\n", db.SchemaVersion)
+	}
+	return nil
+}
+
+func userCommand(ctx *context.AppContext, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	var user model.User
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		loginID := flags.String("login", "", "")
+		name := flags.String("name", "", "")
+		roles := flags.String("role", "", "")
+		organizationID := flags.Int("org", db.DefaultOrganizationID, "")
+		email := flags.String("email", "", "")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 0 {
+			return errUsage
+		}
+		role, err := auth.RoleBits(splitList(*roles))
+		if err != nil {
+			return fmt.Errorf("ロールの指定が不正です: %w", err)
+		}
+		password, err := readPassword(stdin)
+		if err != nil {
+			return err
+		}
+
+		userID, err := user.Create(ctx, model.NewUser{
+			LoginID:        *loginID,
+			Name:           *name,
+			Password:       password,
+			Role:           role,
+			OrganizationID: *organizationID,
+			Email:          *email,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "ユーザー%sを作成しました(ID: %d)\n", *loginID, userID)
+		return nil
+
+	case "passwd":
+		if len(args) != 2 {
+			return errUsage
+		}
+		found, err := user.FindByLoginID(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		password, err := readPassword(stdin)
+		if err != nil {
+			return err
+		}
+		if err := user.SetPassword(ctx, found.ID, password); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "ユーザー%sのパスワードを設定しました. 既存のセッションは無効になります\n", found.LoginID)
+		return nil
+
+	case "deactivate":
+		if len(args) != 2 {
+			return errUsage
+		}
+		found, err := user.FindByLoginID(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		if err := user.Deactivate(ctx, found.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "ユーザー%sを無効にしました\n", found.LoginID)
+		return nil
+	}
+	return errUsage
+}
+
+func requestCommand(ctx *context.AppContext, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	var request model.Request
+
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("request list", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		organizationID := flags.Int("org", db.DefaultOrganizationID, "")
+		status := flags.String("status", "", "")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 0 {
+			return errUsage
+		}
+
+		requests, err := request.FindAllInOrganization(ctx, *organizationID, *status)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTART\tEND\tDEADLINE\tCREATOR")
+		for _, r := range requests {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.ID, r.StartDate.Format(), r.EndDate.Format(), r.Deadline.Format(), r.Creator.LoginID)
+		}
+		return w.Flush()
+
+	case "close":
+		if len(args) != 2 {
+			return errUsage
+		}
+		requestID, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errUsage
+		}
+		if err := request.Close(ctx, requestID); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "シフトリクエスト%dの受付を終了しました\n", requestID)
+		return nil
+	}
+	return errUsage
+}
+
+// 1行に1エントリーを出力する. 形式はGET /requests/{id}/exportと同じ
+func exportCommand(ctx *context.AppContext, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	requestID := flags.Int("request", 0, "")
+	output := flags.String("o", "", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 || *requestID == 0 {
+		return errUsage
+	}
+
+	var submission model.Submission
+	submissions, err := submission.FindAllByRequestID(ctx, *requestID)
+	if err != nil {
+		return err
+	}
+
+	out := stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	return model.WriteSubmissionsCSV(out, submissions)
+}
+
+// 標準入力の1行目をパスワードとして読む
+func readPassword(stdin io.Reader) (string, error) {
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("パスワードを標準入力から渡してください")
+	}
+	return password, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// 利用者に見せるエラーメッセージ
+func errorMessage(err error) string {
+	var inputErr model.InputError
+	switch {
+	case errors.As(err, &inputErr):
+		return inputErr.Message()
+	case errors.Is(err, db.ErrUserNotFound):
+		return "ユーザーが見つかりません"
+	case errors.Is(err, db.ErrRequestNotFound):
+		return "シフトリクエストが見つかりません"
+	case errors.Is(err, db.ErrOrganizationNotFound):
+		return "組織が見つかりません"
+	}
+	return err.Error()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MODE", "")
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("DB_PATH", filepath.Join(dir, "app.db"))
+
+	shiftctl := func(stdin string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	// migrate以外ではDBを作成しない
+	if code, _, stderr := shiftctl("", "request", "list"); code != 1 || !strings.Contains(stderr, "DBを開けません") {
+		t.Errorf("want exit 1 for missing DB, got %d: %s", code, stderr)
+	}
+	if code, _, stderr := shiftctl("", "migrate"); code != 0 {
+		t.Fatalf("migrate failed: %s", stderr)
+	}
+
+	// 最初のマネージャーを作成する
+	if code, stdout, stderr := shiftctl("newpass123\n", "user", "create", "-login", "boss", "-name", "店長", "-role", "manager,employee"); code != 0 || !strings.Contains(stdout, "ID: 1") {
+		t.Fatalf("user create failed: %d %s %s", code, stdout, stderr)
+	}
+	if code, _, stderr := shiftctl("newpass123\n", "user", "create", "-login", "boss", "-name", "店長", "-role", "manager"); code != 1 || !strings.Contains(stderr, "既に使われています") {
+		t.Errorf("want exit 1 for duplicate login_id, got %d: %s", code, stderr)
+	}
+	if code, _, stderr := shiftctl("", "user", "passwd", "boss"); code != 1 || !strings.Contains(stderr, "標準入力") {
+		t.Errorf("want exit 1 for empty password, got %d: %s", code, stderr)
+	}
+	if code, _, stderr := shiftctl("another123\n", "user", "passwd", "nobody"); code != 1 || !strings.Contains(stderr, "ユーザーが見つかりません") {
+		t.Errorf("want exit 1 for unknown user, got %d: %s", code, stderr)
+	}
+	if code, _, stderr := shiftctl("another123\n", "user", "passwd", "boss"); code != 0 {
+		t.Errorf("user passwd failed: %s", stderr)
+	}
+
+	if code, stdout, _ := shiftctl("", "request", "list"); code != 0 || !strings.HasPrefix(stdout, "ID") {
+		t.Errorf("unexpected request list: %d %s", code, stdout)
+	}
+	if code, _, stderr := shiftctl("", "request", "close", "1"); code != 1 || !strings.Contains(stderr, "シフトリクエストが見つかりません") {
+		t.Errorf("want exit 1 for unknown request, got %d: %s", code, stderr)
+	}
+	if code, _, _ := shiftctl("", "export"); code != 2 {
+		t.Errorf("want exit 2 without -request, got %d", code)
+	}
+
+	backup := filepath.Join(dir, "backup.db")
+	if code, _, stderr := shiftctl("", "backup", backup); code != 0 {
+		t.Errorf("backup failed: %s", stderr)
+	}
+
+	if code, _, _ := shiftctl("", "user", "deactivate", "boss"); code != 0 {
+		t.Errorf("user deactivate failed")
+	}
+	if code, _, stderr := shiftctl("", "user", "deactivate", "boss"); code != 1 || !strings.Contains(stderr, "既に無効") {
+		t.Errorf("want exit 1 for deactivated user, got %d: %s", code, stderr)
+	}
+
+	// バックアップには無効にする前の状態が残る
+	t.Setenv("DB_PATH", backup)
+	if code, _, _ := shiftctl("", "user", "deactivate", "boss"); code != 0 {
+		t.Errorf("user in backup should be active")
+	}
+
+	if code, _, _ := shiftctl("", "unknown"); code != 2 {
+		t.Errorf("want exit 2 for unknown command, got %d", code)
+	}
+}
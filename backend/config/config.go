@@ -0,0 +1,270 @@
+package config
+
+import (
+	"backend/auth"
+	"backend/notify"
+	"backend/webhook"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// サーバーの設定
+// 各フィールドはenvタグの環境変数、または設定ファイルの同じ名前(小文字)のキーで指定する
+// secretタグを付けたフィールドは--print-configで値を出さない
+type Config struct {
+	// testの場合はモックDBを使う
+	Mode   string `env:"MODE"`
+	DBPath string `env:"DB_PATH"`
+	Port   string `env:"PORT"`
+	// フロントエンドのURL. OIDCでのログイン後のリダイレクト先にも使う
+	FrontendURL string `env:"FRONTEND_URL"`
+	// CORSで許可するオリジン. 省略時はFrontendURLのみ
+	AllowedOrigins []string   `env:"ALLOWED_ORIGINS"`
+	LogLevel       slog.Level `env:"LOG_LEVEL"`
+	// X-Forwarded-Forを信頼するリバースプロキシのIPアドレスまたはCIDR. 省略時は使わない
+	TrustedProxies []string `env:"TRUSTED_PROXIES"`
+
+	// セッションCookieの署名に使う鍵
+	SessionKey      string        `env:"SESSION_KEY" secret:"true"`
+	SessionLifetime time.Duration `env:"SESSION_LIFETIME"`
+	CookieSecure    bool          `env:"COOKIE_SECURE"`
+	// lax, strict, noneのいずれか. noneの場合はCookieSecureが必要
+	CookieSameSite string `env:"COOKIE_SAMESITE"`
+
+	// 1リクエストあたりの処理時間の上限. 0は無制限
+	RequestTimeout time.Duration `env:"REQUEST_TIMEOUT"`
+	ReadTimeout    time.Duration `env:"SERVER_READ_TIMEOUT"`
+	// 省略時はRequestTimeout+5秒. SSEのストリームには適用しない
+	WriteTimeout    time.Duration `env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout     time.Duration `env:"SERVER_IDLE_TIMEOUT"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT"`
+
+	PasswordMinLength int           `env:"PASSWORD_MIN_LENGTH"`
+	PasswordResetTTL  time.Duration `env:"PASSWORD_RESET_TTL"`
+	// trueの場合、マネージャーは二要素認証を登録しないとログインできない
+	RequireManager2FA bool   `env:"REQUIRE_MANAGER_2FA"`
+	TOTPIssuer        string `env:"TOTP_ISSUER"`
+	// 例: "shift_leader=request.create,submission.view_all"
+	RolePermissions string `env:"ROLE_PERMISSIONS"`
+
+	// OIDCIssuerを設定した場合のみOIDCでのログインを有効にする
+	OIDCIssuer       string `env:"OIDC_ISSUER"`
+	OIDCClientID     string `env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret string `env:"OIDC_CLIENT_SECRET" secret:"true"`
+	OIDCRedirectURL  string `env:"OIDC_REDIRECT_URL"`
+
+	// アプリ内の通知に加えて使う送信方法. smtp, webhook, logのいずれか
+	Notifiers            []string      `env:"NOTIFIERS"`
+	NotifyInterval       time.Duration `env:"NOTIFY_INTERVAL"`
+	NotifyReminderBefore time.Duration `env:"NOTIFY_REMINDER_BEFORE"`
+	SMTPAddr             string        `env:"SMTP_ADDR"`
+	SMTPFrom             string        `env:"SMTP_FROM"`
+	SMTPUsername         string        `env:"SMTP_USERNAME"`
+	SMTPPassword         string        `env:"SMTP_PASSWORD" secret:"true"`
+	NotifyWebhookURL     string        `env:"NOTIFY_WEBHOOK_URL" secret:"true"`
+
+	WebhookInterval    time.Duration `env:"WEBHOOK_INTERVAL"`
+	WebhookMaxAttempts int           `env:"WEBHOOK_MAX_ATTEMPTS"`
+	// trueの場合、ループバックやプライベートネットワークのアドレスにもWebhookを送る
+	WebhookAllowPrivateAddresses bool `env:"WEBHOOK_ALLOW_PRIVATE_ADDRESSES"`
+
+	// 設定した場合、/metricsの取得にAuthorization: Bearer <MetricsToken>が必要
+	// MODE=productionで設定しない場合は、/metricsを公開しない
+	MetricsToken string `env:"METRICS_TOKEN" secret:"true"`
+}
+
+const (
+	ModeProduction = "production"
+	ModeTest       = "test"
+)
+
+// 何も指定しなかった場合の設定
+func Default() Config {
+	return Config{
+		Mode:                 ModeProduction,
+		LogLevel:             slog.LevelInfo,
+		SessionLifetime:      auth.DefaultSessionLifetime,
+		CookieSameSite:       "lax",
+		RequestTimeout:       10 * time.Second,
+		ReadTimeout:          15 * time.Second,
+		IdleTimeout:          60 * time.Second,
+		ShutdownTimeout:      30 * time.Second,
+		PasswordMinLength:    auth.DefaultPasswordPolicy.MinLength,
+		PasswordResetTTL:     auth.DefaultPasswordPolicy.ResetTokenTTL,
+		TOTPIssuer:           auth.DefaultTwoFactorPolicy.Issuer,
+		NotifyInterval:       notify.DefaultInterval,
+		NotifyReminderBefore: notify.DefaultReminderWindow,
+		WebhookInterval:      webhook.DefaultInterval,
+		WebhookMaxAttempts:   webhook.DefaultMaxAttempts,
+	}
+}
+
+// 他の設定から決まる既定値を設定する
+func (c *Config) applyDerivedDefaults(set map[string]bool) {
+	if len(c.AllowedOrigins) == 0 && c.FrontendURL != "" {
+		c.AllowedOrigins = []string{c.FrontendURL}
+	}
+	if !set["SERVER_WRITE_TIMEOUT"] && c.RequestTimeout > 0 {
+		c.WriteTimeout = c.RequestTimeout + 5*time.Second
+	}
+}
+
+// CookieSameSiteに対応するhttp.SameSite
+func (c *Config) SameSite() http.SameSite {
+	switch c.CookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+// TrustedProxiesをCIDRに変換する. IPアドレスは1つのアドレスだけのCIDRにする
+// 形式はvalidateで検証済みなので、不正な値は無視する
+func (c *Config) TrustedProxyPrefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, proxy := range c.TrustedProxies {
+		if prefix, err := parseProxy(proxy); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+func parseProxy(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		prefix, err := netip.ParsePrefix(proxy)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// 全ての問題をまとめて返す
+func (c *Config) validate() []error {
+	var errs []error
+	problem := func(message string) {
+		errs = append(errs, errors.New(message))
+	}
+
+	switch c.Mode {
+	case ModeProduction, ModeTest:
+	default:
+		problem("MODEはproduction, testのいずれかでなければいけません")
+	}
+	if c.DBPath == "" && c.Mode != ModeTest {
+		problem("DB_PATHが設定されていません")
+	}
+	if c.Port == "" {
+		problem("PORTが設定されていません")
+	}
+	if c.FrontendURL == "" {
+		problem("FRONTEND_URLが設定されていません")
+	}
+	if c.SessionKey == "" {
+		problem("SESSION_KEYが設定されていません")
+	}
+	if c.SessionLifetime <= 0 {
+		problem("SESSION_LIFETIMEは0より長くなければいけません")
+	}
+
+	switch c.CookieSameSite {
+	case "lax", "strict":
+	case "none":
+		if !c.CookieSecure {
+			problem("COOKIE_SAMESITE=noneの場合はCOOKIE_SECURE=trueが必要です")
+		}
+	default:
+		problem("COOKIE_SAMESITEはlax, strict, noneのいずれかでなければいけません")
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"REQUEST_TIMEOUT", c.RequestTimeout},
+		{"SERVER_READ_TIMEOUT", c.ReadTimeout},
+		{"SERVER_WRITE_TIMEOUT", c.WriteTimeout},
+		{"SERVER_IDLE_TIMEOUT", c.IdleTimeout},
+	} {
+		if timeout.value < 0 {
+			problem(timeout.name + "は0以上でなければいけません")
+		}
+	}
+	if c.WriteTimeout > 0 && c.RequestTimeout > 0 && c.WriteTimeout <= c.RequestTimeout {
+		problem("SERVER_WRITE_TIMEOUTはREQUEST_TIMEOUTより長くなければいけません")
+	}
+	if c.ShutdownTimeout <= 0 {
+		problem("SHUTDOWN_TIMEOUTは0より長くなければいけません")
+	}
+
+	if c.PasswordMinLength < 1 {
+		problem("PASSWORD_MIN_LENGTHは1以上の整数でなければいけません")
+	}
+	if c.PasswordResetTTL <= 0 {
+		problem("PASSWORD_RESET_TTLは0より長くなければいけません")
+	}
+	if c.RolePermissions != "" {
+		if _, err := auth.ParseRolePermissions(auth.DefaultRoles, c.RolePermissions); err != nil {
+			problem("ROLE_PERMISSIONSの形式が不正です: " + err.Error())
+		}
+	}
+
+	// コールバックはIDプロバイダーからのリダイレクトなので、COOKIE_SAMESITE=strictでは使えない
+	if c.OIDCIssuer != "" {
+		if c.CookieSameSite == "strict" {
+			problem("OIDCでのログインはCOOKIE_SAMESITE=strictと併用できません")
+		}
+		if c.OIDCClientID == "" || c.OIDCRedirectURL == "" {
+			problem("OIDC_ISSUERを設定した場合はOIDC_CLIENT_ID, OIDC_REDIRECT_URLが必要です")
+		}
+	}
+
+	for _, name := range c.Notifiers {
+		switch name {
+		case "smtp":
+			if c.SMTPAddr == "" || c.SMTPFrom == "" {
+				problem("NOTIFIERSにsmtpを指定した場合はSMTP_ADDR, SMTP_FROMが必要です")
+			}
+		case "webhook":
+			if c.NotifyWebhookURL == "" {
+				problem("NOTIFIERSにwebhookを指定した場合はNOTIFY_WEBHOOK_URLが必要です")
+			}
+		case "log":
+		default:
+			problem("NOTIFIERSに不明な送信方法が指定されています: " + name)
+		}
+	}
+	if c.NotifyInterval <= 0 {
+		problem("NOTIFY_INTERVALは0より長くなければいけません")
+	}
+	if c.NotifyReminderBefore < 0 {
+		problem("NOTIFY_REMINDER_BEFOREは0以上でなければいけません")
+	}
+	if c.WebhookInterval <= 0 {
+		problem("WEBHOOK_INTERVALは0より長くなければいけません")
+	}
+	if c.WebhookMaxAttempts < 1 {
+		problem("WEBHOOK_MAX_ATTEMPTSは1以上の整数でなければいけません")
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parseProxy(proxy); err != nil {
+			problem("TRUSTED_PROXIESにはIPアドレスまたはCIDRを指定します: " + proxy)
+		}
+	}
+	for _, origin := range c.AllowedOrigins {
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			problem("ALLOWED_ORIGINSにはhttp://またはhttps://で始まるオリジンを指定します: " + origin)
+		}
+	}
+	return errs
+}
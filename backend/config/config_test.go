@@ -0,0 +1,264 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 必須の設定だけを環境変数で指定する
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_PATH", "/tmp/app.db")
+	t.Setenv("PORT", "8080")
+	t.Setenv("FRONTEND_URL", "http://localhost:3000")
+	t.Setenv("SESSION_KEY", "session-secret")
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Default()
+	want.DBPath = "/tmp/app.db"
+	want.Port = "8080"
+	want.FrontendURL = "http://localhost:3000"
+	want.SessionKey = "session-secret"
+	// 他の設定から決まる既定値
+	want.AllowedOrigins = []string{"http://localhost:3000"}
+	want.WriteTimeout = 15 * time.Second
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	setRequiredEnv(t)
+	path := writeFile(t, `
+# コメント
+port = "9000"
+allowed_origins = ["https://a.example.com", 'https://b.example.com',] # 末尾のカンマ
+session_lifetime = "8h"
+cookie_secure = true
+password_min_length = 12
+log_level = "debug"
+smtp_from = "shift \"app\" <shift@example.com>"
+`)
+	// 環境変数は設定ファイルより優先する. 空の環境変数は設定していないものとみなす
+	t.Setenv("SESSION_LIFETIME", "30m")
+	t.Setenv("PORT", "")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Port != "9000" {
+		t.Errorf("port: got %q", cfg.Port)
+	}
+	if want := []string{"https://a.example.com", "https://b.example.com"}; !reflect.DeepEqual(cfg.AllowedOrigins, want) {
+		t.Errorf("allowed_origins: got %v, want %v", cfg.AllowedOrigins, want)
+	}
+	if cfg.SessionLifetime != 30*time.Minute {
+		t.Errorf("session_lifetime: got %v", cfg.SessionLifetime)
+	}
+	if !cfg.CookieSecure || cfg.PasswordMinLength != 12 || cfg.LogLevel != slog.LevelDebug {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if cfg.SMTPFrom != `shift "app" <shift@example.com>` {
+		t.Errorf("smtp_from: got %q", cfg.SMTPFrom)
+	}
+
+	// CONFIG_FILEでも指定できる
+	t.Setenv("CONFIG_FILE", path)
+	if cfg, err := Load(""); err != nil || cfg.Port != "9000" {
+		t.Errorf("CONFIG_FILE should be loaded, got port=%q, err=%v", cfg.Port, err)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	setRequiredEnv(t)
+	for _, content := range []string{
+		"[server]\nport = 1",
+		"port",
+		`port = "1`,
+		"port = 1 2",
+		"port = 1\nport = 2",
+		`allowed_origins = ["a", 1]`,
+		`allowed_origins = ["a"`,
+	} {
+		if _, err := Load(writeFile(t, content)); err == nil {
+			t.Errorf("expected error for %q", content)
+		}
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	// 全ての問題をまとめて返す
+	path := writeFile(t, "unknown_key = 1\n")
+	for _, name := range []string{"DB_PATH", "PORT", "FRONTEND_URL", "SESSION_KEY"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("REQUEST_TIMEOUT", "10")
+	t.Setenv("COOKIE_SAMESITE", "none")
+	t.Setenv("NOTIFIERS", "smtp, fax")
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "0")
+	t.Setenv("ALLOWED_ORIGINS", "example.com")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy.local")
+
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{
+		"設定ファイルに不明なキーがあります: unknown_key",
+		"REQUEST_TIMEOUTの形式が不正です",
+		"DB_PATHが設定されていません",
+		"PORTが設定されていません",
+		"FRONTEND_URLが設定されていません",
+		"SESSION_KEYが設定されていません",
+		"COOKIE_SAMESITE=noneの場合はCOOKIE_SECURE=trueが必要です",
+		"NOTIFIERSにsmtpを指定した場合はSMTP_ADDR, SMTP_FROMが必要です",
+		"NOTIFIERSに不明な送信方法が指定されています: fax",
+		"WEBHOOK_MAX_ATTEMPTSは1以上の整数でなければいけません",
+		"ALLOWED_ORIGINSにはhttp://またはhttps://で始まるオリジンを指定します: example.com",
+		"TRUSTED_PROXIESにはIPアドレスまたはCIDRを指定します: proxy.local",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error should contain %q, got:\n%s", want, err)
+		}
+	}
+
+	// テストモードではDB_PATHは不要
+	setRequiredEnv(t)
+	t.Setenv("DB_PATH", "")
+	t.Setenv("MODE", "test")
+	t.Setenv("REQUEST_TIMEOUT", "")
+	t.Setenv("COOKIE_SAMESITE", "")
+	t.Setenv("NOTIFIERS", "")
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "")
+	t.Setenv("ALLOWED_ORIGINS", "")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,192.0.2.1")
+	cfg, err := Load("")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := fmt.Sprint(cfg.TrustedProxyPrefixes()); got != "[10.0.0.0/8 192.0.2.1/32]" {
+		t.Errorf("unexpected trusted proxies: %s", got)
+	}
+
+	// SERVER_WRITE_TIMEOUTはREQUEST_TIMEOUTより長くする
+	t.Setenv("SERVER_WRITE_TIMEOUT", "5s")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "SERVER_WRITE_TIMEOUTはREQUEST_TIMEOUTより長くなければいけません") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	// 値の組み合わせは検証せず、形式の誤りだけを返す
+	for _, name := range []string{"PORT", "FRONTEND_URL", "SESSION_KEY"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("DB_PATH", "/tmp/app.db")
+	cfg, err := Read("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.DBPath != "/tmp/app.db" {
+		t.Errorf("unexpected DB_PATH: %s", cfg.DBPath)
+	}
+
+	t.Setenv("PASSWORD_MIN_LENGTH", "eight")
+	if _, err := Read(""); err == nil || !strings.Contains(err.Error(), "PASSWORD_MIN_LENGTHの形式が不正です") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("ALLOWED_ORIGINS", "https://a.example.com,https://b.example.com")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var sb strings.Builder
+	cfg.Print(&sb)
+	out := sb.String()
+	for _, want := range []string{
+		"DB_PATH=/tmp/app.db\n",
+		"ALLOWED_ORIGINS=https://a.example.com,https://b.example.com\n",
+		"LOG_LEVEL=INFO\n",
+		"SESSION_LIFETIME=3h0m0s\n",
+		"COOKIE_SECURE=false\n",
+		"WEBHOOK_MAX_ATTEMPTS=8\n",
+		// 秘密の値は伏せる. 設定していない場合は空
+		"SESSION_KEY=<redacted>\n",
+		"METRICS_TOKEN=\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "session-secret") {
+		t.Errorf("secret should be redacted, got:\n%s", out)
+	}
+
+	// 出力した設定は、そのまま環境変数として読み込める
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		if value != "<redacted>" {
+			t.Setenv(key, value)
+		}
+	}
+	reloaded, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(reloaded, cfg) {
+		t.Errorf("got %+v, want %+v", reloaded, cfg)
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	setRequiredEnv(t)
+	// t.Setenvで終了時に元に戻す
+	os.Unsetenv("PORT")
+
+	// .envはカレントディレクトリから読み込み、環境変数を上書きしない
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("PORT=7000\nFRONTEND_URL=http://ignored\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Port != "7000" || cfg.FrontendURL != "http://localhost:3000" {
+		t.Errorf("unexpected config: port=%q, frontend_url=%q", cfg.Port, cfg.FrontendURL)
+	}
+}
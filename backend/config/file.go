@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 設定ファイルを読み込む
+// 形式はTOMLのうち、1行に1つの key = value を書くものだけに対応する
+// 値は文字列("..."または'...')、整数、真偽値、文字列の配列(["a", "b"])のいずれか. テーブルには対応しない
+// 値は環境変数と同じ形式の文字列にして返す. 配列はカンマ区切りにする
+//
+//	# コメント
+//	db_path = "/var/lib/shift/app.db"
+//	allowed_origins = ["https://shift.example.com", "https://admin.example.com"]
+//	session_lifetime = "8h"
+//	cookie_secure = true
+func readFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("%s:%d: テーブルには対応していません", path, lineNo)
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: key = value の形式ではありません", path, lineNo)
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("%s:%d: %sが重複しています", path, lineNo, key)
+		}
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func parseValue(raw string) (string, error) {
+	if strings.HasPrefix(raw, "[") {
+		rest := strings.TrimSpace(strings.TrimPrefix(raw, "["))
+		var items []string
+		for {
+			rest = strings.TrimSpace(rest)
+			if strings.HasPrefix(rest, "]") {
+				rest = rest[1:]
+				break
+			}
+			item, after, err := parseString(rest)
+			if err != nil {
+				return "", fmt.Errorf("配列には文字列だけを書けます: %w", err)
+			}
+			if strings.Contains(item, ",") {
+				return "", fmt.Errorf("配列の要素にカンマは使えません: %q", item)
+			}
+			items = append(items, item)
+			rest = strings.TrimSpace(after)
+			if strings.HasPrefix(rest, ",") {
+				rest = rest[1:]
+			} else if !strings.HasPrefix(rest, "]") {
+				return "", fmt.Errorf("配列が閉じられていません")
+			}
+		}
+		if err := checkTrailing(rest); err != nil {
+			return "", err
+		}
+		return strings.Join(items, ","), nil
+	}
+
+	if strings.HasPrefix(raw, `"`) || strings.HasPrefix(raw, "'") {
+		value, rest, err := parseString(raw)
+		if err != nil {
+			return "", err
+		}
+		if err := checkTrailing(rest); err != nil {
+			return "", err
+		}
+		return value, nil
+	}
+
+	// 整数と真偽値
+	value, _, _ := strings.Cut(raw, "#")
+	value = strings.TrimSpace(value)
+	if value == "true" || value == "false" {
+		return value, nil
+	}
+	if _, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 10, 64); err == nil {
+		return strings.ReplaceAll(value, "_", ""), nil
+	}
+	return "", fmt.Errorf("値の形式が不正です: %s", raw)
+}
+
+// 先頭の文字列を読み、残りを返す
+// "..."はエスケープに対応し、'...'はそのまま使う
+func parseString(s string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(s, "'"):
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return "", "", fmt.Errorf("文字列が閉じられていません")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case strings.HasPrefix(s, `"`):
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("文字列のエスケープが不正です")
+				}
+				return value, s[i+1:], nil
+			}
+		}
+		return "", "", fmt.Errorf("文字列が閉じられていません")
+	}
+	return "", "", fmt.Errorf("文字列ではありません: %s", s)
+}
+
+// 値の後にはコメントだけを書ける
+func checkTrailing(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("値の後に余分な文字があります: %s", rest)
+	}
+	return nil
+}
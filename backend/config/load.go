@@ -0,0 +1,183 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// 設定を読み込んで検証する
+// 優先順位は 環境変数 > .env > 設定ファイル > 既定値
+// pathが空の場合は環境変数CONFIG_FILEの設定ファイルを読み込む. どちらも空の場合は設定ファイルを使わない
+// 問題があった場合は、全ての問題をまとめたエラーと、読み込めた範囲の設定を返す
+func Load(path string) (Config, error) {
+	cfg, errs := read(path)
+	errs = append(errs, cfg.validate()...)
+	return cfg, errors.Join(errs...)
+}
+
+// Loadと同じように読み込むが、値の組み合わせは検証しない
+// サーバーを起動しないshiftctlのように、一部の設定だけを使う場合に使う
+func Read(path string) (Config, error) {
+	cfg, errs := read(path)
+	return cfg, errors.Join(errs...)
+}
+
+func read(path string) (Config, []error) {
+	cfg := Default()
+	var errs []error
+
+	// .envは既に設定されている環境変数を上書きしない
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		errs = append(errs, fmt.Errorf(".envの読み込みに失敗しました: %w", err))
+	}
+
+	values := make(map[string]string)
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		fileValues, err := readFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("設定ファイルの読み込みに失敗しました: %w", err))
+		}
+		for key, value := range fileValues {
+			name := strings.ToUpper(key)
+			if _, ok := fieldByEnv(name); !ok {
+				errs = append(errs, fmt.Errorf("設定ファイルに不明なキーがあります: %s", key))
+				continue
+			}
+			values[name] = value
+		}
+	}
+	for _, field := range fields() {
+		if value := os.Getenv(field.env); value != "" {
+			values[field.env] = value
+		}
+	}
+
+	set := make(map[string]bool)
+	v := reflect.ValueOf(&cfg).Elem()
+	for _, field := range fields() {
+		value, ok := values[field.env]
+		if !ok {
+			continue
+		}
+		if err := setValue(v.Field(field.index), value); err != nil {
+			errs = append(errs, fmt.Errorf("%sの形式が不正です: %w", field.env, err))
+			continue
+		}
+		set[field.env] = true
+	}
+	cfg.applyDerivedDefaults(set)
+	return cfg, errs
+}
+
+// 設定を環境変数の形式(KEY=value)で書き出す
+// secretの値は設定されている場合も出さない
+func (c Config) Print(w io.Writer) {
+	v := reflect.ValueOf(c)
+	for _, field := range fields() {
+		value := formatValue(v.Field(field.index))
+		if field.secret && value != "" {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(w, "%s=%s\n", field.env, value)
+	}
+}
+
+type field struct {
+	index  int
+	env    string
+	secret bool
+}
+
+func fields() []field {
+	t := reflect.TypeOf(Config{})
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		env := f.Tag.Get("env")
+		if env == "" {
+			continue
+		}
+		fields = append(fields, field{index: i, env: env, secret: f.Tag.Get("secret") == "true"})
+	}
+	return fields
+}
+
+func fieldByEnv(env string) (field, bool) {
+	for _, field := range fields() {
+		if field.env == env {
+			return field, true
+		}
+	}
+	return field{}, false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// 文字列の値をフィールドの型に変換して設定する
+// リストはカンマ区切り
+func setValue(v reflect.Value, value string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		panic("config: unsupported field type " + v.Type().String())
+	}
+	return nil
+}
+
+// setValueで読み込める文字列にする
+func formatValue(v reflect.Value) string {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, _ := m.MarshalText()
+		return string(text)
+	}
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -2,25 +2,80 @@ package context
 
 import (
 	"backend/db"
+	"backend/event"
+	stdcontext "context"
+	"time"
 
 	"github.com/gorilla/sessions"
 )
 
 // アプリケーション全体で利用されるデータを管理
 type AppContext struct {
-	db           db.DB
-	sessionStore *sessions.CookieStore
+	db             db.DB
+	sessionStore   *sessions.CookieStore
+	requestTimeout time.Duration
+	eventHub       *event.Hub
+
+	// HTTPリクエスト単位の値
+	// WithContext, WithClientIPで設定したコピーでのみ使われる
+	ctx      stdcontext.Context
+	clientIP string
 }
 
 // create new AppContext and set db and sessionStore
 func NewAppContext(db db.DB, sessionStore *sessions.CookieStore) *AppContext {
-	return &AppContext{db: db, sessionStore: sessionStore}
+	return &AppContext{db: db, sessionStore: sessionStore, eventHub: event.NewHub()}
 }
 
 func (ctx *AppContext) GetDB() db.DB {
 	return ctx.db
 }
 
+// プロセス内でイベントを配信するHub
+func (ctx *AppContext) GetEventHub() *event.Hub {
+	return ctx.eventHub
+}
+
 func (ctx *AppContext) GetSessionStore() *sessions.CookieStore {
 	return ctx.sessionStore
 }
+
+// 1リクエストあたりの処理時間の上限を設定する
+// 0の場合は上限なし
+func (ctx *AppContext) SetRequestTimeout(timeout time.Duration) {
+	ctx.requestTimeout = timeout
+}
+
+func (ctx *AppContext) GetRequestTimeout() time.Duration {
+	return ctx.requestTimeout
+}
+
+// リクエスト単位のcontext.Contextを設定したコピーを返す
+// 元のAppContextは変更しない
+func (ctx *AppContext) WithContext(c stdcontext.Context) *AppContext {
+	copied := *ctx
+	copied.ctx = c
+	return &copied
+}
+
+// DBなどに渡すcontext.Contextを返す
+// WithContextで設定されていない場合はcontext.Background()を返す
+func (ctx *AppContext) Context() stdcontext.Context {
+	if ctx.ctx == nil {
+		return stdcontext.Background()
+	}
+	return ctx.ctx
+}
+
+// リクエスト元のIPアドレスを設定したコピーを返す
+func (ctx *AppContext) WithClientIP(ip string) *AppContext {
+	copied := *ctx
+	copied.clientIP = ip
+	return &copied
+}
+
+// リクエスト元のIPアドレスを返す
+// HTTPリクエストの外では空文字
+func (ctx *AppContext) ClientIP() string {
+	return ctx.clientIP
+}
@@ -0,0 +1,30 @@
+package context
+
+import (
+	stdcontext "context"
+)
+
+// HTTPリクエスト単位でアクセスログに出す情報
+// middleware.RequestLoggingが作成し、ハンドラーや認証の処理で埋める
+type RequestInfo struct {
+	RequestID string
+	// マッチしたルートのパターン。例: "GET /api/requests/{id}"
+	Route string
+	// 認証できたユーザーのID。認証していない場合は0
+	UserID int
+	// ハンドラーが返したエラーと、エラーが作成された場所
+	Err       error
+	ErrSource string
+}
+
+type requestInfoKey struct{}
+
+func WithRequestInfo(ctx stdcontext.Context, info *RequestInfo) stdcontext.Context {
+	return stdcontext.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// contextのRequestInfoを返す。HTTPリクエストの処理でない場合はnil
+func RequestInfoFrom(ctx stdcontext.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
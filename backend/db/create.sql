@@ -0,0 +1,302 @@
+-- TODO: unique制約
+
+-- 組織(店舗)テーブル
+-- ユーザーとシフトリクエストはいずれか1つの組織に所属する
+CREATE TABLE IF NOT EXISTS organizations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+-- 組織を作る前のユーザーとシフトリクエストは、この組織に所属させる
+INSERT OR IGNORE INTO organizations (id, name) VALUES (1, 'デフォルト');
+
+-- ユーザーテーブル
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    login_id TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL,
+    name TEXT NOT NULL,
+    role INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    -- パスワード変更のたびに増やし、古いセッションを無効にする
+    session_version INTEGER NOT NULL DEFAULT 0,
+    -- OIDCでログインする際の紐づけに使う
+    email TEXT UNIQUE,
+    organization_id INTEGER NOT NULL DEFAULT 1,
+    -- 無効にした日時(UNIX時間). 有効なユーザーは0
+    deactivated_at INTEGER NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (organization_id) REFERENCES organizations(id)
+);
+
+-- セッションテーブル
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token TEXT NOT NULL UNIQUE,
+    expires_at INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- リクエストテーブル
+CREATE TABLE IF NOT EXISTS requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER NOT NULL DEFAULT 1,
+    creator_id INTEGER NOT NULL,
+    start_date TEXT NOT NULL,
+    end_date TEXT NOT NULL,
+    deadline TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+
+    FOREIGN KEY (organization_id) REFERENCES organizations(id)
+);
+
+CREATE INDEX IF NOT EXISTS requests_organization ON requests (organization_id);
+
+-- 従業員のグループテーブル(キッチン、ホールなど)
+CREATE TABLE IF NOT EXISTS user_groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+
+    FOREIGN KEY (organization_id) REFERENCES organizations(id)
+);
+
+-- グループに所属するユーザーテーブル
+CREATE TABLE IF NOT EXISTS user_group_members (
+    group_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+
+    PRIMARY KEY (group_id, user_id),
+    FOREIGN KEY (group_id) REFERENCES user_groups(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS user_group_members_user ON user_group_members (user_id);
+
+-- シフトリクエストの対象のユーザー、グループテーブル
+-- どちらにも行がないシフトリクエストは組織の全員が対象
+CREATE TABLE IF NOT EXISTS request_target_users (
+    request_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+
+    PRIMARY KEY (request_id, user_id),
+    FOREIGN KEY (request_id) REFERENCES requests(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS request_target_groups (
+    request_id INTEGER NOT NULL,
+    group_id INTEGER NOT NULL,
+
+    PRIMARY KEY (request_id, group_id),
+    FOREIGN KEY (request_id) REFERENCES requests(id),
+    FOREIGN KEY (group_id) REFERENCES user_groups(id)
+);
+
+-- シフトエントリーテーブル
+CREATE TABLE IF NOT EXISTS entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    submission_id INTEGER NOT NULL,
+    date TEXT NOT NULL,
+    hour INTEGER NOT NULL,
+
+    FOREIGN KEY (submission_id) REFERENCES submissions(id)
+);
+
+-- シフト提出テーブル
+CREATE TABLE IF NOT EXISTS submissions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    request_id INTEGER NOT NULL,
+    submitter_id INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    updated_at TEXT NOT NULL,
+
+    FOREIGN KEY (submitter_id) REFERENCES users(id),
+    FOREIGN KEY (request_id) REFERENCES requests(id)
+);
+
+-- 監査ログテーブル
+-- 追記のみ可能で、更新・削除はトリガーで禁止する
+-- organization_idは操作したユーザー、不明な場合は対象のユーザーの組織
+CREATE TABLE IF NOT EXISTS audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER NOT NULL DEFAULT 1,
+    actor_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id INTEGER NOT NULL,
+    before TEXT NOT NULL,
+    after TEXT NOT NULL,
+    client_ip TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_target ON audit_events (target_type, target_id);
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+-- ログイン試行の失敗状況テーブル
+-- keyは"ip:<IPアドレス>"または"login_id:<ログインID>"
+-- 時刻はUNIX時間(秒)
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at INTEGER NOT NULL,
+    blocked_until INTEGER NOT NULL
+);
+
+-- パスワードリセット用のワンタイムトークンテーブル
+-- トークンはSHA-256のハッシュ値だけを保存する
+-- 時刻はUNIX時間(秒)。used_atは未使用の場合0
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    creator_id INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at INTEGER NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (creator_id) REFERENCES users(id)
+);
+
+-- 二要素認証(TOTP)の設定テーブル
+-- secretはワンタイムパスワードの計算に必要なのでそのまま保存する
+-- 時刻はUNIX時間(秒)。confirmed_atは登録が完了していない場合0
+CREATE TABLE IF NOT EXISTS user_totps (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    confirmed_at INTEGER NOT NULL DEFAULT 0,
+    last_used_step INTEGER NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- 二要素認証のリカバリーコードテーブル
+-- コードはSHA-256のハッシュ値だけを保存する。used_atは未使用の場合0
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- 外部のIDプロバイダー(OIDC)のアカウントとユーザーの紐づけテーブル
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+
+    PRIMARY KEY (issuer, subject),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- スクリプトなどから使うAPIトークンテーブル
+-- トークンはSHA-256のハッシュ値だけを保存する。scopesはスペース区切り
+-- 時刻はUNIX時間(秒)。expires_at, last_used_at, revoked_atは未設定の場合0
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    creator_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    expires_at INTEGER NOT NULL DEFAULT 0,
+    last_used_at INTEGER NOT NULL DEFAULT 0,
+    revoked_at INTEGER NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (creator_id) REFERENCES users(id)
+);
+
+-- 送信済みの通知テーブル
+-- 再起動しても同じ通知を送らないように、種類、シフトリクエスト、受信者の組ごとに記録する
+-- sent_atはUNIX時間(秒)
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+    kind TEXT NOT NULL,
+    request_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    sent_at INTEGER NOT NULL,
+
+    PRIMARY KEY (kind, request_id, user_id),
+    FOREIGN KEY (request_id) REFERENCES requests(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- アプリ内でユーザーに表示する通知テーブル
+-- request_idは関連するシフトリクエスト。ない場合は0
+-- dedup_keyが同じ通知は1ユーザーに1件だけ作成する。NULLの場合は重複を確認しない
+-- read_atはUNIX時間(秒)で、未読の場合0
+CREATE TABLE IF NOT EXISTS user_notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    request_id INTEGER NOT NULL DEFAULT 0,
+    title TEXT NOT NULL,
+    body TEXT NOT NULL,
+    dedup_key TEXT,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    read_at INTEGER NOT NULL DEFAULT 0,
+
+    UNIQUE (user_id, dedup_key),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_notifications_user_id_read_at ON user_notifications (user_id, read_at);
+
+-- 外部のサービスにイベントを送るWebhookの登録テーブル
+-- eventsはスペース区切り. secretは署名に使うのでそのまま保存する
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    organization_id INTEGER NOT NULL,
+    creator_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+
+    FOREIGN KEY (organization_id) REFERENCES organizations(id),
+    FOREIGN KEY (creator_id) REFERENCES users(id)
+);
+
+-- Webhookで送るイベントの送信待ちと送信結果のテーブル
+-- statusは'pending', 'succeeded', 'failed'のいずれか
+-- 時刻はUNIX時間(秒). delivered_atは送信済みでない場合0
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL,
+    last_status_code INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    delivered_at INTEGER NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status_next_attempt_at ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_id ON webhook_deliveries (subscription_id);
+
+-- スキーマのバージョン. 起動時と/readyzでdb.SchemaVersionと一致するか確認する
+-- テーブルや列を変更した場合は、db.SchemaVersionと合わせて1増やし、既存のDBを更新するためのmigrationをdb/migrate.goに追加する
+PRAGMA user_version = 2;
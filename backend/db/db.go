@@ -1,10 +1,25 @@
 package db
 
 import (
+	"context"
 	"errors"
 )
 
-var ErrUserNotFound = errors.New("user not found")
+var (
+	ErrUserNotFound          = errors.New("user not found")
+	ErrRequestNotFound       = errors.New("request not found")
+	ErrSubmissionNotFound    = errors.New("submission not found")
+	ErrThrottleNotFound      = errors.New("login throttle not found")
+	ErrPasswordResetNotFound = errors.New("password reset not found")
+	ErrTOTPNotFound          = errors.New("totp not found")
+	ErrRecoveryCodeNotFound  = errors.New("recovery code not found")
+	ErrAPITokenNotFound      = errors.New("api token not found")
+	ErrOrganizationNotFound  = errors.New("organization not found")
+	ErrGroupNotFound         = errors.New("group not found")
+	ErrNotificationNotFound  = errors.New("notification not found")
+	ErrWebhookNotFound       = errors.New("webhook not found")
+	ErrUserAlreadyExists     = errors.New("user already exists")
+)
 
 type User struct {
 	ID        int
@@ -13,15 +28,117 @@ type User struct {
 	Name      string
 	Role      int
 	CreatedAt string
+	// パスワード変更のたびに増える。これより古いセッションは無効
+	SessionVersion int
+	// OIDCでログインする際の紐づけに使う。未設定の場合は空文字
+	Email string
+	// 所属する組織(店舗)
+	OrganizationID int
+	// 無効にした日時(UNIX時間)。有効なユーザーは0
+	// 無効なユーザーはログインできず、APIトークンも使えない
+	DeactivatedAt int64
+}
+
+// 組織を指定せずに作成したユーザーが所属する組織のID
+// create.sqlで作成する
+const DefaultOrganizationID = 1
+
+// 組織(店舗)
+// ユーザーとシフトリクエストはいずれか1つの組織に所属する
+type Organization struct {
+	ID        int
+	Name      string
+	CreatedAt string
+}
+
+// 組織内の従業員のグループ(キッチン、ホールなど)
+type Group struct {
+	ID             int
+	OrganizationID int
+	Name           string
+	CreatedAt      string
+}
+
+type GroupMember struct {
+	GroupID int
+	UserID  int
 }
 
 type Request struct {
+	ID             int
+	OrganizationID int
+	CreatorID      int
+	StartDate      string
+	EndDate        string
+	Deadline       string
+	CreatedAt      string
+}
+
+// リクエスト一覧のソートキー
+const (
+	RequestSortCreatedAt = "created_at"
+	RequestSortStartDate = "start_date"
+	RequestSortDeadline  = "deadline"
+)
+
+// ソートキーに対応するカラムの値を返す
+func (r Request) SortValue(sort string) string {
+	switch sort {
+	case RequestSortStartDate:
+		return r.StartDate
+	case RequestSortDeadline:
+		return r.Deadline
+	default:
+		return r.CreatedAt
+	}
+}
+
+// シフトリクエストの対象のユーザーとグループ
+// どちらも空の場合は組織の全員が対象
+type RequestTargets struct {
+	UserIDs  []int
+	GroupIDs []int
+}
+
+// リクエスト一覧のページングで、前ページ最後のリクエストを表す
+type RequestCursor struct {
+	SortValue string
 	ID        int
+}
+
+// リクエスト一覧の検索条件
+// 文字列やIDがゼロ値の条件は絞り込みに使われない
+// ただし、OrganizationIDは他の組織のリクエストを返さないように常に絞り込みに使う
+type RequestQuery struct {
+	OrganizationID int
+
+	// 期間 [From, To] と重なるリクエストに絞り込む
+	From string
+	To   string
+
+	// deadline > DeadlineAfter のリクエストに絞り込む
+	DeadlineAfter string
+	// deadline <= DeadlineNotAfter のリクエストに絞り込む
+	DeadlineNotAfter string
+
 	CreatorID int
-	StartDate string
-	EndDate   string
-	Deadline  string
-	CreatedAt string
+
+	// TargetUserIDのユーザーが対象のリクエストに絞り込む
+	// 対象が指定されていないリクエストは全員が対象
+	TargetUserID int
+
+	// SubmitterIDのユーザーが提出済み(true)か未提出(false)かで絞り込む
+	SubmitterID   int
+	HasSubmission bool
+
+	// RequestSort* のいずれか。同じ値の場合はIDで並べる
+	Sort string
+	Desc bool
+
+	// このカーソルより後のリクエストだけを返す
+	After *RequestCursor
+	// 0の場合は件数を制限しない
+	Limit int
 }
 
 type Entry struct {
@@ -39,15 +156,250 @@ type Submission struct {
 	UpdatedAt   string
 }
 
+// 監査ログのイベント
+// Before, Afterは変更前後の状態のJSON。存在しない場合は空文字
+type AuditEvent struct {
+	ID             int
+	OrganizationID int // 操作したユーザー、不明な場合は対象のユーザーの組織。どちらも不明な場合は0(どの組織の監査ログにも含めない)
+	ActorID        int // 操作したユーザー。不明な場合は0
+	Action         string
+	TargetType     string
+	TargetID       int
+	Before         string
+	After          string
+	ClientIP       string
+	CreatedAt      string
+}
+
+// 監査ログの検索条件
+// 文字列やIDがゼロ値の条件は絞り込みに使われない
+// ただし、OrganizationIDは常に絞り込みに使う
+type AuditQuery struct {
+	OrganizationID int
+
+	ActorID    int
+	Action     string
+	TargetType string
+	TargetID   int
+
+	// Since <= created_at < Until のイベントに絞り込む
+	Since string
+	Until string
+
+	// このIDより前のイベントだけを返す
+	BeforeID int
+	// 0の場合は件数を制限しない
+	Limit int
+}
+
+// ログイン試行の失敗状況
+// Keyは"ip:<IPアドレス>"や"login_id:<ログインID>"の形式
+// 時刻はUNIX時間(秒)
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt int64
+	BlockedUntil  int64
+}
+
+// パスワードリセット用のワンタイムトークン
+// トークンそのものは保存せず、SHA-256のハッシュ値だけを保存する
+// 時刻はUNIX時間(秒)。UsedAtは未使用の場合0
+type PasswordReset struct {
+	TokenHash string
+	UserID    int
+	CreatorID int
+	ExpiresAt int64
+	UsedAt    int64
+}
+
+// ユーザーの二要素認証(TOTP)の設定
+// ワンタイムパスワードの計算に必要なので、Secretはそのまま保存する
+// 時刻はUNIX時間(秒)。ConfirmedAtは登録が完了していない場合0
+// LastUsedStepは同じコードの再利用を防ぐため、最後に使われた時間ステップ
+type UserTOTP struct {
+	UserID       int
+	Secret       string
+	ConfirmedAt  int64
+	LastUsedStep int64
+}
+
+// 送信済みの通知
+// 再起動しても同じ通知を送らないように、種類、シフトリクエスト、受信者の組ごとに記録する
+// SentAtはUNIX時間(秒)
+type NotificationDelivery struct {
+	Kind      string
+	RequestID int
+	UserID    int
+	SentAt    int64
+}
+
+// アプリ内でユーザーに表示する通知
+// RequestIDは関連するシフトリクエスト。ない場合は0
+// DedupKeyが同じ通知は1ユーザーに1件だけ作成する。空の場合は重複を確認しない
+// ReadAtはUNIX時間(秒)で、未読の場合0
+type UserNotification struct {
+	ID        int
+	UserID    int
+	Kind      string
+	RequestID int
+	Title     string
+	Body      string
+	DedupKey  string
+	CreatedAt string
+	ReadAt    int64
+}
+
+// アプリ内の通知の検索条件
+type UserNotificationQuery struct {
+	UserID     int
+	UnreadOnly bool
+	// このIDより前の通知だけを返す
+	BeforeID int
+	// 0の場合は件数を制限しない
+	Limit int
+}
+
+// 外部のサービスにイベントを送るWebhookの登録
+// Eventsはスペース区切り。Secretは署名に使うのでそのまま保存する
+type WebhookSubscription struct {
+	ID             int
+	OrganizationID int
+	CreatorID      int
+	URL            string
+	Secret         string
+	Events         string
+	CreatedAt      string
+}
+
+// Webhookの送信状況
+const (
+	WebhookDeliveryPending   = "pending"   // 送信待ち、または再送待ち
+	WebhookDeliverySucceeded = "succeeded" // 送信済み
+	WebhookDeliveryFailed    = "failed"    // 再送の上限に達した
+)
+
+// Webhookで送るイベント1件の送信状況
+// 時刻はUNIX時間(秒)。DeliveredAtは送信済みでない場合0
+// LastStatusCodeはレスポンスがなかった場合0
+type WebhookDelivery struct {
+	ID             int
+	SubscriptionID int
+	Event          string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  int64
+	LastStatusCode int
+	LastError      string
+	CreatedAt      string
+	DeliveredAt    int64
+}
+
+// Webhookの送信状況の検索条件
+type WebhookDeliveryQuery struct {
+	SubscriptionID int
+	// このIDより前の送信状況だけを返す
+	BeforeID int
+	// 0の場合は件数を制限しない
+	Limit int
+}
+
+// スクリプトなどから使うAPIトークン
+// トークンそのものは保存せず、SHA-256のハッシュ値だけを保存する
+// Scopesはスペース区切り。時刻はUNIX時間(秒)で、0は未設定(期限なし、未使用、未失効)
+type APIToken struct {
+	ID         int
+	UserID     int
+	CreatorID  int
+	Name       string
+	TokenHash  string
+	Scopes     string
+	CreatedAt  string
+	ExpiresAt  int64
+	LastUsedAt int64
+	RevokedAt  int64
+}
+
+// create.sqlで設定するスキーマのバージョン(PRAGMA user_version)
+// create.sqlを変更した場合は両方を1増やし、migrationsにも追加する
+const SchemaVersion = 2
+
 type DB interface {
-	GetUserByID(id int) (User, error)
-	GetUserByLoginID(loginID string) (User, error)
-	GetRequests() ([]Request, error)
-	GetRequestByID(id int) (Request, error)
-	GetEntriesBySubmissionID(submissionID int) ([]Entry, error)
-	GetSubmissionsByRequestID(requestID int) ([]Submission, error)
-	GetSubmissionByRequestIDAndSubmitterID(requestID int, submitterID int) (*Submission, error)
-	CreateRequest(creatorID int, startDate string, endDate string, deadline string) (int, error)
-	CreateEntries(entries []Entry) ([]int, error)
-	CreateSubmission(submitterID int, requestID int) (int, error)
+	// DBに接続できるか確認する
+	Ping(ctx context.Context) error
+	// DBに適用されているスキーマのバージョンを返す
+	GetSchemaVersion(ctx context.Context) (int, error)
+	GetUserByID(ctx context.Context, id int) (User, error)
+	GetUsersByIDs(ctx context.Context, ids []int) ([]User, error)
+	GetUserByLoginID(ctx context.Context, loginID string) (User, error)
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	GetUserByIdentity(ctx context.Context, issuer string, subject string) (User, error)
+	CreateUserIdentity(ctx context.Context, issuer string, subject string, userID int) error
+	CreateUser(ctx context.Context, user User) (int, error)
+	DeactivateUser(ctx context.Context, userID int, deactivatedAt int64) error
+	GetOrganizations(ctx context.Context) ([]Organization, error)
+	GetOrganizationByID(ctx context.Context, id int) (Organization, error)
+	CreateOrganization(ctx context.Context, name string) (int, error)
+	GetUsersByOrganizationID(ctx context.Context, organizationID int) ([]User, error)
+	UpdateUserOrganization(ctx context.Context, userID int, organizationID int) error
+	GetGroupsByOrganizationID(ctx context.Context, organizationID int) ([]Group, error)
+	GetGroupByID(ctx context.Context, id int) (Group, error)
+	GetGroupsByIDs(ctx context.Context, ids []int) ([]Group, error)
+	CreateGroup(ctx context.Context, organizationID int, name string) (int, error)
+	GetGroupMembers(ctx context.Context, groupIDs []int) ([]GroupMember, error)
+	GetGroupIDsByUserID(ctx context.Context, userID int) ([]int, error)
+	AddGroupMember(ctx context.Context, groupID int, userID int) error
+	RemoveGroupMember(ctx context.Context, groupID int, userID int) error
+	GetRequests(ctx context.Context, organizationID int) ([]Request, error)
+	QueryRequests(ctx context.Context, query RequestQuery) ([]Request, error)
+	GetRequestByID(ctx context.Context, id int) (Request, error)
+	GetEntriesBySubmissionID(ctx context.Context, submissionID int) ([]Entry, error)
+	GetEntriesBySubmissionIDs(ctx context.Context, submissionIDs []int) ([]Entry, error)
+	GetSubmissionsByRequestID(ctx context.Context, requestID int) ([]Submission, error)
+	GetSubmissionByRequestIDAndSubmitterID(ctx context.Context, requestID int, submitterID int) (*Submission, error)
+	GetSubmissionByID(ctx context.Context, id int) (*Submission, error)
+	CreateRequest(ctx context.Context, organizationID int, creatorID int, startDate string, endDate string, deadline string, targets RequestTargets) (int, error)
+	UpdateRequestDeadline(ctx context.Context, requestID int, deadline string) error
+	CreateRequestTargets(ctx context.Context, requestID int, targets RequestTargets) error
+	GetRequestTargets(ctx context.Context, requestID int) (RequestTargets, error)
+	CreateEntries(ctx context.Context, entries []Entry) ([]int, error)
+	CreateSubmission(ctx context.Context, submitterID int, requestID int) (int, error)
+	ReplaceSubmissionEntries(ctx context.Context, submissionID int, entries []Entry) error
+	CreateAuditEvent(ctx context.Context, event AuditEvent) (int, error)
+	QueryAuditEvents(ctx context.Context, query AuditQuery) ([]AuditEvent, error)
+	GetLoginThrottle(ctx context.Context, key string) (LoginThrottle, error)
+	SaveLoginThrottle(ctx context.Context, throttle LoginThrottle) error
+	IncrementLoginFailures(ctx context.Context, key string, failedAt int64, resetBefore int64) (int, error)
+	ExtendLoginBlock(ctx context.Context, key string, blockedUntil int64) error
+	DeleteLoginThrottle(ctx context.Context, key string) error
+	UpdateUserPassword(ctx context.Context, userID int, password string) error
+	CreatePasswordReset(ctx context.Context, reset PasswordReset) error
+	GetPasswordReset(ctx context.Context, tokenHash string) (PasswordReset, error)
+	UsePasswordReset(ctx context.Context, tokenHash string, usedAt int64) error
+	GetUserTOTP(ctx context.Context, userID int) (UserTOTP, error)
+	SaveUserTOTP(ctx context.Context, totp UserTOTP) error
+	DeleteUserTOTP(ctx context.Context, userID int) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string, usedAt int64) error
+	CreateAPIToken(ctx context.Context, token APIToken) (int, error)
+	GetAPITokensByOrganizationID(ctx context.Context, organizationID int) ([]APIToken, error)
+	GetAPITokenByID(ctx context.Context, id int) (APIToken, error)
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (APIToken, error)
+	RevokeAPIToken(ctx context.Context, id int, revokedAt int64) error
+	TouchAPIToken(ctx context.Context, id int, usedAt int64) error
+	GetNotificationDeliveries(ctx context.Context, kind string, requestID int) ([]NotificationDelivery, error)
+	CreateNotificationDelivery(ctx context.Context, delivery NotificationDelivery) error
+	CreateUserNotification(ctx context.Context, notification UserNotification) (int, error)
+	QueryUserNotifications(ctx context.Context, query UserNotificationQuery) ([]UserNotification, error)
+	CountUnreadUserNotifications(ctx context.Context, userID int) (int, error)
+	ReadUserNotification(ctx context.Context, id int, userID int, readAt int64) error
+	CreateWebhookSubscription(ctx context.Context, subscription WebhookSubscription) (int, error)
+	GetWebhookSubscriptionsByOrganizationID(ctx context.Context, organizationID int) ([]WebhookSubscription, error)
+	GetWebhookSubscriptionByID(ctx context.Context, id int) (WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, id int) error
+	CreateWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error
+	QueryWebhookDeliveries(ctx context.Context, query WebhookDeliveryQuery) ([]WebhookDelivery, error)
+	GetDueWebhookDeliveries(ctx context.Context, now int64, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error
 }
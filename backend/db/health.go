@@ -0,0 +1,25 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DBのスキーマのバージョンがSchemaVersionと一致しない
+var ErrSchemaVersionMismatch = errors.New("schema version mismatch")
+
+// DBに接続でき、最新のスキーマが適用されているか確認する
+func CheckReady(ctx context.Context, d DB) error {
+	if err := d.Ping(ctx); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	version, err := d.GetSchemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("schema version: %w", err)
+	}
+	if version != SchemaVersion {
+		return fmt.Errorf("%w: want %d, got %d", ErrSchemaVersionMismatch, SchemaVersion, version)
+	}
+	return nil
+}
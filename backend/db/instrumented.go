@@ -0,0 +1,392 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// メソッドごとの処理時間を記録するDB
+// DBインターフェースにメソッドを追加した場合は、ここにも追加する
+type instrumentedDB struct {
+	db      DB
+	observe func(method string, duration time.Duration)
+}
+
+// innerの各メソッドの処理時間をobserveに渡すDBを作成する
+func NewInstrumentedDB(inner DB, observe func(method string, duration time.Duration)) DB {
+	return &instrumentedDB{db: inner, observe: observe}
+}
+
+func (d *instrumentedDB) record(method string, start time.Time) {
+	d.observe(method, time.Since(start))
+}
+
+func (d *instrumentedDB) Ping(ctx context.Context) error {
+	defer d.record("Ping", time.Now())
+	return d.db.Ping(ctx)
+}
+
+func (d *instrumentedDB) GetSchemaVersion(ctx context.Context) (int, error) {
+	defer d.record("GetSchemaVersion", time.Now())
+	return d.db.GetSchemaVersion(ctx)
+}
+
+func (d *instrumentedDB) GetUserByID(ctx context.Context, id int) (User, error) {
+	defer d.record("GetUserByID", time.Now())
+	return d.db.GetUserByID(ctx, id)
+}
+
+func (d *instrumentedDB) GetUsersByIDs(ctx context.Context, ids []int) ([]User, error) {
+	defer d.record("GetUsersByIDs", time.Now())
+	return d.db.GetUsersByIDs(ctx, ids)
+}
+
+func (d *instrumentedDB) GetUserByLoginID(ctx context.Context, loginID string) (User, error) {
+	defer d.record("GetUserByLoginID", time.Now())
+	return d.db.GetUserByLoginID(ctx, loginID)
+}
+
+func (d *instrumentedDB) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	defer d.record("GetUserByEmail", time.Now())
+	return d.db.GetUserByEmail(ctx, email)
+}
+
+func (d *instrumentedDB) GetUserByIdentity(ctx context.Context, issuer string, subject string) (User, error) {
+	defer d.record("GetUserByIdentity", time.Now())
+	return d.db.GetUserByIdentity(ctx, issuer, subject)
+}
+
+func (d *instrumentedDB) CreateUserIdentity(ctx context.Context, issuer string, subject string, userID int) error {
+	defer d.record("CreateUserIdentity", time.Now())
+	return d.db.CreateUserIdentity(ctx, issuer, subject, userID)
+}
+
+func (d *instrumentedDB) CreateUser(ctx context.Context, user User) (int, error) {
+	defer d.record("CreateUser", time.Now())
+	return d.db.CreateUser(ctx, user)
+}
+
+func (d *instrumentedDB) DeactivateUser(ctx context.Context, userID int, deactivatedAt int64) error {
+	defer d.record("DeactivateUser", time.Now())
+	return d.db.DeactivateUser(ctx, userID, deactivatedAt)
+}
+
+func (d *instrumentedDB) GetOrganizations(ctx context.Context) ([]Organization, error) {
+	defer d.record("GetOrganizations", time.Now())
+	return d.db.GetOrganizations(ctx)
+}
+
+func (d *instrumentedDB) GetOrganizationByID(ctx context.Context, id int) (Organization, error) {
+	defer d.record("GetOrganizationByID", time.Now())
+	return d.db.GetOrganizationByID(ctx, id)
+}
+
+func (d *instrumentedDB) CreateOrganization(ctx context.Context, name string) (int, error) {
+	defer d.record("CreateOrganization", time.Now())
+	return d.db.CreateOrganization(ctx, name)
+}
+
+func (d *instrumentedDB) GetUsersByOrganizationID(ctx context.Context, organizationID int) ([]User, error) {
+	defer d.record("GetUsersByOrganizationID", time.Now())
+	return d.db.GetUsersByOrganizationID(ctx, organizationID)
+}
+
+func (d *instrumentedDB) UpdateUserOrganization(ctx context.Context, userID int, organizationID int) error {
+	defer d.record("UpdateUserOrganization", time.Now())
+	return d.db.UpdateUserOrganization(ctx, userID, organizationID)
+}
+
+func (d *instrumentedDB) GetGroupsByOrganizationID(ctx context.Context, organizationID int) ([]Group, error) {
+	defer d.record("GetGroupsByOrganizationID", time.Now())
+	return d.db.GetGroupsByOrganizationID(ctx, organizationID)
+}
+
+func (d *instrumentedDB) GetGroupByID(ctx context.Context, id int) (Group, error) {
+	defer d.record("GetGroupByID", time.Now())
+	return d.db.GetGroupByID(ctx, id)
+}
+
+func (d *instrumentedDB) GetGroupsByIDs(ctx context.Context, ids []int) ([]Group, error) {
+	defer d.record("GetGroupsByIDs", time.Now())
+	return d.db.GetGroupsByIDs(ctx, ids)
+}
+
+func (d *instrumentedDB) CreateGroup(ctx context.Context, organizationID int, name string) (int, error) {
+	defer d.record("CreateGroup", time.Now())
+	return d.db.CreateGroup(ctx, organizationID, name)
+}
+
+func (d *instrumentedDB) GetGroupMembers(ctx context.Context, groupIDs []int) ([]GroupMember, error) {
+	defer d.record("GetGroupMembers", time.Now())
+	return d.db.GetGroupMembers(ctx, groupIDs)
+}
+
+func (d *instrumentedDB) GetGroupIDsByUserID(ctx context.Context, userID int) ([]int, error) {
+	defer d.record("GetGroupIDsByUserID", time.Now())
+	return d.db.GetGroupIDsByUserID(ctx, userID)
+}
+
+func (d *instrumentedDB) AddGroupMember(ctx context.Context, groupID int, userID int) error {
+	defer d.record("AddGroupMember", time.Now())
+	return d.db.AddGroupMember(ctx, groupID, userID)
+}
+
+func (d *instrumentedDB) RemoveGroupMember(ctx context.Context, groupID int, userID int) error {
+	defer d.record("RemoveGroupMember", time.Now())
+	return d.db.RemoveGroupMember(ctx, groupID, userID)
+}
+
+func (d *instrumentedDB) GetRequests(ctx context.Context, organizationID int) ([]Request, error) {
+	defer d.record("GetRequests", time.Now())
+	return d.db.GetRequests(ctx, organizationID)
+}
+
+func (d *instrumentedDB) QueryRequests(ctx context.Context, query RequestQuery) ([]Request, error) {
+	defer d.record("QueryRequests", time.Now())
+	return d.db.QueryRequests(ctx, query)
+}
+
+func (d *instrumentedDB) GetRequestByID(ctx context.Context, id int) (Request, error) {
+	defer d.record("GetRequestByID", time.Now())
+	return d.db.GetRequestByID(ctx, id)
+}
+
+func (d *instrumentedDB) GetEntriesBySubmissionID(ctx context.Context, submissionID int) ([]Entry, error) {
+	defer d.record("GetEntriesBySubmissionID", time.Now())
+	return d.db.GetEntriesBySubmissionID(ctx, submissionID)
+}
+
+func (d *instrumentedDB) GetEntriesBySubmissionIDs(ctx context.Context, submissionIDs []int) ([]Entry, error) {
+	defer d.record("GetEntriesBySubmissionIDs", time.Now())
+	return d.db.GetEntriesBySubmissionIDs(ctx, submissionIDs)
+}
+
+func (d *instrumentedDB) GetSubmissionsByRequestID(ctx context.Context, requestID int) ([]Submission, error) {
+	defer d.record("GetSubmissionsByRequestID", time.Now())
+	return d.db.GetSubmissionsByRequestID(ctx, requestID)
+}
+
+func (d *instrumentedDB) GetSubmissionByRequestIDAndSubmitterID(ctx context.Context, requestID int, submitterID int) (*Submission, error) {
+	defer d.record("GetSubmissionByRequestIDAndSubmitterID", time.Now())
+	return d.db.GetSubmissionByRequestIDAndSubmitterID(ctx, requestID, submitterID)
+}
+
+func (d *instrumentedDB) CreateRequest(ctx context.Context, organizationID int, creatorID int, startDate string, endDate string, deadline string, targets RequestTargets) (int, error) {
+	defer d.record("CreateRequest", time.Now())
+	return d.db.CreateRequest(ctx, organizationID, creatorID, startDate, endDate, deadline, targets)
+}
+
+func (d *instrumentedDB) UpdateRequestDeadline(ctx context.Context, requestID int, deadline string) error {
+	defer d.record("UpdateRequestDeadline", time.Now())
+	return d.db.UpdateRequestDeadline(ctx, requestID, deadline)
+}
+
+func (d *instrumentedDB) CreateRequestTargets(ctx context.Context, requestID int, targets RequestTargets) error {
+	defer d.record("CreateRequestTargets", time.Now())
+	return d.db.CreateRequestTargets(ctx, requestID, targets)
+}
+
+func (d *instrumentedDB) GetRequestTargets(ctx context.Context, requestID int) (RequestTargets, error) {
+	defer d.record("GetRequestTargets", time.Now())
+	return d.db.GetRequestTargets(ctx, requestID)
+}
+
+func (d *instrumentedDB) CreateEntries(ctx context.Context, entries []Entry) ([]int, error) {
+	defer d.record("CreateEntries", time.Now())
+	return d.db.CreateEntries(ctx, entries)
+}
+
+func (d *instrumentedDB) GetSubmissionByID(ctx context.Context, id int) (*Submission, error) {
+	defer d.record("GetSubmissionByID", time.Now())
+	return d.db.GetSubmissionByID(ctx, id)
+}
+
+func (d *instrumentedDB) ReplaceSubmissionEntries(ctx context.Context, submissionID int, entries []Entry) error {
+	defer d.record("ReplaceSubmissionEntries", time.Now())
+	return d.db.ReplaceSubmissionEntries(ctx, submissionID, entries)
+}
+
+func (d *instrumentedDB) CreateSubmission(ctx context.Context, submitterID int, requestID int) (int, error) {
+	defer d.record("CreateSubmission", time.Now())
+	return d.db.CreateSubmission(ctx, submitterID, requestID)
+}
+
+func (d *instrumentedDB) CreateAuditEvent(ctx context.Context, event AuditEvent) (int, error) {
+	defer d.record("CreateAuditEvent", time.Now())
+	return d.db.CreateAuditEvent(ctx, event)
+}
+
+func (d *instrumentedDB) QueryAuditEvents(ctx context.Context, query AuditQuery) ([]AuditEvent, error) {
+	defer d.record("QueryAuditEvents", time.Now())
+	return d.db.QueryAuditEvents(ctx, query)
+}
+
+func (d *instrumentedDB) GetLoginThrottle(ctx context.Context, key string) (LoginThrottle, error) {
+	defer d.record("GetLoginThrottle", time.Now())
+	return d.db.GetLoginThrottle(ctx, key)
+}
+
+func (d *instrumentedDB) SaveLoginThrottle(ctx context.Context, throttle LoginThrottle) error {
+	defer d.record("SaveLoginThrottle", time.Now())
+	return d.db.SaveLoginThrottle(ctx, throttle)
+}
+
+func (d *instrumentedDB) IncrementLoginFailures(ctx context.Context, key string, failedAt int64, resetBefore int64) (int, error) {
+	defer d.record("IncrementLoginFailures", time.Now())
+	return d.db.IncrementLoginFailures(ctx, key, failedAt, resetBefore)
+}
+
+func (d *instrumentedDB) ExtendLoginBlock(ctx context.Context, key string, blockedUntil int64) error {
+	defer d.record("ExtendLoginBlock", time.Now())
+	return d.db.ExtendLoginBlock(ctx, key, blockedUntil)
+}
+
+func (d *instrumentedDB) DeleteLoginThrottle(ctx context.Context, key string) error {
+	defer d.record("DeleteLoginThrottle", time.Now())
+	return d.db.DeleteLoginThrottle(ctx, key)
+}
+
+func (d *instrumentedDB) UpdateUserPassword(ctx context.Context, userID int, password string) error {
+	defer d.record("UpdateUserPassword", time.Now())
+	return d.db.UpdateUserPassword(ctx, userID, password)
+}
+
+func (d *instrumentedDB) CreatePasswordReset(ctx context.Context, reset PasswordReset) error {
+	defer d.record("CreatePasswordReset", time.Now())
+	return d.db.CreatePasswordReset(ctx, reset)
+}
+
+func (d *instrumentedDB) GetPasswordReset(ctx context.Context, tokenHash string) (PasswordReset, error) {
+	defer d.record("GetPasswordReset", time.Now())
+	return d.db.GetPasswordReset(ctx, tokenHash)
+}
+
+func (d *instrumentedDB) UsePasswordReset(ctx context.Context, tokenHash string, usedAt int64) error {
+	defer d.record("UsePasswordReset", time.Now())
+	return d.db.UsePasswordReset(ctx, tokenHash, usedAt)
+}
+
+func (d *instrumentedDB) GetUserTOTP(ctx context.Context, userID int) (UserTOTP, error) {
+	defer d.record("GetUserTOTP", time.Now())
+	return d.db.GetUserTOTP(ctx, userID)
+}
+
+func (d *instrumentedDB) SaveUserTOTP(ctx context.Context, totp UserTOTP) error {
+	defer d.record("SaveUserTOTP", time.Now())
+	return d.db.SaveUserTOTP(ctx, totp)
+}
+
+func (d *instrumentedDB) DeleteUserTOTP(ctx context.Context, userID int) error {
+	defer d.record("DeleteUserTOTP", time.Now())
+	return d.db.DeleteUserTOTP(ctx, userID)
+}
+
+func (d *instrumentedDB) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	defer d.record("ReplaceRecoveryCodes", time.Now())
+	return d.db.ReplaceRecoveryCodes(ctx, userID, codeHashes)
+}
+
+func (d *instrumentedDB) UseRecoveryCode(ctx context.Context, userID int, codeHash string, usedAt int64) error {
+	defer d.record("UseRecoveryCode", time.Now())
+	return d.db.UseRecoveryCode(ctx, userID, codeHash, usedAt)
+}
+
+func (d *instrumentedDB) CreateAPIToken(ctx context.Context, token APIToken) (int, error) {
+	defer d.record("CreateAPIToken", time.Now())
+	return d.db.CreateAPIToken(ctx, token)
+}
+
+func (d *instrumentedDB) GetAPITokensByOrganizationID(ctx context.Context, organizationID int) ([]APIToken, error) {
+	defer d.record("GetAPITokensByOrganizationID", time.Now())
+	return d.db.GetAPITokensByOrganizationID(ctx, organizationID)
+}
+
+func (d *instrumentedDB) GetAPITokenByID(ctx context.Context, id int) (APIToken, error) {
+	defer d.record("GetAPITokenByID", time.Now())
+	return d.db.GetAPITokenByID(ctx, id)
+}
+
+func (d *instrumentedDB) GetAPITokenByHash(ctx context.Context, tokenHash string) (APIToken, error) {
+	defer d.record("GetAPITokenByHash", time.Now())
+	return d.db.GetAPITokenByHash(ctx, tokenHash)
+}
+
+func (d *instrumentedDB) RevokeAPIToken(ctx context.Context, id int, revokedAt int64) error {
+	defer d.record("RevokeAPIToken", time.Now())
+	return d.db.RevokeAPIToken(ctx, id, revokedAt)
+}
+
+func (d *instrumentedDB) TouchAPIToken(ctx context.Context, id int, usedAt int64) error {
+	defer d.record("TouchAPIToken", time.Now())
+	return d.db.TouchAPIToken(ctx, id, usedAt)
+}
+
+func (d *instrumentedDB) GetNotificationDeliveries(ctx context.Context, kind string, requestID int) ([]NotificationDelivery, error) {
+	defer d.record("GetNotificationDeliveries", time.Now())
+	return d.db.GetNotificationDeliveries(ctx, kind, requestID)
+}
+
+func (d *instrumentedDB) CreateNotificationDelivery(ctx context.Context, delivery NotificationDelivery) error {
+	defer d.record("CreateNotificationDelivery", time.Now())
+	return d.db.CreateNotificationDelivery(ctx, delivery)
+}
+
+func (d *instrumentedDB) CreateUserNotification(ctx context.Context, notification UserNotification) (int, error) {
+	defer d.record("CreateUserNotification", time.Now())
+	return d.db.CreateUserNotification(ctx, notification)
+}
+
+func (d *instrumentedDB) QueryUserNotifications(ctx context.Context, query UserNotificationQuery) ([]UserNotification, error) {
+	defer d.record("QueryUserNotifications", time.Now())
+	return d.db.QueryUserNotifications(ctx, query)
+}
+
+func (d *instrumentedDB) CountUnreadUserNotifications(ctx context.Context, userID int) (int, error) {
+	defer d.record("CountUnreadUserNotifications", time.Now())
+	return d.db.CountUnreadUserNotifications(ctx, userID)
+}
+
+func (d *instrumentedDB) ReadUserNotification(ctx context.Context, id int, userID int, readAt int64) error {
+	defer d.record("ReadUserNotification", time.Now())
+	return d.db.ReadUserNotification(ctx, id, userID, readAt)
+}
+
+func (d *instrumentedDB) CreateWebhookSubscription(ctx context.Context, subscription WebhookSubscription) (int, error) {
+	defer d.record("CreateWebhookSubscription", time.Now())
+	return d.db.CreateWebhookSubscription(ctx, subscription)
+}
+
+func (d *instrumentedDB) GetWebhookSubscriptionsByOrganizationID(ctx context.Context, organizationID int) ([]WebhookSubscription, error) {
+	defer d.record("GetWebhookSubscriptionsByOrganizationID", time.Now())
+	return d.db.GetWebhookSubscriptionsByOrganizationID(ctx, organizationID)
+}
+
+func (d *instrumentedDB) GetWebhookSubscriptionByID(ctx context.Context, id int) (WebhookSubscription, error) {
+	defer d.record("GetWebhookSubscriptionByID", time.Now())
+	return d.db.GetWebhookSubscriptionByID(ctx, id)
+}
+
+func (d *instrumentedDB) DeleteWebhookSubscription(ctx context.Context, id int) error {
+	defer d.record("DeleteWebhookSubscription", time.Now())
+	return d.db.DeleteWebhookSubscription(ctx, id)
+}
+
+func (d *instrumentedDB) CreateWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error {
+	defer d.record("CreateWebhookDeliveries", time.Now())
+	return d.db.CreateWebhookDeliveries(ctx, deliveries)
+}
+
+func (d *instrumentedDB) QueryWebhookDeliveries(ctx context.Context, query WebhookDeliveryQuery) ([]WebhookDelivery, error) {
+	defer d.record("QueryWebhookDeliveries", time.Now())
+	return d.db.QueryWebhookDeliveries(ctx, query)
+}
+
+func (d *instrumentedDB) GetDueWebhookDeliveries(ctx context.Context, now int64, limit int) ([]WebhookDelivery, error) {
+	defer d.record("GetDueWebhookDeliveries", time.Now())
+	return d.db.GetDueWebhookDeliveries(ctx, now, limit)
+}
+
+func (d *instrumentedDB) UpdateWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	defer d.record("UpdateWebhookDelivery", time.Now())
+	return d.db.UpdateWebhookDelivery(ctx, delivery)
+}
@@ -0,0 +1,330 @@
+package db
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// 最新のスキーマ. 新しいDBにはこれをそのまま適用する
+//
+//go:embed create.sql
+var createSQL string
+
+// 既存のDBを1つ前のバージョンから更新するSQL
+// create.sqlを変更した場合は、同じ変更をここにも追加する
+// Statementsは1つのトランザクションで順に実行する
+type migration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "バージョンを管理する前のDB(最初のcreate.sql)を更新する",
+		Statements: []string{
+			// 組織. 既存のユーザーとシフトリクエストはデフォルトの組織に所属させる
+			`CREATE TABLE IF NOT EXISTS organizations (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+			)`,
+			"INSERT OR IGNORE INTO organizations (id, name) VALUES (1, 'デフォルト')",
+			"ALTER TABLE users ADD COLUMN organization_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id)",
+			"UPDATE users SET organization_id = 1",
+			"ALTER TABLE requests ADD COLUMN organization_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id)",
+			"UPDATE requests SET organization_id = 1",
+			"CREATE INDEX IF NOT EXISTS requests_organization ON requests (organization_id)",
+			// 監査ログ
+			`CREATE TABLE IF NOT EXISTS audit_events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				organization_id INTEGER NOT NULL DEFAULT 1,
+				actor_id INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				target_type TEXT NOT NULL,
+				target_id INTEGER NOT NULL,
+				before TEXT NOT NULL,
+				after TEXT NOT NULL,
+				client_ip TEXT NOT NULL,
+				created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+			)`,
+			"CREATE INDEX IF NOT EXISTS audit_events_target ON audit_events (target_type, target_id)",
+			`CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+			BEGIN
+				SELECT RAISE(ABORT, 'audit_events is append-only');
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+			BEGIN
+				SELECT RAISE(ABORT, 'audit_events is append-only');
+			END`,
+			// ログインの失敗回数の制限
+			`CREATE TABLE IF NOT EXISTS login_throttles (
+				key TEXT PRIMARY KEY,
+				failures INTEGER NOT NULL,
+				last_failure_at INTEGER NOT NULL,
+				blocked_until INTEGER NOT NULL
+			)`,
+			// パスワードの変更とリセット. 既存のユーザーのセッションはそのまま使えるようにする
+			"ALTER TABLE users ADD COLUMN session_version INTEGER NOT NULL DEFAULT 0",
+			"UPDATE users SET session_version = 0",
+			`CREATE TABLE IF NOT EXISTS password_resets (
+				token_hash TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				creator_id INTEGER NOT NULL,
+				expires_at INTEGER NOT NULL,
+				used_at INTEGER NOT NULL DEFAULT 0,
+
+				FOREIGN KEY (user_id) REFERENCES users(id),
+				FOREIGN KEY (creator_id) REFERENCES users(id)
+			)`,
+			// 二要素認証
+			`CREATE TABLE IF NOT EXISTS user_totps (
+				user_id INTEGER PRIMARY KEY,
+				secret TEXT NOT NULL,
+				confirmed_at INTEGER NOT NULL DEFAULT 0,
+				last_used_step INTEGER NOT NULL DEFAULT 0,
+
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS recovery_codes (
+				user_id INTEGER NOT NULL,
+				code_hash TEXT NOT NULL,
+				used_at INTEGER NOT NULL DEFAULT 0,
+
+				PRIMARY KEY (user_id, code_hash),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			)`,
+			// OIDCでのログイン. UNIQUEの列はALTER TABLEで追加できないので、インデックスで重複を禁止する
+			// 既存のユーザーのメールアドレスは未設定(NULL)にする
+			"ALTER TABLE users ADD COLUMN email TEXT",
+			"CREATE UNIQUE INDEX IF NOT EXISTS users_email ON users (email)",
+			`CREATE TABLE IF NOT EXISTS user_identities (
+				issuer TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				user_id INTEGER NOT NULL,
+				created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+
+				PRIMARY KEY (issuer, subject),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			)`,
+			// APIトークン
+			`CREATE TABLE IF NOT EXISTS api_tokens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				creator_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				scopes TEXT NOT NULL,
+				created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+				expires_at INTEGER NOT NULL DEFAULT 0,
+				last_used_at INTEGER NOT NULL DEFAULT 0,
+				revoked_at INTEGER NOT NULL DEFAULT 0,
+
+				FOREIGN KEY (user_id) REFERENCES users(id),
+				FOREIGN KEY (creator_id) REFERENCES users(id)
+			)`,
+			// グループと対象を絞ったシフトリクエスト. 既存のシフトリクエストは組織の全員が対象のままにする
+			`CREATE TABLE IF NOT EXISTS user_groups (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				organization_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+
+				FOREIGN KEY (organization_id) REFERENCES organizations(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS user_group_members (
+				group_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+
+				PRIMARY KEY (group_id, user_id),
+				FOREIGN KEY (group_id) REFERENCES user_groups(id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			)`,
+			"CREATE INDEX IF NOT EXISTS user_group_members_user ON user_group_members (user_id)",
+			`CREATE TABLE IF NOT EXISTS request_target_users (
+				request_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+
+				PRIMARY KEY (request_id, user_id),
+				FOREIGN KEY (request_id) REFERENCES requests(id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS request_target_groups (
+				request_id INTEGER NOT NULL,
+				group_id INTEGER NOT NULL,
+
+				PRIMARY KEY (request_id, group_id),
+				FOREIGN KEY (request_id) REFERENCES requests(id),
+				FOREIGN KEY (group_id) REFERENCES user_groups(id)
+			)`,
+			// 通知
+			`CREATE TABLE IF NOT EXISTS notification_deliveries (
+				kind TEXT NOT NULL,
+				request_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				sent_at INTEGER NOT NULL,
+
+				PRIMARY KEY (kind, request_id, user_id),
+				FOREIGN KEY (request_id) REFERENCES requests(id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS user_notifications (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				kind TEXT NOT NULL,
+				request_id INTEGER NOT NULL DEFAULT 0,
+				title TEXT NOT NULL,
+				body TEXT NOT NULL,
+				dedup_key TEXT,
+				created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+				read_at INTEGER NOT NULL DEFAULT 0,
+
+				UNIQUE (user_id, dedup_key),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			)`,
+			"CREATE INDEX IF NOT EXISTS idx_user_notifications_user_id_read_at ON user_notifications (user_id, read_at)",
+			// Webhook
+			`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				organization_id INTEGER NOT NULL,
+				creator_id INTEGER NOT NULL,
+				url TEXT NOT NULL,
+				secret TEXT NOT NULL,
+				events TEXT NOT NULL,
+				created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+
+				FOREIGN KEY (organization_id) REFERENCES organizations(id),
+				FOREIGN KEY (creator_id) REFERENCES users(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				subscription_id INTEGER NOT NULL,
+				event TEXT NOT NULL,
+				payload TEXT NOT NULL,
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt_at INTEGER NOT NULL,
+				last_status_code INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT '',
+				created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+				delivered_at INTEGER NOT NULL DEFAULT 0,
+
+				FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions(id)
+			)`,
+			"CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status_next_attempt_at ON webhook_deliveries (status, next_attempt_at)",
+			"CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_id ON webhook_deliveries (subscription_id)",
+		},
+	},
+	{
+		Version:     2,
+		Description: "ユーザーを無効にできるようにする",
+		Statements:  []string{"ALTER TABLE users ADD COLUMN deactivated_at INTEGER NOT NULL DEFAULT 0"},
+	},
+}
+
+// 最初のcreate.sqlで作成したusersテーブルの列. バージョンが0のDBがこの状態の場合はバージョン1から更新する
+var baselineUserColumns = []string{"id", "login_id", "password", "name", "role", "created_at"}
+
+// バージョンのないDBが最初のcreate.sqlの状態ではないなど、更新の方法が分からない
+var ErrUnknownSchema = errors.New("unknown schema")
+
+// DBのスキーマを最新にする
+// 新しいDBにはcreate.sqlを適用し、既存のDBには現在のバージョンより新しいmigrationを順に適用する
+// 適用したバージョンを返す. 既に最新の場合は空
+func (db *Sqlite3DB) Migrate(ctx context.Context) ([]int, error) {
+	version, err := db.GetSchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("%w: want %d, got %d", ErrSchemaVersionMismatch, SchemaVersion, version)
+	}
+
+	if version == 0 {
+		var tables int
+		err := db.Conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'").Scan(&tables)
+		if err != nil {
+			return nil, err
+		}
+		if tables == 0 {
+			if err := db.execInTx(ctx, createSQL); err != nil {
+				return nil, fmt.Errorf("create.sql: %w", err)
+			}
+			return []int{SchemaVersion}, nil
+		}
+		// テーブルがある場合は、最初のcreate.sqlで作成したDBだけをmigrationで更新する
+		// それ以外はどこまで適用されているか分からないので更新しない
+		columns, err := db.tableColumns(ctx, "users")
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Equal(columns, baselineUserColumns) {
+			return nil, fmt.Errorf("%w: user_version is 0 but users has columns %v", ErrUnknownSchema, columns)
+		}
+	}
+
+	var applied []int
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		// PRAGMAではプレースホルダーを使えない
+		queries := append(slices.Clone(m.Statements), fmt.Sprintf("PRAGMA user_version = %d", m.Version))
+		if err := db.execInTx(ctx, queries...); err != nil {
+			return applied, fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+		applied = append(applied, m.Version)
+	}
+	return applied, nil
+}
+
+// テーブルの列名を定義の順に返す
+func (db *Sqlite3DB) tableColumns(ctx context.Context, table string) ([]string, error) {
+	rows, err := db.Conn.QueryContext(ctx, "SELECT name FROM pragma_table_info(?) ORDER BY cid", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// 1つのトランザクションでクエリを順に実行する
+func (db *Sqlite3DB) execInTx(ctx context.Context, queries ...string) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DBの内容をpathに書き出す
+// サーバーの実行中でも、書き込み途中の状態にならない一貫したコピーを作る
+// pathに既にファイルがある場合はエラー
+func (db *Sqlite3DB) Backup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s: %w", path, os.ErrExist)
+	}
+	_, err := db.Conn.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
@@ -0,0 +1,335 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func newTestSqlite3DB(t *testing.T) *Sqlite3DB {
+	t.Helper()
+	d, err := NewSqlite3DB(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	// 新しいDBにはcreate.sqlを適用する
+	d := newTestSqlite3DB(t)
+	applied, err := d.Migrate(ctx)
+	if err != nil || !slices.Equal(applied, []int{SchemaVersion}) {
+		t.Fatalf("want [%d], got %v, %v", SchemaVersion, applied, err)
+	}
+	if err := CheckReady(ctx, d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 最新の場合は何もしない
+	if applied, err := d.Migrate(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("want no migrations, got %v, %v", applied, err)
+	}
+
+	// バージョン1のDBはmigrationで最新にする
+	old := newTestSqlite3DB(t)
+	if _, err := old.Conn.Exec(`
+		CREATE TABLE organizations (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')));
+		INSERT INTO organizations (id, name) VALUES (1, 'デフォルト');
+		CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, login_id TEXT NOT NULL UNIQUE, password TEXT NOT NULL, name TEXT NOT NULL, role INTEGER NOT NULL, created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')), session_version INTEGER NOT NULL DEFAULT 0, email TEXT UNIQUE, organization_id INTEGER NOT NULL DEFAULT 1);
+		INSERT INTO users (login_id, password, name, role) VALUES ('old', 'x', '既存', 1);
+		PRAGMA user_version = 1;
+	`); err != nil {
+		t.Fatal(err)
+	}
+	applied, err = old.Migrate(ctx)
+	if err != nil || applied[len(applied)-1] != SchemaVersion {
+		t.Fatalf("want migrations up to %d, got %v, %v", SchemaVersion, applied, err)
+	}
+	if err := old.DeactivateUser(ctx, 1, 100); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	user, err := old.GetUserByLoginID(ctx, "old")
+	if err != nil || user.DeactivatedAt != 100 || user.SessionVersion != 1 {
+		t.Errorf("unexpected user: %+v, %v", user, err)
+	}
+
+	// バージョンのないDBは更新しない
+	unknown := newTestSqlite3DB(t)
+	unknown.Conn.Exec("CREATE TABLE users (id INTEGER)")
+	if _, err := unknown.Migrate(ctx); !errors.Is(err, ErrUnknownSchema) {
+		t.Errorf("want ErrUnknownSchema, got %v", err)
+	}
+}
+
+// バージョンを管理する前の最初のcreate.sql
+const baselineCreateSQL = `
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    login_id TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL,
+    name TEXT NOT NULL,
+    role INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token TEXT NOT NULL UNIQUE,
+    expires_at INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    creator_id INTEGER NOT NULL,
+    start_date TEXT NOT NULL,
+    end_date TEXT NOT NULL,
+    deadline TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime'))
+);
+CREATE TABLE IF NOT EXISTS entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    submission_id INTEGER NOT NULL,
+    date TEXT NOT NULL,
+    hour INTEGER NOT NULL,
+
+    FOREIGN KEY (submission_id) REFERENCES submissions(id)
+);
+CREATE TABLE IF NOT EXISTS submissions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    request_id INTEGER NOT NULL,
+    submitter_id INTEGER NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
+    updated_at TEXT NOT NULL,
+
+    FOREIGN KEY (submitter_id) REFERENCES users(id),
+    FOREIGN KEY (request_id) REFERENCES requests(id)
+);
+`
+
+// テーブルごとの列名
+func schemaColumns(t *testing.T, d *Sqlite3DB) map[string][]string {
+	t.Helper()
+	rows, err := d.Conn.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name != 'sqlite_sequence'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	schema := map[string][]string{}
+	for _, table := range tables {
+		columns, err := d.tableColumns(context.Background(), table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(columns)
+		schema[table] = columns
+	}
+	return schema
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	ctx := context.Background()
+
+	old := newTestSqlite3DB(t)
+	if _, err := old.Conn.Exec(baselineCreateSQL + `
+		INSERT INTO users (login_id, password, name, role) VALUES ('old', 'x', '既存', 1);
+		INSERT INTO requests (creator_id, start_date, end_date, deadline) VALUES (1, '2024-01-01', '2024-01-07', '2023-12-25');
+	`); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := old.Migrate(ctx)
+	if err != nil || !slices.Equal(applied, []int{1, 2}) {
+		t.Fatalf("want [1 2], got %v, %v", applied, err)
+	}
+	if err := CheckReady(ctx, old); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// create.sqlで作成したDBと同じテーブル、列になる
+	latest := newTestSqlite3DB(t)
+	if _, err := latest.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want, got := schemaColumns(t, latest), schemaColumns(t, old)
+	if len(want) != len(got) {
+		t.Errorf("want tables %v, got %v", want, got)
+	}
+	for table, columns := range want {
+		if !slices.Equal(got[table], columns) {
+			t.Errorf("%s: want %v, got %v", table, columns, got[table])
+		}
+	}
+
+	// 既存のユーザーとシフトリクエストはデフォルトの組織に所属し、セッションはそのまま使える
+	user, err := old.GetUserByLoginID(ctx, "old")
+	if err != nil || user.OrganizationID != DefaultOrganizationID || user.SessionVersion != 0 || user.Email != "" || user.DeactivatedAt != 0 {
+		t.Errorf("unexpected user: %+v, %v", user, err)
+	}
+	requests, err := old.GetRequests(ctx, DefaultOrganizationID)
+	if err != nil || len(requests) != 1 {
+		t.Errorf("want 1 request in the default organization, got %v, %v", requests, err)
+	}
+
+	// メールアドレスの重複は禁止する
+	if _, err := old.CreateUser(ctx, User{LoginID: "a", Password: "x", Name: "a", Role: 1, Email: "a@example.com", OrganizationID: DefaultOrganizationID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := old.CreateUser(ctx, User{LoginID: "b", Password: "x", Name: "b", Role: 1, Email: "a@example.com", OrganizationID: DefaultOrganizationID}); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Errorf("want ErrUserAlreadyExists, got %v", err)
+	}
+
+	// 監査ログは追記のみ
+	if _, err := old.CreateAuditEvent(ctx, AuditEvent{Action: "test", TargetType: "user", TargetID: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := old.Conn.Exec("DELETE FROM audit_events"); err == nil {
+		t.Error("audit_events should be append-only")
+	}
+}
+
+func TestCreateUserAndBackup(t *testing.T) {
+	ctx := context.Background()
+	d := newTestSqlite3DB(t)
+	if _, err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// メールアドレスは未設定のユーザーが複数いてもよい
+	for _, loginID := range []string{"a", "b"} {
+		if _, err := d.CreateUser(ctx, User{LoginID: loginID, Password: "x", Name: loginID, Role: 1, OrganizationID: DefaultOrganizationID}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := d.CreateUser(ctx, User{LoginID: "a", Password: "x", Name: "a", Role: 1, OrganizationID: DefaultOrganizationID}); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Errorf("want ErrUserAlreadyExists, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := d.Backup(ctx, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.Backup(ctx, path); !errors.Is(err, os.ErrExist) {
+		t.Errorf("want os.ErrExist, got %v", err)
+	}
+	backup, err := NewSqlite3DB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	if _, err := backup.GetUserByLoginID(ctx, "b"); err != nil {
+		t.Errorf("backup should contain users: %v", err)
+	}
+}
+
+func TestIncrementLoginFailures(t *testing.T) {
+	ctx := context.Background()
+	d := newTestSqlite3DB(t)
+	if _, err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同時に失敗しても数え漏れがない
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := d.IncrementLoginFailures(ctx, "ip:127.0.0.1", 100, 0); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if failures, err := d.IncrementLoginFailures(ctx, "ip:127.0.0.1", 100, 0); err != nil || failures != 21 {
+		t.Errorf("want 21, got %d, %v", failures, err)
+	}
+
+	// 待ち時間は短くしない
+	if err := d.ExtendLoginBlock(ctx, "ip:127.0.0.1", 500); err != nil {
+		t.Fatal(err)
+	}
+	d.ExtendLoginBlock(ctx, "ip:127.0.0.1", 200)
+	// 最後の失敗がresetBeforeより前の場合は1からやり直す
+	if failures, err := d.IncrementLoginFailures(ctx, "ip:127.0.0.1", 300, 200); err != nil || failures != 1 {
+		t.Errorf("want 1, got %d, %v", failures, err)
+	}
+	throttle, err := d.GetLoginThrottle(ctx, "ip:127.0.0.1")
+	if err != nil || throttle.BlockedUntil != 500 || throttle.LastFailureAt != 300 {
+		t.Errorf("unexpected throttle: %+v, %v", throttle, err)
+	}
+}
+
+func TestCreateRequestWithTargets(t *testing.T) {
+	ctx := context.Background()
+	d := newTestSqlite3DB(t)
+	if _, err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := d.CreateRequest(ctx, DefaultOrganizationID, 1, "2024-01-01", "2024-01-07", "2023-12-25", RequestTargets{UserIDs: []int{2, 3}, GroupIDs: []int{1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	targets, err := d.GetRequestTargets(ctx, id)
+	if err != nil || !slices.Equal(targets.UserIDs, []int{2, 3}) || !slices.Equal(targets.GroupIDs, []int{1}) {
+		t.Errorf("unexpected targets: %+v, %v", targets, err)
+	}
+
+	// 対象を保存できない場合はシフトリクエストも作成しない
+	if _, err := d.CreateRequest(ctx, DefaultOrganizationID, 1, "2024-02-01", "2024-02-07", "2024-01-25", RequestTargets{UserIDs: []int{2, 2}}); err == nil {
+		t.Fatal("want error for duplicate targets")
+	}
+	requests, err := d.GetRequests(ctx, DefaultOrganizationID)
+	if err != nil || len(requests) != 1 {
+		t.Errorf("want 1 request, got %v, %v", requests, err)
+	}
+}
+
+func TestGetAPITokensByOrganizationID(t *testing.T) {
+	ctx := context.Background()
+	d := newTestSqlite3DB(t)
+	if _, err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	otherID, err := d.CreateOrganization(ctx, "2号店")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := d.CreateUser(ctx, User{LoginID: "user", Password: "password", Name: "ユーザー", Role: 1, OrganizationID: DefaultOrganizationID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherUserID, err := d.CreateUser(ctx, User{LoginID: "other_user", Password: "password", Name: "2号店ユーザー", Role: 1, OrganizationID: otherID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenID, err := d.CreateAPIToken(ctx, APIToken{UserID: userID, CreatorID: userID, Name: "token", TokenHash: "hash1", Scopes: "requests:read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateAPIToken(ctx, APIToken{UserID: otherUserID, CreatorID: otherUserID, Name: "token", TokenHash: "hash2", Scopes: "requests:read"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 他の組織のユーザーのトークンは含まない
+	tokens, err := d.GetAPITokensByOrganizationID(ctx, DefaultOrganizationID)
+	if err != nil || len(tokens) != 1 || tokens[0].ID != tokenID {
+		t.Errorf("unexpected tokens: %+v, %v", tokens, err)
+	}
+}
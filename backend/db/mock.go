@@ -1,7 +1,9 @@
 package db
 
 import (
-	"errors"
+	"context"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -11,22 +13,290 @@ type mockDB struct {
 	Users       []User
 	Entries     []Entry
 	Submissions []Submission
+	AuditEvents []AuditEvent
+	Throttles   map[string]LoginThrottle
+	Resets      []PasswordReset
+	TOTPs       map[int]UserTOTP
+	// ユーザーIDごとのリカバリーコードのハッシュ値と使用日時
+	RecoveryCodes map[int]map[string]int64
+	// issuerとsubjectの組からユーザーIDへの対応
+	Identities    map[[2]string]int
+	APITokens     []APIToken
+	Organizations []Organization
+	Groups        []Group
+	GroupMembers  []GroupMember
+	// シフトリクエストIDごとの対象
+	RequestTargets map[int]RequestTargets
+	Deliveries     []NotificationDelivery
+	Notifications  []UserNotification
+	Webhooks       []WebhookSubscription
+	// 削除したWebhookの送信状況も削除する
+	WebhookDeliveries []WebhookDelivery
 }
 
-func (m *mockDB) GetRequests() ([]Request, error) {
-	return m.Requests, nil
+func (m *mockDB) GetOrganizations(ctx context.Context) ([]Organization, error) {
+	return slices.Clone(m.Organizations), nil
 }
 
-func (m *mockDB) GetRequestByID(id int) (Request, error) {
+func (m *mockDB) GetOrganizationByID(ctx context.Context, id int) (Organization, error) {
+	for _, organization := range m.Organizations {
+		if organization.ID == id {
+			return organization, nil
+		}
+	}
+	return Organization{}, ErrOrganizationNotFound
+}
+
+func (m *mockDB) CreateOrganization(ctx context.Context, name string) (int, error) {
+	id := len(m.Organizations) + 1
+	m.Organizations = append(m.Organizations, Organization{ID: id, Name: name, CreatedAt: time.Now().Format(time.DateTime)})
+	return id, nil
+}
+
+func (m *mockDB) CreateUser(ctx context.Context, user User) (int, error) {
+	id := 1
+	for _, u := range m.Users {
+		if u.LoginID == user.LoginID || (user.Email != "" && u.Email == user.Email) {
+			return -1, ErrUserAlreadyExists
+		}
+		id = max(id, u.ID+1)
+	}
+	user.ID = id
+	user.CreatedAt = time.Now().Format(time.DateTime)
+	user.SessionVersion = 0
+	user.DeactivatedAt = 0
+	m.Users = append(m.Users, user)
+	return id, nil
+}
+
+func (m *mockDB) DeactivateUser(ctx context.Context, userID int, deactivatedAt int64) error {
+	for i := range m.Users {
+		if m.Users[i].ID == userID {
+			m.Users[i].DeactivatedAt = deactivatedAt
+			m.Users[i].SessionVersion++
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (m *mockDB) GetUsersByOrganizationID(ctx context.Context, organizationID int) ([]User, error) {
+	users := []User{}
+	for _, user := range m.Users {
+		if user.OrganizationID == organizationID {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (m *mockDB) UpdateUserOrganization(ctx context.Context, userID int, organizationID int) error {
+	for i := range m.Users {
+		if m.Users[i].ID == userID {
+			m.Users[i].OrganizationID = organizationID
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (m *mockDB) GetGroupsByOrganizationID(ctx context.Context, organizationID int) ([]Group, error) {
+	groups := []Group{}
+	for _, group := range m.Groups {
+		if group.OrganizationID == organizationID {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+func (m *mockDB) GetGroupByID(ctx context.Context, id int) (Group, error) {
+	for _, group := range m.Groups {
+		if group.ID == id {
+			return group, nil
+		}
+	}
+	return Group{}, ErrGroupNotFound
+}
+
+func (m *mockDB) GetGroupsByIDs(ctx context.Context, ids []int) ([]Group, error) {
+	groups := []Group{}
+	for _, group := range m.Groups {
+		if slices.Contains(ids, group.ID) {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+func (m *mockDB) CreateGroup(ctx context.Context, organizationID int, name string) (int, error) {
+	id := len(m.Groups) + 1
+	m.Groups = append(m.Groups, Group{ID: id, OrganizationID: organizationID, Name: name, CreatedAt: time.Now().Format(time.DateTime)})
+	return id, nil
+}
+
+func (m *mockDB) GetGroupMembers(ctx context.Context, groupIDs []int) ([]GroupMember, error) {
+	members := []GroupMember{}
+	for _, member := range m.GroupMembers {
+		if slices.Contains(groupIDs, member.GroupID) {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+func (m *mockDB) GetGroupIDsByUserID(ctx context.Context, userID int) ([]int, error) {
+	groupIDs := []int{}
+	for _, member := range m.GroupMembers {
+		if member.UserID == userID {
+			groupIDs = append(groupIDs, member.GroupID)
+		}
+	}
+	return groupIDs, nil
+}
+
+func (m *mockDB) AddGroupMember(ctx context.Context, groupID int, userID int) error {
+	member := GroupMember{GroupID: groupID, UserID: userID}
+	if !slices.Contains(m.GroupMembers, member) {
+		m.GroupMembers = append(m.GroupMembers, member)
+	}
+	return nil
+}
+
+func (m *mockDB) RemoveGroupMember(ctx context.Context, groupID int, userID int) error {
+	m.GroupMembers = slices.DeleteFunc(m.GroupMembers, func(member GroupMember) bool {
+		return member.GroupID == groupID && member.UserID == userID
+	})
+	return nil
+}
+
+func (m *mockDB) CreateRequestTargets(ctx context.Context, requestID int, targets RequestTargets) error {
+	if len(targets.UserIDs) == 0 && len(targets.GroupIDs) == 0 {
+		return nil
+	}
+	m.RequestTargets[requestID] = RequestTargets{
+		UserIDs:  slices.Clone(targets.UserIDs),
+		GroupIDs: slices.Clone(targets.GroupIDs),
+	}
+	return nil
+}
+
+func (m *mockDB) GetRequestTargets(ctx context.Context, requestID int) (RequestTargets, error) {
+	targets := m.RequestTargets[requestID]
+	return RequestTargets{
+		UserIDs:  append([]int{}, targets.UserIDs...),
+		GroupIDs: append([]int{}, targets.GroupIDs...),
+	}, nil
+}
+
+// ユーザーがシフトリクエストの対象か判定する
+func (m *mockDB) isRequestTarget(ctx context.Context, requestID int, userID int) bool {
+	targets, ok := m.RequestTargets[requestID]
+	if !ok || slices.Contains(targets.UserIDs, userID) {
+		return true
+	}
+	groupIDs, _ := m.GetGroupIDsByUserID(ctx, userID)
+	for _, groupID := range groupIDs {
+		if slices.Contains(targets.GroupIDs, groupID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mockDB) GetRequests(ctx context.Context, organizationID int) ([]Request, error) {
+	requests := []Request{}
+	for _, request := range m.Requests {
+		if request.OrganizationID == organizationID {
+			requests = append(requests, request)
+		}
+	}
+	return requests, nil
+}
+
+func (m *mockDB) QueryRequests(ctx context.Context, query RequestQuery) ([]Request, error) {
+	requests := []Request{}
+	for _, request := range m.Requests {
+		if request.OrganizationID != query.OrganizationID {
+			continue
+		}
+		if query.From != "" && request.EndDate < query.From {
+			continue
+		}
+		if query.To != "" && request.StartDate > query.To {
+			continue
+		}
+		if query.DeadlineAfter != "" && request.Deadline <= query.DeadlineAfter {
+			continue
+		}
+		if query.DeadlineNotAfter != "" && request.Deadline > query.DeadlineNotAfter {
+			continue
+		}
+		if query.CreatorID != 0 && request.CreatorID != query.CreatorID {
+			continue
+		}
+		if query.TargetUserID != 0 && !m.isRequestTarget(ctx, request.ID, query.TargetUserID) {
+			continue
+		}
+		if query.SubmitterID != 0 {
+			_, err := m.GetSubmissionByRequestIDAndSubmitterID(ctx, request.ID, query.SubmitterID)
+			if (err == nil) != query.HasSubmission {
+				continue
+			}
+		}
+		requests = append(requests, request)
+	}
+
+	// ソートキー、IDの順に並べる
+	compare := func(a Request, b Request) int {
+		if c := strings.Compare(a.SortValue(query.Sort), b.SortValue(query.Sort)); c != 0 {
+			return c
+		}
+		return a.ID - b.ID
+	}
+	slices.SortFunc(requests, func(a Request, b Request) int {
+		if query.Desc {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+
+	// カーソルより後のリクエストだけを残す
+	if query.After != nil {
+		after := Request{ID: query.After.ID}
+		switch query.Sort {
+		case RequestSortStartDate:
+			after.StartDate = query.After.SortValue
+		case RequestSortDeadline:
+			after.Deadline = query.After.SortValue
+		default:
+			after.CreatedAt = query.After.SortValue
+		}
+		requests = slices.DeleteFunc(requests, func(request Request) bool {
+			c := compare(request, after)
+			if query.Desc {
+				return c >= 0
+			}
+			return c <= 0
+		})
+	}
+
+	if query.Limit > 0 && len(requests) > query.Limit {
+		requests = requests[:query.Limit]
+	}
+	return requests, nil
+}
+
+func (m *mockDB) GetRequestByID(ctx context.Context, id int) (Request, error) {
 	for _, request := range m.Requests {
 		if request.ID == id {
 			return request, nil
 		}
 	}
-	return Request{}, errors.New("request not found")
+	return Request{}, ErrRequestNotFound
 }
 
-func (m *mockDB) GetUserByID(id int) (User, error) {
+func (m *mockDB) GetUserByID(ctx context.Context, id int) (User, error) {
 	for _, user := range m.Users {
 		if user.ID == id {
 			return user, nil
@@ -35,7 +305,17 @@ func (m *mockDB) GetUserByID(id int) (User, error) {
 	return User{}, ErrUserNotFound
 }
 
-func (m *mockDB) GetUserByLoginID(loginID string) (User, error) {
+func (m *mockDB) GetUsersByIDs(ctx context.Context, ids []int) ([]User, error) {
+	users := []User{}
+	for _, user := range m.Users {
+		if slices.Contains(ids, user.ID) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (m *mockDB) GetUserByLoginID(ctx context.Context, loginID string) (User, error) {
 	for _, user := range m.Users {
 		if user.LoginID == loginID {
 			return user, nil
@@ -44,7 +324,29 @@ func (m *mockDB) GetUserByLoginID(loginID string) (User, error) {
 	return User{}, ErrUserNotFound
 }
 
-func (m *mockDB) GetEntriesBySubmissionID(submissionID int) ([]Entry, error) {
+func (m *mockDB) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	for _, user := range m.Users {
+		if user.Email != "" && user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (m *mockDB) GetUserByIdentity(ctx context.Context, issuer string, subject string) (User, error) {
+	userID, ok := m.Identities[[2]string{issuer, subject}]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return m.GetUserByID(ctx, userID)
+}
+
+func (m *mockDB) CreateUserIdentity(ctx context.Context, issuer string, subject string, userID int) error {
+	m.Identities[[2]string{issuer, subject}] = userID
+	return nil
+}
+
+func (m *mockDB) GetEntriesBySubmissionID(ctx context.Context, submissionID int) ([]Entry, error) {
 	entries := []Entry{}
 	for _, entry := range m.Entries {
 		if entry.SubmissionID == submissionID {
@@ -54,7 +356,17 @@ func (m *mockDB) GetEntriesBySubmissionID(submissionID int) ([]Entry, error) {
 	return entries, nil
 }
 
-func (m *mockDB) GetSubmissionsByRequestID(requestID int) ([]Submission, error) {
+func (m *mockDB) GetEntriesBySubmissionIDs(ctx context.Context, submissionIDs []int) ([]Entry, error) {
+	entries := []Entry{}
+	for _, entry := range m.Entries {
+		if slices.Contains(submissionIDs, entry.SubmissionID) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *mockDB) GetSubmissionsByRequestID(ctx context.Context, requestID int) ([]Submission, error) {
 	submissions := []Submission{}
 	for _, submission := range m.Submissions {
 		if submission.RequestID == requestID {
@@ -64,21 +376,43 @@ func (m *mockDB) GetSubmissionsByRequestID(requestID int) ([]Submission, error)
 	return submissions, nil
 }
 
-func (m *mockDB) GetSubmissionByRequestIDAndSubmitterID(requestID int, submitterID int) (*Submission, error) {
+func (m *mockDB) GetSubmissionByRequestIDAndSubmitterID(ctx context.Context, requestID int, submitterID int) (*Submission, error) {
 	for _, submission := range m.Submissions {
 		if submission.RequestID == requestID && submission.SubmitterID == submitterID {
 			return &submission, nil
 		}
 	}
-	return nil, nil
+	return nil, ErrSubmissionNotFound
 }
 
-func (m *mockDB) CreateRequest(creatorID int, startDate string, endDate string, deadline string) (int, error) {
-	m.Requests = append(m.Requests, Request{ID: len(m.Requests) + 1, CreatorID: creatorID, StartDate: startDate, EndDate: endDate, Deadline: deadline, CreatedAt: time.Now().Format(time.DateTime)})
+func (m *mockDB) GetSubmissionByID(ctx context.Context, id int) (*Submission, error) {
+	for _, submission := range m.Submissions {
+		if submission.ID == id {
+			return &submission, nil
+		}
+	}
+	return nil, ErrSubmissionNotFound
+}
+
+func (m *mockDB) CreateRequest(ctx context.Context, organizationID int, creatorID int, startDate string, endDate string, deadline string, targets RequestTargets) (int, error) {
+	m.Requests = append(m.Requests, Request{ID: len(m.Requests) + 1, OrganizationID: organizationID, CreatorID: creatorID, StartDate: startDate, EndDate: endDate, Deadline: deadline, CreatedAt: time.Now().Format(time.DateTime)})
+	if err := m.CreateRequestTargets(ctx, len(m.Requests), targets); err != nil {
+		return -1, err
+	}
 	return len(m.Requests), nil
 }
 
-func (m *mockDB) CreateEntries(entries []Entry) ([]int, error) {
+func (m *mockDB) UpdateRequestDeadline(ctx context.Context, requestID int, deadline string) error {
+	for i := range m.Requests {
+		if m.Requests[i].ID == requestID {
+			m.Requests[i].Deadline = deadline
+			return nil
+		}
+	}
+	return ErrRequestNotFound
+}
+
+func (m *mockDB) CreateEntries(ctx context.Context, entries []Entry) ([]int, error) {
 	lastID := len(m.Entries)
 	ids := []int{}
 	for i := range entries {
@@ -90,7 +424,7 @@ func (m *mockDB) CreateEntries(entries []Entry) ([]int, error) {
 	return ids, nil
 }
 
-func (m *mockDB) CreateSubmission(submitterID int, requestID int) (int, error) {
+func (m *mockDB) CreateSubmission(ctx context.Context, submitterID int, requestID int) (int, error) {
 	submission := Submission{
 		ID:          len(m.Submissions) + 1,
 		RequestID:   requestID,
@@ -102,12 +436,452 @@ func (m *mockDB) CreateSubmission(submitterID int, requestID int) (int, error) {
 	return submission.ID, nil
 }
 
+func (m *mockDB) ReplaceSubmissionEntries(ctx context.Context, submissionID int, entries []Entry) error {
+	i := slices.IndexFunc(m.Submissions, func(submission Submission) bool { return submission.ID == submissionID })
+	if i < 0 {
+		return ErrSubmissionNotFound
+	}
+	m.Submissions[i].UpdatedAt = time.Now().Format(time.DateTime)
+
+	nextID := 1
+	for _, entry := range m.Entries {
+		nextID = max(nextID, entry.ID+1)
+	}
+	m.Entries = slices.DeleteFunc(m.Entries, func(entry Entry) bool { return entry.SubmissionID == submissionID })
+	for _, entry := range entries {
+		entry.ID = nextID
+		entry.SubmissionID = submissionID
+		m.Entries = append(m.Entries, entry)
+		nextID++
+	}
+	return nil
+}
+
+func (m *mockDB) CreateAuditEvent(ctx context.Context, event AuditEvent) (int, error) {
+	event.ID = len(m.AuditEvents) + 1
+	event.CreatedAt = time.Now().Format(time.DateTime)
+	m.AuditEvents = append(m.AuditEvents, event)
+	return event.ID, nil
+}
+
+func (m *mockDB) QueryAuditEvents(ctx context.Context, query AuditQuery) ([]AuditEvent, error) {
+	events := []AuditEvent{}
+	// 新しい順に返す
+	for i := len(m.AuditEvents) - 1; i >= 0; i-- {
+		event := m.AuditEvents[i]
+		if event.OrganizationID != query.OrganizationID {
+			continue
+		}
+		if query.ActorID != 0 && event.ActorID != query.ActorID {
+			continue
+		}
+		if query.Action != "" && event.Action != query.Action {
+			continue
+		}
+		if query.TargetType != "" && event.TargetType != query.TargetType {
+			continue
+		}
+		if query.TargetID != 0 && event.TargetID != query.TargetID {
+			continue
+		}
+		if query.Since != "" && event.CreatedAt < query.Since {
+			continue
+		}
+		if query.Until != "" && event.CreatedAt >= query.Until {
+			continue
+		}
+		if query.BeforeID != 0 && event.ID >= query.BeforeID {
+			continue
+		}
+		events = append(events, event)
+		if query.Limit > 0 && len(events) == query.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (m *mockDB) GetLoginThrottle(ctx context.Context, key string) (LoginThrottle, error) {
+	throttle, ok := m.Throttles[key]
+	if !ok {
+		return LoginThrottle{}, ErrThrottleNotFound
+	}
+	return throttle, nil
+}
+
+func (m *mockDB) SaveLoginThrottle(ctx context.Context, throttle LoginThrottle) error {
+	m.Throttles[throttle.Key] = throttle
+	return nil
+}
+
+func (m *mockDB) IncrementLoginFailures(ctx context.Context, key string, failedAt int64, resetBefore int64) (int, error) {
+	throttle, ok := m.Throttles[key]
+	if !ok || throttle.LastFailureAt < resetBefore {
+		throttle = LoginThrottle{Key: key, BlockedUntil: throttle.BlockedUntil}
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = failedAt
+	m.Throttles[key] = throttle
+	return throttle.Failures, nil
+}
+
+func (m *mockDB) ExtendLoginBlock(ctx context.Context, key string, blockedUntil int64) error {
+	throttle, ok := m.Throttles[key]
+	if ok && blockedUntil > throttle.BlockedUntil {
+		throttle.BlockedUntil = blockedUntil
+		m.Throttles[key] = throttle
+	}
+	return nil
+}
+
+func (m *mockDB) DeleteLoginThrottle(ctx context.Context, key string) error {
+	delete(m.Throttles, key)
+	return nil
+}
+
+func (m *mockDB) UpdateUserPassword(ctx context.Context, userID int, password string) error {
+	for i := range m.Users {
+		if m.Users[i].ID == userID {
+			m.Users[i].Password = password
+			m.Users[i].SessionVersion++
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (m *mockDB) CreatePasswordReset(ctx context.Context, reset PasswordReset) error {
+	m.Resets = append(m.Resets, reset)
+	return nil
+}
+
+func (m *mockDB) GetPasswordReset(ctx context.Context, tokenHash string) (PasswordReset, error) {
+	for _, reset := range m.Resets {
+		if reset.TokenHash == tokenHash {
+			return reset, nil
+		}
+	}
+	return PasswordReset{}, ErrPasswordResetNotFound
+}
+
+func (m *mockDB) UsePasswordReset(ctx context.Context, tokenHash string, usedAt int64) error {
+	for i := range m.Resets {
+		if m.Resets[i].TokenHash == tokenHash && m.Resets[i].UsedAt == 0 {
+			m.Resets[i].UsedAt = usedAt
+			return nil
+		}
+	}
+	return ErrPasswordResetNotFound
+}
+
+func (m *mockDB) GetUserTOTP(ctx context.Context, userID int) (UserTOTP, error) {
+	totp, ok := m.TOTPs[userID]
+	if !ok {
+		return UserTOTP{}, ErrTOTPNotFound
+	}
+	return totp, nil
+}
+
+func (m *mockDB) SaveUserTOTP(ctx context.Context, totp UserTOTP) error {
+	m.TOTPs[totp.UserID] = totp
+	return nil
+}
+
+func (m *mockDB) DeleteUserTOTP(ctx context.Context, userID int) error {
+	delete(m.TOTPs, userID)
+	delete(m.RecoveryCodes, userID)
+	return nil
+}
+
+func (m *mockDB) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	codes := make(map[string]int64, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes[hash] = 0
+	}
+	m.RecoveryCodes[userID] = codes
+	return nil
+}
+
+func (m *mockDB) UseRecoveryCode(ctx context.Context, userID int, codeHash string, usedAt int64) error {
+	codes := m.RecoveryCodes[userID]
+	if used, ok := codes[codeHash]; !ok || used != 0 {
+		return ErrRecoveryCodeNotFound
+	}
+	codes[codeHash] = usedAt
+	return nil
+}
+
+func (m *mockDB) CreateAPIToken(ctx context.Context, token APIToken) (int, error) {
+	token.ID = len(m.APITokens) + 1
+	token.CreatedAt = time.Now().Format(time.DateTime)
+	m.APITokens = append(m.APITokens, token)
+	return token.ID, nil
+}
+
+func (m *mockDB) GetAPITokensByOrganizationID(ctx context.Context, organizationID int) ([]APIToken, error) {
+	tokens := []APIToken{}
+	for _, token := range m.APITokens {
+		for _, user := range m.Users {
+			if user.ID == token.UserID && user.OrganizationID == organizationID {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func (m *mockDB) GetAPITokenByID(ctx context.Context, id int) (APIToken, error) {
+	for _, token := range m.APITokens {
+		if token.ID == id {
+			return token, nil
+		}
+	}
+	return APIToken{}, ErrAPITokenNotFound
+}
+
+func (m *mockDB) GetAPITokenByHash(ctx context.Context, tokenHash string) (APIToken, error) {
+	for _, token := range m.APITokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+	return APIToken{}, ErrAPITokenNotFound
+}
+
+func (m *mockDB) RevokeAPIToken(ctx context.Context, id int, revokedAt int64) error {
+	for i := range m.APITokens {
+		if m.APITokens[i].ID == id && m.APITokens[i].RevokedAt == 0 {
+			m.APITokens[i].RevokedAt = revokedAt
+			return nil
+		}
+	}
+	return ErrAPITokenNotFound
+}
+
+func (m *mockDB) TouchAPIToken(ctx context.Context, id int, usedAt int64) error {
+	for i := range m.APITokens {
+		if m.APITokens[i].ID == id {
+			m.APITokens[i].LastUsedAt = usedAt
+			return nil
+		}
+	}
+	return ErrAPITokenNotFound
+}
+
+func (m *mockDB) Ping(ctx context.Context) error {
+	return nil
+}
+
+// モックDBは常に最新のスキーマとみなす
+func (m *mockDB) GetSchemaVersion(ctx context.Context) (int, error) {
+	return SchemaVersion, nil
+}
+
 // テスト用データを入れたモックDBを生成
+// create.sqlと同様にデフォルトの組織を作成し、組織を指定していないユーザーとシフトリクエストはデフォルトの組織に所属させる
 func NewMockDB(requests []Request, users []User, entries []Entry, submissions []Submission) *mockDB {
+	requests = slices.Clone(requests)
+	for i := range requests {
+		if requests[i].OrganizationID == 0 {
+			requests[i].OrganizationID = DefaultOrganizationID
+		}
+	}
+	users = slices.Clone(users)
+	for i := range users {
+		if users[i].OrganizationID == 0 {
+			users[i].OrganizationID = DefaultOrganizationID
+		}
+	}
+
 	return &mockDB{
-		Requests:    requests,
-		Users:       users,
-		Entries:     entries,
-		Submissions: submissions,
+		Organizations: []Organization{
+			{ID: DefaultOrganizationID, Name: "デフォルト", CreatedAt: time.Now().Format(time.DateTime)},
+		},
+		Requests:       requests,
+		Users:          users,
+		Entries:        entries,
+		Submissions:    submissions,
+		Throttles:      map[string]LoginThrottle{},
+		TOTPs:          map[int]UserTOTP{},
+		RecoveryCodes:  map[int]map[string]int64{},
+		Identities:     map[[2]string]int{},
+		RequestTargets: map[int]RequestTargets{},
+	}
+}
+
+func (m *mockDB) GetNotificationDeliveries(ctx context.Context, kind string, requestID int) ([]NotificationDelivery, error) {
+	deliveries := []NotificationDelivery{}
+	for _, delivery := range m.Deliveries {
+		if delivery.Kind == kind && delivery.RequestID == requestID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *mockDB) CreateNotificationDelivery(ctx context.Context, delivery NotificationDelivery) error {
+	for _, d := range m.Deliveries {
+		if d.Kind == delivery.Kind && d.RequestID == delivery.RequestID && d.UserID == delivery.UserID {
+			return nil
+		}
+	}
+	m.Deliveries = append(m.Deliveries, delivery)
+	return nil
+}
+
+func (m *mockDB) CreateUserNotification(ctx context.Context, notification UserNotification) (int, error) {
+	if notification.DedupKey != "" {
+		for _, n := range m.Notifications {
+			if n.UserID == notification.UserID && n.DedupKey == notification.DedupKey {
+				return n.ID, nil
+			}
+		}
+	}
+	notification.ID = len(m.Notifications) + 1
+	notification.CreatedAt = time.Now().Format(time.DateTime)
+	notification.ReadAt = 0
+	m.Notifications = append(m.Notifications, notification)
+	return notification.ID, nil
+}
+
+func (m *mockDB) QueryUserNotifications(ctx context.Context, query UserNotificationQuery) ([]UserNotification, error) {
+	notifications := []UserNotification{}
+	// 新しい順に返す
+	for i := len(m.Notifications) - 1; i >= 0; i-- {
+		notification := m.Notifications[i]
+		if notification.UserID != query.UserID {
+			continue
+		}
+		if query.UnreadOnly && notification.ReadAt != 0 {
+			continue
+		}
+		if query.BeforeID != 0 && notification.ID >= query.BeforeID {
+			continue
+		}
+		notifications = append(notifications, notification)
+		if query.Limit > 0 && len(notifications) == query.Limit {
+			break
+		}
+	}
+	return notifications, nil
+}
+
+func (m *mockDB) CountUnreadUserNotifications(ctx context.Context, userID int) (int, error) {
+	count := 0
+	for _, notification := range m.Notifications {
+		if notification.UserID == userID && notification.ReadAt == 0 {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockDB) ReadUserNotification(ctx context.Context, id int, userID int, readAt int64) error {
+	for i, notification := range m.Notifications {
+		if notification.ID == id && notification.UserID == userID {
+			if notification.ReadAt == 0 {
+				m.Notifications[i].ReadAt = readAt
+			}
+			return nil
+		}
+	}
+	return ErrNotificationNotFound
+}
+
+func (m *mockDB) CreateWebhookSubscription(ctx context.Context, subscription WebhookSubscription) (int, error) {
+	subscription.ID = 1
+	if len(m.Webhooks) > 0 {
+		subscription.ID = m.Webhooks[len(m.Webhooks)-1].ID + 1
+	}
+	subscription.CreatedAt = time.Now().Format(time.DateTime)
+	m.Webhooks = append(m.Webhooks, subscription)
+	return subscription.ID, nil
+}
+
+func (m *mockDB) GetWebhookSubscriptionsByOrganizationID(ctx context.Context, organizationID int) ([]WebhookSubscription, error) {
+	subscriptions := []WebhookSubscription{}
+	for _, subscription := range m.Webhooks {
+		if subscription.OrganizationID == organizationID {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions, nil
+}
+
+func (m *mockDB) GetWebhookSubscriptionByID(ctx context.Context, id int) (WebhookSubscription, error) {
+	for _, subscription := range m.Webhooks {
+		if subscription.ID == id {
+			return subscription, nil
+		}
+	}
+	return WebhookSubscription{}, ErrWebhookNotFound
+}
+
+func (m *mockDB) DeleteWebhookSubscription(ctx context.Context, id int) error {
+	m.Webhooks = slices.DeleteFunc(m.Webhooks, func(subscription WebhookSubscription) bool {
+		return subscription.ID == id
+	})
+	m.WebhookDeliveries = slices.DeleteFunc(m.WebhookDeliveries, func(delivery WebhookDelivery) bool {
+		return delivery.SubscriptionID == id
+	})
+	return nil
+}
+
+func (m *mockDB) CreateWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error {
+	for _, delivery := range deliveries {
+		delivery.ID = 1
+		if len(m.WebhookDeliveries) > 0 {
+			delivery.ID = m.WebhookDeliveries[len(m.WebhookDeliveries)-1].ID + 1
+		}
+		delivery.CreatedAt = time.Now().Format(time.DateTime)
+		m.WebhookDeliveries = append(m.WebhookDeliveries, delivery)
+	}
+	return nil
+}
+
+func (m *mockDB) QueryWebhookDeliveries(ctx context.Context, query WebhookDeliveryQuery) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	// 新しい順に返す
+	for i := len(m.WebhookDeliveries) - 1; i >= 0; i-- {
+		delivery := m.WebhookDeliveries[i]
+		if delivery.SubscriptionID != query.SubscriptionID {
+			continue
+		}
+		if query.BeforeID != 0 && delivery.ID >= query.BeforeID {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+		if query.Limit > 0 && len(deliveries) == query.Limit {
+			break
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *mockDB) GetDueWebhookDeliveries(ctx context.Context, now int64, limit int) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range m.WebhookDeliveries {
+		if delivery.Status != WebhookDeliveryPending || delivery.NextAttemptAt > now {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+		if limit > 0 && len(deliveries) == limit {
+			break
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *mockDB) UpdateWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	for i := range m.WebhookDeliveries {
+		if m.WebhookDeliveries[i].ID == delivery.ID {
+			delivery.SubscriptionID = m.WebhookDeliveries[i].SubscriptionID
+			delivery.Event = m.WebhookDeliveries[i].Event
+			delivery.Payload = m.WebhookDeliveries[i].Payload
+			delivery.CreatedAt = m.WebhookDeliveries[i].CreatedAt
+			m.WebhookDeliveries[i] = delivery
+			return nil
+		}
 	}
+	return nil
 }
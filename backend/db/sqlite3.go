@@ -1,9 +1,12 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 // Sqlite3DBはDBインターフェースのsqlite3実装
@@ -26,11 +29,27 @@ func (db *Sqlite3DB) Close() error {
 	return db.Conn.Close()
 }
 
+func (db *Sqlite3DB) Ping(ctx context.Context) error {
+	return db.Conn.PingContext(ctx)
+}
+
+// create.sqlもmigrationも実行していない場合は0
+func (db *Sqlite3DB) GetSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	if err := db.Conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// usersテーブルから取得する列。emailは未設定の場合NULLなので空文字にする
+const userColumns = "id, login_id, password, name, role, created_at, session_version, COALESCE(email, ''), organization_id, deactivated_at"
+
 // ユーザーIDでユーザーを取得
-func (db *Sqlite3DB) GetUserByID(id int) (User, error) {
+func (db *Sqlite3DB) GetUserByID(ctx context.Context, id int) (User, error) {
 	var user User
-	row := db.Conn.QueryRow("SELECT id, login_id, password, name, role, created_at FROM users WHERE id = ?", id)
-	err := row.Scan(&user.ID, &user.LoginID, &user.Password, &user.Name, &user.Role, &user.CreatedAt)
+	row := db.Conn.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", id)
+	err := row.Scan(&user.ID, &user.LoginID, &user.Password, &user.Name, &user.Role, &user.CreatedAt, &user.SessionVersion, &user.Email, &user.OrganizationID, &user.DeactivatedAt)
 	if err == sql.ErrNoRows {
 		return User{}, ErrUserNotFound
 	}
@@ -40,11 +59,73 @@ func (db *Sqlite3DB) GetUserByID(id int) (User, error) {
 	return user, nil
 }
 
+// 複数のユーザーIDでユーザーをまとめて取得
+// 存在しないIDは無視される
+func (db *Sqlite3DB) GetUsersByIDs(ctx context.Context, ids []int) ([]User, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	rows, err := db.Conn.QueryContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE id IN ("+placeholders(len(ids))+")",
+		intArgs(ids)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.LoginID, &user.Password, &user.Name, &user.Role, &user.CreatedAt, &user.SessionVersion, &user.Email, &user.OrganizationID, &user.DeactivatedAt)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // login_idでユーザーを取得
-func (db *Sqlite3DB) GetUserByLoginID(loginID string) (User, error) {
+func (db *Sqlite3DB) GetUserByLoginID(ctx context.Context, loginID string) (User, error) {
+	var user User
+	row := db.Conn.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE login_id = ?", loginID)
+	err := row.Scan(&user.ID, &user.LoginID, &user.Password, &user.Name, &user.Role, &user.CreatedAt, &user.SessionVersion, &user.Email, &user.OrganizationID, &user.DeactivatedAt)
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// メールアドレスでユーザーを取得
+func (db *Sqlite3DB) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	row := db.Conn.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE email = ?", email)
+	err := row.Scan(&user.ID, &user.LoginID, &user.Password, &user.Name, &user.Role, &user.CreatedAt, &user.SessionVersion, &user.Email, &user.OrganizationID, &user.DeactivatedAt)
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// 外部のIDプロバイダーのアカウントに紐づくユーザーを取得
+func (db *Sqlite3DB) GetUserByIdentity(ctx context.Context, issuer string, subject string) (User, error) {
 	var user User
-	row := db.Conn.QueryRow("SELECT id, login_id, password, name, role, created_at FROM users WHERE login_id = ?", loginID)
-	err := row.Scan(&user.ID, &user.LoginID, &user.Password, &user.Name, &user.Role, &user.CreatedAt)
+	row := db.Conn.QueryRowContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE id = (SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?)",
+		issuer, subject,
+	)
+	err := row.Scan(&user.ID, &user.LoginID, &user.Password, &user.Name, &user.Role, &user.CreatedAt, &user.SessionVersion, &user.Email, &user.OrganizationID, &user.DeactivatedAt)
 	if err == sql.ErrNoRows {
 		return User{}, ErrUserNotFound
 	}
@@ -54,100 +135,207 @@ func (db *Sqlite3DB) GetUserByLoginID(loginID string) (User, error) {
 	return user, nil
 }
 
-// 全リクエストを取得
-func (db *Sqlite3DB) GetRequests() ([]Request, error) {
-	rows, err := db.Conn.Query("SELECT id, creator_id, start_date, end_date, deadline, created_at FROM requests")
+// 外部のIDプロバイダーのアカウントをユーザーに紐づける
+func (db *Sqlite3DB) CreateUserIdentity(ctx context.Context, issuer string, subject string, userID int) error {
+	_, err := db.Conn.ExecContext(ctx,
+		"INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)",
+		issuer, subject, userID,
+	)
+	return err
+}
+
+// ユーザーを作成
+// ログインIDかメールアドレスが既に使われている場合はErrUserAlreadyExistsを返す
+func (db *Sqlite3DB) CreateUser(ctx context.Context, user User) (int, error) {
+	// emailはUNIQUEなので、未設定の場合は空文字ではなくNULLにする
+	var email sql.NullString
+	if user.Email != "" {
+		email = sql.NullString{String: user.Email, Valid: true}
+	}
+
+	res, err := db.Conn.ExecContext(ctx,
+		"INSERT INTO users (login_id, password, name, role, email, organization_id) VALUES (?, ?, ?, ?, ?, ?)",
+		user.LoginID, user.Password, user.Name, user.Role, email, user.OrganizationID,
+	)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return -1, ErrUserAlreadyExists
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// ユーザーを無効にする
+// 既存のセッションも無効にするため、session_versionを増やす
+func (db *Sqlite3DB) DeactivateUser(ctx context.Context, userID int, deactivatedAt int64) error {
+	res, err := db.Conn.ExecContext(ctx,
+		"UPDATE users SET deactivated_at = ?, session_version = session_version + 1 WHERE id = ?",
+		deactivatedAt, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// 全組織を取得
+func (db *Sqlite3DB) GetOrganizations(ctx context.Context) ([]Organization, error) {
+	rows, err := db.Conn.QueryContext(ctx, "SELECT id, name, created_at FROM organizations ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var requests []Request
+	var organizations []Organization
 	for rows.Next() {
-		var req Request
-		err := rows.Scan(&req.ID, &req.CreatorID, &req.StartDate, &req.EndDate, &req.Deadline, &req.CreatedAt)
-		if err != nil {
+		var organization Organization
+		if err := rows.Scan(&organization.ID, &organization.Name, &organization.CreatedAt); err != nil {
 			return nil, err
 		}
-		requests = append(requests, req)
+		organizations = append(organizations, organization)
 	}
-	return requests, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return organizations, nil
 }
 
-// 指定リクエストIDのリクエストを取得
-func (db *Sqlite3DB) GetRequestByID(id int) (Request, error) {
-	var req Request
-	row := db.Conn.QueryRow("SELECT id, creator_id, start_date, end_date, deadline, created_at FROM requests WHERE id = ?", id)
-	err := row.Scan(&req.ID, &req.CreatorID, &req.StartDate, &req.EndDate, &req.Deadline, &req.CreatedAt)
+// 組織IDで組織を取得
+func (db *Sqlite3DB) GetOrganizationByID(ctx context.Context, id int) (Organization, error) {
+	var organization Organization
+	row := db.Conn.QueryRowContext(ctx, "SELECT id, name, created_at FROM organizations WHERE id = ?", id)
+	err := row.Scan(&organization.ID, &organization.Name, &organization.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Organization{}, ErrOrganizationNotFound
+	}
 	if err != nil {
-		return Request{}, err
+		return Organization{}, err
 	}
-	return req, nil
+	return organization, nil
 }
 
-// 指定リクエストIDのエントリー一覧を取得
-func (db *Sqlite3DB) GetEntriesBySubmissionID(submissionID int) ([]Entry, error) {
-	rows, err := db.Conn.Query("SELECT id, submission_id, date, hour FROM entries WHERE submission_id = ?", submissionID)
+// 新しい組織を作成
+func (db *Sqlite3DB) CreateOrganization(ctx context.Context, name string) (int, error) {
+	res, err := db.Conn.ExecContext(ctx, "INSERT INTO organizations (name) VALUES (?)", name)
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// 組織に所属するユーザーを取得
+func (db *Sqlite3DB) GetUsersByOrganizationID(ctx context.Context, organizationID int) ([]User, error) {
+	rows, err := db.Conn.QueryContext(ctx, "SELECT "+userColumns+" FROM users WHERE organization_id = ? ORDER BY id", organizationID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var entries []Entry
+	var users []User
 	for rows.Next() {
-		var entry Entry
-		err := rows.Scan(&entry.ID, &entry.SubmissionID, &entry.Date, &entry.Hour)
+		var user User
+		err := rows.Scan(&user.ID, &user.LoginID, &user.Password, &user.Name, &user.Role, &user.CreatedAt, &user.SessionVersion, &user.Email, &user.OrganizationID, &user.DeactivatedAt)
 		if err != nil {
 			return nil, err
 		}
-		entries = append(entries, entry)
+		users = append(users, user)
 	}
-	return entries, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ユーザーの所属する組織を変更
+func (db *Sqlite3DB) UpdateUserOrganization(ctx context.Context, userID int, organizationID int) error {
+	res, err := db.Conn.ExecContext(ctx, "UPDATE users SET organization_id = ? WHERE id = ?", organizationID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
 
-func (db *Sqlite3DB) GetSubmissionsByRequestID(requestID int) ([]Submission, error) {
-	rows, err := db.Conn.Query("SELECT id, request_id, submitter_id, created_at, updated_at FROM submissions WHERE request_id = ?", requestID)
+const groupColumns = "id, organization_id, name, created_at"
+
+// グループの一覧を取得するクエリを実行する
+func (db *Sqlite3DB) queryGroups(ctx context.Context, query string, args ...any) ([]Group, error) {
+	rows, err := db.Conn.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var submissions []Submission
+
+	groups := []Group{}
 	for rows.Next() {
-		var submission Submission
-		err := rows.Scan(&submission.ID, &submission.RequestID, &submission.SubmitterID, &submission.CreatedAt, &submission.UpdatedAt)
-		if err != nil {
+		var group Group
+		if err := rows.Scan(&group.ID, &group.OrganizationID, &group.Name, &group.CreatedAt); err != nil {
 			return nil, err
 		}
-		submissions = append(submissions, submission)
+		groups = append(groups, group)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return submissions, nil
+	return groups, nil
 }
 
-func (db *Sqlite3DB) GetSubmissionByRequestIDAndSubmitterID(requestID int, submitterID int) (*Submission, error) {
-	var submission Submission
-	row := db.Conn.QueryRow(
-		"SELECT id, request_id, submitter_id, created_at, updated_at FROM submissions WHERE request_id = ? AND submitter_id = ?",
-		requestID, submitterID,
-	)
-	err := row.Scan(&submission.ID, &submission.RequestID, &submission.SubmitterID, &submission.CreatedAt, &submission.UpdatedAt)
+// 組織のグループを取得
+func (db *Sqlite3DB) GetGroupsByOrganizationID(ctx context.Context, organizationID int) ([]Group, error) {
+	return db.queryGroups(ctx, "SELECT "+groupColumns+" FROM user_groups WHERE organization_id = ? ORDER BY id", organizationID)
+}
+
+// グループIDでグループを取得
+func (db *Sqlite3DB) GetGroupByID(ctx context.Context, id int) (Group, error) {
+	var group Group
+	row := db.Conn.QueryRowContext(ctx, "SELECT "+groupColumns+" FROM user_groups WHERE id = ?", id)
+	err := row.Scan(&group.ID, &group.OrganizationID, &group.Name, &group.CreatedAt)
 	if err == sql.ErrNoRows {
-		return nil, nil // 存在しない場合はnilを返す
+		return Group{}, ErrGroupNotFound
 	}
 	if err != nil {
-		return nil, err
+		return Group{}, err
 	}
-	return &submission, nil
+	return group, nil
 }
 
-// 新しいシフトリクエストを作成
-func (db *Sqlite3DB) CreateRequest(creatorID int, startDate string, endDate string, deadline string) (int, error) {
-	res, err := db.Conn.Exec(
-		"INSERT INTO requests (creator_id, start_date, end_date, deadline) VALUES (?, ?, ?, ?)",
-		creatorID, startDate, endDate, deadline,
-	)
+// 複数のグループをまとめて取得
+func (db *Sqlite3DB) GetGroupsByIDs(ctx context.Context, ids []int) ([]Group, error) {
+	if len(ids) == 0 {
+		return []Group{}, nil
+	}
+	return db.queryGroups(ctx, "SELECT "+groupColumns+" FROM user_groups WHERE id IN ("+placeholders(len(ids))+") ORDER BY id", intArgs(ids)...)
+}
+
+// 新しいグループを作成
+func (db *Sqlite3DB) CreateGroup(ctx context.Context, organizationID int, name string) (int, error) {
+	res, err := db.Conn.ExecContext(ctx, "INSERT INTO user_groups (organization_id, name) VALUES (?, ?)", organizationID, name)
 	if err != nil {
 		return -1, err
 	}
@@ -159,58 +347,1148 @@ func (db *Sqlite3DB) CreateRequest(creatorID int, startDate string, endDate stri
 	return int(id), nil
 }
 
-// 新しい1つのエントリーを作成
-func (db *Sqlite3DB) createEntry(submissionID int, date string, hour int) (int, error) {
-	res, err := db.Conn.Exec(
-		"INSERT INTO entries (submission_id, date, hour) VALUES (?, ?, ?)",
-		submissionID, date, hour,
+// 複数のグループの所属ユーザーをまとめて取得
+func (db *Sqlite3DB) GetGroupMembers(ctx context.Context, groupIDs []int) ([]GroupMember, error) {
+	if len(groupIDs) == 0 {
+		return []GroupMember{}, nil
+	}
+
+	rows, err := db.Conn.QueryContext(ctx,
+		"SELECT group_id, user_id FROM user_group_members WHERE group_id IN ("+placeholders(len(groupIDs))+") ORDER BY group_id, user_id",
+		intArgs(groupIDs)...,
 	)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	id, err := res.LastInsertId()
+	members := []GroupMember{}
+	for rows.Next() {
+		var member GroupMember
+		if err := rows.Scan(&member.GroupID, &member.UserID); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// ユーザーが所属するグループのIDを取得
+func (db *Sqlite3DB) GetGroupIDsByUserID(ctx context.Context, userID int) ([]int, error) {
+	rows, err := db.Conn.QueryContext(ctx, "SELECT group_id FROM user_group_members WHERE user_id = ? ORDER BY group_id", userID)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
-	return int(id), nil
+	defer rows.Close()
+
+	groupIDs := []int{}
+	for rows.Next() {
+		var groupID int
+		if err := rows.Scan(&groupID); err != nil {
+			return nil, err
+		}
+		groupIDs = append(groupIDs, groupID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return groupIDs, nil
 }
 
-// 新しいエントリーを作成
-func (db *Sqlite3DB) CreateEntries(entries []Entry) ([]int, error) {
-	tx, err := db.Conn.Begin()
+// ユーザーをグループに追加。追加済みの場合は何もしない
+func (db *Sqlite3DB) AddGroupMember(ctx context.Context, groupID int, userID int) error {
+	_, err := db.Conn.ExecContext(ctx, "INSERT OR IGNORE INTO user_group_members (group_id, user_id) VALUES (?, ?)", groupID, userID)
+	return err
+}
+
+// ユーザーをグループから外す。所属していない場合は何もしない
+func (db *Sqlite3DB) RemoveGroupMember(ctx context.Context, groupID int, userID int) error {
+	_, err := db.Conn.ExecContext(ctx, "DELETE FROM user_group_members WHERE group_id = ? AND user_id = ?", groupID, userID)
+	return err
+}
+
+// requestsテーブルから取得する列
+const requestColumns = "id, organization_id, creator_id, start_date, end_date, deadline, created_at"
+
+// 組織の全リクエストを取得
+func (db *Sqlite3DB) GetRequests(ctx context.Context, organizationID int) ([]Request, error) {
+	rows, err := db.Conn.QueryContext(ctx, "SELECT "+requestColumns+" FROM requests WHERE organization_id = ?", organizationID)
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
+	defer rows.Close()
 
-	var ids []int
-	for _, entry := range entries {
-		id, err := db.createEntry(entry.SubmissionID, entry.Date, entry.Hour)
+	var requests []Request
+	for rows.Next() {
+		var req Request
+		err := rows.Scan(&req.ID, &req.OrganizationID, &req.CreatorID, &req.StartDate, &req.EndDate, &req.Deadline, &req.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
-		ids = append(ids, id)
+		requests = append(requests, req)
 	}
+	return requests, nil
+}
 
-	if err := tx.Commit(); err != nil {
+// 検索条件に一致するリクエストを取得
+func (db *Sqlite3DB) QueryRequests(ctx context.Context, query RequestQuery) ([]Request, error) {
+	conds := []string{"organization_id = ?"}
+	args := []any{query.OrganizationID}
+
+	if query.From != "" {
+		conds = append(conds, "end_date >= ?")
+		args = append(args, query.From)
+	}
+	if query.To != "" {
+		conds = append(conds, "start_date <= ?")
+		args = append(args, query.To)
+	}
+	if query.DeadlineAfter != "" {
+		conds = append(conds, "deadline > ?")
+		args = append(args, query.DeadlineAfter)
+	}
+	if query.DeadlineNotAfter != "" {
+		conds = append(conds, "deadline <= ?")
+		args = append(args, query.DeadlineNotAfter)
+	}
+	if query.CreatorID != 0 {
+		conds = append(conds, "creator_id = ?")
+		args = append(args, query.CreatorID)
+	}
+	if query.TargetUserID != 0 {
+		conds = append(conds, "(NOT EXISTS (SELECT 1 FROM request_target_users WHERE request_id = requests.id)"+
+			" AND NOT EXISTS (SELECT 1 FROM request_target_groups WHERE request_id = requests.id)"+
+			" OR EXISTS (SELECT 1 FROM request_target_users WHERE request_id = requests.id AND user_id = ?)"+
+			" OR EXISTS (SELECT 1 FROM request_target_groups JOIN user_group_members USING (group_id) WHERE request_id = requests.id AND user_id = ?))")
+		args = append(args, query.TargetUserID, query.TargetUserID)
+	}
+	if query.SubmitterID != 0 {
+		exists := "EXISTS (SELECT 1 FROM submissions WHERE submissions.request_id = requests.id AND submissions.submitter_id = ?)"
+		if !query.HasSubmission {
+			exists = "NOT " + exists
+		}
+		conds = append(conds, exists)
+		args = append(args, query.SubmitterID)
+	}
+
+	// ソートキーはカラム名としてSQLに埋め込むので、許可されたものだけを使う
+	sortColumn := RequestSortCreatedAt
+	switch query.Sort {
+	case RequestSortStartDate, RequestSortDeadline:
+		sortColumn = query.Sort
+	}
+	direction, cmp := "ASC", ">"
+	if query.Desc {
+		direction, cmp = "DESC", "<"
+	}
+
+	if query.After != nil {
+		conds = append(conds, "("+sortColumn+" "+cmp+" ? OR ("+sortColumn+" = ? AND id "+cmp+" ?))")
+		args = append(args, query.After.SortValue, query.After.SortValue, query.After.ID)
+	}
+
+	q := "SELECT " + requestColumns + " FROM requests WHERE " + strings.Join(conds, " AND ")
+	q += " ORDER BY " + sortColumn + " " + direction + ", id " + direction
+	if query.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := db.Conn.QueryContext(ctx, q, args...)
+	if err != nil {
 		return nil, err
 	}
-	return ids, nil
+	defer rows.Close()
+
+	var requests []Request
+	for rows.Next() {
+		var req Request
+		err := rows.Scan(&req.ID, &req.OrganizationID, &req.CreatorID, &req.StartDate, &req.EndDate, &req.Deadline, &req.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return requests, nil
 }
 
-func (db *Sqlite3DB) CreateSubmission(submitterID int, requestID int) (int, error) {
-	res, err := db.Conn.Exec(
-		"INSERT INTO submissions (submitter_id, request_id) VALUES (?, ?)",
-		submitterID, requestID,
-	)
+// 指定リクエストIDのリクエストを取得
+func (db *Sqlite3DB) GetRequestByID(ctx context.Context, id int) (Request, error) {
+	var req Request
+	row := db.Conn.QueryRowContext(ctx, "SELECT "+requestColumns+" FROM requests WHERE id = ?", id)
+	err := row.Scan(&req.ID, &req.OrganizationID, &req.CreatorID, &req.StartDate, &req.EndDate, &req.Deadline, &req.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Request{}, ErrRequestNotFound
+	}
 	if err != nil {
-		return -1, err
+		return Request{}, err
+	}
+	return req, nil
+}
+
+// 指定リクエストIDのエントリー一覧を取得
+func (db *Sqlite3DB) GetEntriesBySubmissionID(ctx context.Context, submissionID int) ([]Entry, error) {
+	rows, err := db.Conn.QueryContext(ctx, "SELECT id, submission_id, date, hour FROM entries WHERE submission_id = ?", submissionID)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	id, err := res.LastInsertId()
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		err := rows.Scan(&entry.ID, &entry.SubmissionID, &entry.Date, &entry.Hour)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// 複数の提出IDのエントリー一覧をまとめて取得
+func (db *Sqlite3DB) GetEntriesBySubmissionIDs(ctx context.Context, submissionIDs []int) ([]Entry, error) {
+	if len(submissionIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := db.Conn.QueryContext(ctx,
+		"SELECT id, submission_id, date, hour FROM entries WHERE submission_id IN ("+placeholders(len(submissionIDs))+") ORDER BY id",
+		intArgs(submissionIDs)...,
+	)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
-	return int(id), nil
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		err := rows.Scan(&entry.ID, &entry.SubmissionID, &entry.Date, &entry.Hour)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (db *Sqlite3DB) GetSubmissionsByRequestID(ctx context.Context, requestID int) ([]Submission, error) {
+	rows, err := db.Conn.QueryContext(ctx, "SELECT id, request_id, submitter_id, created_at, updated_at FROM submissions WHERE request_id = ?", requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var submissions []Submission
+	for rows.Next() {
+		var submission Submission
+		err := rows.Scan(&submission.ID, &submission.RequestID, &submission.SubmitterID, &submission.CreatedAt, &submission.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		submissions = append(submissions, submission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return submissions, nil
+}
+
+func (db *Sqlite3DB) GetSubmissionByRequestIDAndSubmitterID(ctx context.Context, requestID int, submitterID int) (*Submission, error) {
+	var submission Submission
+	row := db.Conn.QueryRowContext(ctx,
+		"SELECT id, request_id, submitter_id, created_at, updated_at FROM submissions WHERE request_id = ? AND submitter_id = ?",
+		requestID, submitterID,
+	)
+	err := row.Scan(&submission.ID, &submission.RequestID, &submission.SubmitterID, &submission.CreatedAt, &submission.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrSubmissionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &submission, nil
+}
+
+func (db *Sqlite3DB) GetSubmissionByID(ctx context.Context, id int) (*Submission, error) {
+	var submission Submission
+	row := db.Conn.QueryRowContext(ctx, "SELECT id, request_id, submitter_id, created_at, updated_at FROM submissions WHERE id = ?", id)
+	err := row.Scan(&submission.ID, &submission.RequestID, &submission.SubmitterID, &submission.CreatedAt, &submission.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrSubmissionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &submission, nil
+}
+
+// 新しいシフトリクエストを対象と合わせて作成
+// 対象のないシフトリクエストが残らないように、1つのトランザクションで作成する
+func (db *Sqlite3DB) CreateRequest(ctx context.Context, organizationID int, creatorID int, startDate string, endDate string, deadline string, targets RequestTargets) (int, error) {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO requests (organization_id, creator_id, start_date, end_date, deadline) VALUES (?, ?, ?, ?, ?)",
+		organizationID, creatorID, startDate, endDate, deadline,
+	)
+	if err != nil {
+		return -1, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	if err := db.createRequestTargets(ctx, tx, int(id), targets); err != nil {
+		return -1, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// シフトリクエストの締め切りを変更
+func (db *Sqlite3DB) UpdateRequestDeadline(ctx context.Context, requestID int, deadline string) error {
+	res, err := db.Conn.ExecContext(ctx, "UPDATE requests SET deadline = ? WHERE id = ?", deadline, requestID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRequestNotFound
+	}
+	return nil
+}
+
+// シフトリクエストの対象を保存
+func (db *Sqlite3DB) CreateRequestTargets(ctx context.Context, requestID int, targets RequestTargets) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.createRequestTargets(ctx, tx, requestID, targets); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *Sqlite3DB) createRequestTargets(ctx context.Context, tx *sql.Tx, requestID int, targets RequestTargets) error {
+	for _, userID := range targets.UserIDs {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO request_target_users (request_id, user_id) VALUES (?, ?)", requestID, userID); err != nil {
+			return err
+		}
+	}
+	for _, groupID := range targets.GroupIDs {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO request_target_groups (request_id, group_id) VALUES (?, ?)", requestID, groupID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// シフトリクエストの対象を取得
+func (db *Sqlite3DB) GetRequestTargets(ctx context.Context, requestID int) (RequestTargets, error) {
+	targets := RequestTargets{UserIDs: []int{}, GroupIDs: []int{}}
+
+	queries := []struct {
+		query string
+		ids   *[]int
+	}{
+		{"SELECT user_id FROM request_target_users WHERE request_id = ? ORDER BY user_id", &targets.UserIDs},
+		{"SELECT group_id FROM request_target_groups WHERE request_id = ? ORDER BY group_id", &targets.GroupIDs},
+	}
+	for _, q := range queries {
+		rows, err := db.Conn.QueryContext(ctx, q.query, requestID)
+		if err != nil {
+			return RequestTargets{}, err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return RequestTargets{}, err
+			}
+			*q.ids = append(*q.ids, id)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return RequestTargets{}, err
+		}
+	}
+	return targets, nil
+}
+
+// 新しい1つのエントリーを作成
+func (db *Sqlite3DB) createEntry(ctx context.Context, tx *sql.Tx, submissionID int, date string, hour int) (int, error) {
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO entries (submission_id, date, hour) VALUES (?, ?, ?)",
+		submissionID, date, hour,
+	)
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// 新しいエントリーを作成
+func (db *Sqlite3DB) CreateEntries(ctx context.Context, entries []Entry) ([]int, error) {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var ids []int
+	for _, entry := range entries {
+		id, err := db.createEntry(ctx, tx, entry.SubmissionID, entry.Date, entry.Hour)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (db *Sqlite3DB) CreateSubmission(ctx context.Context, submitterID int, requestID int) (int, error) {
+	res, err := db.Conn.ExecContext(ctx,
+		"INSERT INTO submissions (submitter_id, request_id, updated_at) VALUES (?, ?, DATETIME('now', 'localtime'))",
+		submitterID, requestID,
+	)
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// 提出のエントリーを全て置き換え、更新日時を現在にする
+func (db *Sqlite3DB) ReplaceSubmissionEntries(ctx context.Context, submissionID int, entries []Entry) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE submissions SET updated_at = DATETIME('now', 'localtime') WHERE id = ?", submissionID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSubmissionNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM entries WHERE submission_id = ?", submissionID); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := db.createEntry(ctx, tx, submissionID, entry.Date, entry.Hour); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// 監査ログのイベントを追加
+func (db *Sqlite3DB) CreateAuditEvent(ctx context.Context, event AuditEvent) (int, error) {
+	res, err := db.Conn.ExecContext(ctx,
+		"INSERT INTO audit_events (organization_id, actor_id, action, target_type, target_id, before, after, client_ip) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		event.OrganizationID, event.ActorID, event.Action, event.TargetType, event.TargetID, event.Before, event.After, event.ClientIP,
+	)
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(id), nil
+}
+
+// 検索条件に一致する監査ログを新しい順に取得
+func (db *Sqlite3DB) QueryAuditEvents(ctx context.Context, query AuditQuery) ([]AuditEvent, error) {
+	conds := []string{"organization_id = ?"}
+	args := []any{query.OrganizationID}
+
+	if query.ActorID != 0 {
+		conds = append(conds, "actor_id = ?")
+		args = append(args, query.ActorID)
+	}
+	if query.Action != "" {
+		conds = append(conds, "action = ?")
+		args = append(args, query.Action)
+	}
+	if query.TargetType != "" {
+		conds = append(conds, "target_type = ?")
+		args = append(args, query.TargetType)
+	}
+	if query.TargetID != 0 {
+		conds = append(conds, "target_id = ?")
+		args = append(args, query.TargetID)
+	}
+	if query.Since != "" {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, query.Since)
+	}
+	if query.Until != "" {
+		conds = append(conds, "created_at < ?")
+		args = append(args, query.Until)
+	}
+	if query.BeforeID != 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, query.BeforeID)
+	}
+
+	q := "SELECT id, organization_id, actor_id, action, target_type, target_id, before, after, client_ip, created_at FROM audit_events WHERE " + strings.Join(conds, " AND ")
+	q += " ORDER BY id DESC"
+	if query.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := db.Conn.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var event AuditEvent
+		err := rows.Scan(&event.ID, &event.OrganizationID, &event.ActorID, &event.Action, &event.TargetType, &event.TargetID, &event.Before, &event.After, &event.ClientIP, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ログイン試行の失敗状況を取得
+func (db *Sqlite3DB) GetLoginThrottle(ctx context.Context, key string) (LoginThrottle, error) {
+	var throttle LoginThrottle
+	row := db.Conn.QueryRowContext(ctx, "SELECT key, failures, last_failure_at, blocked_until FROM login_throttles WHERE key = ?", key)
+	err := row.Scan(&throttle.Key, &throttle.Failures, &throttle.LastFailureAt, &throttle.BlockedUntil)
+	if err == sql.ErrNoRows {
+		return LoginThrottle{}, ErrThrottleNotFound
+	}
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	return throttle, nil
+}
+
+// ログイン試行の失敗状況を保存(存在する場合は上書き)
+func (db *Sqlite3DB) SaveLoginThrottle(ctx context.Context, throttle LoginThrottle) error {
+	_, err := db.Conn.ExecContext(ctx,
+		`INSERT INTO login_throttles (key, failures, last_failure_at, blocked_until) VALUES (?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET failures = excluded.failures, last_failure_at = excluded.last_failure_at, blocked_until = excluded.blocked_until`,
+		throttle.Key, throttle.Failures, throttle.LastFailureAt, throttle.BlockedUntil,
+	)
+	return err
+}
+
+// ログイン試行の失敗回数を1増やし、増やした後の回数を返す
+// 最後の失敗がresetBeforeより前の場合は1からやり直す
+// 同時に失敗しても数え漏れがないように、読み込みと更新を1つのクエリで行う
+func (db *Sqlite3DB) IncrementLoginFailures(ctx context.Context, key string, failedAt int64, resetBefore int64) (int, error) {
+	var failures int
+	row := db.Conn.QueryRowContext(ctx,
+		`INSERT INTO login_throttles (key, failures, last_failure_at, blocked_until) VALUES (?, 1, ?, 0)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures`,
+		key, failedAt, resetBefore,
+	)
+	if err := row.Scan(&failures); err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+// 次の試行を受け付ける時刻を設定する
+// 同時に失敗した場合に短い待ち時間で上書きしないように、今より後の場合だけ更新する
+func (db *Sqlite3DB) ExtendLoginBlock(ctx context.Context, key string, blockedUntil int64) error {
+	_, err := db.Conn.ExecContext(ctx, "UPDATE login_throttles SET blocked_until = MAX(blocked_until, ?) WHERE key = ?", blockedUntil, key)
+	return err
+}
+
+// ログイン試行の失敗状況を削除
+func (db *Sqlite3DB) DeleteLoginThrottle(ctx context.Context, key string) error {
+	_, err := db.Conn.ExecContext(ctx, "DELETE FROM login_throttles WHERE key = ?", key)
+	return err
+}
+
+// パスワードを更新し、既存のセッションを無効にする
+func (db *Sqlite3DB) UpdateUserPassword(ctx context.Context, userID int, password string) error {
+	res, err := db.Conn.ExecContext(ctx,
+		"UPDATE users SET password = ?, session_version = session_version + 1 WHERE id = ?",
+		password, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// パスワードリセット用のトークンを保存
+func (db *Sqlite3DB) CreatePasswordReset(ctx context.Context, reset PasswordReset) error {
+	_, err := db.Conn.ExecContext(ctx,
+		"INSERT INTO password_resets (token_hash, user_id, creator_id, expires_at, used_at) VALUES (?, ?, ?, ?, ?)",
+		reset.TokenHash, reset.UserID, reset.CreatorID, reset.ExpiresAt, reset.UsedAt,
+	)
+	return err
+}
+
+// パスワードリセット用のトークンを取得
+func (db *Sqlite3DB) GetPasswordReset(ctx context.Context, tokenHash string) (PasswordReset, error) {
+	var reset PasswordReset
+	row := db.Conn.QueryRowContext(ctx, "SELECT token_hash, user_id, creator_id, expires_at, used_at FROM password_resets WHERE token_hash = ?", tokenHash)
+	err := row.Scan(&reset.TokenHash, &reset.UserID, &reset.CreatorID, &reset.ExpiresAt, &reset.UsedAt)
+	if err == sql.ErrNoRows {
+		return PasswordReset{}, ErrPasswordResetNotFound
+	}
+	if err != nil {
+		return PasswordReset{}, err
+	}
+	return reset, nil
+}
+
+// パスワードリセット用のトークンを使用済みにする
+// 未使用のトークンが存在しない場合はErrPasswordResetNotFoundを返す
+func (db *Sqlite3DB) UsePasswordReset(ctx context.Context, tokenHash string, usedAt int64) error {
+	res, err := db.Conn.ExecContext(ctx,
+		"UPDATE password_resets SET used_at = ? WHERE token_hash = ? AND used_at = 0",
+		usedAt, tokenHash,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPasswordResetNotFound
+	}
+	return nil
+}
+
+// 二要素認証の設定を取得
+func (db *Sqlite3DB) GetUserTOTP(ctx context.Context, userID int) (UserTOTP, error) {
+	var totp UserTOTP
+	row := db.Conn.QueryRowContext(ctx, "SELECT user_id, secret, confirmed_at, last_used_step FROM user_totps WHERE user_id = ?", userID)
+	err := row.Scan(&totp.UserID, &totp.Secret, &totp.ConfirmedAt, &totp.LastUsedStep)
+	if err == sql.ErrNoRows {
+		return UserTOTP{}, ErrTOTPNotFound
+	}
+	if err != nil {
+		return UserTOTP{}, err
+	}
+	return totp, nil
+}
+
+// 二要素認証の設定を保存する。既に存在する場合は上書きする
+func (db *Sqlite3DB) SaveUserTOTP(ctx context.Context, totp UserTOTP) error {
+	_, err := db.Conn.ExecContext(ctx,
+		`INSERT INTO user_totps (user_id, secret, confirmed_at, last_used_step) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, confirmed_at = excluded.confirmed_at, last_used_step = excluded.last_used_step`,
+		totp.UserID, totp.Secret, totp.ConfirmedAt, totp.LastUsedStep,
+	)
+	return err
+}
+
+// 二要素認証の設定とリカバリーコードを削除する
+func (db *Sqlite3DB) DeleteUserTOTP(ctx context.Context, userID int) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_totps WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// リカバリーコードを新しいものに置き換える
+func (db *Sqlite3DB) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// リカバリーコードを使用済みにする
+// 未使用のコードが存在しない場合はErrRecoveryCodeNotFoundを返す
+func (db *Sqlite3DB) UseRecoveryCode(ctx context.Context, userID int, codeHash string, usedAt int64) error {
+	res, err := db.Conn.ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at = 0",
+		usedAt, userID, codeHash,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+	return nil
+}
+
+// APIトークンを保存
+func (db *Sqlite3DB) CreateAPIToken(ctx context.Context, token APIToken) (int, error) {
+	res, err := db.Conn.ExecContext(ctx,
+		"INSERT INTO api_tokens (user_id, creator_id, name, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		token.UserID, token.CreatorID, token.Name, token.TokenHash, token.Scopes, token.ExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// 組織のユーザーに発行したAPIトークンを全て取得
+func (db *Sqlite3DB) GetAPITokensByOrganizationID(ctx context.Context, organizationID int) ([]APIToken, error) {
+	rows, err := db.Conn.QueryContext(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id IN (SELECT id FROM users WHERE organization_id = ?) ORDER BY id", organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		var token APIToken
+		err := rows.Scan(&token.ID, &token.UserID, &token.CreatorID, &token.Name, &token.TokenHash, &token.Scopes, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt, &token.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// IDでAPIトークンを取得
+func (db *Sqlite3DB) GetAPITokenByID(ctx context.Context, id int) (APIToken, error) {
+	var token APIToken
+	row := db.Conn.QueryRowContext(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE id = ?", id)
+	err := row.Scan(&token.ID, &token.UserID, &token.CreatorID, &token.Name, &token.TokenHash, &token.Scopes, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt, &token.RevokedAt)
+	if err == sql.ErrNoRows {
+		return APIToken{}, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return APIToken{}, err
+	}
+	return token, nil
+}
+
+// ハッシュ値でAPIトークンを取得
+func (db *Sqlite3DB) GetAPITokenByHash(ctx context.Context, tokenHash string) (APIToken, error) {
+	var token APIToken
+	row := db.Conn.QueryRowContext(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", tokenHash)
+	err := row.Scan(&token.ID, &token.UserID, &token.CreatorID, &token.Name, &token.TokenHash, &token.Scopes, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt, &token.RevokedAt)
+	if err == sql.ErrNoRows {
+		return APIToken{}, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return APIToken{}, err
+	}
+	return token, nil
+}
+
+// APIトークンを失効させる
+// 存在しない、または失効済みの場合はErrAPITokenNotFoundを返す
+func (db *Sqlite3DB) RevokeAPIToken(ctx context.Context, id int, revokedAt int64) error {
+	res, err := db.Conn.ExecContext(ctx,
+		"UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at = 0",
+		revokedAt, id,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// APIトークンの最終使用日時を更新
+func (db *Sqlite3DB) TouchAPIToken(ctx context.Context, id int, usedAt int64) error {
+	_, err := db.Conn.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE id = ?", usedAt, id)
+	return err
+}
+
+const apiTokenColumns = "id, user_id, creator_id, name, token_hash, scopes, created_at, expires_at, last_used_at, revoked_at"
+
+// IN句用のプレースホルダー "?, ?, ..." を生成
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// []intをクエリ引数に変換
+func intArgs(ids []int) []any {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+// 送信済みの通知を取得
+func (db *Sqlite3DB) GetNotificationDeliveries(ctx context.Context, kind string, requestID int) ([]NotificationDelivery, error) {
+	rows, err := db.Conn.QueryContext(ctx, "SELECT kind, request_id, user_id, sent_at FROM notification_deliveries WHERE kind = ? AND request_id = ? ORDER BY user_id", kind, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []NotificationDelivery{}
+	for rows.Next() {
+		var delivery NotificationDelivery
+		if err := rows.Scan(&delivery.Kind, &delivery.RequestID, &delivery.UserID, &delivery.SentAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// 送信済みの通知を記録。記録済みの場合は何もしない
+func (db *Sqlite3DB) CreateNotificationDelivery(ctx context.Context, delivery NotificationDelivery) error {
+	_, err := db.Conn.ExecContext(ctx,
+		"INSERT OR IGNORE INTO notification_deliveries (kind, request_id, user_id, sent_at) VALUES (?, ?, ?, ?)",
+		delivery.Kind, delivery.RequestID, delivery.UserID, delivery.SentAt,
+	)
+	return err
+}
+
+// アプリ内の通知を作成
+// DedupKeyが同じ通知がユーザーに作成済みの場合は、作成せずにそのIDを返す
+func (db *Sqlite3DB) CreateUserNotification(ctx context.Context, notification UserNotification) (int, error) {
+	// dedup_keyはNULLの場合にUNIQUE制約の対象外になる
+	var dedupKey any
+	if notification.DedupKey != "" {
+		dedupKey = notification.DedupKey
+	}
+
+	var id int
+	err := db.Conn.QueryRowContext(ctx,
+		`INSERT INTO user_notifications (user_id, kind, request_id, title, body, dedup_key) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, dedup_key) DO UPDATE SET dedup_key = excluded.dedup_key
+		RETURNING id`,
+		notification.UserID, notification.Kind, notification.RequestID, notification.Title, notification.Body, dedupKey,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// アプリ内の通知を新しい順に取得
+func (db *Sqlite3DB) QueryUserNotifications(ctx context.Context, query UserNotificationQuery) ([]UserNotification, error) {
+	conds := []string{"user_id = ?"}
+	args := []any{query.UserID}
+
+	if query.UnreadOnly {
+		conds = append(conds, "read_at = 0")
+	}
+	if query.BeforeID != 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, query.BeforeID)
+	}
+
+	q := "SELECT id, user_id, kind, request_id, title, body, IFNULL(dedup_key, ''), created_at, read_at FROM user_notifications WHERE " + strings.Join(conds, " AND ")
+	q += " ORDER BY id DESC"
+	if query.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := db.Conn.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []UserNotification{}
+	for rows.Next() {
+		var n UserNotification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.RequestID, &n.Title, &n.Body, &n.DedupKey, &n.CreatedAt, &n.ReadAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// 未読の通知の件数を取得
+func (db *Sqlite3DB) CountUnreadUserNotifications(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := db.Conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM user_notifications WHERE user_id = ? AND read_at = 0", userID).Scan(&count)
+	return count, err
+}
+
+// 通知を既読にする。既読の場合は既読にした日時を変更しない
+// 他のユーザーの通知の場合はErrNotificationNotFoundを返す
+func (db *Sqlite3DB) ReadUserNotification(ctx context.Context, id int, userID int, readAt int64) error {
+	var exists bool
+	err := db.Conn.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM user_notifications WHERE id = ? AND user_id = ?)", id, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotificationNotFound
+	}
+
+	_, err = db.Conn.ExecContext(ctx, "UPDATE user_notifications SET read_at = ? WHERE id = ? AND read_at = 0", readAt, id)
+	return err
+}
+
+// webhook_subscriptionsテーブルから取得する列
+const webhookSubscriptionColumns = "id, organization_id, creator_id, url, secret, events, created_at"
+
+func (db *Sqlite3DB) queryWebhookSubscriptions(ctx context.Context, query string, args ...any) ([]WebhookSubscription, error) {
+	rows, err := db.Conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []WebhookSubscription{}
+	for rows.Next() {
+		var s WebhookSubscription
+		if err := rows.Scan(&s.ID, &s.OrganizationID, &s.CreatorID, &s.URL, &s.Secret, &s.Events, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// Webhookを登録
+func (db *Sqlite3DB) CreateWebhookSubscription(ctx context.Context, subscription WebhookSubscription) (int, error) {
+	res, err := db.Conn.ExecContext(ctx,
+		"INSERT INTO webhook_subscriptions (organization_id, creator_id, url, secret, events) VALUES (?, ?, ?, ?, ?)",
+		subscription.OrganizationID, subscription.CreatorID, subscription.URL, subscription.Secret, subscription.Events,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// 組織のWebhookを全て取得
+func (db *Sqlite3DB) GetWebhookSubscriptionsByOrganizationID(ctx context.Context, organizationID int) ([]WebhookSubscription, error) {
+	return db.queryWebhookSubscriptions(ctx, "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE organization_id = ? ORDER BY id", organizationID)
+}
+
+// IDでWebhookを取得
+func (db *Sqlite3DB) GetWebhookSubscriptionByID(ctx context.Context, id int) (WebhookSubscription, error) {
+	subscriptions, err := db.queryWebhookSubscriptions(ctx, "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE id = ?", id)
+	if err != nil {
+		return WebhookSubscription{}, err
+	}
+	if len(subscriptions) == 0 {
+		return WebhookSubscription{}, ErrWebhookNotFound
+	}
+	return subscriptions[0], nil
+}
+
+// Webhookを送信状況と合わせて削除
+func (db *Sqlite3DB) DeleteWebhookSubscription(ctx context.Context, id int) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE subscription_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Webhookで送るイベントを送信待ちに追加
+func (db *Sqlite3DB) CreateWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, d := range deliveries {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO webhook_deliveries (subscription_id, event, payload, status, attempts, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?)",
+			d.SubscriptionID, d.Event, d.Payload, d.Status, d.Attempts, d.NextAttemptAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// webhook_deliveriesテーブルから取得する列
+const webhookDeliveryColumns = "id, subscription_id, event, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at"
+
+func (db *Sqlite3DB) queryWebhookDeliveries(ctx context.Context, query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := db.Conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.SubscriptionID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Webhookの送信状況を新しい順に取得
+func (db *Sqlite3DB) QueryWebhookDeliveries(ctx context.Context, query WebhookDeliveryQuery) ([]WebhookDelivery, error) {
+	conds := []string{"subscription_id = ?"}
+	args := []any{query.SubscriptionID}
+
+	if query.BeforeID != 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, query.BeforeID)
+	}
+
+	q := "SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries WHERE " + strings.Join(conds, " AND ")
+	q += " ORDER BY id DESC"
+	if query.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+	return db.queryWebhookDeliveries(ctx, q, args...)
+}
+
+// 送信する時刻になった送信待ちのイベントを古い順に取得
+func (db *Sqlite3DB) GetDueWebhookDeliveries(ctx context.Context, now int64, limit int) ([]WebhookDelivery, error) {
+	q := "SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY id"
+	args := []any{WebhookDeliveryPending, now}
+	if limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, limit)
+	}
+	return db.queryWebhookDeliveries(ctx, q, args...)
+}
+
+// 送信の結果を記録
+func (db *Sqlite3DB) UpdateWebhookDelivery(ctx context.Context, d WebhookDelivery) error {
+	_, err := db.Conn.ExecContext(ctx,
+		"UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_status_code = ?, last_error = ?, delivered_at = ? WHERE id = ?",
+		d.Status, d.Attempts, d.NextAttemptAt, d.LastStatusCode, d.LastError, d.DeliveredAt, d.ID,
+	)
+	return err
 }
@@ -0,0 +1,116 @@
+package event
+
+import "sync"
+
+// 1つの購読者に溜めておけるイベントの数
+// 読み出しが追いつかない購読者は、メモリを使い続けないように購読を解除する
+const subscriptionBufferSize = 32
+
+// 購読者に送るイベント
+type Event struct {
+	// イベントの種類。SSEのevent名に使う
+	Name string
+	// イベントの内容。JSONにして送る
+	Data any
+}
+
+// プロセス内でイベントを配信する
+// トピックごとに購読者を管理し、Publishしたイベントをそのトピックの全ての購読者に送る
+// 複数のプロセスで動かす場合、他のプロセスで起きたイベントは届かない
+type Hub struct {
+	mu     sync.Mutex
+	topics map[string]map[*Subscription]struct{}
+	// Closeした後はtrue
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{topics: make(map[string]map[*Subscription]struct{})}
+}
+
+// トピックの購読。使い終わったら必ずCloseする
+type Subscription struct {
+	hub   *Hub
+	topic string
+	ch    chan Event
+}
+
+// トピックを購読する
+// Closeした後は、閉じられたチャネルを持つ購読を返す
+func (h *Hub) Subscribe(topic string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &Subscription{hub: h, topic: topic, ch: make(chan Event, subscriptionBufferSize)}
+	if h.closed {
+		close(sub.ch)
+		return sub
+	}
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*Subscription]struct{})
+	}
+	h.topics[topic][sub] = struct{}{}
+	return sub
+}
+
+// トピックの全ての購読者にイベントを送る。購読者を待たずにすぐ返る
+// バッファがいっぱいの購読者は購読を解除する
+func (h *Hub) Publish(topic string, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.topics[topic] {
+		select {
+		case sub.ch <- event:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// トピックの購読者の数
+func (h *Hub) SubscriberCount(topic string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.topics[topic])
+}
+
+// 全ての購読を解除する。サーバーの終了時に、購読者に接続を閉じさせるために使う
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subs := range h.topics {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
+
+// 購読者を削除してチャネルを閉じる。削除済みの場合は何もしない
+// h.muをロックして呼び出す
+func (h *Hub) remove(sub *Subscription) {
+	subs := h.topics[sub.topic]
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.topics, sub.topic)
+	}
+	close(sub.ch)
+}
+
+// イベントを受け取るチャネル
+// 購読が解除されると閉じられる
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// 購読を解除する。何度呼び出してもよい
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
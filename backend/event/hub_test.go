@@ -0,0 +1,111 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+)
+
+func receive(t *testing.T, sub *Subscription) []Event {
+	t.Helper()
+	var events []Event
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	sub1 := hub.Subscribe("request:1")
+	sub2 := hub.Subscribe("request:1")
+	other := hub.Subscribe("request:2")
+
+	// 同じトピックの全ての購読者に送る
+	hub.Publish("request:1", Event{Name: "submission.created", Data: 1})
+	want := []Event{{Name: "submission.created", Data: 1}}
+	if got := receive(t, sub1); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := receive(t, sub2); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	// 他のトピックには送らない
+	if got := receive(t, other); len(got) != 0 {
+		t.Errorf("got %+v, want no events", got)
+	}
+
+	// 購読を解除するとチャネルが閉じられ、以降は送らない
+	sub1.Close()
+	sub1.Close()
+	if _, ok := <-sub1.Events(); ok {
+		t.Errorf("channel should be closed")
+	}
+	if got := hub.SubscriberCount("request:1"); got != 1 {
+		t.Errorf("got %d subscribers, want 1", got)
+	}
+	hub.Publish("request:1", Event{Name: "submission.created", Data: 2})
+	if got := receive(t, sub2); len(got) != 1 {
+		t.Errorf("got %+v, want 1 event", got)
+	}
+
+	sub2.Close()
+	other.Close()
+	if got := hub.SubscriberCount("request:1"); got != 0 {
+		t.Errorf("got %d subscribers, want 0", got)
+	}
+	// 購読者がいなくても送れる
+	hub.Publish("request:1", Event{Name: "submission.created", Data: 3})
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	hub := NewHub()
+	slow := hub.Subscribe("request:1")
+	defer slow.Close()
+
+	// バッファを超えたら購読を解除する
+	for i := range subscriptionBufferSize + 1 {
+		hub.Publish("request:1", Event{Name: "submission.created", Data: i})
+	}
+	if got := hub.SubscriberCount("request:1"); got != 0 {
+		t.Errorf("got %d subscribers, want 0", got)
+	}
+	if got := receive(t, slow); len(got) != subscriptionBufferSize {
+		t.Errorf("got %d events, want %d", len(got), subscriptionBufferSize)
+	}
+	if _, ok := <-slow.Events(); ok {
+		t.Errorf("channel should be closed")
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	hub := NewHub()
+	sub1 := hub.Subscribe("request:1")
+	sub2 := hub.Subscribe("request:2")
+
+	// 全ての購読が解除され、チャネルが閉じられる
+	hub.Close()
+	for _, sub := range []*Subscription{sub1, sub2} {
+		if _, ok := <-sub.Events(); ok {
+			t.Errorf("channel should be closed")
+		}
+		sub.Close()
+	}
+	if got := hub.SubscriberCount("request:1"); got != 0 {
+		t.Errorf("got %d subscribers, want 0", got)
+	}
+
+	// Closeした後の購読は、すぐに閉じられる
+	sub3 := hub.Subscribe("request:1")
+	defer sub3.Close()
+	if _, ok := <-sub3.Events(); ok {
+		t.Errorf("channel should be closed")
+	}
+	hub.Publish("request:1", Event{Name: "submission.created", Data: 1})
+}
@@ -1,11 +1,22 @@
 package dto
 
+import "encoding/json"
+
 // UserSessionInfo はセッション内のユーザー情報の構造体です
+// Permissions はユーザーが持つ権限の一覧です
 type UserSessionInfo struct {
-	ID        int      `json:"id"`
-	Name      string   `json:"name"`
-	Roles     []string `json:"roles"`
-	CreatedAt string   `json:"created_at"`
+	ID           int              `json:"id"`
+	Name         string           `json:"name"`
+	Roles        []string         `json:"roles"`
+	Permissions  []string         `json:"permissions"`
+	Organization OrganizationInfo `json:"organization"`
+	CreatedAt    string           `json:"created_at"`
+}
+
+// OrganizationInfo は組織(店舗)情報の構造体です
+type OrganizationInfo struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
 }
 
 // UserInfo はユーザー情報の構造体です
@@ -41,3 +52,98 @@ type EntryIDInfo struct {
 type SubmissionInfo struct {
 	Submitter UserInfo `json:"submitter"`
 }
+
+// SubmissionStatusInfo はユーザーごとの提出状況の構造体です
+// 未提出の場合 SubmittedAt, UpdatedAt はnullになります
+type SubmissionStatusInfo struct {
+	User        UserInfo `json:"user"`
+	Submitted   bool     `json:"submitted"`
+	SubmittedAt *string  `json:"submitted_at"`
+	UpdatedAt   *string  `json:"updated_at"`
+	EntryCount  int      `json:"entry_count"`
+}
+
+// NotificationInfo はアプリ内の通知の構造体です
+// RequestID は関連するシフトリクエストがない場合、ReadAt は未読の場合nullになります
+type NotificationInfo struct {
+	ID        int     `json:"id"`
+	Kind      string  `json:"kind"`
+	RequestID *int    `json:"request_id"`
+	Title     string  `json:"title"`
+	Body      string  `json:"body"`
+	CreatedAt string  `json:"created_at"`
+	ReadAt    *string `json:"read_at"`
+}
+
+// AuditEventInfo は監査ログのイベントの構造体です
+// Before, After は変更前後の状態で、存在しない場合はnullになります
+type AuditEventInfo struct {
+	ID         int             `json:"id"`
+	ActorID    int             `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int             `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	ClientIP   string          `json:"client_ip"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+// APITokenInfo はAPIトークン一覧内の個別トークンの構造体です
+// トークンそのものは含みません。ExpiresAt, LastUsedAt, RevokedAt は存在しない場合nullになります
+type APITokenInfo struct {
+	ID         int      `json:"id"`
+	User       UserInfo `json:"user"`
+	CreatorID  int      `json:"creator_id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  *string  `json:"expires_at"`
+	LastUsedAt *string  `json:"last_used_at"`
+	RevokedAt  *string  `json:"revoked_at"`
+}
+
+// GroupSummary はグループの名前だけを含む構造体です
+type GroupSummary struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// GroupInfo はグループ一覧内の個別グループの構造体です
+type GroupInfo struct {
+	ID      int        `json:"id"`
+	Name    string     `json:"name"`
+	Members []UserInfo `json:"members"`
+}
+
+// RequestTargets はシフトリクエストの対象の構造体です
+// Users, Groups がどちらも空の場合は組織の全員が対象です
+type RequestTargets struct {
+	Users  []UserInfo     `json:"users"`
+	Groups []GroupSummary `json:"groups"`
+}
+
+// WebhookInfo はWebhook一覧内の個別Webhookの構造体です
+// 署名用の秘密鍵は含みません
+type WebhookInfo struct {
+	ID        int      `json:"id"`
+	CreatorID int      `json:"creator_id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// WebhookDeliveryInfo はWebhookのイベント1件の送信状況の構造体です
+// LastStatusCode はレスポンスがなかった場合、NextAttemptAt は送信待ちでない場合、
+// DeliveredAt は送信済みでない場合nullになります
+type WebhookDeliveryInfo struct {
+	ID             int     `json:"id"`
+	Event          string  `json:"event"`
+	Status         string  `json:"status"`
+	Attempts       int     `json:"attempts"`
+	LastStatusCode *int    `json:"last_status_code"`
+	LastError      string  `json:"last_error"`
+	CreatedAt      string  `json:"created_at"`
+	NextAttemptAt  *string `json:"next_attempt_at"`
+	DeliveredAt    *string `json:"delivered_at"`
+}
@@ -9,10 +9,13 @@ type LoginRequest struct {
 }
 
 // CreateRequestRequest はシフトリクエスト作成リクエストの構造体です
+// TargetUserIDs, TargetGroupIDs をどちらも省略した場合は組織の全員が対象になります
 type CreateRequestRequest struct {
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
-	Deadline  string `json:"deadline"`
+	StartDate      string `json:"start_date"`
+	EndDate        string `json:"end_date"`
+	Deadline       string `json:"deadline"`
+	TargetUserIDs  []int  `json:"target_user_ids"`
+	TargetGroupIDs []int  `json:"target_group_ids"`
 }
 
 // CreateEntryRequest はエントリー作成リクエストの構造体です
@@ -20,3 +23,56 @@ type CreateEntryRequest struct {
 	Date string `json:"date"`
 	Hour int    `json:"hour"`
 }
+
+// ChangePasswordRequest はパスワード変更リクエストの構造体です
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ResetPasswordRequest はパスワードリセットリクエストの構造体です
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// TOTPCodeRequest は二要素認証のコードを送るリクエストの構造体です
+// Code にはワンタイムパスワードかリカバリーコードを指定します
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// CreateAPITokenRequest はAPIトークン発行リクエストの構造体です
+// ExpiresAt を省略した場合は期限なしになります
+type CreateAPITokenRequest struct {
+	UserID    int      `json:"user_id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt *string  `json:"expires_at"`
+}
+
+// CreateOrganizationRequest は組織作成リクエストの構造体です
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+// AddOrganizationMemberRequest は組織にユーザーを所属させるリクエストの構造体です
+type AddOrganizationMemberRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// CreateGroupRequest はグループ作成リクエストの構造体です
+type CreateGroupRequest struct {
+	Name string `json:"name"`
+}
+
+// AddGroupMemberRequest はグループにユーザーを追加するリクエストの構造体です
+type AddGroupMemberRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// CreateWebhookRequest はWebhook登録リクエストの構造体です
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
@@ -2,13 +2,30 @@ package dto
 
 // httpレスポンスDTO
 
+// LoginResponse はログインのレスポンス構造体です
+// MFARequired がtrueの場合は POST /login/totp で二段階目の認証を行います
+// MFAEnrollmentRequired がtrueの場合は、その前に二要素認証を登録する必要があります
+type LoginResponse struct {
+	MFARequired           bool   `json:"mfa_required"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required"`
+	CSRFToken             string `json:"csrf_token"`
+}
+
 // SessionResponse はセッション情報のレスポンス構造体です
+// CSRFToken は状態を変更するリクエストのX-CSRF-Tokenヘッダーに付与します
 type SessionResponse struct {
-	User UserSessionInfo `json:"user"`
+	User      UserSessionInfo `json:"user"`
+	CSRFToken string          `json:"csrf_token"`
+	// 未読のアプリ内の通知の件数
+	UnreadNotificationCount int `json:"unread_notification_count"`
 }
 
 // RequestsResponse はリクエスト一覧のレスポンス構造体です
-type RequestsResponse []RequestInfo
+// NextCursor は次のページがない場合nullになります
+type RequestsResponse struct {
+	Requests   []RequestInfo `json:"requests"`
+	NextCursor *string       `json:"next_cursor"`
+}
 
 // CreateRequestResponse はリクエスト作成レスポンスの構造体です
 type CreateRequestResponse struct {
@@ -23,6 +40,110 @@ type RequestDetailResponse struct {
 	EndDate     string           `json:"end_date"`
 	Deadline    string           `json:"deadline"`
 	CreatedAt   string           `json:"created_at"`
+	Targets     RequestTargets   `json:"targets"`
 	Submissions []SubmissionInfo `json:"submissions"`
 	Entries     []EntryInfo      `json:"entries"`
 }
+
+// RequestStatusResponse はシフトリクエストの提出状況レスポンスの構造体です
+type RequestStatusResponse struct {
+	RequestID         int                    `json:"request_id"`
+	SubmittedCount    int                    `json:"submitted_count"`
+	NotSubmittedCount int                    `json:"not_submitted_count"`
+	Statuses          []SubmissionStatusInfo `json:"statuses"`
+}
+
+// NotificationsResponse はアプリ内の通知一覧のレスポンス構造体です
+// NextCursor は次のページがない場合nullになります
+type NotificationsResponse struct {
+	Notifications []NotificationInfo `json:"notifications"`
+	UnreadCount   int                `json:"unread_count"`
+	NextCursor    *string            `json:"next_cursor"`
+}
+
+// AuditEventsResponse は監査ログ一覧のレスポンス構造体です
+// NextCursor は次のページがない場合nullになります
+type AuditEventsResponse struct {
+	Events     []AuditEventInfo `json:"events"`
+	NextCursor *string          `json:"next_cursor"`
+}
+
+// PasswordResetResponse はパスワードリセット用トークン発行レスポンスの構造体です
+// Token はこのレスポンスでしか取得できません
+type PasswordResetResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// TOTPEnrollmentResponse は二要素認証の登録開始レスポンスの構造体です
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// RecoveryCodesResponse はリカバリーコード発行レスポンスの構造体です
+// RecoveryCodes はこのレスポンスでしか取得できません
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// CreateAPITokenResponse はAPIトークン発行レスポンスの構造体です
+// Token はこのレスポンスでしか取得できません
+type CreateAPITokenResponse struct {
+	ID    int    `json:"id"`
+	Token string `json:"token"`
+}
+
+// APITokensResponse はAPIトークン一覧のレスポンス構造体です
+type APITokensResponse struct {
+	Tokens []APITokenInfo `json:"tokens"`
+}
+
+// OrganizationsResponse は組織一覧のレスポンス構造体です
+type OrganizationsResponse struct {
+	Organizations []OrganizationInfo `json:"organizations"`
+}
+
+// CreateOrganizationResponse は組織作成レスポンスの構造体です
+type CreateOrganizationResponse struct {
+	ID int `json:"id"`
+}
+
+// OrganizationMembersResponse は組織に所属するユーザー一覧のレスポンス構造体です
+type OrganizationMembersResponse struct {
+	Members []UserInfo `json:"members"`
+}
+
+// GroupsResponse はグループ一覧のレスポンス構造体です
+type GroupsResponse struct {
+	Groups []GroupInfo `json:"groups"`
+}
+
+// CreateGroupResponse はグループ作成レスポンスの構造体です
+type CreateGroupResponse struct {
+	ID int `json:"id"`
+}
+
+// WebhooksResponse はWebhook一覧のレスポンス構造体です
+type WebhooksResponse struct {
+	Webhooks []WebhookInfo `json:"webhooks"`
+}
+
+// CreateWebhookResponse はWebhook登録レスポンスの構造体です
+// Secret はこのレスポンスでしか取得できません
+type CreateWebhookResponse struct {
+	ID     int    `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// WebhookDeliveriesResponse はWebhookの送信状況一覧のレスポンス構造体です
+// NextCursor は次のページがない場合nullになります
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDeliveryInfo `json:"deliveries"`
+	NextCursor *string               `json:"next_cursor"`
+}
+
+// HealthResponse は/healthz, /readyzのレスポンス構造体です
+type HealthResponse struct {
+	Status string `json:"status"`
+}
@@ -2,8 +2,15 @@ package handler
 
 import (
 	"backend/context"
-	"log"
+	"backend/metrics"
+	stdcontext "context"
+	"errors"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type HandlerFuncWithContext func(*context.AppContext, http.ResponseWriter, *http.Request) *AppError
@@ -11,25 +18,109 @@ type HandlerFuncWithContext func(*context.AppContext, http.ResponseWriter, *http
 type Handler struct {
 	ctx       *context.AppContext
 	handlerFn HandlerFuncWithContext
+	// trueの場合はタイムアウトを設定しない
+	stream bool
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if err := h.handlerFn(h.ctx, w, r); err != nil {
+	// クライアントの切断やタイムアウトをDBまで伝えるため、リクエストのcontextを使う
+	reqCtx := r.Context()
+	if timeout := h.ctx.GetRequestTimeout(); timeout > 0 && !h.stream {
+		var cancel stdcontext.CancelFunc
+		reqCtx, cancel = stdcontext.WithTimeout(reqCtx, timeout)
+		defer cancel()
+	}
+	// ストリームはhttp.ServerのWriteTimeoutで切断されないように、書き込みの期限を外す
+	// httptest.ResponseRecorderなど期限を設定できないResponseWriterでは何もしない
+	if h.stream {
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
+	appCtx := h.ctx.WithContext(reqCtx).WithClientIP(clientIP(r))
+
+	// アクセスログに出す情報を設定する
+	info := context.RequestInfoFrom(reqCtx)
+	if info != nil {
+		info.Route = r.Pattern
+	}
+
+	if err := h.handlerFn(appCtx, w, r.WithContext(reqCtx)); err != nil {
+		// 処理が時間内に終わらなかった場合は、個別のエラーより優先して返す
+		if errors.Is(err.err, stdcontext.DeadlineExceeded) {
+			err.message = "処理がタイムアウトしました"
+			err.code = http.StatusServiceUnavailable
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(err.code)
 		w.Write([]byte(`{"error": "` + err.message + `"}`))
 
-		// log error details
-		if err.err != nil {
+		metrics.AppErrors.Inc(strconv.Itoa(err.code))
+
+		// エラーの詳細はmiddleware.RequestLoggingがアクセスログに出す
+		if info != nil && err.err != nil {
+			info.Err = err.err
 			if err.file != "" && err.line != 0 {
-				log.Printf("Error at %s:%d: %s\n", err.file, err.line, err.err.Error())
-			} else {
-				log.Printf("Error: %s\n", err.err.Error())
+				info.ErrSource = err.file + ":" + strconv.Itoa(err.line)
 			}
 		}
 	}
 }
 
+// X-Forwarded-Forを信頼するリバースプロキシ
+var trustedProxies []netip.Prefix
+
+// X-Forwarded-Forを信頼するリバースプロキシを設定する
+// サーバー起動時に呼び出す. 空の場合はX-Forwarded-Forを使わない
+func SetTrustedProxies(proxies []netip.Prefix) {
+	trustedProxies = proxies
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// 接続元のIPアドレスを返す
+// X-Forwarded-Forは偽装できるので、信頼するプロキシからの接続の場合だけ使う
+// 右から順に辿り、信頼するプロキシでない最初のアドレスを接続元とする
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isTrustedProxy(addr) {
+		return host
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return addr.Unmap().String()
+}
+
 func NewHandler(ctx *context.AppContext, handlerFn HandlerFuncWithContext) *Handler {
 	return &Handler{ctx: ctx, handlerFn: handlerFn}
 }
+
+// 接続を維持してレスポンスを送り続けるHandlerを作成する
+// タイムアウトを設定しないので、handlerFnはクライアントの切断(r.Context().Done())で終了すること
+func NewStreamHandler(ctx *context.AppContext, handlerFn HandlerFuncWithContext) *Handler {
+	return &Handler{ctx: ctx, handlerFn: handlerFn, stream: true}
+}
@@ -3,12 +3,19 @@ package handler
 import (
 	"backend/auth"
 	"backend/context"
+	"backend/db"
 	"backend/handler/dto"
 	"backend/model"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 )
 
 /*
@@ -23,16 +30,135 @@ func LoginRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Reques
 		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
 	}
 
-	err := auth.Login(ctx, w, r, loginReq.LoginID, loginReq.Password)
+	var audit model.AuditEvent
+	userID, err := auth.Login(ctx, w, r, loginReq.LoginID, loginReq.Password)
+
+	// パスワードは正しいが、二段階目の認証が必要
+	var secondFactorErr *auth.SecondFactorRequiredError
+	if errors.As(err, &secondFactorErr) {
+		return writeLoginResponse(ctx, w, r, dto.LoginResponse{
+			MFARequired:           true,
+			MFAEnrollmentRequired: !secondFactorErr.Enrolled,
+		})
+	}
+
 	if err != nil {
 		if errors.Is(err, auth.ErrIncorrectAuth) {
+			// 失敗したログインも監査ログに記録する
+			// ログインIDのユーザーがいる場合は、そのユーザーの組織に記録する. いない場合は組織なしで記録される
+			_, auditErr := audit.Record(ctx, model.NewAuditEvent{
+				Action:     model.AuditActionLoginFailed,
+				TargetType: model.AuditTargetUser,
+				TargetID:   max(userID, 0),
+				After:      map[string]any{"login_id": loginReq.LoginID},
+			})
+			if auditErr != nil {
+				return NewAppError(auditErr, "ログインに失敗しました", http.StatusInternalServerError)
+			}
 			return NewAppError(err, "ログインIDまたはパスワードが間違っています", http.StatusUnauthorized)
 		}
+
+		var throttledErr *auth.ThrottledError
+		if errors.As(err, &throttledErr) {
+			// 秒単位に切り上げて、再試行できる時刻を伝える
+			retryAfter := int(math.Ceil(throttledErr.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			return NewAppError(err, "ログインの試行回数が多すぎます。しばらくしてから再度お試しください", http.StatusTooManyRequests)
+		}
+
+		return NewAppError(err, "ログインに失敗しました", http.StatusInternalServerError)
+	}
+
+	return completeLogin(ctx, w, r, userID)
+}
+
+// ログインの完了を監査ログに記録してレスポンスを返す
+func completeLogin(ctx *context.AppContext, w http.ResponseWriter, r *http.Request, userID int) *AppError {
+	var audit model.AuditEvent
+	_, err := audit.Record(ctx, model.NewAuditEvent{
+		ActorID:    userID,
+		Action:     model.AuditActionLogin,
+		TargetType: model.AuditTargetUser,
+		TargetID:   userID,
+	})
+	if err != nil {
+		return NewAppError(err, "ログインに失敗しました", http.StatusInternalServerError)
+	}
+	return writeLoginResponse(ctx, w, r, dto.LoginResponse{})
+}
+
+// セッションのCSRFトークンを付けてログインのレスポンスを返す
+func writeLoginResponse(ctx *context.AppContext, w http.ResponseWriter, r *http.Request, response dto.LoginResponse) *AppError {
+	csrfToken, err := auth.GetCSRFToken(ctx, w, r)
+	if err != nil {
 		return NewAppError(err, "ログインに失敗しました", http.StatusInternalServerError)
 	}
+	response.CSRFToken = csrfToken
+
+	json.NewEncoder(w).Encode(response)
 	return nil
 }
 
+// 二段階目の認証待ちの状態を返す
+// OIDCでのログインでは、リダイレクトされたフロントエンドがこれで次の手順を決める
+func GetLoginPendingRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	enrolled, err := auth.PendingLoginEnrolled(ctx, r)
+	if err != nil {
+		if errors.Is(err, auth.ErrNoPendingLogin) {
+			return NewAppError(err, "ログインからやり直してください", http.StatusUnauthorized)
+		}
+		return NewAppError(err, "ログイン状態の取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	return writeLoginResponse(ctx, w, r, dto.LoginResponse{
+		MFARequired:           true,
+		MFAEnrollmentRequired: !enrolled,
+	})
+}
+
+func PostLoginTOTPRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	var codeReq dto.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&codeReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	userID, err := auth.VerifySecondFactor(ctx, w, r, codeReq.Code)
+	if err != nil {
+		if errors.Is(err, auth.ErrNoPendingLogin) {
+			return NewAppError(err, "ログインIDとパスワードからやり直してください", http.StatusUnauthorized)
+		}
+		if errors.Is(err, auth.ErrTOTPNotEnrolled) {
+			return NewAppError(err, "二要素認証の登録が必要です", http.StatusBadRequest)
+		}
+		if errors.Is(err, auth.ErrIncorrectAuth) {
+			// 失敗したログインも監査ログに記録する
+			var audit model.AuditEvent
+			pendingUserID, _ := auth.GetPendingUserID(ctx, r)
+			_, auditErr := audit.Record(ctx, model.NewAuditEvent{
+				Action:     model.AuditActionLoginFailed,
+				TargetType: model.AuditTargetUser,
+				TargetID:   pendingUserID,
+				After:      map[string]any{"step": "totp"},
+			})
+			if auditErr != nil {
+				return NewAppError(auditErr, "ログインに失敗しました", http.StatusInternalServerError)
+			}
+			return NewAppError(err, "認証コードが正しくありません", http.StatusUnauthorized)
+		}
+
+		var throttledErr *auth.ThrottledError
+		if errors.As(err, &throttledErr) {
+			retryAfter := int(math.Ceil(throttledErr.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			return NewAppError(err, "ログインの試行回数が多すぎます。しばらくしてから再度お試しください", http.StatusTooManyRequests)
+		}
+
+		return NewAppError(err, "ログインに失敗しました", http.StatusInternalServerError)
+	}
+
+	return completeLogin(ctx, w, r, userID)
+}
+
 func GetSessionRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
 	// ログインユーザのみ認可
 	userID, isLoggedIn := auth.GetUserID(ctx, r)
@@ -48,22 +174,46 @@ func GetSessionRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.R
 	}
 
 	// レスポンスDTOを作成
-	roles := []string{}
-	// TODO: 抽象化できていない
-	if (user.Role & auth.RoleEmployee) != 0 {
-		roles = append(roles, "employee")
+	// 画面の表示を切り替えられるように、ロールと権限を返す
+	permissions := []string{}
+	for _, permission := range auth.PermissionsOf(user.Role) {
+		permissions = append(permissions, string(permission))
+	}
+
+	// 所属している組織を取得
+	var org model.Organization
+	organization, err := org.FindByUserID(ctx, userID)
+	if err != nil {
+		return NewAppError(err, "セッションの取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	// CSRFトークンを取得
+	csrfToken, err := auth.GetCSRFToken(ctx, w, r)
+	if err != nil {
+		return NewAppError(err, "セッションの取得に失敗しました", http.StatusInternalServerError)
 	}
-	if (user.Role & auth.RoleManager) != 0 {
-		roles = append(roles, "manager")
+
+	// 未読の通知の件数を取得
+	var notification model.UserNotification
+	unreadCount, err := notification.CountUnread(ctx, userID)
+	if err != nil {
+		return NewAppError(err, "セッションの取得に失敗しました", http.StatusInternalServerError)
 	}
 
 	sessionResponse := dto.SessionResponse{
 		User: dto.UserSessionInfo{
-			ID:        user.ID,
-			Name:      user.Name,
-			Roles:     roles,
+			ID:          user.ID,
+			Name:        user.Name,
+			Roles:       auth.RoleNames(user.Role),
+			Permissions: permissions,
+			Organization: dto.OrganizationInfo{
+				ID:   organization.ID,
+				Name: organization.Name,
+			},
 			CreatedAt: user.CreatedAt.Format(),
 		},
+		CSRFToken:               csrfToken,
+		UnreadNotificationCount: unreadCount,
 	}
 
 	json.NewEncoder(w).Encode(sessionResponse)
@@ -80,19 +230,76 @@ func LogoutRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Reque
 
 func GetRequestsRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
 	// ログインユーザのみ認可
-	if _, isLoggedIn := auth.GetUserID(ctx, r); !isLoggedIn {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
 		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
 	}
 
+	// クエリパラメータから検索条件を作成
+	query := r.URL.Query()
+	filter := model.RequestFilter{
+		ViewerID: userID,
+		Status:   query.Get("status"),
+		Sort:     query.Get("sort"),
+		Order:    query.Get("order"),
+		Cursor:   query.Get("cursor"),
+	}
+
+	if from := query.Get("from"); from != "" {
+		fromDate, err := model.NewDateOnly(from)
+		if err != nil {
+			return NewAppError(err, "fromのフォーマットが不正です", http.StatusBadRequest)
+		}
+		filter.From = &fromDate
+	}
+
+	if to := query.Get("to"); to != "" {
+		toDate, err := model.NewDateOnly(to)
+		if err != nil {
+			return NewAppError(err, "toのフォーマットが不正です", http.StatusBadRequest)
+		}
+		filter.To = &toDate
+	}
+
+	if creatorID := query.Get("creator_id"); creatorID != "" {
+		creatorIDInt, err := strconv.Atoi(creatorID)
+		if err != nil {
+			return NewAppError(err, "creator_idが整数ではありません", http.StatusBadRequest)
+		}
+		filter.CreatorID = creatorIDInt
+	}
+
+	if hasMySubmission := query.Get("has_my_submission"); hasMySubmission != "" {
+		hasMySubmissionBool, err := strconv.ParseBool(hasMySubmission)
+		if err != nil {
+			return NewAppError(err, "has_my_submissionが真偽値ではありません", http.StatusBadRequest)
+		}
+		filter.HasMySubmission = &hasMySubmissionBool
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return NewAppError(err, "limitが整数ではありません", http.StatusBadRequest)
+		}
+		filter.Limit = limitInt
+	}
+
 	var req model.Request
-	requests, err := req.FindAll(ctx)
+	page, err := req.FindPage(ctx, filter)
 	if err != nil {
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
 		return NewAppError(err, "シフトリクエストの取得に失敗しました", http.StatusInternalServerError)
 	}
 
 	// モデルをDTOに変換
-	var requestsResponse dto.RequestsResponse
-	for _, req := range requests {
+	requestsResponse := dto.RequestsResponse{
+		Requests: []dto.RequestInfo{},
+	}
+	for _, req := range page.Requests {
 		requestInfo := dto.RequestInfo{
 			ID: req.ID,
 			Creator: dto.UserInfo{
@@ -104,7 +311,10 @@ func GetRequestsRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.
 			Deadline:  req.Deadline.Format(),
 			CreatedAt: req.CreatedAt.Format(),
 		}
-		requestsResponse = append(requestsResponse, requestInfo)
+		requestsResponse.Requests = append(requestsResponse.Requests, requestInfo)
+	}
+	if page.NextCursor != "" {
+		requestsResponse.NextCursor = &page.NextCursor
 	}
 
 	json.NewEncoder(w).Encode(requestsResponse)
@@ -113,7 +323,8 @@ func GetRequestsRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.
 
 func GetRequestRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
 	// ログインユーザのみ認可
-	if _, isLoggedIn := auth.GetUserID(ctx, r); !isLoggedIn {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
 		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
 	}
 
@@ -125,18 +336,34 @@ func GetRequestRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.R
 
 	// リクエスト情報を取得
 	var req model.Request
-	request, err := req.FindByID(ctx, requestIdInt)
+	request, err := req.FindByID(ctx, userID, requestIdInt)
 	if err != nil {
-		// TODO: errcode修正
+		if errors.Is(err, db.ErrRequestNotFound) {
+			return NewAppError(err, "シフトリクエストが見つかりません", http.StatusNotFound)
+		}
 		return NewAppError(err, "リクエストの取得に失敗しました", http.StatusInternalServerError)
 	}
 
 	// 提出情報を取得
 	var sub model.Submission
-	submissions, err := sub.FindByRequestID(ctx, requestIdInt)
+	submissions, err := sub.FindByRequestID(ctx, userID, requestIdInt)
+	if err != nil {
+		if errors.Is(err, db.ErrRequestNotFound) {
+			return NewAppError(err, "シフトリクエストが見つかりません", http.StatusNotFound)
+		}
+		return NewAppError(err, "提出情報の取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	// 他のユーザーの提出内容を閲覧できない場合は、自分の提出だけを返す
+	canViewAll, err := auth.Can(ctx, userID, auth.PermissionSubmissionViewAll)
 	if err != nil {
 		return NewAppError(err, "提出情報の取得に失敗しました", http.StatusInternalServerError)
 	}
+	if !canViewAll {
+		submissions = slices.DeleteFunc(submissions, func(submission model.Submission) bool {
+			return submission.SubmitterID != userID
+		})
+	}
 
 	// シフト提出情報をDTOに変換
 	var submissionsInfo []dto.SubmissionInfo
@@ -169,6 +396,18 @@ func GetRequestRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.R
 	}
 
 	// レスポンスDTOを作成
+	// 対象をDTOに変換
+	targets := dto.RequestTargets{
+		Users:  []dto.UserInfo{},
+		Groups: []dto.GroupSummary{},
+	}
+	for _, user := range request.TargetUsers {
+		targets.Users = append(targets.Users, dto.UserInfo{ID: user.ID, Name: user.Name})
+	}
+	for _, group := range request.TargetGroups {
+		targets.Groups = append(targets.Groups, dto.GroupSummary{ID: group.ID, Name: group.Name})
+	}
+
 	response := dto.RequestDetailResponse{
 		ID: request.ID,
 		Creator: dto.UserInfo{
@@ -179,6 +418,7 @@ func GetRequestRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.R
 		EndDate:     request.EndDate.Format(),
 		Deadline:    request.Deadline.Format(),
 		CreatedAt:   request.CreatedAt.Format(),
+		Targets:     targets,
 		Submissions: submissionsInfo,
 		Entries:     entriesInfo,
 	}
@@ -220,10 +460,12 @@ func PostRequestsRequest(ctx *context.AppContext, w http.ResponseWriter, r *http
 	// 新しいシフトリクエストを作成する
 	var req model.Request
 	requestID, err := req.Create(ctx, model.NewRequest{
-		CreatorID: userID,
-		StartDate: startDate,
-		EndDate:   endDate,
-		Deadline:  deadline,
+		CreatorID:      userID,
+		StartDate:      startDate,
+		EndDate:        endDate,
+		Deadline:       deadline,
+		TargetUserIDs:  createReq.TargetUserIDs,
+		TargetGroupIDs: createReq.TargetGroupIDs,
 	})
 	if err != nil {
 		if errors.Is(err, model.ErrForbidden) {
@@ -298,6 +540,14 @@ func PostSubmissionsRequest(ctx *context.AppContext, w http.ResponseWriter, r *h
 			return NewAppError(err, "権限がありません", http.StatusForbidden)
 		}
 
+		if errors.Is(err, db.ErrRequestNotFound) {
+			return NewAppError(err, "シフトリクエストが見つかりません", http.StatusNotFound)
+		}
+
+		if errors.Is(err, model.ErrAlreadySubmitted) {
+			return NewAppError(err, "このシフトリクエストには提出済みです", http.StatusConflict)
+		}
+
 		var inputErr model.InputError
 		if errors.As(err, &inputErr) {
 			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
@@ -317,6 +567,153 @@ func PostSubmissionsRequest(ctx *context.AppContext, w http.ResponseWriter, r *h
 	return nil
 }
 
+// 提出のエントリーを全て置き換える
+// 本人、またはsubmission.edit_all権限を持つユーザーが変更できる
+func PutSubmissionRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	requestID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return NewAppError(err, "requestidが整数ではありません", http.StatusBadRequest)
+	}
+	submissionID, err := strconv.Atoi(r.PathValue("submission_id"))
+	if err != nil {
+		return NewAppError(err, "submissionidが整数ではありません", http.StatusBadRequest)
+	}
+
+	// リクエストボディのデコード
+	var entryRequests []dto.CreateEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&entryRequests); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	// DTOからモデルに変換
+	update := model.SubmissionUpdate{
+		RequestID:    requestID,
+		SubmissionID: submissionID,
+		EditorID:     userID,
+		NewEntries:   []model.NewEntry{},
+	}
+	for _, entry := range entryRequests {
+		dateOnly, err := model.NewDateOnly(entry.Date)
+		if err != nil {
+			return NewAppError(err, "日付のフォーマットが不正です", http.StatusBadRequest)
+		}
+		update.NewEntries = append(update.NewEntries, model.NewEntry{
+			Date: dateOnly,
+			Hour: entry.Hour,
+		})
+	}
+
+	var sub model.Submission
+	if err := sub.Update(ctx, update); err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		if errors.Is(err, db.ErrRequestNotFound) {
+			return NewAppError(err, "シフトリクエストが見つかりません", http.StatusNotFound)
+		}
+		if errors.Is(err, db.ErrSubmissionNotFound) {
+			return NewAppError(err, "提出が見つかりません", http.StatusNotFound)
+		}
+
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+
+		return NewAppError(err, "提出の変更に失敗しました", http.StatusInternalServerError)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// シフトリクエストへの提出をCSVで返す
+func GetRequestExportRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	requestID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return NewAppError(err, "requestIdが整数ではありません", http.StatusBadRequest)
+	}
+
+	var sub model.Submission
+	submissions, err := sub.FindForExport(ctx, userID, requestID)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		if errors.Is(err, db.ErrRequestNotFound) {
+			return NewAppError(err, "シフトリクエストが見つかりません", http.StatusNotFound)
+		}
+		return NewAppError(err, "提出情報の取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="request-%d.csv"`, requestID))
+	if err := model.WriteSubmissionsCSV(w, submissions); err != nil {
+		return NewAppError(err, "CSVの書き出しに失敗しました", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// シフトリクエストの対象者ごとの提出状況を返す
+func GetRequestStatusRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	requestID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return NewAppError(err, "requestIdが整数ではありません", http.StatusBadRequest)
+	}
+
+	var sub model.Submission
+	statuses, err := sub.FindStatusesByRequestID(ctx, userID, requestID)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		if errors.Is(err, db.ErrRequestNotFound) {
+			return NewAppError(err, "シフトリクエストが見つかりません", http.StatusNotFound)
+		}
+		return NewAppError(err, "提出状況の取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	response := dto.RequestStatusResponse{
+		RequestID: requestID,
+		Statuses:  make([]dto.SubmissionStatusInfo, 0, len(statuses)),
+	}
+	for _, status := range statuses {
+		info := dto.SubmissionStatusInfo{
+			User: dto.UserInfo{ID: status.User.ID, Name: status.User.Name},
+		}
+		if status.Submission != nil {
+			submittedAt := status.Submission.CreatedAt.Format()
+			updatedAt := status.Submission.UpdatedAt.Format()
+			info.Submitted = true
+			info.SubmittedAt = &submittedAt
+			info.UpdatedAt = &updatedAt
+			info.EntryCount = len(status.Submission.Entries)
+			response.SubmittedCount++
+		} else {
+			response.NotSubmittedCount++
+		}
+		response.Statuses = append(response.Statuses, info)
+	}
+
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
 func GetMySubmissionRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
 	// ログインしているユーザーのIDを取得する
 	userID, isLoggedIn := auth.GetUserID(ctx, r)
@@ -335,6 +732,9 @@ func GetMySubmissionRequest(ctx *context.AppContext, w http.ResponseWriter, r *h
 	var sub model.Submission
 	submission, err := sub.FindByRequestIDAndSubmitterID(ctx, requestIdInt, userID)
 	if err != nil {
+		if errors.Is(err, db.ErrRequestNotFound) {
+			return NewAppError(err, "シフトリクエストが見つかりません", http.StatusNotFound)
+		}
 		return NewAppError(err, "提出情報の取得に失敗しました", http.StatusInternalServerError)
 	}
 
@@ -387,3 +787,1144 @@ func GetMySubmissionRequest(ctx *context.AppContext, w http.ResponseWriter, r *h
 	json.NewEncoder(w).Encode(response)
 	return nil
 }
+
+func GetAuditRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインユーザのみ認可
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	// クエリパラメータから検索条件を作成
+	query := r.URL.Query()
+	filter := model.AuditFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		Cursor:     query.Get("cursor"),
+	}
+
+	if actorID := query.Get("actor_id"); actorID != "" {
+		actorIDInt, err := strconv.Atoi(actorID)
+		if err != nil {
+			return NewAppError(err, "actor_idが整数ではありません", http.StatusBadRequest)
+		}
+		filter.ActorID = actorIDInt
+	}
+
+	if targetID := query.Get("target_id"); targetID != "" {
+		targetIDInt, err := strconv.Atoi(targetID)
+		if err != nil {
+			return NewAppError(err, "target_idが整数ではありません", http.StatusBadRequest)
+		}
+		filter.TargetID = targetIDInt
+	}
+
+	if since := query.Get("since"); since != "" {
+		sinceDateTime, err := model.NewDateTime(since)
+		if err != nil {
+			return NewAppError(err, "sinceのフォーマットが不正です", http.StatusBadRequest)
+		}
+		filter.Since = &sinceDateTime
+	}
+
+	if until := query.Get("until"); until != "" {
+		untilDateTime, err := model.NewDateTime(until)
+		if err != nil {
+			return NewAppError(err, "untilのフォーマットが不正です", http.StatusBadRequest)
+		}
+		filter.Until = &untilDateTime
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return NewAppError(err, "limitが整数ではありません", http.StatusBadRequest)
+		}
+		filter.Limit = limitInt
+	}
+
+	var audit model.AuditEvent
+	page, err := audit.FindPage(ctx, userID, filter)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+
+		return NewAppError(err, "監査ログの取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	// モデルをDTOに変換
+	response := dto.AuditEventsResponse{
+		Events: []dto.AuditEventInfo{},
+	}
+	for _, event := range page.Events {
+		response.Events = append(response.Events, dto.AuditEventInfo{
+			ID:         event.ID,
+			ActorID:    event.ActorID,
+			Action:     event.Action,
+			TargetType: event.TargetType,
+			TargetID:   event.TargetID,
+			Before:     event.Before,
+			After:      event.After,
+			ClientIP:   event.ClientIP,
+			CreatedAt:  event.CreatedAt.Format(),
+		})
+	}
+	if page.NextCursor != "" {
+		response.NextCursor = &page.NextCursor
+	}
+
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+func PostUnlockUserRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	targetID := r.PathValue("id")
+	targetIDInt, err := strconv.Atoi(targetID)
+	if err != nil {
+		return NewAppError(err, "user idが整数ではありません", http.StatusBadRequest)
+	}
+
+	var user model.User
+	if err := user.Unlock(ctx, userID, targetIDInt); err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		if errors.Is(err, db.ErrUserNotFound) {
+			return NewAppError(err, "ユーザーが見つかりません", http.StatusNotFound)
+		}
+		return NewAppError(err, "ロックの解除に失敗しました", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func PostMyPasswordRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var passwordReq dto.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&passwordReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	var user model.User
+	if err := user.ChangePassword(ctx, userID, passwordReq.CurrentPassword, passwordReq.NewPassword); err != nil {
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+		// 現在のパスワードの誤りが続いている
+		var throttledErr *auth.ThrottledError
+		if errors.As(err, &throttledErr) {
+			retryAfter := int(math.Ceil(throttledErr.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			return NewAppError(err, "試行回数が多すぎます。しばらくしてから再度お試しください", http.StatusTooManyRequests)
+		}
+		return NewAppError(err, "パスワードの変更に失敗しました", http.StatusInternalServerError)
+	}
+
+	// 他のセッションは無効になるが、変更を行ったセッションはそのまま使えるようにする
+	if err := auth.RenewSession(ctx, w, r); err != nil {
+		return NewAppError(err, "セッションの更新に失敗しました", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func PostPasswordResetTokenRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	targetID := r.PathValue("id")
+	targetIDInt, err := strconv.Atoi(targetID)
+	if err != nil {
+		return NewAppError(err, "user idが整数ではありません", http.StatusBadRequest)
+	}
+
+	var user model.User
+	token, err := user.IssuePasswordReset(ctx, userID, targetIDInt)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		if errors.Is(err, db.ErrUserNotFound) {
+			return NewAppError(err, "ユーザーが見つかりません", http.StatusNotFound)
+		}
+		return NewAppError(err, "パスワードリセット用トークンの発行に失敗しました", http.StatusInternalServerError)
+	}
+
+	response := dto.PasswordResetResponse{
+		Token:     token.Token,
+		ExpiresAt: token.ExpiresAt.Format(),
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+func PostPasswordResetRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	var resetReq dto.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&resetReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	var user model.User
+	if err := user.ResetPassword(ctx, resetReq.Token, resetReq.NewPassword); err != nil {
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+		return NewAppError(err, "パスワードのリセットに失敗しました", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func GetOIDCStartRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	authURL, err := auth.StartOIDCLogin(ctx, w, r)
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCNotConfigured) {
+			return NewAppError(err, "OIDCでのログインは設定されていません", http.StatusNotFound)
+		}
+		return NewAppError(err, "ログインに失敗しました", http.StatusInternalServerError)
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+func GetOIDCCallbackRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	var audit model.AuditEvent
+	userID, err := auth.FinishOIDCLogin(ctx, w, r)
+
+	// IDプロバイダーでの認証には成功したが、二段階目の認証が必要
+	// ログインの記録は、フロントエンドで二段階目の認証が終わったときに行う
+	var secondFactorErr *auth.SecondFactorRequiredError
+	if errors.As(err, &secondFactorErr) {
+		http.Redirect(w, r, auth.OIDCSecondFactorURL(), http.StatusFound)
+		return nil
+	}
+
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCNotConfigured) {
+			return NewAppError(err, "OIDCでのログインは設定されていません", http.StatusNotFound)
+		}
+		if errors.Is(err, auth.ErrInvalidOIDCState) || errors.Is(err, auth.ErrOIDCDenied) {
+			return NewAppError(err, "ログインを最初からやり直してください", http.StatusBadRequest)
+		}
+		if errors.Is(err, auth.ErrInvalidIDToken) || errors.Is(err, auth.ErrOIDCUserNotFound) {
+			// 失敗したログインも監査ログに記録する
+			_, auditErr := audit.Record(ctx, model.NewAuditEvent{
+				Action:     model.AuditActionLoginFailed,
+				TargetType: model.AuditTargetUser,
+				After:      map[string]any{"method": "oidc", "reason": err.Error()},
+			})
+			if auditErr != nil {
+				return NewAppError(auditErr, "ログインに失敗しました", http.StatusInternalServerError)
+			}
+			if errors.Is(err, auth.ErrOIDCUserNotFound) {
+				return NewAppError(err, "このアカウントに対応するユーザーが登録されていません", http.StatusForbidden)
+			}
+			return NewAppError(err, "ログインに失敗しました", http.StatusUnauthorized)
+		}
+		return NewAppError(err, "ログインに失敗しました", http.StatusInternalServerError)
+	}
+
+	// 監査ログに記録
+	_, err = audit.Record(ctx, model.NewAuditEvent{
+		ActorID:    userID,
+		Action:     model.AuditActionLogin,
+		TargetType: model.AuditTargetUser,
+		TargetID:   userID,
+		After:      map[string]any{"method": "oidc"},
+	})
+	if err != nil {
+		return NewAppError(err, "ログインに失敗しました", http.StatusInternalServerError)
+	}
+
+	http.Redirect(w, r, auth.OIDCPostLoginURL(), http.StatusFound)
+	return nil
+}
+
+// 二要素認証を登録するユーザーのIDを取得する
+// 二要素認証が必須で未登録のユーザーは、二段階目の認証待ちのまま登録する
+func getTOTPUserID(ctx *context.AppContext, r *http.Request) (userID int, pending bool, ok bool) {
+	if userID, ok := auth.GetUserID(ctx, r); ok {
+		return userID, false, true
+	}
+	if userID, ok := auth.GetPendingUserID(ctx, r); ok {
+		return userID, true, true
+	}
+	return -1, false, false
+}
+
+func PostTOTPRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, _, ok := getTOTPUserID(ctx, r)
+	if !ok {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var user model.User
+	enrollment, err := user.BeginTOTPEnrollment(ctx, userID)
+	if err != nil {
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+		return NewAppError(err, "二要素認証の登録に失敗しました", http.StatusInternalServerError)
+	}
+
+	response := dto.TOTPEnrollmentResponse{
+		Secret:     enrollment.Secret,
+		OtpauthURI: enrollment.URI,
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+func PostTOTPConfirmRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, pending, ok := getTOTPUserID(ctx, r)
+	if !ok {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var codeReq dto.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&codeReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	var user model.User
+	codes, err := user.ConfirmTOTPEnrollment(ctx, userID, codeReq.Code)
+	if err != nil {
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+		return NewAppError(err, "二要素認証の登録に失敗しました", http.StatusInternalServerError)
+	}
+
+	// 認証待ちのユーザーは、登録したコードで二段階目の認証を済ませたものとしてログインを完了する
+	if pending {
+		if _, err := auth.CompletePendingLogin(ctx, w, r); err != nil {
+			return NewAppError(err, "ログインに失敗しました", http.StatusInternalServerError)
+		}
+		var audit model.AuditEvent
+		_, err := audit.Record(ctx, model.NewAuditEvent{
+			ActorID:    userID,
+			Action:     model.AuditActionLogin,
+			TargetType: model.AuditTargetUser,
+			TargetID:   userID,
+		})
+		if err != nil {
+			return NewAppError(err, "ログインに失敗しました", http.StatusInternalServerError)
+		}
+	}
+
+	json.NewEncoder(w).Encode(dto.RecoveryCodesResponse{RecoveryCodes: codes})
+	return nil
+}
+
+func PostTOTPRecoveryCodesRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var codeReq dto.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&codeReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	var user model.User
+	codes, err := user.RegenerateRecoveryCodes(ctx, userID, codeReq.Code)
+	if err != nil {
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+		return NewAppError(err, "リカバリーコードの発行に失敗しました", http.StatusInternalServerError)
+	}
+
+	json.NewEncoder(w).Encode(dto.RecoveryCodesResponse{RecoveryCodes: codes})
+	return nil
+}
+
+func PostTOTPDisableRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var codeReq dto.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&codeReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	var user model.User
+	if err := user.DisableTOTP(ctx, userID, codeReq.Code); err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "二要素認証は必須のため解除できません", http.StatusForbidden)
+		}
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+		return NewAppError(err, "二要素認証の解除に失敗しました", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func PostAPITokensRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var createReq dto.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	newToken := model.NewAPIToken{
+		UserID: createReq.UserID,
+		Name:   createReq.Name,
+		Scopes: createReq.Scopes,
+	}
+	if createReq.ExpiresAt != nil {
+		expiresAt, err := model.NewDateTime(*createReq.ExpiresAt)
+		if err != nil {
+			return NewAppError(err, "expires_atのフォーマットが不正です", http.StatusBadRequest)
+		}
+		newToken.ExpiresAt = &expiresAt
+	}
+
+	var apiToken model.APIToken
+	id, token, err := apiToken.Issue(ctx, userID, newToken)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		if errors.Is(err, db.ErrUserNotFound) {
+			return NewAppError(err, "ユーザーが見つかりません", http.StatusNotFound)
+		}
+
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+
+		return NewAppError(err, "APIトークンの発行に失敗しました", http.StatusInternalServerError)
+	}
+
+	response := dto.CreateAPITokenResponse{
+		ID:    id,
+		Token: token,
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+func GetAPITokensRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var apiToken model.APIToken
+	tokens, err := apiToken.FindAll(ctx, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		return NewAppError(err, "APIトークンの取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	// モデルをDTOに変換
+	formatOptional := func(t *model.DateTime) *string {
+		if t == nil {
+			return nil
+		}
+		s := t.Format()
+		return &s
+	}
+	response := dto.APITokensResponse{
+		Tokens: []dto.APITokenInfo{},
+	}
+	for _, token := range tokens {
+		response.Tokens = append(response.Tokens, dto.APITokenInfo{
+			ID: token.ID,
+			User: dto.UserInfo{
+				ID:   token.User.ID,
+				Name: token.User.Name,
+			},
+			CreatorID:  token.CreatorID,
+			Name:       token.Name,
+			Scopes:     token.Scopes,
+			CreatedAt:  token.CreatedAt.Format(),
+			ExpiresAt:  formatOptional(token.ExpiresAt),
+			LastUsedAt: formatOptional(token.LastUsedAt),
+			RevokedAt:  formatOptional(token.RevokedAt),
+		})
+	}
+
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+func DeleteAPITokenRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	tokenID := r.PathValue("id")
+	tokenIDInt, err := strconv.Atoi(tokenID)
+	if err != nil {
+		return NewAppError(err, "token idが整数ではありません", http.StatusBadRequest)
+	}
+
+	var apiToken model.APIToken
+	if err := apiToken.Revoke(ctx, userID, tokenIDInt); err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		if errors.Is(err, db.ErrAPITokenNotFound) {
+			return NewAppError(err, "APIトークンが見つかりません", http.StatusNotFound)
+		}
+		return NewAppError(err, "APIトークンの失効に失敗しました", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func GetOrganizationsRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var org model.Organization
+	organizations, err := org.FindAll(ctx, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		return NewAppError(err, "組織の取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	response := dto.OrganizationsResponse{
+		Organizations: []dto.OrganizationInfo{},
+	}
+	for _, organization := range organizations {
+		response.Organizations = append(response.Organizations, dto.OrganizationInfo{
+			ID:   organization.ID,
+			Name: organization.Name,
+		})
+	}
+
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+func PostOrganizationsRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var createReq dto.CreateOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	var org model.Organization
+	id, err := org.Create(ctx, userID, createReq.Name)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+		return NewAppError(err, "組織の作成に失敗しました", http.StatusInternalServerError)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.CreateOrganizationResponse{ID: id})
+	return nil
+}
+
+func GetOrganizationMembersRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	organizationID := r.PathValue("id")
+	organizationIDInt, err := strconv.Atoi(organizationID)
+	if err != nil {
+		return NewAppError(err, "organization idが整数ではありません", http.StatusBadRequest)
+	}
+
+	var org model.Organization
+	members, err := org.FindMembers(ctx, userID, organizationIDInt)
+	if err != nil {
+		if errors.Is(err, db.ErrOrganizationNotFound) {
+			return NewAppError(err, "組織が見つかりません", http.StatusNotFound)
+		}
+		return NewAppError(err, "組織のユーザーの取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	response := dto.OrganizationMembersResponse{
+		Members: []dto.UserInfo{},
+	}
+	for _, member := range members {
+		response.Members = append(response.Members, dto.UserInfo{
+			ID:   member.ID,
+			Name: member.Name,
+		})
+	}
+
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+func PostOrganizationMembersRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	organizationID := r.PathValue("id")
+	organizationIDInt, err := strconv.Atoi(organizationID)
+	if err != nil {
+		return NewAppError(err, "organization idが整数ではありません", http.StatusBadRequest)
+	}
+
+	var addReq dto.AddOrganizationMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&addReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	var org model.Organization
+	if err := org.AddMember(ctx, userID, organizationIDInt, addReq.UserID); err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		if errors.Is(err, db.ErrOrganizationNotFound) {
+			return NewAppError(err, "組織が見つかりません", http.StatusNotFound)
+		}
+		if errors.Is(err, db.ErrUserNotFound) {
+			return NewAppError(err, "ユーザーが見つかりません", http.StatusNotFound)
+		}
+		return NewAppError(err, "組織への追加に失敗しました", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func GetGroupsRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var grp model.Group
+	groups, err := grp.FindAll(ctx, userID)
+	if err != nil {
+		return NewAppError(err, "グループの取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	response := dto.GroupsResponse{
+		Groups: []dto.GroupInfo{},
+	}
+	for _, group := range groups {
+		members := []dto.UserInfo{}
+		for _, member := range group.Members {
+			members = append(members, dto.UserInfo{ID: member.ID, Name: member.Name})
+		}
+		response.Groups = append(response.Groups, dto.GroupInfo{
+			ID:      group.ID,
+			Name:    group.Name,
+			Members: members,
+		})
+	}
+
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+func PostGroupsRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var createReq dto.CreateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	var grp model.Group
+	id, err := grp.Create(ctx, userID, createReq.Name)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+		return NewAppError(err, "グループの作成に失敗しました", http.StatusInternalServerError)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.CreateGroupResponse{ID: id})
+	return nil
+}
+
+func PostGroupMembersRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	groupID := r.PathValue("id")
+	groupIDInt, err := strconv.Atoi(groupID)
+	if err != nil {
+		return NewAppError(err, "group idが整数ではありません", http.StatusBadRequest)
+	}
+
+	var addReq dto.AddGroupMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&addReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	var grp model.Group
+	if err := grp.AddMember(ctx, userID, groupIDInt, addReq.UserID); err != nil {
+		return groupMemberError(err, "グループへの追加に失敗しました")
+	}
+	return nil
+}
+
+func DeleteGroupMemberRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	// ログインしているユーザーのIDを取得する
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	groupID := r.PathValue("id")
+	groupIDInt, err := strconv.Atoi(groupID)
+	if err != nil {
+		return NewAppError(err, "group idが整数ではありません", http.StatusBadRequest)
+	}
+	memberID := r.PathValue("user_id")
+	memberIDInt, err := strconv.Atoi(memberID)
+	if err != nil {
+		return NewAppError(err, "user idが整数ではありません", http.StatusBadRequest)
+	}
+
+	var grp model.Group
+	if err := grp.RemoveMember(ctx, userID, groupIDInt, memberIDInt); err != nil {
+		return groupMemberError(err, "グループからの削除に失敗しました")
+	}
+	return nil
+}
+
+// グループのメンバー変更のエラーをAppErrorに変換する
+func groupMemberError(err error, message string) *AppError {
+	if errors.Is(err, model.ErrForbidden) {
+		return NewAppError(err, "権限がありません", http.StatusForbidden)
+	}
+	if errors.Is(err, db.ErrGroupNotFound) {
+		return NewAppError(err, "グループが見つかりません", http.StatusNotFound)
+	}
+	if errors.Is(err, db.ErrUserNotFound) {
+		return NewAppError(err, "ユーザーが見つかりません", http.StatusNotFound)
+	}
+	return NewAppError(err, message, http.StatusInternalServerError)
+}
+
+// ログインユーザーのアプリ内の通知を新しい順に返す
+func GetMyNotificationsRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	query := r.URL.Query()
+	filter := model.UserNotificationFilter{
+		Cursor: query.Get("cursor"),
+	}
+	switch query.Get("unread") {
+	case "":
+	case "true":
+		filter.UnreadOnly = true
+	case "false":
+	default:
+		return NewAppError(errors.New("invalid unread"), "unreadはtrueまたはfalseでなければいけません", http.StatusBadRequest)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return NewAppError(err, "limitが整数ではありません", http.StatusBadRequest)
+		}
+		filter.Limit = limitInt
+	}
+
+	var notification model.UserNotification
+	page, err := notification.FindPage(ctx, userID, filter)
+	if err != nil {
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+		return NewAppError(err, "通知の取得に失敗しました", http.StatusInternalServerError)
+	}
+	unreadCount, err := notification.CountUnread(ctx, userID)
+	if err != nil {
+		return NewAppError(err, "通知の取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	response := dto.NotificationsResponse{
+		Notifications: make([]dto.NotificationInfo, 0, len(page.Notifications)),
+		UnreadCount:   unreadCount,
+	}
+	for _, n := range page.Notifications {
+		info := dto.NotificationInfo{
+			ID:        n.ID,
+			Kind:      n.Kind,
+			Title:     n.Title,
+			Body:      n.Body,
+			CreatedAt: n.CreatedAt.Format(),
+		}
+		if n.RequestID != 0 {
+			requestID := n.RequestID
+			info.RequestID = &requestID
+		}
+		if n.ReadAt != nil {
+			readAt := n.ReadAt.Format()
+			info.ReadAt = &readAt
+		}
+		response.Notifications = append(response.Notifications, info)
+	}
+	if page.NextCursor != "" {
+		response.NextCursor = &page.NextCursor
+	}
+
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+// ログインユーザーのアプリ内の通知を既読にする
+func PostMyNotificationReadRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	notificationID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return NewAppError(err, "notification idが整数ではありません", http.StatusBadRequest)
+	}
+
+	var notification model.UserNotification
+	if err := notification.MarkRead(ctx, userID, notificationID); err != nil {
+		if errors.Is(err, db.ErrNotificationNotFound) {
+			return NewAppError(err, "通知が見つかりません", http.StatusNotFound)
+		}
+		return NewAppError(err, "通知の更新に失敗しました", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// ログインユーザーの組織のWebhookを返す
+func GetWebhooksRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var webhook model.Webhook
+	webhooks, err := webhook.FindAll(ctx, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		return NewAppError(err, "Webhookの取得に失敗しました", http.StatusInternalServerError)
+	}
+
+	response := dto.WebhooksResponse{
+		Webhooks: make([]dto.WebhookInfo, 0, len(webhooks)),
+	}
+	for _, wh := range webhooks {
+		response.Webhooks = append(response.Webhooks, dto.WebhookInfo{
+			ID:        wh.ID,
+			CreatorID: wh.CreatorID,
+			URL:       wh.URL,
+			Events:    wh.Events,
+			CreatedAt: wh.CreatedAt.Format(),
+		})
+	}
+
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+// ログインユーザーの組織にWebhookを登録する
+func PostWebhooksRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	var createReq dto.CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&createReq); err != nil {
+		return NewAppError(err, "リクエストボディのデコードに失敗しました", http.StatusBadRequest)
+	}
+
+	var webhook model.Webhook
+	id, secret, err := webhook.Create(ctx, userID, model.NewWebhook{
+		URL:    createReq.URL,
+		Events: createReq.Events,
+	})
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		var inputErr model.InputError
+		if errors.As(err, &inputErr) {
+			return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+		}
+		return NewAppError(err, "Webhookの登録に失敗しました", http.StatusInternalServerError)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dto.CreateWebhookResponse{ID: id, Secret: secret})
+	return nil
+}
+
+// Webhookを削除する
+func DeleteWebhookRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	webhookID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return NewAppError(err, "webhook idが整数ではありません", http.StatusBadRequest)
+	}
+
+	var webhook model.Webhook
+	if err := webhook.Delete(ctx, userID, webhookID); err != nil {
+		return webhookError(err, "Webhookの削除に失敗しました")
+	}
+	return nil
+}
+
+// Webhookの送信状況を新しい順に返す
+func GetWebhookDeliveriesRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	webhookID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return NewAppError(err, "webhook idが整数ではありません", http.StatusBadRequest)
+	}
+
+	query := r.URL.Query()
+	filter := model.WebhookDeliveryFilter{
+		Cursor: query.Get("cursor"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return NewAppError(err, "limitが整数ではありません", http.StatusBadRequest)
+		}
+		filter.Limit = limitInt
+	}
+
+	var webhook model.Webhook
+	page, err := webhook.FindDeliveries(ctx, userID, webhookID, filter)
+	if err != nil {
+		return webhookError(err, "送信状況の取得に失敗しました")
+	}
+
+	response := dto.WebhookDeliveriesResponse{
+		Deliveries: make([]dto.WebhookDeliveryInfo, 0, len(page.Deliveries)),
+	}
+	for _, d := range page.Deliveries {
+		info := dto.WebhookDeliveryInfo{
+			ID:        d.ID,
+			Event:     d.Event,
+			Status:    d.Status,
+			Attempts:  d.Attempts,
+			LastError: d.LastError,
+			CreatedAt: d.CreatedAt.Format(),
+		}
+		if d.LastStatusCode != 0 {
+			statusCode := d.LastStatusCode
+			info.LastStatusCode = &statusCode
+		}
+		if d.NextAttemptAt != nil {
+			nextAttemptAt := d.NextAttemptAt.Format()
+			info.NextAttemptAt = &nextAttemptAt
+		}
+		if d.DeliveredAt != nil {
+			deliveredAt := d.DeliveredAt.Format()
+			info.DeliveredAt = &deliveredAt
+		}
+		response.Deliveries = append(response.Deliveries, info)
+	}
+	if page.NextCursor != "" {
+		response.NextCursor = &page.NextCursor
+	}
+
+	json.NewEncoder(w).Encode(response)
+	return nil
+}
+
+// Webhookの操作のエラーをAppErrorに変換する
+func webhookError(err error, message string) *AppError {
+	if errors.Is(err, model.ErrForbidden) {
+		return NewAppError(err, "権限がありません", http.StatusForbidden)
+	}
+	if errors.Is(err, db.ErrWebhookNotFound) {
+		return NewAppError(err, "Webhookが見つかりません", http.StatusNotFound)
+	}
+	var inputErr model.InputError
+	if errors.As(err, &inputErr) {
+		return NewAppError(inputErr, inputErr.Message(), http.StatusBadRequest)
+	}
+	return NewAppError(err, message, http.StatusInternalServerError)
+}
+
+// 接続を維持するために、イベントがなくてもコメントを送る間隔
+var sseHeartbeatInterval = 30 * time.Second
+
+// シフトリクエストの提出の作成と、日時ごとの提出数の増減をServer-Sent Eventsで送り続ける
+func GetRequestEventsRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	userID, isLoggedIn := auth.GetUserID(ctx, r)
+	if !isLoggedIn {
+		return NewAppError(ErrNotLoggedIn, "ログインしていません", http.StatusUnauthorized)
+	}
+
+	requestID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return NewAppError(err, "request idが整数ではありません", http.StatusBadRequest)
+	}
+
+	var request model.Request
+	sub, err := request.Subscribe(ctx, userID, requestID)
+	if err != nil {
+		if errors.Is(err, model.ErrForbidden) {
+			return NewAppError(err, "権限がありません", http.StatusForbidden)
+		}
+		if errors.Is(err, db.ErrRequestNotFound) {
+			return NewAppError(err, "シフトリクエストが見つかりません", http.StatusNotFound)
+		}
+		return NewAppError(err, "イベントの購読に失敗しました", http.StatusInternalServerError)
+	}
+	// クライアントが切断したら購読を解除する
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// リバースプロキシにバッファリングさせない
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// ヘッダーを送った後はエラーレスポンスを返せないので、書き込めなくなったら終了する
+	rc := http.NewResponseController(w)
+	if _, err := io.WriteString(w, ": connected\n\n"); err != nil {
+		return nil
+	}
+	if err := rc.Flush(); err != nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(sseHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				// 読み出しが追いつかずに購読が解除された
+				// クライアントは再接続して詳細を取得し直す
+				return nil
+			}
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "イベントのエンコードに失敗しました", slog.String("event", e.Name), slog.String("error", err.Error()))
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Name, data); err != nil {
+				return nil
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return nil
+		}
+	}
+}
+
+// プロセスが動いているか(liveness)
+// DBには問い合わせない
+func GetHealthzRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	json.NewEncoder(w).Encode(dto.HealthResponse{Status: "ok"})
+	return nil
+}
+
+// リクエストを受け付けられるか(readiness)
+// DBに接続でき、スキーマが最新の場合だけ200を返す
+func GetReadyzRequest(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+	if err := db.CheckReady(ctx.Context(), ctx.GetDB()); err != nil {
+		if errors.Is(err, db.ErrSchemaVersionMismatch) {
+			return NewAppError(err, "DBのスキーマが最新ではありません", http.StatusServiceUnavailable)
+		}
+		return NewAppError(err, "DBに接続できません", http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(dto.HealthResponse{Status: "ok"})
+	return nil
+}
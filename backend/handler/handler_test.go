@@ -4,12 +4,26 @@ import (
 	"backend/auth"
 	"backend/context"
 	"backend/db"
+	"backend/handler/dto"
+	"backend/model"
+	"bufio"
 	"bytes"
+	stdcontext "context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/bcrypt"
@@ -75,6 +89,36 @@ func setHandlerToEndpoint(appCtx *context.AppContext, endpoint string, handlerFn
 	return mux
 }
 
+func TestClientIP(t *testing.T) {
+	SetTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	t.Cleanup(func() { SetTrustedProxies(nil) })
+
+	tests := []struct {
+		remoteAddr    string
+		xForwardedFor string
+		want          string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		// 信頼しない接続元のX-Forwarded-Forは偽装できるので使わない
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		// 利用者が付けた値は無視して、信頼するプロキシが付けた値を使う
+		{"10.0.0.1:1234", "203.0.113.1, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "unknown", "10.0.0.1"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.xForwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", test.xForwardedFor)
+		}
+		if got := clientIP(req); got != test.want {
+			t.Errorf("remote=%s xff=%q: want %s, got %s", test.remoteAddr, test.xForwardedFor, test.want, got)
+		}
+	}
+}
+
 func TestGetRequestsHandler(t *testing.T) {
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
 	appCtx := newTestContext(
@@ -100,27 +144,72 @@ func TestGetRequestsHandler(t *testing.T) {
 
 	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
 
+	// デフォルトは作成日時の降順。同じ作成日時の場合はIDの降順
 	wantJSON := `
-	[
-		{
-			"id": 1,
-			"creator": {"id": 2, "name": "テストマネージャー"},
-			"start_date": "2024-06-01",
-			"end_date": "2024-06-01",
-			"deadline": "2024-06-01 00:00:00",
-			"created_at": "2024-06-01 00:00:00"
-		},
-		{
-			"id": 2,
-			"creator": {"id": 2, "name": "テストマネージャー"},
-			"start_date": "2024-06-01",
-			"end_date": "2024-06-01",
-			"deadline": "2024-06-01 00:00:00",
-			"created_at": "2024-06-01 00:00:00"
-		}
-	]
+	{
+		"requests": [
+			{
+				"id": 2,
+				"creator": {"id": 2, "name": "テストマネージャー"},
+				"start_date": "2024-06-01",
+				"end_date": "2024-06-01",
+				"deadline": "2024-06-01 00:00:00",
+				"created_at": "2024-06-01 00:00:00"
+			},
+			{
+				"id": 1,
+				"creator": {"id": 2, "name": "テストマネージャー"},
+				"start_date": "2024-06-01",
+				"end_date": "2024-06-01",
+				"deadline": "2024-06-01 00:00:00",
+				"created_at": "2024-06-01 00:00:00"
+			}
+		],
+		"next_cursor": null
+	}
 	`
 	AssertRes(t, w.Body.Bytes(), wantJSON)
+
+	// --- ページング: 1件ずつ取得する ---
+	req2 := httptest.NewRequest("GET", "/requests?limit=1&order=asc", nil)
+	addCookiesToRequest(req2, cookies)
+	w2 := httptest.NewRecorder()
+	mux.ServeHTTP(w2, req2)
+	AssertCode(t, w2.Code, http.StatusOK, w2.Body.Bytes())
+
+	var page struct {
+		Requests []struct {
+			ID int `json:"id"`
+		} `json:"requests"`
+		NextCursor *string `json:"next_cursor"`
+	}
+	json.Unmarshal(w2.Body.Bytes(), &page)
+	if len(page.Requests) != 1 || page.Requests[0].ID != 1 || page.NextCursor == nil {
+		t.Fatalf("unexpected first page: %s", w2.Body.String())
+	}
+
+	req3 := httptest.NewRequest("GET", "/requests?limit=1&order=asc&cursor="+*page.NextCursor, nil)
+	addCookiesToRequest(req3, cookies)
+	w3 := httptest.NewRecorder()
+	mux.ServeHTTP(w3, req3)
+	AssertCode(t, w3.Code, http.StatusOK, w3.Body.Bytes())
+
+	page.NextCursor = nil
+	json.Unmarshal(w3.Body.Bytes(), &page)
+	if len(page.Requests) != 1 || page.Requests[0].ID != 2 || page.NextCursor != nil {
+		t.Fatalf("unexpected second page: %s", w3.Body.String())
+	}
+
+	// --- 異常系: 不正なクエリパラメータ ---
+	for _, query := range []string{"limit=abc", "limit=-1", "limit=101", "from=2024/06/01", "status=unknown", "sort=id", "has_my_submission=maybe", "cursor=invalid"} {
+		req := httptest.NewRequest("GET", "/requests?"+query, nil)
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: want status code %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
 }
 
 func TestGetRequestHandler(t *testing.T) {
@@ -163,6 +252,7 @@ func TestGetRequestHandler(t *testing.T) {
 		"end_date": "2024-06-01",
 		"deadline": "2024-06-01 00:00:00",
 		"created_at": "2024-06-01 00:00:00",
+		"targets": {"users": [], "groups": []},
 		"submissions": [
 			{
 				"submitter": {"id": 1, "name": "テストユーザー1"}
@@ -188,6 +278,34 @@ func TestGetRequestHandler(t *testing.T) {
 	}
 	`
 	AssertRes(t, w.Body.Bytes(), wantJSON)
+
+	// --- 異常系: 存在しないリクエストID ---
+	req2 := httptest.NewRequest("GET", "/requests/999", nil)
+	addCookiesToRequest(req2, cookies)
+	w2 := httptest.NewRecorder()
+	mux.ServeHTTP(w2, req2)
+
+	AssertCode(t, w2.Code, http.StatusNotFound, w2.Body.Bytes())
+
+	// --- 正常系: submission.view_allを持たない場合は自分の提出だけを返す ---
+	roles, err := auth.ParseRolePermissions(auth.DefaultRoles, "employee=submission.create")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auth.SetRoles(roles)
+	t.Cleanup(func() { auth.SetRoles(auth.DefaultRoles) })
+
+	req3 := httptest.NewRequest("GET", "/requests/1", nil)
+	addCookiesToRequest(req3, cookies)
+	w3 := httptest.NewRecorder()
+	mux.ServeHTTP(w3, req3)
+
+	AssertCode(t, w3.Code, http.StatusOK, w3.Body.Bytes())
+	var res dto.RequestDetailResponse
+	json.Unmarshal(w3.Body.Bytes(), &res)
+	if len(res.Submissions) != 1 || res.Submissions[0].Submitter.ID != 1 || len(res.Entries) != 1 {
+		t.Errorf("should return only own submission: %s", w3.Body.String())
+	}
 }
 
 func TestPostRequestsHandler(t *testing.T) {
@@ -273,6 +391,69 @@ func TestPostSubmissionsHandler(t *testing.T) {
 	}
 	`
 	AssertRes(t, w.Body.Bytes(), wantJSON)
+
+	// --- 異常系: 提出済み ---
+	req3 := httptest.NewRequest("POST", "/requests/1/submissions", bytes.NewBuffer(body))
+	req3.Header.Set("Content-Type", "application/json")
+	addCookiesToRequest(req3, cookies)
+	w3 := httptest.NewRecorder()
+	mux.ServeHTTP(w3, req3)
+
+	AssertCode(t, w3.Code, http.StatusConflict, w3.Body.Bytes())
+
+	// --- 異常系: 存在しないリクエストID ---
+	req2 := httptest.NewRequest("POST", "/requests/999/submissions", bytes.NewBuffer(body))
+	req2.Header.Set("Content-Type", "application/json")
+	addCookiesToRequest(req2, cookies)
+	w2 := httptest.NewRecorder()
+	mux.ServeHTTP(w2, req2)
+
+	AssertCode(t, w2.Code, http.StatusNotFound, w2.Body.Bytes())
+}
+
+func TestPutSubmissionHandler(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{
+			{ID: 1, CreatorID: 2, StartDate: "2024-06-01", EndDate: "2024-06-02", Deadline: "2024-06-01 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "other_user", Password: string(hashedPassword), Name: "他の従業員", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{
+			{ID: 1, SubmissionID: 1, Date: "2024-06-01", Hour: 9},
+		},
+		[]db.Submission{
+			{ID: 1, RequestID: 1, SubmitterID: 1, CreatedAt: "2024-06-01 00:00:00", UpdatedAt: "2024-06-01 00:00:00"},
+		},
+	)
+	mux := setHandlerToEndpoint(appCtx, "PUT /requests/{id}/submissions/{submission_id}", PutSubmissionRequest)
+	body, _ := json.Marshal([]map[string]any{{"date": "2024-06-02", "hour": 10}})
+
+	tests := []struct {
+		name         string
+		login        string
+		path         string
+		expectedCode int
+	}{
+		{"本人", "test_user", "/requests/1/submissions/1", http.StatusNoContent},
+		{"マネージャー", "test_manager", "/requests/1/submissions/1", http.StatusNoContent},
+		{"他の従業員", "other_user", "/requests/1/submissions/1", http.StatusForbidden},
+		{"存在しない提出", "test_manager", "/requests/1/submissions/999", http.StatusNotFound},
+		{"存在しないリクエスト", "test_manager", "/requests/999/submissions/1", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", tt.path, bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			addCookiesToRequest(req, getLoginCookies(appCtx, tt.login, "password"))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			AssertCode(t, w.Code, tt.expectedCode, w.Body.Bytes())
+		})
+	}
 }
 
 func TestLoginHandler(t *testing.T) {
@@ -323,6 +504,21 @@ func TestLoginHandler(t *testing.T) {
 	w2 := httptest.NewRecorder()
 	mux.ServeHTTP(w2, req2)
 	AssertCode(t, w2.Code, http.StatusUnauthorized, w2.Body.Bytes())
+
+	// --- 異常系: 失敗が続くと試行を制限される ---
+	for i := 1; i < auth.DefaultThrottlePolicy.BackoffAfter; i++ {
+		req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBody2))
+		req.Header.Set("Content-Type", "application/json")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req3 := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBody))
+	req3.Header.Set("Content-Type", "application/json")
+	w3 := httptest.NewRecorder()
+	mux.ServeHTTP(w3, req3)
+	AssertCode(t, w3.Code, http.StatusTooManyRequests, w3.Body.Bytes())
+	if w3.Header().Get("Retry-After") != "1" {
+		t.Errorf("want Retry-After 1, got %q", w3.Header().Get("Retry-After"))
+	}
 }
 
 func TestGetSessionHandler(t *testing.T) {
@@ -353,11 +549,23 @@ func TestGetSessionHandler(t *testing.T) {
 			"id": 1,
 			"name": "テストユーザー",
 			"roles": ["employee"],
+			"permissions": ["submission.create", "submission.view_all"],
+			"organization": {"id": 1, "name": "デフォルト"},
 			"created_at": "2024-06-01 00:00:00"
-		}
+		},
+		"unread_notification_count": 0
 	}
 	`
-	AssertRes(t, w.Body.Bytes(), wantJSON)
+
+	// CSRFトークンはランダムなので、存在だけ確認して比較から除く
+	var res map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if token, _ := res["csrf_token"].(string); token == "" {
+		t.Errorf("csrf_token should be set, got %v", res["csrf_token"])
+	}
+	delete(res, "csrf_token")
+	body, _ := json.Marshal(res)
+	AssertRes(t, body, wantJSON)
 }
 
 func TestLogoutHandler(t *testing.T) {
@@ -531,8 +739,1086 @@ func TestGetMySubmissionRequest(t *testing.T) {
 		_, mux, cookies := setupTest(true)
 		w := executeRequest(mux, "/requests/999/submissions/mine", cookies)
 
-		if w.Code == http.StatusOK {
-			t.Fatal("expected a status not ok for invalid request ID, but got ", w.Code)
-		}
+		AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+	})
+}
+
+func TestHandlerRequestTimeout(t *testing.T) {
+	appCtx := newTestContext([]db.Request{}, []db.User{}, []db.Entry{}, []db.Submission{})
+	appCtx.SetRequestTimeout(10 * time.Millisecond)
+
+	// contextがキャンセルされるまで待つハンドラー
+	mux := setHandlerToEndpoint(appCtx, "GET /slow", func(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+		<-ctx.Context().Done()
+		return NewAppError(ctx.Context().Err(), "取得に失敗しました", http.StatusInternalServerError)
 	})
+
+	req := httptest.NewRequest("GET", "/slow", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	AssertCode(t, w.Code, http.StatusServiceUnavailable, w.Body.Bytes())
+}
+
+func TestGetAuditHandler(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "other_user", Password: string(hashedPassword), Name: "他の組織のユーザー", Role: auth.RoleEmployee, OrganizationID: 2, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	mux := setHandlerToEndpoint(appCtx, "GET /audit", GetAuditRequest)
+
+	// ログインの成功と失敗が記録される
+	getLoginCookies(appCtx, "test_user", "wrong")
+	managerCookies := getLoginCookies(appCtx, "test_manager", "password")
+	employeeCookies := getLoginCookies(appCtx, "test_user", "password")
+
+	// 他の組織のユーザー、存在しないユーザーのログインの失敗は、この組織の監査ログに含めない
+	getLoginCookies(appCtx, "other_user", "wrong")
+	getLoginCookies(appCtx, "unknown_user", "wrong")
+
+	// --- 正常系: マネージャーは閲覧できる ---
+	req := httptest.NewRequest("GET", "/audit?action=auth.login_failed", nil)
+	addCookiesToRequest(req, managerCookies)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+
+	var res struct {
+		Events []map[string]interface{} `json:"events"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if len(res.Events) != 1 {
+		t.Fatalf("want 1 event, got %s", w.Body.String())
+	}
+	event := res.Events[0]
+	if event["actor_id"] != float64(0) || event["target_id"] != float64(1) || event["client_ip"] != "192.0.2.1" || event["before"] != nil {
+		t.Errorf("unexpected event: %v", event)
+	}
+	if after, _ := event["after"].(map[string]interface{}); after["login_id"] != "test_user" {
+		t.Errorf("unexpected after: %v", event["after"])
+	}
+	events, _ := appCtx.GetDB().QueryAuditEvents(appCtx.Context(), db.AuditQuery{OrganizationID: 2, Action: model.AuditActionLoginFailed})
+	if len(events) != 1 || events[0].TargetID != 3 {
+		t.Errorf("login failure should be recorded in the user's organization: %+v", events)
+	}
+
+	// --- 異常系: 従業員は閲覧できない ---
+	req2 := httptest.NewRequest("GET", "/audit", nil)
+	addCookiesToRequest(req2, employeeCookies)
+	w2 := httptest.NewRecorder()
+	mux.ServeHTTP(w2, req2)
+
+	AssertCode(t, w2.Code, http.StatusForbidden, w2.Body.Bytes())
+
+	// --- 異常系: ログインしていない ---
+	req3 := httptest.NewRequest("GET", "/audit", nil)
+	w3 := httptest.NewRecorder()
+	mux.ServeHTTP(w3, req3)
+
+	AssertCode(t, w3.Code, http.StatusUnauthorized, w3.Body.Bytes())
+}
+
+func TestPostUnlockUserHandler(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	mux := setHandlerToEndpoint(appCtx, "POST /users/{id}/unlock", PostUnlockUserRequest)
+
+	managerCookies := getLoginCookies(appCtx, "test_manager", "password")
+	employeeCookies := getLoginCookies(appCtx, "test_user", "password")
+
+	// ロックされた状態にする
+	appCtx.GetDB().SaveLoginThrottle(appCtx.Context(), db.LoginThrottle{Key: "login_id:test_user", Failures: 10, LastFailureAt: time.Now().Unix(), BlockedUntil: time.Now().Add(time.Hour).Unix()})
+
+	unlock := func(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Content-Type", "application/json")
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	// --- 異常系: 従業員は解除できない ---
+	w := unlock("/users/1/unlock", employeeCookies)
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	// --- 異常系: 存在しないユーザー ---
+	w = unlock("/users/999/unlock", managerCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	// --- 正常系 ---
+	w = unlock("/users/1/unlock", managerCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+
+	if _, err := appCtx.GetDB().GetLoginThrottle(appCtx.Context(), "login_id:test_user"); !errors.Is(err, db.ErrThrottleNotFound) {
+		t.Errorf("throttle should be deleted, got %v", err)
+	}
+}
+
+func TestPasswordHandlers(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password1"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	mux := http.NewServeMux()
+	mux.Handle("POST /me/password", NewHandler(appCtx, PostMyPasswordRequest))
+	mux.Handle("POST /users/{id}/password-reset", NewHandler(appCtx, PostPasswordResetTokenRequest))
+	mux.Handle("POST /password-reset", NewHandler(appCtx, PostPasswordResetRequest))
+	mux.Handle("GET /session", NewHandler(appCtx, GetSessionRequest))
+
+	post := func(path string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	getSession := func(cookies []*http.Cookie) int {
+		req := httptest.NewRequest("GET", "/session", nil)
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	employeeCookies := getLoginCookies(appCtx, "test_user", "password1")
+	otherCookies := getLoginCookies(appCtx, "test_user", "password1")
+
+	// --- 異常系: 現在のパスワードが間違っている ---
+	w := post("/me/password", `{"current_password":"wrong","new_password":"newpass123"}`, employeeCookies)
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+
+	// --- 異常系: 新しいパスワードが条件を満たさない ---
+	w = post("/me/password", `{"current_password":"password1","new_password":"short"}`, employeeCookies)
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+
+	// --- 正常系: 変更したセッションは使い続けられ、他のセッションは無効になる ---
+	w = post("/me/password", `{"current_password":"password1","new_password":"newpass123"}`, employeeCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	renewedCookies := w.Result().Cookies()
+	if code := getSession(renewedCookies); code != http.StatusOK {
+		t.Errorf("renewed session should be valid, got %d", code)
+	}
+	if code := getSession(otherCookies); code != http.StatusUnauthorized {
+		t.Errorf("other session should be invalid, got %d", code)
+	}
+
+	// --- 異常系: 従業員はリセット用トークンを発行できない ---
+	w = post("/users/1/password-reset", "", renewedCookies)
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	// --- 正常系: マネージャーが発行したトークンでパスワードを設定し直す ---
+	managerCookies := getLoginCookies(appCtx, "test_manager", "password1")
+	w = post("/users/1/password-reset", "", managerCookies)
+	AssertCode(t, w.Code, http.StatusCreated, w.Body.Bytes())
+	var resetRes dto.PasswordResetResponse
+	json.Unmarshal(w.Body.Bytes(), &resetRes)
+	if resetRes.Token == "" || resetRes.ExpiresAt == "" {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+
+	w = post("/password-reset", `{"token":"`+resetRes.Token+`","new_password":"reset1234"}`, nil)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	if code := getSession(renewedCookies); code != http.StatusUnauthorized {
+		t.Errorf("session should be invalid after reset, got %d", code)
+	}
+	if cookies := getLoginCookies(appCtx, "test_user", "reset1234"); len(cookies) == 0 {
+		t.Errorf("should be able to login with the new password")
+	}
+
+	// --- 異常系: 同じトークンは二度使えない ---
+	w = post("/password-reset", `{"token":"`+resetRes.Token+`","new_password":"again1234"}`, nil)
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+}
+
+// テスト用にRFC 6238のワンタイムパスワードを計算する
+func totpCodeForTest(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("invalid secret: %v", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+func TestTOTPLoginHandlers(t *testing.T) {
+	auth.SetTwoFactorPolicy(auth.TwoFactorPolicy{RequireForManager: true, Issuer: "test", PendingTimeout: time.Minute, RecoveryCodeCount: 2})
+	t.Cleanup(func() { auth.SetTwoFactorPolicy(auth.DefaultTwoFactorPolicy) })
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{},
+		[]db.User{
+			{ID: 2, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	mux := http.NewServeMux()
+	mux.Handle("POST /login", NewHandler(appCtx, LoginRequest))
+	mux.Handle("POST /login/totp", NewHandler(appCtx, PostLoginTOTPRequest))
+	mux.Handle("POST /me/totp", NewHandler(appCtx, PostTOTPRequest))
+	mux.Handle("POST /me/totp/confirm", NewHandler(appCtx, PostTOTPConfirmRequest))
+	mux.Handle("GET /session", NewHandler(appCtx, GetSessionRequest))
+
+	do := func(method string, path string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	loginBody := `{"login_id":"test_manager","password":"password"}`
+
+	// --- 未登録のマネージャーは、ログインを完了する前に登録を求められる ---
+	w := do("POST", "/login", loginBody, nil)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	var loginRes dto.LoginResponse
+	json.Unmarshal(w.Body.Bytes(), &loginRes)
+	if !loginRes.MFARequired || !loginRes.MFAEnrollmentRequired || loginRes.CSRFToken == "" {
+		t.Fatalf("unexpected login response: %s", w.Body.String())
+	}
+	pendingCookies := w.Result().Cookies()
+	if w := do("GET", "/session", "", pendingCookies); w.Code != http.StatusUnauthorized {
+		t.Errorf("pending session should not be logged in, got %d", w.Code)
+	}
+
+	// --- 認証待ちのまま登録し、ログインを完了する ---
+	w = do("POST", "/me/totp", "", pendingCookies)
+	AssertCode(t, w.Code, http.StatusCreated, w.Body.Bytes())
+	var enrollRes dto.TOTPEnrollmentResponse
+	json.Unmarshal(w.Body.Bytes(), &enrollRes)
+	if !strings.HasPrefix(enrollRes.OtpauthURI, "otpauth://totp/") {
+		t.Errorf("unexpected otpauth uri: %s", enrollRes.OtpauthURI)
+	}
+
+	w = do("POST", "/me/totp/confirm", `{"code":"abc"}`, pendingCookies)
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+
+	w = do("POST", "/me/totp/confirm", `{"code":"`+totpCodeForTest(t, enrollRes.Secret)+`"}`, pendingCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	var codesRes dto.RecoveryCodesResponse
+	json.Unmarshal(w.Body.Bytes(), &codesRes)
+	if len(codesRes.RecoveryCodes) != 2 {
+		t.Fatalf("unexpected recovery codes: %s", w.Body.String())
+	}
+	if w := do("GET", "/session", "", w.Result().Cookies()); w.Code != http.StatusOK {
+		t.Errorf("session should be logged in after enrollment, got %d", w.Code)
+	}
+
+	// --- 登録後はパスワードの次にコードを求められる ---
+	w = do("POST", "/login", loginBody, nil)
+	json.Unmarshal(w.Body.Bytes(), &loginRes)
+	if !loginRes.MFARequired || loginRes.MFAEnrollmentRequired {
+		t.Fatalf("unexpected login response: %s", w.Body.String())
+	}
+	pendingCookies = w.Result().Cookies()
+
+	w = do("POST", "/login/totp", `{"code":"0000-0000-0000-0000"}`, pendingCookies)
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+
+	w = do("POST", "/login/totp", `{"code":"`+codesRes.RecoveryCodes[0]+`"}`, pendingCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	if w := do("GET", "/session", "", w.Result().Cookies()); w.Code != http.StatusOK {
+		t.Errorf("session should be logged in after second factor, got %d", w.Code)
+	}
+
+	// --- 認証待ちでなければ401 ---
+	w = do("POST", "/login/totp", `{"code":"123456"}`, nil)
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+}
+
+func TestAPITokenHandlers(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{
+			{ID: 1, CreatorID: 2, StartDate: "2024-06-01", EndDate: "2024-06-01", Deadline: "2024-06-01 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	// ルーターと同じように、APIトークンで呼び出せるルートにはスコープを設定する
+	withScope := func(scope string, next HandlerFuncWithContext) HandlerFuncWithContext {
+		return func(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+			return next(ctx, w, r.WithContext(auth.WithRequiredScope(r.Context(), scope)))
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /api-tokens", NewHandler(appCtx, PostAPITokensRequest))
+	mux.Handle("GET /api-tokens", NewHandler(appCtx, GetAPITokensRequest))
+	mux.Handle("DELETE /api-tokens/{id}", NewHandler(appCtx, DeleteAPITokenRequest))
+	mux.Handle("GET /requests", NewHandler(appCtx, withScope(auth.ScopeRequestsRead, GetRequestsRequest)))
+	mux.Handle("GET /requests/{id}", NewHandler(appCtx, withScope(auth.ScopeSubmissionsRead, GetRequestRequest)))
+
+	do := func(method string, path string, body string, cookies []*http.Cookie, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		addCookiesToRequest(req, cookies)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	managerCookies := getLoginCookies(appCtx, "test_manager", "password")
+	employeeCookies := getLoginCookies(appCtx, "test_user", "password")
+
+	// --- 異常系: 従業員は発行できない ---
+	w := do("POST", "/api-tokens", `{"user_id":1,"name":"給与計算","scopes":["requests:read"]}`, employeeCookies, "")
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	// --- 異常系: 不明なスコープ ---
+	w = do("POST", "/api-tokens", `{"user_id":1,"name":"給与計算","scopes":["admin"]}`, managerCookies, "")
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+
+	// --- 正常系: 発行したトークンでスコープを持つルートを呼び出せる ---
+	w = do("POST", "/api-tokens", `{"user_id":1,"name":"給与計算","scopes":["requests:read"],"expires_at":"2999-01-01 00:00:00"}`, managerCookies, "")
+	AssertCode(t, w.Code, http.StatusCreated, w.Body.Bytes())
+	var createRes dto.CreateAPITokenResponse
+	json.Unmarshal(w.Body.Bytes(), &createRes)
+	if !strings.HasPrefix(createRes.Token, "swt_") {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+
+	w = do("GET", "/requests", "", nil, createRes.Token)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+
+	// --- 異常系: スコープを持たないルート、トークン管理はセッションでしか呼び出せない ---
+	w = do("GET", "/requests/1", "", nil, createRes.Token)
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+	w = do("GET", "/api-tokens", "", nil, createRes.Token)
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+
+	// --- 正常系: 一覧にはトークンそのものを含めず、最終使用日時を含める ---
+	w = do("GET", "/api-tokens", "", managerCookies, "")
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	if strings.Contains(w.Body.String(), createRes.Token) {
+		t.Errorf("token should not be listed: %s", w.Body.String())
+	}
+	var listRes dto.APITokensResponse
+	json.Unmarshal(w.Body.Bytes(), &listRes)
+	if len(listRes.Tokens) != 1 || listRes.Tokens[0].ID != createRes.ID || listRes.Tokens[0].LastUsedAt == nil ||
+		listRes.Tokens[0].ExpiresAt == nil || *listRes.Tokens[0].ExpiresAt != "2999-01-01 00:00:00" {
+		t.Errorf("unexpected response: %s", w.Body.String())
+	}
+
+	// --- 正常系: 失効したトークンは使えない ---
+	path := fmt.Sprintf("/api-tokens/%d", createRes.ID)
+	w = do("DELETE", path, "", managerCookies, "")
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	w = do("GET", "/requests", "", nil, createRes.Token)
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+
+	// --- 異常系: 失効済み ---
+	w = do("DELETE", path, "", managerCookies, "")
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+}
+
+func TestOrganizationHandlers(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{
+			{ID: 1, CreatorID: 2, StartDate: "2024-06-01", EndDate: "2024-06-01", Deadline: "2024-06-01 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "test_admin", Password: string(hashedPassword), Name: "テスト管理者", Role: auth.RoleAdmin, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	mux := http.NewServeMux()
+	mux.Handle("GET /organizations", NewHandler(appCtx, GetOrganizationsRequest))
+	mux.Handle("POST /organizations", NewHandler(appCtx, PostOrganizationsRequest))
+	mux.Handle("GET /organizations/{id}/members", NewHandler(appCtx, GetOrganizationMembersRequest))
+	mux.Handle("POST /organizations/{id}/members", NewHandler(appCtx, PostOrganizationMembersRequest))
+	mux.Handle("GET /requests/{id}", NewHandler(appCtx, GetRequestRequest))
+
+	do := func(method string, path string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	adminCookies := getLoginCookies(appCtx, "test_admin", "password")
+	managerCookies := getLoginCookies(appCtx, "test_manager", "password")
+	employeeCookies := getLoginCookies(appCtx, "test_user", "password")
+
+	// --- 異常系: 管理者以外は作成できない ---
+	w := do("POST", "/organizations", `{"name":"2号店"}`, managerCookies)
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	// --- 正常系: 作成して一覧に含まれる ---
+	w = do("POST", "/organizations", `{"name":"2号店"}`, adminCookies)
+	AssertCode(t, w.Code, http.StatusCreated, w.Body.Bytes())
+	AssertRes(t, w.Body.Bytes(), `{"id": 2}`)
+
+	w = do("GET", "/organizations", "", adminCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	AssertRes(t, w.Body.Bytes(), `{"organizations": [{"id": 1, "name": "デフォルト"}, {"id": 2, "name": "2号店"}]}`)
+
+	// --- 正常系: 従業員を2号店に移すと、元の店舗のシフトリクエストは見えなくなる ---
+	w = do("GET", "/requests/1", "", employeeCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+
+	w = do("POST", "/organizations/2/members", `{"user_id":1}`, adminCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+
+	w = do("GET", "/requests/1", "", employeeCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	// --- 正常系: 同じ店舗の所属ユーザーを閲覧できる ---
+	w = do("GET", "/organizations/2/members", "", employeeCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	AssertRes(t, w.Body.Bytes(), `{"members": [{"id": 1, "name": "テストユーザー"}]}`)
+
+	// --- 異常系: 他の店舗の所属ユーザーは閲覧できない ---
+	w = do("GET", "/organizations/2/members", "", managerCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+}
+
+func TestGroupHandlers(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "other_user", Password: string(hashedPassword), Name: "ホール担当", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	mux := http.NewServeMux()
+	mux.Handle("GET /groups", NewHandler(appCtx, GetGroupsRequest))
+	mux.Handle("POST /groups", NewHandler(appCtx, PostGroupsRequest))
+	mux.Handle("POST /groups/{id}/members", NewHandler(appCtx, PostGroupMembersRequest))
+	mux.Handle("DELETE /groups/{id}/members/{user_id}", NewHandler(appCtx, DeleteGroupMemberRequest))
+	mux.Handle("POST /requests", NewHandler(appCtx, PostRequestsRequest))
+	mux.Handle("GET /requests/{id}", NewHandler(appCtx, GetRequestRequest))
+	mux.Handle("GET /requests/{request_id}/submissions/mine", NewHandler(appCtx, GetMySubmissionRequest))
+
+	do := func(method string, path string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	managerCookies := getLoginCookies(appCtx, "test_manager", "password")
+	employeeCookies := getLoginCookies(appCtx, "test_user", "password")
+	otherCookies := getLoginCookies(appCtx, "other_user", "password")
+
+	// --- 異常系: マネージャー以外は作成できない ---
+	w := do("POST", "/groups", `{"name":"キッチン"}`, employeeCookies)
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	// --- 正常系: 作成してメンバーを追加すると一覧に含まれる ---
+	w = do("POST", "/groups", `{"name":"キッチン"}`, managerCookies)
+	AssertCode(t, w.Code, http.StatusCreated, w.Body.Bytes())
+	AssertRes(t, w.Body.Bytes(), `{"id": 1}`)
+
+	w = do("POST", "/groups/1/members", `{"user_id":1}`, managerCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+
+	w = do("GET", "/groups", "", employeeCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	AssertRes(t, w.Body.Bytes(), `{"groups": [{"id": 1, "name": "キッチン", "members": [{"id": 1, "name": "テストユーザー"}]}]}`)
+
+	// --- 異常系: 存在しないグループ、ユーザー ---
+	w = do("POST", "/groups/999/members", `{"user_id":1}`, managerCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+	w = do("POST", "/groups/1/members", `{"user_id":999}`, managerCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	// --- 正常系: グループを対象にしたシフトリクエストは対象外のユーザーには見えない ---
+	w = do("POST", "/requests", `{"start_date":"2099-06-01","end_date":"2099-06-07","deadline":"2099-05-25 00:00:00","target_group_ids":[1]}`, managerCookies)
+	AssertCode(t, w.Code, http.StatusCreated, w.Body.Bytes())
+
+	w = do("GET", "/requests/1", "", employeeCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	var detail struct {
+		Targets json.RawMessage `json:"targets"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("json decode error: %v", err)
+	}
+	AssertRes(t, detail.Targets, `{"users": [], "groups": [{"id": 1, "name": "キッチン"}]}`)
+
+	w = do("GET", "/requests/1", "", otherCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	// 自分の提出の取得も同じ
+	w = do("GET", "/requests/1/submissions/mine", "", employeeCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	w = do("GET", "/requests/1/submissions/mine", "", otherCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	// --- 異常系: 存在しないグループを対象にできない ---
+	w = do("POST", "/requests", `{"start_date":"2099-06-01","end_date":"2099-06-07","deadline":"2099-05-25 00:00:00","target_group_ids":[999]}`, managerCookies)
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+
+	// --- 正常系: メンバーから外すと見えなくなる ---
+	w = do("DELETE", "/groups/1/members/1", "", managerCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+
+	w = do("GET", "/requests/1", "", employeeCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+	w = do("GET", "/requests/1/submissions/mine", "", employeeCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+}
+
+func TestGetRequestStatusHandler(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{
+			{ID: 1, CreatorID: 1, StartDate: "2099-06-01", EndDate: "2099-06-07", Deadline: "2099-05-25 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.User{
+			{ID: 1, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_user_2", Password: string(hashedPassword), Name: "テストユーザー2", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "test_user_3", Password: string(hashedPassword), Name: "テストユーザー3", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{
+			{ID: 1, SubmissionID: 1, Date: "2099-06-01", Hour: 8},
+			{ID: 2, SubmissionID: 1, Date: "2099-06-01", Hour: 9},
+		},
+		[]db.Submission{
+			{ID: 1, RequestID: 1, SubmitterID: 2, CreatedAt: "2024-06-02 00:00:00", UpdatedAt: "2024-06-03 00:00:00"},
+		},
+	)
+	mux := http.NewServeMux()
+	mux.Handle("GET /requests/{id}/status", NewHandler(appCtx, GetRequestStatusRequest))
+
+	do := func(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	managerCookies := getLoginCookies(appCtx, "test_manager", "password")
+	employeeCookies := getLoginCookies(appCtx, "test_user_2", "password")
+
+	// --- 正常系: 未提出のユーザーも含めて返す ---
+	w := do("/requests/1/status", managerCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	AssertRes(t, w.Body.Bytes(), `{
+		"request_id": 1,
+		"submitted_count": 1,
+		"not_submitted_count": 1,
+		"statuses": [
+			{"user": {"id": 2, "name": "テストユーザー2"}, "submitted": true, "submitted_at": "2024-06-02 00:00:00", "updated_at": "2024-06-03 00:00:00", "entry_count": 2},
+			{"user": {"id": 3, "name": "テストユーザー3"}, "submitted": false, "submitted_at": null, "updated_at": null, "entry_count": 0}
+		]
+	}`)
+
+	// --- 異常系: 権限がない ---
+	w = do("/requests/1/status", employeeCookies)
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	// --- 異常系: 存在しないシフトリクエスト ---
+	w = do("/requests/999/status", managerCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	// --- 異常系: ログインしていない ---
+	w = do("/requests/1/status", nil)
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+}
+
+func TestGetRequestExportHandler(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{
+			{ID: 1, CreatorID: 1, StartDate: "2099-06-01", EndDate: "2099-06-07", Deadline: "2099-05-25 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.User{
+			{ID: 1, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_user_2", Password: string(hashedPassword), Name: "テストユーザー2", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "test_admin", Password: string(hashedPassword), Name: "テスト管理者", Role: auth.RoleAdmin, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{
+			{ID: 1, SubmissionID: 1, Date: "2099-06-01", Hour: 8},
+			{ID: 2, SubmissionID: 1, Date: "2099-06-01", Hour: 9},
+		},
+		[]db.Submission{
+			{ID: 1, RequestID: 1, SubmitterID: 2, CreatedAt: "2024-06-02 00:00:00", UpdatedAt: "2024-06-03 00:00:00"},
+		},
+	)
+	// ルーターと同じように、exportスコープを設定する
+	handlerFn := func(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *AppError {
+		return GetRequestExportRequest(ctx, w, r.WithContext(auth.WithRequiredScope(r.Context(), auth.ScopeExport)))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /requests/{id}/export", NewHandler(appCtx, handlerFn))
+
+	do := func(path string, cookies []*http.Cookie, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		addCookiesToRequest(req, cookies)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	managerCookies := getLoginCookies(appCtx, "test_manager", "password")
+	adminCookies := getLoginCookies(appCtx, "test_admin", "password")
+
+	// --- 正常系: shiftctl exportと同じ形式のCSVを返す ---
+	w := do("/requests/1/export", managerCookies, "")
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	if w.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected content type: %s", w.Header().Get("Content-Type"))
+	}
+	wantCSV := "request_id,submission_id,login_id,name,date,hour\n" +
+		"1,1,test_user_2,テストユーザー2,2099-06-01,8\n" +
+		"1,1,test_user_2,テストユーザー2,2099-06-01,9\n"
+	if w.Body.String() != wantCSV {
+		t.Errorf("unexpected csv:\n%s", w.Body.String())
+	}
+
+	// --- 正常系: exportスコープのAPIトークンで呼び出せる ---
+	var apiToken model.APIToken
+	_, exportToken, err := apiToken.Issue(appCtx, 1, model.NewAPIToken{UserID: 1, Name: "給与計算", Scopes: []string{auth.ScopeExport}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w = do("/requests/1/export", nil, exportToken)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+
+	// --- 異常系: exportスコープを持たないトークン ---
+	_, readToken, err := apiToken.Issue(appCtx, 1, model.NewAPIToken{UserID: 1, Name: "閲覧", Scopes: []string{auth.ScopeSubmissionsRead}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w = do("/requests/1/export", nil, readToken)
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+
+	// --- 異常系: 権限がない ---
+	w = do("/requests/1/export", adminCookies, "")
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	// --- 異常系: 存在しないシフトリクエスト ---
+	w = do("/requests/999/export", managerCookies, "")
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	// --- 異常系: ログインしていない ---
+	w = do("/requests/1/export", nil, "")
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+}
+
+func TestNotificationHandlers(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_user_2", Password: string(hashedPassword), Name: "テストユーザー2", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	var n model.UserNotification
+	n.Create(appCtx, model.NewUserNotification{UserID: 1, Kind: model.NotificationKindRequestCreated, RequestID: 1, Title: "新しいシフトリクエストが作成されました", Body: "本文"})
+	n.Create(appCtx, model.NewUserNotification{UserID: 1, Kind: "info", Title: "お知らせ", Body: "本文"})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /me/notifications", NewHandler(appCtx, GetMyNotificationsRequest))
+	mux.Handle("POST /me/notifications/{id}/read", NewHandler(appCtx, PostMyNotificationReadRequest))
+	mux.Handle("GET /session", NewHandler(appCtx, GetSessionRequest))
+
+	do := func(method string, path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Content-Type", "application/json")
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	// 作成日時は実行時刻なので比較から除く
+	withoutCreatedAt := func(body []byte) []byte {
+		var res map[string]any
+		json.Unmarshal(body, &res)
+		for _, notification := range res["notifications"].([]any) {
+			delete(notification.(map[string]any), "created_at")
+		}
+		body, _ = json.Marshal(res)
+		return body
+	}
+
+	userCookies := getLoginCookies(appCtx, "test_user", "password")
+	otherCookies := getLoginCookies(appCtx, "test_user_2", "password")
+
+	// --- 正常系: 新しい順に返す ---
+	w := do("GET", "/me/notifications", userCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	AssertRes(t, withoutCreatedAt(w.Body.Bytes()), `{
+		"notifications": [
+			{"id": 2, "kind": "info", "request_id": null, "title": "お知らせ", "body": "本文", "read_at": null},
+			{"id": 1, "kind": "request.created", "request_id": 1, "title": "新しいシフトリクエストが作成されました", "body": "本文", "read_at": null}
+		],
+		"unread_count": 2,
+		"next_cursor": null
+	}`)
+
+	// --- 異常系: 他のユーザーの通知は既読にできない ---
+	w = do("POST", "/me/notifications/1/read", otherCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	// --- 正常系: 既読にすると未読の件数が減る ---
+	w = do("POST", "/me/notifications/1/read", userCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+
+	w = do("GET", "/me/notifications?unread=true", userCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	AssertRes(t, withoutCreatedAt(w.Body.Bytes()), `{
+		"notifications": [
+			{"id": 2, "kind": "info", "request_id": null, "title": "お知らせ", "body": "本文", "read_at": null}
+		],
+		"unread_count": 1,
+		"next_cursor": null
+	}`)
+
+	w = do("GET", "/session", userCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	var session dto.SessionResponse
+	json.Unmarshal(w.Body.Bytes(), &session)
+	if session.UnreadNotificationCount != 1 {
+		t.Errorf("want 1 unread notification, got %d", session.UnreadNotificationCount)
+	}
+
+	// --- 異常系: 不正なクエリパラメータ ---
+	w = do("GET", "/me/notifications?unread=maybe", userCookies)
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+	w = do("GET", "/me/notifications?limit=0", userCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	w = do("GET", "/me/notifications?limit=1000", userCookies)
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+}
+
+func TestWebhookHandlers(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	mux := http.NewServeMux()
+	mux.Handle("GET /webhooks", NewHandler(appCtx, GetWebhooksRequest))
+	mux.Handle("POST /webhooks", NewHandler(appCtx, PostWebhooksRequest))
+	mux.Handle("DELETE /webhooks/{id}", NewHandler(appCtx, DeleteWebhookRequest))
+	mux.Handle("GET /webhooks/{id}/deliveries", NewHandler(appCtx, GetWebhookDeliveriesRequest))
+	mux.Handle("POST /requests", NewHandler(appCtx, PostRequestsRequest))
+
+	do := func(method string, path string, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	managerCookies := getLoginCookies(appCtx, "test_manager", "password")
+	employeeCookies := getLoginCookies(appCtx, "test_user", "password")
+
+	// --- 異常系: マネージャー以外は登録、閲覧できない ---
+	w := do("POST", "/webhooks", `{"url":"https://example.com/hook","events":["request.created"]}`, employeeCookies)
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+	w = do("GET", "/webhooks", "", employeeCookies)
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	// --- 異常系: 不正なイベント ---
+	w = do("POST", "/webhooks", `{"url":"https://example.com/hook","events":["user.created"]}`, managerCookies)
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+
+	// --- 正常系: 登録すると秘密鍵が返り、一覧には秘密鍵が含まれない ---
+	w = do("POST", "/webhooks", `{"url":"https://example.com/hook","events":["request.created"]}`, managerCookies)
+	AssertCode(t, w.Code, http.StatusCreated, w.Body.Bytes())
+	var created dto.CreateWebhookResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("json decode error: %v", err)
+	}
+	if created.ID != 1 || !strings.HasPrefix(created.Secret, "whsec_") {
+		t.Errorf("unexpected response: %s", w.Body.String())
+	}
+
+	w = do("GET", "/webhooks", "", managerCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	if strings.Contains(w.Body.String(), created.Secret) {
+		t.Errorf("secret should not be listed: %s", w.Body.String())
+	}
+	var list dto.WebhooksResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("json decode error: %v", err)
+	}
+	if len(list.Webhooks) != 1 || list.Webhooks[0].URL != "https://example.com/hook" {
+		t.Errorf("unexpected response: %s", w.Body.String())
+	}
+
+	// --- 正常系: シフトリクエストを作成すると送信待ちになる ---
+	w = do("POST", "/requests", `{"start_date":"2099-06-01","end_date":"2099-06-07","deadline":"2099-05-25 00:00:00"}`, managerCookies)
+	AssertCode(t, w.Code, http.StatusCreated, w.Body.Bytes())
+
+	w = do("GET", "/webhooks/1/deliveries", "", managerCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	var deliveries struct {
+		Deliveries []struct {
+			Event          string  `json:"event"`
+			Status         string  `json:"status"`
+			Attempts       int     `json:"attempts"`
+			LastStatusCode *int    `json:"last_status_code"`
+			NextAttemptAt  *string `json:"next_attempt_at"`
+			DeliveredAt    *string `json:"delivered_at"`
+		} `json:"deliveries"`
+		NextCursor *string `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &deliveries); err != nil {
+		t.Fatalf("json decode error: %v", err)
+	}
+	if len(deliveries.Deliveries) != 1 || deliveries.NextCursor != nil {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+	d := deliveries.Deliveries[0]
+	if d.Event != "request.created" || d.Status != "pending" || d.Attempts != 0 || d.LastStatusCode != nil || d.NextAttemptAt == nil || d.DeliveredAt != nil {
+		t.Errorf("unexpected delivery: %s", w.Body.String())
+	}
+
+	// --- 異常系: 不正なカーソル、存在しないWebhook ---
+	w = do("GET", "/webhooks/1/deliveries?cursor=abc", "", managerCookies)
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+	w = do("GET", "/webhooks/999/deliveries", "", managerCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	// --- 正常系: 削除 ---
+	w = do("DELETE", "/webhooks/1", "", managerCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	w = do("GET", "/webhooks", "", managerCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	AssertRes(t, w.Body.Bytes(), `{"webhooks": []}`)
+	w = do("DELETE", "/webhooks/1", "", managerCookies)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+}
+
+func TestRequestEventsHandler(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	appCtx := newTestContext(
+		[]db.Request{
+			{ID: 1, CreatorID: 2, StartDate: "2099-06-10", EndDate: "2099-06-16", Deadline: "2099-06-03 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: string(hashedPassword), Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "test_admin", Password: string(hashedPassword), Name: "テスト管理者", Role: auth.RoleAdmin, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	// ストリームにはリクエストのタイムアウトを設定しない
+	appCtx.SetRequestTimeout(10 * time.Millisecond)
+	mux := http.NewServeMux()
+	mux.Handle("GET /requests/{id}/events", NewStreamHandler(appCtx, GetRequestEventsRequest))
+	mux.Handle("POST /requests/{id}/submissions", NewHandler(appCtx, PostSubmissionsRequest))
+	// ストリームにはサーバーの書き込みのタイムアウトも適用しない
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.WriteTimeout = 20 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	managerCookies := getLoginCookies(appCtx, "test_manager", "password")
+	employeeCookies := getLoginCookies(appCtx, "test_user", "password")
+	adminCookies := getLoginCookies(appCtx, "test_admin", "password")
+
+	// --- 異常系: submission.view_all権限がない、存在しないシフトリクエスト ---
+	req := httptest.NewRequest("GET", "/requests/1/events", nil)
+	addCookiesToRequest(req, adminCookies)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	req = httptest.NewRequest("GET", "/requests/999/events", nil)
+	addCookiesToRequest(req, managerCookies)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	// --- 正常系: 接続中に提出されるとイベントが届く ---
+	streamCtx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	defer cancel()
+	streamReq, _ := http.NewRequestWithContext(streamCtx, "GET", server.URL+"/requests/1/events", nil)
+	addCookiesToRequest(streamReq, managerCookies)
+	res, err := http.DefaultClient.Do(streamReq)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer res.Body.Close()
+	AssertCode(t, res.StatusCode, http.StatusOK, nil)
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("unexpected Content-Type: %s", got)
+	}
+	reader := bufio.NewReader(res.Body)
+	// 1つのイベント(空行まで)を読む
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	if got := readEvent(); got != ": connected\n" {
+		t.Fatalf("unexpected first event: %q", got)
+	}
+
+	// タイムアウトより長く接続していても切れない
+	time.Sleep(50 * time.Millisecond)
+
+	body := `[{"date":"2099-06-10","hour":9},{"date":"2099-06-10","hour":10}]`
+	req = httptest.NewRequest("POST", "/requests/1/submissions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	addCookiesToRequest(req, employeeCookies)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	AssertCode(t, w.Code, http.StatusCreated, w.Body.Bytes())
+
+	if got, want := readEvent(), "event: submission.created\n"+
+		`data: {"request_id":1,"submission":{"entries":[{"date":"2099-06-10","hour":9},{"date":"2099-06-10","hour":10}],"id":1,"submitter":{"id":1,"name":"テストユーザー"}}}`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := readEvent(), "event: coverage.delta\n"+
+		`data: {"changes":[{"date":"2099-06-10","delta":1,"hour":9},{"date":"2099-06-10","delta":1,"hour":10}],"request_id":1}`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// --- 正常系: クライアントが切断すると購読を解除する ---
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for appCtx.GetEventHub().SubscriberCount("request:1") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("subscription was not closed after client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// --- 正常系: サーバーの終了時にHubを閉じると、接続を閉じる ---
+	streamReq, _ = http.NewRequest("GET", server.URL+"/requests/1/events", nil)
+	addCookiesToRequest(streamReq, managerCookies)
+	res, err = http.DefaultClient.Do(streamReq)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer res.Body.Close()
+	reader = bufio.NewReader(res.Body)
+	if got := readEvent(); got != ": connected\n" {
+		t.Fatalf("unexpected first event: %q", got)
+	}
+	appCtx.GetEventHub().Close()
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Errorf("stream should be closed without error, got %v", err)
+	}
+}
+
+// Ping, GetSchemaVersionの結果を差し替えるDB
+type healthCheckDB struct {
+	db.DB
+	pingErr       error
+	schemaVersion int
+}
+
+func (d *healthCheckDB) Ping(ctx stdcontext.Context) error {
+	return d.pingErr
+}
+
+func (d *healthCheckDB) GetSchemaVersion(ctx stdcontext.Context) (int, error) {
+	return d.schemaVersion, nil
+}
+
+func TestHealthHandlers(t *testing.T) {
+	database := &healthCheckDB{DB: db.NewMockDB(nil, nil, nil, nil), schemaVersion: db.SchemaVersion}
+	appCtx := context.NewAppContext(database, sessions.NewCookieStore([]byte("test-secret")))
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", NewHandler(appCtx, GetHealthzRequest))
+	mux.Handle("GET /readyz", NewHandler(appCtx, GetReadyzRequest))
+
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	// --- 正常系 ---
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w := do(path)
+		AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+		AssertRes(t, w.Body.Bytes(), `{"status":"ok"}`)
+	}
+
+	// --- 異常系: スキーマが古い ---
+	database.schemaVersion = db.SchemaVersion - 1
+	w := do("/readyz")
+	AssertCode(t, w.Code, http.StatusServiceUnavailable, w.Body.Bytes())
+	AssertRes(t, w.Body.Bytes(), `{"error":"DBのスキーマが最新ではありません"}`)
+
+	// --- 異常系: DBに接続できなくても、livenessは成功する ---
+	database.schemaVersion = db.SchemaVersion
+	database.pingErr = errors.New("unable to open database file")
+	w = do("/readyz")
+	AssertCode(t, w.Code, http.StatusServiceUnavailable, w.Body.Bytes())
+	AssertRes(t, w.Body.Bytes(), `{"error":"DBに接続できません"}`)
+	w = do("/healthz")
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
 }
@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"backend/auth"
+	"backend/db"
+	"backend/handler/dto"
+	stdcontext "context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// テスト用のIDプロバイダー
+// /authorize は利用者の操作を省略して、すぐに認可コードを発行する
+type fakeIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// ログインする利用者
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// 発行するIDトークンのクレームを書き換える
+	ModifyClaims func(claims map[string]any)
+
+	mu    sync.Mutex
+	codes map[string]url.Values // 認可コードごとの認可リクエスト
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key, codes: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := "code-" + query.Get("state")
+		idp.mu.Lock()
+		idp.codes[code] = query
+		idp.mu.Unlock()
+
+		redirect, _ := url.Parse(query.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mu.Lock()
+		authReq, ok := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		idp.mu.Unlock()
+
+		// 認可コードは一度だけ使え、PKCEのcode_verifierが一致しなければいけない
+		challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		clientID, secret, _ := r.BasicAuth()
+		if !ok || authReq.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) ||
+			clientID != "test-client" || secret != "test-secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := map[string]any{
+			"iss":            idp.server.URL,
+			"sub":            idp.Subject,
+			"aud":            "test-client",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          authReq.Get("nonce"),
+			"email":          idp.Email,
+			"email_verified": idp.EmailVerified,
+		}
+		if idp.ModifyClaims != nil {
+			idp.ModifyClaims(claims)
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     idp.sign(t, claims),
+		})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// RS256で署名したJWTを作成する
+func (idp *fakeIdP) sign(t *testing.T, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCLoginHandlers(t *testing.T) {
+	appCtx := newTestContext(
+		[]db.Request{},
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: "x", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00", Email: "user@example.com"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	mux := http.NewServeMux()
+	mux.Handle("GET /auth/oidc/start", NewHandler(appCtx, GetOIDCStartRequest))
+	mux.Handle("GET /auth/oidc/callback", NewHandler(appCtx, GetOIDCCallbackRequest))
+	mux.Handle("GET /session", NewHandler(appCtx, GetSessionRequest))
+	mux.Handle("GET /login/pending", NewHandler(appCtx, GetLoginPendingRequest))
+	mux.Handle("POST /login/totp", NewHandler(appCtx, PostLoginTOTPRequest))
+
+	get := func(target string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		addCookiesToRequest(req, cookies)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	// --- 異常系: 設定されていない ---
+	w := get("/auth/oidc/start", nil)
+	AssertCode(t, w.Code, http.StatusNotFound, w.Body.Bytes())
+
+	idp := newFakeIdP(t)
+	provider, err := auth.NewOIDCProvider(stdcontext.Background(), auth.OIDCConfig{
+		Issuer:          idp.server.URL,
+		ClientID:        "test-client",
+		ClientSecret:    "test-secret",
+		RedirectURL:     "http://localhost/auth/oidc/callback",
+		PostLoginURL:    "http://frontend/requests",
+		SecondFactorURL: "http://frontend/login",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auth.SetOIDCProvider(provider)
+	t.Cleanup(func() { auth.SetOIDCProvider(nil) })
+
+	// IDプロバイダーでのログインを行い、コールバックのURLを返す
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	authorize := func(location string) string {
+		res, err := noRedirect.Get(location)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res.Body.Close()
+		callback, _ := url.Parse(res.Header.Get("Location"))
+		return callback.Path + "?" + callback.RawQuery
+	}
+	// ログインを開始してからコールバックまでを行う
+	login := func() *httptest.ResponseRecorder {
+		start := get("/auth/oidc/start", nil)
+		AssertCode(t, start.Code, http.StatusFound, start.Body.Bytes())
+		location := start.Header().Get("Location")
+		if !strings.HasPrefix(location, idp.server.URL+"/authorize?") || !strings.Contains(location, "code_challenge_method=S256") {
+			t.Fatalf("unexpected location: %s", location)
+		}
+		return get(authorize(location), start.Result().Cookies())
+	}
+	loginCookies := func(w *httptest.ResponseRecorder) []*http.Cookie {
+		var cookies []*http.Cookie
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "login_session" {
+				cookies = append(cookies, c)
+			}
+		}
+		return cookies
+	}
+
+	// --- 正常系: 確認済みのメールアドレスでユーザーに紐づける ---
+	idp.Subject, idp.Email, idp.EmailVerified = "subject-1", "user@example.com", true
+	w = login()
+	AssertCode(t, w.Code, http.StatusFound, w.Body.Bytes())
+	if w.Header().Get("Location") != "http://frontend/requests" {
+		t.Errorf("unexpected location: %s", w.Header().Get("Location"))
+	}
+	if s := get("/session", loginCookies(w)); s.Code != http.StatusOK || !strings.Contains(s.Body.String(), `"id":1`) {
+		t.Errorf("should be logged in as user 1, got %d %s", s.Code, s.Body.String())
+	}
+
+	// --- 正常系: 一度紐づけた後は、メールアドレスが変わってもsubjectでログインできる ---
+	idp.Email = "changed@example.com"
+	w = login()
+	AssertCode(t, w.Code, http.StatusFound, w.Body.Bytes())
+
+	// --- 異常系: 対応するユーザーがいない ---
+	idp.Subject, idp.Email = "subject-2", "unknown@example.com"
+	w = login()
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	// --- 異常系: 未確認のメールアドレスでは紐づけない ---
+	idp.Email, idp.EmailVerified = "user@example.com", false
+	w = login()
+	AssertCode(t, w.Code, http.StatusForbidden, w.Body.Bytes())
+
+	// --- 異常系: nonceが一致しない ---
+	idp.Subject = "subject-1"
+	idp.ModifyClaims = func(claims map[string]any) { claims["nonce"] = "other" }
+	w = login()
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+
+	// --- 異常系: 別のクライアント向けのIDトークン ---
+	idp.ModifyClaims = func(claims map[string]any) { claims["aud"] = []string{"other-client"} }
+	w = login()
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+
+	// --- 異常系: 期限切れのIDトークン ---
+	idp.ModifyClaims = func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }
+	w = login()
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+	idp.ModifyClaims = nil
+
+	// --- 異常系: stateが一致しない ---
+	start := get("/auth/oidc/start", nil)
+	callback := authorize(start.Header().Get("Location"))
+	callback = strings.Replace(callback, "state=", "state=x", 1)
+	w = get(callback, start.Result().Cookies())
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+
+	// --- 異常系: 同じ認可コードは二度使えない ---
+	start = get("/auth/oidc/start", nil)
+	callback = authorize(start.Header().Get("Location"))
+	w = get(callback, start.Result().Cookies())
+	AssertCode(t, w.Code, http.StatusFound, w.Body.Bytes())
+	w = get(callback, start.Result().Cookies())
+	AssertCode(t, w.Code, http.StatusBadRequest, w.Body.Bytes())
+
+	// --- 二要素認証を登録したユーザーは、コードを入力するまでログイン済みにならない ---
+	secret, _, err := auth.BeginTOTPEnrollment(appCtx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recoveryCodes, err := auth.ConfirmTOTPEnrollment(appCtx, 1, totpCodeForTest(t, secret))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w = login()
+	AssertCode(t, w.Code, http.StatusFound, w.Body.Bytes())
+	if w.Header().Get("Location") != "http://frontend/login?mfa=pending" {
+		t.Errorf("unexpected location: %s", w.Header().Get("Location"))
+	}
+	pendingCookies := loginCookies(w)
+	if s := get("/session", pendingCookies); s.Code != http.StatusUnauthorized {
+		t.Errorf("pending session should not be logged in, got %d", s.Code)
+	}
+
+	w = get("/login/pending", pendingCookies)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	var pendingRes dto.LoginResponse
+	json.Unmarshal(w.Body.Bytes(), &pendingRes)
+	if !pendingRes.MFARequired || pendingRes.MFAEnrollmentRequired || pendingRes.CSRFToken == "" {
+		t.Errorf("unexpected pending response: %s", w.Body.String())
+	}
+
+	// 登録で使ったワンタイムパスワードは再利用できないので、リカバリーコードを使う
+	req := httptest.NewRequest("POST", "/login/totp", strings.NewReader(`{"code":"`+recoveryCodes[0]+`"}`))
+	addCookiesToRequest(req, pendingCookies)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	AssertCode(t, w.Code, http.StatusOK, w.Body.Bytes())
+	if s := get("/session", loginCookies(w)); s.Code != http.StatusOK {
+		t.Errorf("session should be logged in after second factor, got %d", s.Code)
+	}
+
+	// --- 異常系: 認証待ちでなければ状態を返さない ---
+	w = get("/login/pending", nil)
+	AssertCode(t, w.Code, http.StatusUnauthorized, w.Body.Bytes())
+}
@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"backend/context"
+	stdcontext "context"
+	"io"
+	"log/slog"
+)
+
+// JSONでログを出力するslog.Handlerを作成する
+// HTTPリクエストの処理中のログには、contextからrequest_idを付ける
+func NewHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return &requestIDHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})}
+}
+
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h *requestIDHandler) Handle(ctx stdcontext.Context, record slog.Record) error {
+	if info := context.RequestInfoFrom(ctx); info != nil && info.RequestID != "" {
+		record.AddAttrs(slog.String("request_id", info.RequestID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *requestIDHandler) WithGroup(name string) slog.Handler {
+	return &requestIDHandler{h.Handler.WithGroup(name)}
+}
@@ -1,81 +1,286 @@
 package main
 
 import (
+	"backend/auth"
+	"backend/config"
 	"backend/context"
 	"backend/db"
+	"backend/handler"
+	"backend/logging"
+	"backend/metrics"
+	"backend/middleware"
+	"backend/model"
+	"backend/notify"
 	"backend/router"
 	"backend/test"
+	"backend/webhook"
+	stdcontext "context"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/sessions"
-	"github.com/joho/godotenv"
 	"github.com/rs/cors"
 )
 
 func main() {
-	// .envファイルの読み込み
-	err := godotenv.Load()
-	if err != nil {
-		log.Println(".envファイルが見つかりませんでした")
-	}
+	configPath := flag.String("config", "", "設定ファイルのパス(省略時は環境変数CONFIG_FILE)")
+	printConfig := flag.Bool("print-config", false, "読み込んだ設定を秘密の値を伏せて出力し、終了する")
+	flag.Parse()
 
-	// 環境変数から設定値を取得
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		log.Fatal("DB_PATHが設定されていません")
-	}
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORTが設定されていません")
+	// 環境変数、.env、設定ファイルから設定を読み込む
+	cfg, err := config.Load(*configPath)
+	if *printConfig {
+		cfg.Print(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "設定に問題があります:\n"+err.Error())
+			os.Exit(1)
+		}
+		return
 	}
-	frontEndURL := os.Getenv("FRONTEND_URL")
-	if frontEndURL == "" {
-		log.Fatal("FRONTEND_URLが設定されていません")
+	if err != nil {
+		log.Fatal("設定に問題があります:\n" + err.Error())
 	}
-	sessionKey := os.Getenv("SESSION_KEY")
-	if sessionKey == "" {
-		log.Fatal("SESSION_KEYが設定されていません")
+
+	// ログはJSONで標準エラー出力に出す. logパッケージの出力もslogを通す
+	slog.SetDefault(slog.New(logging.NewHandler(os.Stderr, cfg.LogLevel)))
+
+	auth.SetSessionLifetime(cfg.SessionLifetime)
+	handler.SetTrustedProxies(cfg.TrustedProxyPrefixes())
+
+	// パスワードの条件
+	passwordPolicy := auth.DefaultPasswordPolicy
+	passwordPolicy.MinLength = cfg.PasswordMinLength
+	passwordPolicy.ResetTokenTTL = cfg.PasswordResetTTL
+	auth.SetPasswordPolicy(passwordPolicy)
+
+	// 二要素認証の設定
+	twoFactorPolicy := auth.DefaultTwoFactorPolicy
+	twoFactorPolicy.RequireForManager = cfg.RequireManager2FA
+	twoFactorPolicy.Issuer = cfg.TOTPIssuer
+	auth.SetTwoFactorPolicy(twoFactorPolicy)
+
+	// ロールの権限(省略時はauth.DefaultRoles). 形式はconfig.Loadで検証済み
+	if cfg.RolePermissions != "" {
+		roles, _ := auth.ParseRolePermissions(auth.DefaultRoles, cfg.RolePermissions)
+		auth.SetRoles(roles)
 	}
+	model.SetAllowPrivateWebhookAddresses(cfg.WebhookAllowPrivateAddresses)
 
-	mode := os.Getenv("MODE")
 	var database db.DB
+	// 終了時にDBを閉じる. log.Fatalではdeferが実行されないので、明示的に呼び出す
+	closeDB := func() error { return nil }
 
-	if mode == "test" {
-		log.Println("テストモードで起動します(mock DB使用)")
+	if cfg.Mode == config.ModeTest {
+		slog.Info("テストモードで起動します(mock DB使用)")
 		database = db.NewMockDB(test.MockRequests, test.MockUsers, test.MockEntries, test.MockSubmissions)
 	} else {
-		log.Println("本番モードで起動します(SQLite3使用)")
+		slog.Info("本番モードで起動します(SQLite3使用)")
 		// DBの初期化
-		sqliteDB, err := db.NewSqlite3DB(dbPath)
+		sqliteDB, err := db.NewSqlite3DB(cfg.DBPath)
 		if err != nil {
 			log.Fatal("DBの接続に失敗しました: " + err.Error())
 		}
-		log.Println("DBの接続に成功しました")
 		database = sqliteDB
-		defer sqliteDB.Close()
+		closeDB = sqliteDB.Close
 	}
 
+	// sql.Openは接続しないので、最初のクエリを待たずにDB_PATHの誤りやshiftctl migrateの実行忘れに気付けるようにする
+	startupCtx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 5*time.Second)
+	err = db.CheckReady(startupCtx, database)
+	cancel()
+	if err != nil {
+		closeDB()
+		log.Fatal("DBを使用できません: " + err.Error())
+	}
+	slog.Info("DBの接続に成功しました", slog.Int("schema_version", db.SchemaVersion))
+
+	// DBのメソッドごとの処理時間を/metricsに出す
+	database = db.NewInstrumentedDB(database, func(method string, duration time.Duration) {
+		metrics.DBQueryDuration.Observe(duration.Seconds(), method)
+	})
+	metrics.RegisterGaugeFunc("sessions_active", "有効期限内のログインセッション数(サーバーの起動後に使われたもののみ)", func() float64 {
+		return float64(auth.ActiveSessionCount())
+	})
+
 	// セッションの初期化
-	cookie := sessions.NewCookieStore([]byte(sessionKey))
+	// SameSiteはデフォルトでLax. Noneにする場合はSecureが必要
+	cookie := sessions.NewCookieStore([]byte(cfg.SessionKey))
+	cookie.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   86400 * 30,
+		HttpOnly: true,
+		Secure:   cfg.CookieSecure,
+		SameSite: cfg.SameSite(),
+	}
+
+	// OIDCでのログインの設定(OIDC_ISSUERを設定した場合のみ有効)
+	if cfg.OIDCIssuer != "" {
+		provider, err := auth.NewOIDCProvider(stdcontext.Background(), auth.OIDCConfig{
+			Issuer:          cfg.OIDCIssuer,
+			ClientID:        cfg.OIDCClientID,
+			ClientSecret:    cfg.OIDCClientSecret,
+			RedirectURL:     cfg.OIDCRedirectURL,
+			PostLoginURL:    cfg.FrontendURL + "/requests",
+			SecondFactorURL: cfg.FrontendURL + "/login",
+		})
+		if err != nil {
+			closeDB()
+			log.Fatal("OIDCの設定に失敗しました: " + err.Error())
+		}
+		auth.SetOIDCProvider(provider)
+		slog.Info("OIDCでのログインを有効にします", slog.String("issuer", cfg.OIDCIssuer))
+	}
 
 	// アプリケーション全体で使うデータを管理するコンテキストを作成
 	appCtx := context.NewAppContext(database, cookie)
+	appCtx.SetRequestTimeout(cfg.RequestTimeout)
+	slog.Info("リクエストのタイムアウトを設定します", slog.String("timeout", cfg.RequestTimeout.String()))
+
+	// SIGINT, SIGTERMを受け取ったらキャンセルされる
+	// バックグラウンドの処理は実行中のものを終えてから止まり、サーバーは処理中のリクエストを待ってから終了する
+	ctx, stop := signal.NotifyContext(stdcontext.Background(), os.Interrupt, syscall.SIGTERM)
+	var jobs sync.WaitGroup
+
+	// 通知の設定
+	// アプリ内の通知は常に作成する. NOTIFIERSを設定した場合は、その方法でも送る
+	notifiers := append([]notify.Notifier{notify.NewInboxNotifier(appCtx)}, newNotifiers(cfg)...)
+	if len(cfg.Notifiers) > 0 {
+		slog.Info("通知の送信方法を設定します", slog.Any("notifiers", cfg.Notifiers))
+	}
+	scheduler := notify.NewScheduler(appCtx, notify.Multi(notifiers...))
+	scheduler.Interval = cfg.NotifyInterval
+	scheduler.ReminderWindow = cfg.NotifyReminderBefore
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		scheduler.Run(ctx)
+	}()
+
+	// 登録されたWebhookにイベントを送る
+	dispatcher := webhook.NewDispatcher(appCtx)
+	dispatcher.Interval = cfg.WebhookInterval
+	dispatcher.MaxAttempts = cfg.WebhookMaxAttempts
+	dispatcher.AllowPrivateAddresses = cfg.WebhookAllowPrivateAddresses
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		dispatcher.Run(ctx)
+	}()
 
 	// ルーティングの設定
 	mux := http.NewServeMux()
 	router.Routes(mux, appCtx)
+	// METRICS_TOKENを設定した場合は、Authorization: Bearer <METRICS_TOKEN>がないと取得できない
+	// MODE=productionでMETRICS_TOKENがない場合は、/metricsを公開しない
+	if cfg.MetricsToken != "" || cfg.Mode != config.ModeProduction {
+		mux.Handle("GET /metrics", metrics.Handler(cfg.MetricsToken))
+	} else {
+		slog.Warn("METRICS_TOKENが設定されていないため、/metricsを無効にします")
+	}
 
 	// CORSの設定
 	corsHandler := cors.New(cors.Options{
-		AllowedOrigins:   []string{frontEndURL},
-		AllowedMethods:   []string{"GET", "POST", "DELETE"},
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", auth.CSRFTokenHeader, middleware.RequestIDHeader},
+		ExposedHeaders:   []string{middleware.RequestIDHeader},
 		AllowCredentials: true,
 	}).Handler(mux)
-	log.Println("CORSを設定します: Allow Origin " + frontEndURL)
+	slog.Info("CORSを設定します", slog.Any("allowed_origins", cfg.AllowedOrigins))
+
+	// CORSのプリフライトも含めて、全てのリクエストにリクエストIDを付けてログに出し、メトリクスを記録する
+	rootHandler := middleware.RequestLogging(slog.Default(), middleware.RecordMetrics(corsHandler))
 
 	// サーバーの起動
-	log.Println("サーバーを起動します: http://localhost:" + port)
-	log.Fatal(http.ListenAndServe(":"+port, corsHandler))
+	server := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      rootHandler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		ErrorLog:     slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+	}
+	// SSEのストリームはShutdownでは終わらないので、購読を解除して接続を閉じさせる
+	server.RegisterOnShutdown(appCtx.GetEventHub().Close)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	slog.Info("サーバーを起動します",
+		slog.String("addr", "http://localhost:"+cfg.Port),
+		slog.String("read_timeout", cfg.ReadTimeout.String()),
+		slog.String("write_timeout", cfg.WriteTimeout.String()),
+		slog.String("idle_timeout", cfg.IdleTimeout.String()),
+	)
+
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		slog.Error("サーバーの起動に失敗しました", slog.String("error", err.Error()))
+		exitCode = 1
+	case <-ctx.Done():
+		slog.Info("終了のシグナルを受け取りました. 処理中のリクエストを待って終了します", slog.String("timeout", cfg.ShutdownTimeout.String()))
+	}
+	// バックグラウンドの処理を止める. 2回目のシグナルでは待たずに終了する
+	stop()
+
+	shutdownCtx, cancel := stdcontext.WithTimeout(stdcontext.Background(), cfg.ShutdownTimeout)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("処理中のリクエストを待てませんでした", slog.String("error", err.Error()))
+		exitCode = 1
+	}
+
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		slog.Error("バックグラウンドの処理を待てませんでした")
+		exitCode = 1
+	}
+
+	if err := closeDB(); err != nil {
+		slog.Error("DBを閉じられませんでした", slog.String("error", err.Error()))
+		exitCode = 1
+	}
+	cancel()
+	slog.Info("サーバーを終了しました")
+	os.Exit(exitCode)
+}
+
+// NOTIFIERSに指定した送信方法のNotifierを作成する
+// 送信方法ごとの設定はconfig.Loadで検証済み
+func newNotifiers(cfg config.Config) []notify.Notifier {
+	var notifiers []notify.Notifier
+	for _, name := range cfg.Notifiers {
+		switch name {
+		case "smtp":
+			notifiers = append(notifiers, &notify.SMTPNotifier{
+				Addr:     cfg.SMTPAddr,
+				From:     cfg.SMTPFrom,
+				Username: cfg.SMTPUsername,
+				Password: cfg.SMTPPassword,
+			})
+		case "webhook":
+			notifiers = append(notifiers, &notify.WebhookNotifier{
+				URL:    cfg.NotifyWebhookURL,
+				Client: &http.Client{Timeout: 10 * time.Second},
+			})
+		case "log":
+			notifiers = append(notifiers, notify.LogNotifier{})
+		}
+	}
+	return notifiers
 }
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// アプリケーションのメトリクス
+// /metricsで出力する
+var Default = NewRegistry()
+
+var (
+	HTTPRequests = newCounterVec(Default, "http_requests_total",
+		"ルートごとのリクエスト数", "method", "route", "status")
+	HTTPRequestDuration = newHistogramVec(Default, "http_request_duration_seconds",
+		"ルートごとのリクエストの処理時間(秒)", DefaultBuckets, "method", "route")
+	AppErrors = newCounterVec(Default, "app_errors_total",
+		"ハンドラーが返したエラーの数", "status")
+	DBQueryDuration = newHistogramVec(Default, "db_query_duration_seconds",
+		"DBのメソッドごとの処理時間(秒)", DefaultBuckets, "method")
+	Logins = newCounterVec(Default, "auth_logins_total",
+		"ログインの試行数. resultはsuccess, failure, throttledのいずれか", "result")
+)
+
+// 出力するときに値を取得するゲージをDefaultに登録する
+func RegisterGaugeFunc(name string, help string, fn func() float64) {
+	newGaugeFunc(Default, name, help, fn)
+}
+
+// Defaultのメトリクスを返すHandler
+// tokenが空でない場合は、Authorization: Bearer <token>が一致するリクエストだけに返す
+func Handler(token string) http.Handler {
+	next := Default.Handler()
+	if token == "" {
+		return next
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	requests := newCounterVec(r, "test_requests_total", "リクエスト数", "route", "status")
+	duration := newHistogramVec(r, "test_duration_seconds", "処理時間", []float64{0.1, 1}, "route")
+	newGaugeFunc(r, "test_active", "有効な数", func() float64 { return 3 })
+
+	requests.Inc("/a", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc(`/b"\`+"\n", "500")
+	duration.Observe(0.05, "/a")
+	duration.Observe(0.5, "/a")
+	duration.Observe(5, "/a")
+
+	if got := requests.Value("/a", "200"); got != 3 {
+		t.Errorf("want 3, got %v", got)
+	}
+	if got := requests.Value("/c", "200"); got != 0 {
+		t.Errorf("want 0, got %v", got)
+	}
+	if got := duration.Count("/a"); got != 3 {
+		t.Errorf("want 3, got %v", got)
+	}
+
+	var sb strings.Builder
+	r.Write(&sb)
+	// 名前の順に出力し、ヒストグラムのバケットは累積で出す
+	want := `# HELP test_active 有効な数
+# TYPE test_active gauge
+test_active 3
+# HELP test_duration_seconds 処理時間
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/a",le="0.1"} 1
+test_duration_seconds_bucket{route="/a",le="1"} 2
+test_duration_seconds_bucket{route="/a",le="+Inf"} 3
+test_duration_seconds_sum{route="/a"} 5.55
+test_duration_seconds_count{route="/a"} 3
+# HELP test_requests_total リクエスト数
+# TYPE test_requests_total counter
+test_requests_total{route="/a",status="200"} 3
+test_requests_total{route="/b\"\\\n",status="500"} 1
+`
+	if got := sb.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// 同じ名前のメトリクスは登録できない
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for duplicate metric name")
+		}
+	}()
+	newGaugeFunc(r, "test_active", "重複", func() float64 { return 0 })
+}
+
+func TestHandler(t *testing.T) {
+	// トークンを設定しない場合は誰でも取得できる
+	w := httptest.NewRecorder()
+	Handler("").ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "# TYPE http_requests_total counter") {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type: %s", got)
+	}
+
+	// トークンを設定した場合は一致するものだけ
+	handler := Handler("secret")
+	for _, test := range []struct {
+		authorization string
+		want          int
+	}{
+		{"", 401},
+		{"Bearer wrong", 401},
+		{"secret", 401},
+		{"Bearer secret", 200},
+	} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != test.want {
+			t.Errorf("Authorization %q: want %d, got %d", test.authorization, test.want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"backend/context"
+	"backend/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ルートに一致しなかったリクエストのrouteラベル
+const unmatchedRoute = "unmatched"
+
+// 標準以外のメソッドのmethodラベル
+// r.Methodはクライアントが自由に決められるため、そのままラベルにすると系列が増え続ける
+const otherMethod = "OTHER"
+
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// ルートごとのリクエスト数と処理時間を記録する
+// ルートのパターンはhandler.HandlerがcontextのRequestInfoに設定する
+func RecordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		info := context.RequestInfoFrom(r.Context())
+		if info == nil {
+			info = &context.RequestInfo{}
+			r = r.WithContext(context.WithRequestInfo(r.Context(), info))
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// パターンは "GET /api/requests/{id}" の形式
+		// handler.Handlerを通らないルート(/metricsなど)は、ServeMuxが設定したr.Patternを使う
+		pattern := info.Route
+		if pattern == "" {
+			pattern = r.Pattern
+		}
+		method, route := r.Method, unmatchedRoute
+		if pattern != "" {
+			if patternMethod, path, ok := strings.Cut(pattern, " "); ok {
+				method, route = patternMethod, path
+			} else {
+				route = pattern
+			}
+		}
+		if !standardMethods[method] {
+			method = otherMethod
+		}
+		metrics.HTTPRequests.Inc(method, route, strconv.Itoa(status))
+		metrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), method, route)
+	})
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"backend/auth"
 	"backend/context"
 	"backend/handler"
 	"net/http"
@@ -18,3 +19,30 @@ func ValidateContentType(next handler.HandlerFuncWithContext) handler.HandlerFun
 	}
 	return fn
 }
+
+// 状態を変更するリクエストでCSRFトークンを検証する
+// トークンはGET /sessionで発行され、X-CSRF-Tokenヘッダーで送られる
+func ValidateCSRFToken(next handler.HandlerFuncWithContext) handler.HandlerFuncWithContext {
+	fn := func(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *handler.AppError {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return next(ctx, w, r)
+		}
+
+		if err := auth.ValidateCSRFToken(ctx, r); err != nil {
+			return handler.NewAppError(err, "CSRFトークンが不正です", http.StatusForbidden)
+		}
+		return next(ctx, w, r)
+	}
+	return fn
+}
+
+// APIトークンで認証する場合に必要なスコープを設定する
+// 設定されていないルートでは、APIトークンを使えない
+func RequireScope(scope string, next handler.HandlerFuncWithContext) handler.HandlerFuncWithContext {
+	fn := func(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *handler.AppError {
+		r = r.WithContext(auth.WithRequiredScope(r.Context(), scope))
+		return next(ctx, w, r)
+	}
+	return fn
+}
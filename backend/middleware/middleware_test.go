@@ -0,0 +1,271 @@
+package middleware
+
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/db"
+	"backend/handler"
+	"backend/logging"
+	"backend/metrics"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidateCSRFToken(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	appCtx := context.NewAppContext(
+		db.NewMockDB(nil, []db.User{{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"}}, nil, nil),
+		sessions.NewCookieStore([]byte("test-secret")),
+	)
+
+	ok := func(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *handler.AppError {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", handler.NewHandler(appCtx, ValidateCSRFToken(ok)))
+	mux.Handle("GET /session", handler.NewHandler(appCtx, handler.GetSessionRequest))
+
+	// ログインしてCookieを取得
+	loginW := httptest.NewRecorder()
+	if _, err := auth.Login(appCtx, loginW, httptest.NewRequest("POST", "/login", nil), "test_user", "password"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	cookies := loginW.Result().Cookies()
+
+	// GET /sessionでCSRFトークンを取得
+	sessionReq := httptest.NewRequest("GET", "/session", nil)
+	for _, c := range cookies {
+		sessionReq.AddCookie(c)
+	}
+	sessionW := httptest.NewRecorder()
+	mux.ServeHTTP(sessionW, sessionReq)
+	var session struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+	json.Unmarshal(sessionW.Body.Bytes(), &session)
+	if session.CSRFToken == "" {
+		t.Fatalf("csrf_token not found: %s", sessionW.Body.String())
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		withCookie bool
+		token      string
+		want       int
+	}{
+		{"GETは検証しない", "GET", true, "", http.StatusOK},
+		{"正しいトークン", "POST", true, session.CSRFToken, http.StatusOK},
+		{"トークンなし", "POST", true, "", http.StatusForbidden},
+		{"間違ったトークン", "DELETE", true, "wrong", http.StatusForbidden},
+		{"PUTも検証する", "PUT", true, "", http.StatusForbidden},
+		{"PATCHも検証する", "PATCH", true, "", http.StatusForbidden},
+		{"Cookieなしは検証しない", "POST", false, "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/", nil)
+			if test.withCookie {
+				for _, c := range cookies {
+					req.AddCookie(c)
+				}
+			}
+			if test.token != "" {
+				req.Header.Set(auth.CSRFTokenHeader, test.token)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != test.want {
+				t.Errorf("want status code %d, got %d: %s", test.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	appCtx := context.NewAppContext(
+		db.NewMockDB(nil, []db.User{{ID: 1, LoginID: "test_user", Password: string(hashedPassword), Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"}}, nil, nil),
+		sessions.NewCookieStore([]byte("test-secret")),
+	)
+
+	fail := func(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *handler.AppError {
+		auth.GetUserID(ctx, r)
+		slog.InfoContext(r.Context(), "処理中")
+		return handler.NewAppError(errors.New("something broke"), "失敗しました", http.StatusInternalServerError)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /fail/{id}", handler.NewHandler(appCtx, fail))
+
+	var buf bytes.Buffer
+	logger := slog.New(logging.NewHandler(&buf, slog.LevelInfo))
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+	server := RequestLogging(logger, mux)
+
+	loginW := httptest.NewRecorder()
+	if _, err := auth.Login(appCtx, loginW, httptest.NewRequest("POST", "/login", nil), "test_user", "password"); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	cookies := loginW.Result().Cookies()
+
+	readLogs := func() []map[string]any {
+		var logs []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("log is not JSON: %q", line)
+			}
+			logs = append(logs, entry)
+		}
+		buf.Reset()
+		return logs
+	}
+
+	// --- リクエストIDがない場合は発行し、ハンドラー内のログとアクセスログに付ける ---
+	req := httptest.NewRequest("GET", "/fail/1", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	requestID := w.Header().Get(RequestIDHeader)
+	if len(requestID) != 32 {
+		t.Fatalf("unexpected request id: %q", requestID)
+	}
+	logs := readLogs()
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 logs, got %+v", logs)
+	}
+	if logs[0]["msg"] != "処理中" || logs[0]["request_id"] != requestID {
+		t.Errorf("unexpected log: %+v", logs[0])
+	}
+	access := logs[1]
+	for key, want := range map[string]any{
+		"level":      "ERROR",
+		"msg":        "request",
+		"method":     "GET",
+		"path":       "/fail/1",
+		"route":      "GET /fail/{id}",
+		"status":     float64(500),
+		"user_id":    float64(1),
+		"error":      "something broke",
+		"request_id": requestID,
+	} {
+		if access[key] != want {
+			t.Errorf("%s: got %v, want %v", key, access[key], want)
+		}
+	}
+	if source, _ := access["error_source"].(string); !strings.Contains(source, "middleware_test.go:") {
+		t.Errorf("unexpected error_source: %v", access["error_source"])
+	}
+	if _, ok := access["latency_ms"].(float64); !ok {
+		t.Errorf("latency_ms is missing: %+v", access)
+	}
+
+	// --- 受け取ったリクエストIDはそのまま使う ---
+	req = httptest.NewRequest("GET", "/fail/1", nil)
+	req.Header.Set(RequestIDHeader, "upstream-123")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if got := w.Header().Get(RequestIDHeader); got != "upstream-123" {
+		t.Errorf("got %q, want upstream-123", got)
+	}
+	access = readLogs()[1]
+	if access["request_id"] != "upstream-123" {
+		t.Errorf("unexpected request_id: %v", access["request_id"])
+	}
+	// ログインしていない場合はuser_idを出さない
+	if _, ok := access["user_id"]; ok {
+		t.Errorf("user_id should be omitted: %+v", access)
+	}
+
+	// --- 不正なリクエストIDは使わない ---
+	req = httptest.NewRequest("GET", "/fail/1", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if got := w.Header().Get(RequestIDHeader); len(got) != 32 {
+		t.Errorf("invalid request id should be replaced, got %q", got)
+	}
+	readLogs()
+
+	// --- ルートがない場合もログに出す ---
+	req = httptest.NewRequest("GET", "/missing", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	access = readLogs()[0]
+	if access["status"] != float64(404) || access["level"] != "WARN" || access["route"] != "" {
+		t.Errorf("unexpected log: %+v", access)
+	}
+}
+
+func TestRecordMetrics(t *testing.T) {
+	appCtx := context.NewAppContext(db.NewMockDB(nil, nil, nil, nil), sessions.NewCookieStore([]byte("test-secret")))
+	notFound := func(ctx *context.AppContext, w http.ResponseWriter, r *http.Request) *handler.AppError {
+		return handler.NewAppError(errors.New("not found"), "見つかりません", http.StatusNotFound)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /items/{id}", handler.NewHandler(appCtx, notFound))
+	mux.Handle("GET /plain", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server := RequestLogging(slog.New(slog.NewTextHandler(io.Discard, nil)), RecordMetrics(mux))
+
+	type count struct {
+		method, route, status string
+	}
+	counts := []count{
+		{"GET", "/items/{id}", "404"},
+		{"GET", "/plain", "200"},
+		{"GET", unmatchedRoute, "404"},
+	}
+	before := map[count]float64{}
+	for _, c := range counts {
+		before[c] = metrics.HTTPRequests.Value(c.method, c.route, c.status)
+	}
+	errorsBefore := metrics.AppErrors.Value("404")
+	durationsBefore := metrics.HTTPRequestDuration.Count("GET", "/items/{id}")
+
+	// ルートはパスではなくパターンで記録する
+	for _, path := range []string{"/items/1", "/items/2", "/plain", "/unknown"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	for c, want := range map[count]float64{counts[0]: 2, counts[1]: 1, counts[2]: 1} {
+		if got := metrics.HTTPRequests.Value(c.method, c.route, c.status) - before[c]; got != want {
+			t.Errorf("%+v: want %v, got %v", c, want, got)
+		}
+	}
+	if got := metrics.HTTPRequestDuration.Count("GET", "/items/{id}") - durationsBefore; got != 2 {
+		t.Errorf("want 2 observations, got %d", got)
+	}
+	if got := metrics.AppErrors.Value("404") - errorsBefore; got != 2 {
+		t.Errorf("want 2 errors, got %v", got)
+	}
+}
+
+func TestRecordMetricsOtherMethod(t *testing.T) {
+	server := RecordMetrics(http.NewServeMux())
+	before := metrics.HTTPRequests.Value(otherMethod, unmatchedRoute, "404")
+
+	// 標準以外のメソッドはOTHERにまとめる
+	for _, method := range []string{"FOO", "BAR"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/unknown", nil))
+	}
+
+	if got := metrics.HTTPRequests.Value(otherMethod, unmatchedRoute, "404") - before; got != 2 {
+		t.Errorf("want 2, got %v", got)
+	}
+	if got := metrics.HTTPRequests.Value("FOO", unmatchedRoute, "404"); got != 0 {
+		t.Errorf("FOO: want 0, got %v", got)
+	}
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"backend/context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// リクエストを識別するIDのヘッダー
+// クライアントやリバースプロキシが付けた場合はそのまま使い、ない場合は発行する
+const RequestIDHeader = "X-Request-ID"
+
+// 受け取るリクエストIDの最大の長さ
+const maxRequestIDLength = 128
+
+// リクエストIDを付けて、リクエストごとにアクセスログを出力する
+// ルートのパターン、ユーザーID、エラーは内側のハンドラーがcontextのRequestInfoに設定する
+func RequestLogging(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		info := &context.RequestInfo{RequestID: requestID}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithRequestInfo(r.Context(), info)))
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", info.Route),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		}
+		if info.UserID != 0 {
+			attrs = append(attrs, slog.Int("user_id", info.UserID))
+		}
+		if info.Err != nil {
+			attrs = append(attrs, slog.String("error", info.Err.Error()))
+			if info.ErrSource != "" {
+				attrs = append(attrs, slog.String("error_source", info.ErrSource))
+			}
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		// request_idはcontextから付ける
+		logger.LogAttrs(context.WithRequestInfo(r.Context(), info), level, "request", attrs...)
+	})
+}
+
+// ログに混ぜられないように、英数字と - _ . : だけを受け付ける
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// レスポンスのステータスコードを記録するResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// http.ResponseControllerでFlushできるように、元のResponseWriterを返す
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,222 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/db"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// スクリプトなどから使うAPIトークン
+// トークンそのものは発行時にしか取得できない
+type APIToken struct {
+	ID         int
+	User       User
+	CreatorID  int
+	Name       string
+	Scopes     []string
+	CreatedAt  DateTime
+	ExpiresAt  *DateTime // 期限なしの場合はnil
+	LastUsedAt *DateTime // 未使用の場合はnil
+	RevokedAt  *DateTime // 失効していない場合はnil
+}
+
+// APIトークン発行用のコマンド構造体
+type NewAPIToken struct {
+	UserID    int
+	Name      string
+	Scopes    []string
+	ExpiresAt *DateTime
+}
+
+// APIトークンを発行し、IDとトークンを返す
+// api_token.manage権限を持つユーザーのみ、自分の権限の範囲内のユーザーに対して実行できる
+func (*APIToken) Issue(ctx *context.AppContext, actorID int, newToken NewAPIToken) (int, string, error) {
+	allowed, err := auth.Can(ctx, actorID, auth.PermissionAPITokenManage)
+	if err != nil {
+		return 0, "", err
+	}
+	if !allowed {
+		return 0, "", ErrForbidden
+	}
+
+	if strings.TrimSpace(newToken.Name) == "" {
+		return 0, "", NewInputError(errors.New("empty name"), "トークンの名前を入力してください")
+	}
+	if len(newToken.Scopes) == 0 {
+		return 0, "", NewInputError(errors.New("empty scopes"), "スコープを1つ以上指定してください")
+	}
+	for _, scope := range newToken.Scopes {
+		if !auth.IsValidScope(scope) {
+			return 0, "", NewInputError(errors.New("invalid scope: "+scope), "スコープが不正です: "+scope)
+		}
+	}
+	var expiresAt int64
+	if newToken.ExpiresAt != nil {
+		if !time.Time(*newToken.ExpiresAt).After(time.Now()) {
+			return 0, "", NewInputError(errors.New("expires_at is in the past"), "有効期限は未来の日時でなければいけない")
+		}
+		expiresAt = time.Time(*newToken.ExpiresAt).Unix()
+	}
+
+	// トークンを使うユーザーが同じ組織に存在するか確認
+	owner, err := findUserInSameOrganization(ctx, actorID, newToken.UserID)
+	if err != nil {
+		return 0, "", err
+	}
+	// 自分より多くの権限を持つユーザーとして操作できないように、自分の権限の範囲内のユーザーに限る
+	actor, err := ctx.GetDB().GetUserByID(ctx.Context(), actorID)
+	if err != nil {
+		return 0, "", err
+	}
+	if !auth.CanManageRole(actor.Role, owner.Role) {
+		return 0, "", ErrForbidden
+	}
+
+	token, hash, err := auth.NewAPIToken()
+	if err != nil {
+		return 0, "", err
+	}
+	scopes := slices.Compact(slices.Sorted(slices.Values(newToken.Scopes)))
+	id, err := ctx.GetDB().CreateAPIToken(ctx.Context(), db.APIToken{
+		UserID:    newToken.UserID,
+		CreatorID: actorID,
+		Name:      newToken.Name,
+		TokenHash: hash,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	// 監査ログに記録。トークンは記録しない
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionAPITokenIssue,
+		TargetType: AuditTargetAPIToken,
+		TargetID:   id,
+		After: map[string]any{
+			"user_id":    newToken.UserID,
+			"name":       newToken.Name,
+			"scopes":     scopes,
+			"expires_at": expiresAt,
+		},
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	return id, token, nil
+}
+
+// 同じ組織のユーザーに発行済みのAPIトークンを全て取得する
+// api_token.manage権限を持つユーザーのみ実行できる
+func (*APIToken) FindAll(ctx *context.AppContext, viewerID int) ([]APIToken, error) {
+	allowed, err := auth.Can(ctx, viewerID, auth.PermissionAPITokenManage)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+
+	organizationID, err := organizationIDOf(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	tokenRecs, err := ctx.GetDB().GetAPITokensByOrganizationID(ctx.Context(), organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]int, 0, len(tokenRecs))
+	for _, tokenRec := range tokenRecs {
+		userIDs = append(userIDs, tokenRec.UserID)
+	}
+	var user User
+	users, err := user.findByIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]APIToken, 0, len(tokenRecs))
+	for _, tokenRec := range tokenRecs {
+		token, err := newAPITokenFromRecord(tokenRec, users[tokenRec.UserID])
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// APIトークンを失効させる
+// api_token.manage権限を持つユーザーのみ実行できる。存在しない、失効済み、または他の組織のユーザーのトークンの場合はdb.ErrAPITokenNotFoundを返す
+func (*APIToken) Revoke(ctx *context.AppContext, actorID int, tokenID int) error {
+	allowed, err := auth.Can(ctx, actorID, auth.PermissionAPITokenManage)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrForbidden
+	}
+
+	tokenRec, err := ctx.GetDB().GetAPITokenByID(ctx.Context(), tokenID)
+	if err != nil {
+		return err
+	}
+	if _, err := findUserInSameOrganization(ctx, actorID, tokenRec.UserID); err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return db.ErrAPITokenNotFound
+		}
+		return err
+	}
+
+	if err := ctx.GetDB().RevokeAPIToken(ctx.Context(), tokenID, time.Now().Unix()); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionAPITokenRevoke,
+		TargetType: AuditTargetAPIToken,
+		TargetID:   tokenID,
+	})
+	return err
+}
+
+// DBのレコードをモデルに変換する
+func newAPITokenFromRecord(tokenRec db.APIToken, user User) (APIToken, error) {
+	createdAt, err := NewDateTime(tokenRec.CreatedAt)
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	return APIToken{
+		ID:         tokenRec.ID,
+		User:       user,
+		CreatorID:  tokenRec.CreatorID,
+		Name:       tokenRec.Name,
+		Scopes:     strings.Fields(tokenRec.Scopes),
+		CreatedAt:  createdAt,
+		ExpiresAt:  unixToDateTime(tokenRec.ExpiresAt),
+		LastUsedAt: unixToDateTime(tokenRec.LastUsedAt),
+		RevokedAt:  unixToDateTime(tokenRec.RevokedAt),
+	}, nil
+}
+
+// UNIX時間をDateTimeに変換する。0の場合はnilを返す
+func unixToDateTime(unix int64) *DateTime {
+	if unix == 0 {
+		return nil
+	}
+	t := DateTime(time.Unix(unix, 0))
+	return &t
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/db"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAPIToken(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "testuser", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "testmanager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "testadmin", Password: "password", Name: "テスト管理者", Role: auth.RoleAdmin, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 4, LoginID: "other_manager", Password: "password", Name: "2号店マネージャー", Role: auth.RoleManager, OrganizationID: 2, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+
+	var apiToken APIToken
+	newToken := NewAPIToken{UserID: 1, Name: "給与計算", Scopes: []string{auth.ScopeSubmissionsRead, auth.ScopeRequestsRead}}
+
+	// マネージャー以外は発行、取得、失効できない
+	if _, _, err := apiToken.Issue(ctx, 1, newToken); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-manager user, got %v", err)
+	}
+	if _, err := apiToken.FindAll(ctx, 1); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-manager user, got %v", err)
+	}
+	if err := apiToken.Revoke(ctx, 1, 1); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-manager user, got %v", err)
+	}
+
+	// 入力が不正
+	past := DateTime(time.Now().Add(-time.Hour))
+	invalids := []NewAPIToken{
+		{UserID: 1, Name: " ", Scopes: []string{auth.ScopeRequestsRead}},
+		{UserID: 1, Name: "test", Scopes: []string{}},
+		{UserID: 1, Name: "test", Scopes: []string{"admin"}},
+		{UserID: 1, Name: "test", Scopes: []string{auth.ScopeRequestsRead}, ExpiresAt: &past},
+	}
+	for _, invalid := range invalids {
+		if _, _, err := apiToken.Issue(ctx, 2, invalid); !errors.As(err, new(InputError)) {
+			t.Errorf("Expected InputError for %+v, got %v", invalid, err)
+		}
+	}
+
+	// 存在しないユーザー
+	if _, _, err := apiToken.Issue(ctx, 2, NewAPIToken{UserID: 999, Name: "test", Scopes: []string{auth.ScopeRequestsRead}}); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	// 自分より多くの権限を持つユーザーのトークンは発行できない
+	if _, _, err := apiToken.Issue(ctx, 2, NewAPIToken{UserID: 3, Name: "test", Scopes: []string{auth.ScopeRequestsRead}}); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for admin user, got %v", err)
+	}
+
+	// 正常系: トークンはハッシュだけが保存される
+	id, token, err := apiToken.Issue(ctx, 2, newToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ctx.GetDB().GetAPITokenByHash(ctx.Context(), token); !errors.Is(err, db.ErrAPITokenNotFound) {
+		t.Errorf("token should not be stored as is")
+	}
+
+	tokens, err := apiToken.FindAll(ctx, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != id || tokens[0].User.ID != 1 || tokens[0].CreatorID != 2 || tokens[0].RevokedAt != nil {
+		t.Fatalf("unexpected tokens: %+v", tokens)
+	}
+	assert(t, tokens[0].Scopes, []string{auth.ScopeRequestsRead, auth.ScopeSubmissionsRead})
+
+	// 他の組織のマネージャーには見えない
+	tokens, err = apiToken.FindAll(ctx, 4)
+	if err != nil || len(tokens) != 0 {
+		t.Errorf("unexpected tokens: %+v, %v", tokens, err)
+	}
+
+	// 正常系: 失効
+	if err := apiToken.Revoke(ctx, 2, id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tokens, _ = apiToken.FindAll(ctx, 2)
+	if tokens[0].RevokedAt == nil {
+		t.Errorf("token should be revoked")
+	}
+
+	// 失効済み、存在しないトークン
+	if err := apiToken.Revoke(ctx, 2, id); !errors.Is(err, db.ErrAPITokenNotFound) {
+		t.Errorf("Expected ErrAPITokenNotFound, got %v", err)
+	}
+
+	for _, action := range []string{AuditActionAPITokenIssue, AuditActionAPITokenRevoke} {
+		events, _ := ctx.GetDB().QueryAuditEvents(ctx.Context(), db.AuditQuery{OrganizationID: db.DefaultOrganizationID, Action: action})
+		if len(events) != 1 || events[0].ActorID != 2 || events[0].TargetID != id {
+			t.Errorf("unexpected audit events for %s: %+v", action, events)
+		}
+	}
+}
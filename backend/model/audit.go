@@ -0,0 +1,268 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/db"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// 監査ログの操作の種類
+const (
+	AuditActionLogin                 = "auth.login"
+	AuditActionLoginFailed           = "auth.login_failed"
+	AuditActionRequestCreate         = "request.create"
+	AuditActionRequestClose          = "request.close"
+	AuditActionSubmissionCreate      = "submission.create"
+	AuditActionSubmissionUpdate      = "submission.update"
+	AuditActionUserCreate            = "user.create"
+	AuditActionUserDeactivate        = "user.deactivate"
+	AuditActionUserUnlock            = "user.unlock"
+	AuditActionPasswordChange        = "user.password_change"
+	AuditActionPasswordIssue         = "user.password_reset_issue"
+	AuditActionPasswordReset         = "user.password_reset"
+	AuditActionPasswordSet           = "user.password_set"
+	AuditActionTOTPEnable            = "user.totp_enable"
+	AuditActionTOTPDisable           = "user.totp_disable"
+	AuditActionRecoveryCodes         = "user.recovery_codes_regenerate"
+	AuditActionAPITokenIssue         = "api_token.issue"
+	AuditActionAPITokenRevoke        = "api_token.revoke"
+	AuditActionOrganizationCreate    = "organization.create"
+	AuditActionOrganizationMemberAdd = "organization.member_add"
+	AuditActionGroupCreate           = "group.create"
+	AuditActionGroupMemberAdd        = "group.member_add"
+	AuditActionGroupMemberRemove     = "group.member_remove"
+	AuditActionWebhookCreate         = "webhook.create"
+	AuditActionWebhookDelete         = "webhook.delete"
+)
+
+// 監査ログの対象の種類
+const (
+	AuditTargetUser         = "user"
+	AuditTargetRequest      = "request"
+	AuditTargetSubmission   = "submission"
+	AuditTargetAPIToken     = "api_token"
+	AuditTargetOrganization = "organization"
+	AuditTargetGroup        = "group"
+	AuditTargetWebhook      = "webhook"
+)
+
+const (
+	defaultAuditPageLimit = 50
+	maxAuditPageLimit     = 200
+)
+
+// 監査ログのイベント
+// 一度記録したイベントは変更・削除しない
+type AuditEvent struct {
+	ID             int
+	OrganizationID int
+	ActorID        int // 操作したユーザー。不明な場合は0
+	Action         string
+	TargetType     string
+	TargetID       int
+	Before         json.RawMessage // 変更前の状態。存在しない場合はnil
+	After          json.RawMessage // 変更後の状態。存在しない場合はnil
+	ClientIP       string
+	CreatedAt      DateTime
+}
+
+// 監査ログ記録用のコマンド構造体
+// Before, AfterはJSONに変換して保存される。nilの場合は保存しない
+type NewAuditEvent struct {
+	ActorID    int
+	Action     string
+	TargetType string
+	TargetID   int
+	Before     any
+	After      any
+}
+
+// 監査ログを記録する
+// リクエスト元のIPアドレスはコンテキストから取得する
+// 組織は操作したユーザーの組織。不明な場合は対象のユーザーまたはシフトリクエストの組織にする
+// どちらも不明な場合(存在しないログインIDでのログインの失敗など)は、他の組織に見せないように組織なし(0)で記録する
+func (*AuditEvent) Record(ctx *context.AppContext, newEvent NewAuditEvent) (int, error) {
+	organizationID, err := auditOrganizationIDOf(ctx, newEvent)
+	if err != nil {
+		return -1, err
+	}
+
+	before, err := marshalAuditState(newEvent.Before)
+	if err != nil {
+		return -1, err
+	}
+	after, err := marshalAuditState(newEvent.After)
+	if err != nil {
+		return -1, err
+	}
+
+	return ctx.GetDB().CreateAuditEvent(ctx.Context(), db.AuditEvent{
+		OrganizationID: organizationID,
+		ActorID:        newEvent.ActorID,
+		Action:         newEvent.Action,
+		TargetType:     newEvent.TargetType,
+		TargetID:       newEvent.TargetID,
+		Before:         before,
+		After:          after,
+		ClientIP:       ctx.ClientIP(),
+	})
+}
+
+// 監査ログのイベントを記録する組織を決める
+func auditOrganizationIDOf(ctx *context.AppContext, newEvent NewAuditEvent) (int, error) {
+	if newEvent.ActorID == 0 && newEvent.TargetType == AuditTargetRequest {
+		requestRec, err := ctx.GetDB().GetRequestByID(ctx.Context(), newEvent.TargetID)
+		if errors.Is(err, db.ErrRequestNotFound) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return requestRec.OrganizationID, nil
+	}
+
+	organizationUserID := newEvent.ActorID
+	if organizationUserID == 0 && newEvent.TargetType == AuditTargetUser {
+		organizationUserID = newEvent.TargetID
+	}
+	organizationID, err := organizationIDOf(ctx, organizationUserID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return 0, nil
+	}
+	return organizationID, err
+}
+
+func marshalAuditState(state any) (string, error) {
+	if state == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// 監査ログの検索条件
+// ゼロ値の条件は絞り込みに使われない
+type AuditFilter struct {
+	ActorID    int
+	Action     string
+	TargetType string
+	TargetID   int
+
+	// Since <= 記録日時 < Until のイベントに絞り込む
+	Since *DateTime
+	Until *DateTime
+
+	// 前のページのNextCursor。空の場合は先頭から
+	Cursor string
+	// 1ページの件数。0の場合はデフォルト値
+	Limit int
+}
+
+// 監査ログの1ページ
+type AuditPage struct {
+	Events []AuditEvent
+	// 次のページを取得するためのカーソル。最後のページの場合は空
+	NextCursor string
+}
+
+// 閲覧ユーザーの組織の監査ログを新しい順に1ページ分取得する
+// audit.view権限を持つユーザーのみ閲覧できる. 組織なしで記録したイベントは含めない
+func (*AuditEvent) FindPage(ctx *context.AppContext, viewerID int, filter AuditFilter) (AuditPage, error) {
+	allowed, err := auth.Can(ctx, viewerID, auth.PermissionAuditView)
+	if err != nil {
+		return AuditPage{}, err
+	}
+	if !allowed {
+		return AuditPage{}, ErrForbidden
+	}
+
+	organizationID, err := organizationIDOf(ctx, viewerID)
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	query := db.AuditQuery{
+		OrganizationID: organizationID,
+		ActorID:        filter.ActorID,
+		Action:         filter.Action,
+		TargetType:     filter.TargetType,
+		TargetID:       filter.TargetID,
+	}
+	if filter.Since != nil {
+		query.Since = filter.Since.Format()
+	}
+	if filter.Until != nil {
+		query.Until = filter.Until.Format()
+	}
+
+	if filter.Cursor != "" {
+		beforeID, err := strconv.Atoi(filter.Cursor)
+		if err != nil || beforeID <= 0 {
+			return AuditPage{}, NewInputError(
+				errors.New("invalid cursor"),
+				"カーソルが不正です",
+			)
+		}
+		query.BeforeID = beforeID
+	}
+
+	switch {
+	case filter.Limit == 0:
+		query.Limit = defaultAuditPageLimit
+	case 1 <= filter.Limit && filter.Limit <= maxAuditPageLimit:
+		query.Limit = filter.Limit
+	default:
+		return AuditPage{}, NewInputError(
+			errors.New("invalid limit"),
+			"limitは1以上200以下でなければいけない",
+		)
+	}
+
+	// 次のページがあるか判定するために1件多く取得する
+	limit := query.Limit
+	query.Limit++
+	eventRecs, err := ctx.GetDB().QueryAuditEvents(ctx.Context(), query)
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	var nextCursor string
+	if len(eventRecs) > limit {
+		eventRecs = eventRecs[:limit]
+		nextCursor = strconv.Itoa(eventRecs[len(eventRecs)-1].ID)
+	}
+
+	var events []AuditEvent
+	for _, eventRec := range eventRecs {
+		createdAt, err := NewDateTime(eventRec.CreatedAt)
+		if err != nil {
+			return AuditPage{}, err
+		}
+
+		event := AuditEvent{
+			ID:             eventRec.ID,
+			OrganizationID: eventRec.OrganizationID,
+			ActorID:        eventRec.ActorID,
+			Action:         eventRec.Action,
+			TargetType:     eventRec.TargetType,
+			TargetID:       eventRec.TargetID,
+			ClientIP:       eventRec.ClientIP,
+			CreatedAt:      createdAt,
+		}
+		if eventRec.Before != "" {
+			event.Before = json.RawMessage(eventRec.Before)
+		}
+		if eventRec.After != "" {
+			event.After = json.RawMessage(eventRec.After)
+		}
+		events = append(events, event)
+	}
+
+	return AuditPage{Events: events, NextCursor: nextCursor}, nil
+}
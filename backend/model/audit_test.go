@@ -0,0 +1,112 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/db"
+	"encoding/json"
+	"testing"
+)
+
+func TestRecordAndFindAuditEvents(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	).WithClientIP("192.0.2.1")
+
+	// リクエストを作成すると監査ログが記録される
+	var r Request
+	requestID, err := r.Create(ctx, NewRequest{CreatorID: 2, StartDate: mustNewDateOnly("2024-06-01"), EndDate: mustNewDateOnly("2024-06-07"), Deadline: mustNewDateTime("2024-05-25 00:00:00")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 提出すると監査ログが記録される
+	var s Submission
+	submissionID, err := s.Create(ctx, NewSubmission{RequestID: requestID, SubmitterID: 1, NewEntries: []NewEntry{{Date: mustNewDateOnly("2024-06-01"), Hour: 9}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var a AuditEvent
+	_, err = a.Record(ctx, NewAuditEvent{ActorID: 1, Action: AuditActionLogin, TargetType: AuditTargetUser, TargetID: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("新しい順に取得", func(t *testing.T) {
+		page, err := a.FindPage(ctx, 2, AuditFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(page.Events) != 3 {
+			t.Fatalf("want 3 events, got %d", len(page.Events))
+		}
+
+		login := page.Events[0]
+		if login.Action != AuditActionLogin || login.Before != nil || login.After != nil || login.ClientIP != "192.0.2.1" {
+			t.Errorf("unexpected login event: %+v", login)
+		}
+
+		submission := page.Events[1]
+		if submission.Action != AuditActionSubmissionCreate || submission.ActorID != 1 || submission.TargetID != submissionID {
+			t.Errorf("unexpected submission event: %+v", submission)
+		}
+
+		request := page.Events[2]
+		if request.Action != AuditActionRequestCreate || request.ActorID != 2 || request.TargetType != AuditTargetRequest || request.TargetID != requestID {
+			t.Errorf("unexpected request event: %+v", request)
+		}
+		var after map[string]string
+		if err := json.Unmarshal(request.After, &after); err != nil {
+			t.Fatalf("after should be JSON: %v", err)
+		}
+		assert(t, after, map[string]string{"start_date": "2024-06-01", "end_date": "2024-06-07", "deadline": "2024-05-25 00:00:00"})
+	})
+
+	t.Run("絞り込み", func(t *testing.T) {
+		page, err := a.FindPage(ctx, 2, AuditFilter{ActorID: 1, TargetType: AuditTargetSubmission})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(page.Events) != 1 || page.Events[0].Action != AuditActionSubmissionCreate {
+			t.Errorf("unexpected events: %+v", page.Events)
+		}
+	})
+
+	t.Run("ページング", func(t *testing.T) {
+		page, err := a.FindPage(ctx, 2, AuditFilter{Limit: 2})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(page.Events) != 2 || page.NextCursor == "" {
+			t.Fatalf("unexpected first page: %+v", page)
+		}
+
+		page, err = a.FindPage(ctx, 2, AuditFilter{Limit: 2, Cursor: page.NextCursor})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(page.Events) != 1 || page.Events[0].Action != AuditActionRequestCreate || page.NextCursor != "" {
+			t.Errorf("unexpected second page: %+v", page)
+		}
+	})
+
+	t.Run("マネージャー以外は閲覧できない", func(t *testing.T) {
+		_, err := a.FindPage(ctx, 1, AuditFilter{})
+		if err != ErrForbidden {
+			t.Errorf("Expected ErrForbidden for non-manager user, got %v", err)
+		}
+	})
+
+	t.Run("不正なカーソル", func(t *testing.T) {
+		_, err := a.FindPage(ctx, 2, AuditFilter{Cursor: "abc"})
+		if _, ok := err.(InputError); !ok {
+			t.Errorf("Expected InputError for invalid cursor, got %v", err)
+		}
+	})
+}
@@ -14,7 +14,7 @@ type entry struct {
 
 func (*entry) findBySubmissionID(ctx *context.AppContext, submissionID int) ([]entry, error) {
 	// DBからエントリー一覧を取得
-	entryRecs, err := ctx.GetDB().GetEntriesBySubmissionID(submissionID)
+	entryRecs, err := ctx.GetDB().GetEntriesBySubmissionID(ctx.Context(), submissionID)
 	if err != nil {
 		return nil, err
 	}
@@ -22,23 +22,51 @@ func (*entry) findBySubmissionID(ctx *context.AppContext, submissionID int) ([]e
 	// エントリー一覧を構築
 	var entries []entry
 	for _, entryRec := range entryRecs {
-		// 時間型に変換
-		date, err := NewDateOnly(entryRec.Date)
+		e, err := newEntryFromRecord(entryRec)
 		if err != nil {
 			return nil, err
 		}
+		entries = append(entries, e)
+	}
 
-		entries = append(entries, entry{
-			ID:           entryRec.ID,
-			SubmissionID: entryRec.SubmissionID,
-			Date:         date,
-			Hour:         entryRec.Hour,
-		})
+	return entries, nil
+}
+
+// 複数の提出のエントリーをまとめて取得し、提出IDをキーとするマップで返す
+func (*entry) findBySubmissionIDs(ctx *context.AppContext, submissionIDs []int) (map[int][]entry, error) {
+	entryRecs, err := ctx.GetDB().GetEntriesBySubmissionIDs(ctx.Context(), submissionIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[int][]entry)
+	for _, entryRec := range entryRecs {
+		e, err := newEntryFromRecord(entryRec)
+		if err != nil {
+			return nil, err
+		}
+		entries[e.SubmissionID] = append(entries[e.SubmissionID], e)
 	}
 
 	return entries, nil
 }
 
+// DBのレコードをモデルに変換する
+func newEntryFromRecord(entryRec db.Entry) (entry, error) {
+	// 時間型に変換
+	date, err := NewDateOnly(entryRec.Date)
+	if err != nil {
+		return entry{}, err
+	}
+
+	return entry{
+		ID:           entryRec.ID,
+		SubmissionID: entryRec.SubmissionID,
+		Date:         date,
+		Hour:         entryRec.Hour,
+	}, nil
+}
+
 type NewEntry struct {
 	Date DateOnly
 	Hour int
@@ -56,7 +84,7 @@ func (*entry) create(ctx *context.AppContext, submissionID int, newEntries []New
 		})
 	}
 
-	entryIDs, err := ctx.GetDB().CreateEntries(entryRecs)
+	entryIDs, err := ctx.GetDB().CreateEntries(ctx.Context(), entryRecs)
 	if err != nil {
 		return nil, err
 	}
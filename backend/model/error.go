@@ -4,6 +4,8 @@ import "errors"
 
 var (
 	ErrForbidden = errors.New("forbidden access")
+	// 同じシフトリクエストに提出済み
+	ErrAlreadySubmitted = errors.New("already submitted")
 )
 
 type InputError struct {
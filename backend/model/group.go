@@ -0,0 +1,190 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/db"
+	"errors"
+	"strings"
+)
+
+// 組織内の従業員のグループ(キッチン、ホールなど)
+// シフトリクエストの対象として指定できる
+type Group struct {
+	ID        int
+	Name      string
+	Members   []User
+	CreatedAt DateTime
+}
+
+// 閲覧ユーザーの組織のグループを、所属するユーザーと合わせて全て取得する
+func (*Group) FindAll(ctx *context.AppContext, viewerID int) ([]Group, error) {
+	organizationID, err := organizationIDOf(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	groupRecs, err := ctx.GetDB().GetGroupsByOrganizationID(ctx.Context(), organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 所属するユーザーをまとめて取得
+	groupIDs := make([]int, 0, len(groupRecs))
+	for _, groupRec := range groupRecs {
+		groupIDs = append(groupIDs, groupRec.ID)
+	}
+	memberRecs, err := ctx.GetDB().GetGroupMembers(ctx.Context(), groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]int, 0, len(memberRecs))
+	for _, memberRec := range memberRecs {
+		userIDs = append(userIDs, memberRec.UserID)
+	}
+	var user User
+	users, err := user.findByIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	members := map[int][]User{}
+	for _, memberRec := range memberRecs {
+		members[memberRec.GroupID] = append(members[memberRec.GroupID], users[memberRec.UserID])
+	}
+
+	groups := make([]Group, 0, len(groupRecs))
+	for _, groupRec := range groupRecs {
+		group, err := newGroupFromRecord(groupRec)
+		if err != nil {
+			return nil, err
+		}
+		group.Members = members[groupRec.ID]
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// 操作するユーザーの組織にグループを作成し、IDを返す
+// group.manage権限を持つユーザーのみ実行できる
+func (*Group) Create(ctx *context.AppContext, actorID int, name string) (int, error) {
+	allowed, err := auth.Can(ctx, actorID, auth.PermissionGroupManage)
+	if err != nil {
+		return -1, err
+	}
+	if !allowed {
+		return -1, ErrForbidden
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return -1, NewInputError(errors.New("empty name"), "グループの名前を入力してください")
+	}
+
+	organizationID, err := organizationIDOf(ctx, actorID)
+	if err != nil {
+		return -1, err
+	}
+	groupID, err := ctx.GetDB().CreateGroup(ctx.Context(), organizationID, name)
+	if err != nil {
+		return -1, err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionGroupCreate,
+		TargetType: AuditTargetGroup,
+		TargetID:   groupID,
+		After:      map[string]any{"name": name},
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return groupID, nil
+}
+
+// ユーザーをグループに追加する。追加済みの場合は何もしない
+// group.manage権限を持つユーザーのみ実行できる。グループとユーザーは操作するユーザーと同じ組織でなければいけない
+func (*Group) AddMember(ctx *context.AppContext, actorID int, groupID int, userID int) error {
+	if err := checkGroupMemberChange(ctx, actorID, groupID, userID); err != nil {
+		return err
+	}
+
+	if err := ctx.GetDB().AddGroupMember(ctx.Context(), groupID, userID); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err := audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionGroupMemberAdd,
+		TargetType: AuditTargetGroup,
+		TargetID:   groupID,
+		After:      map[string]any{"user_id": userID},
+	})
+	return err
+}
+
+// ユーザーをグループから外す。所属していない場合は何もしない
+// group.manage権限を持つユーザーのみ実行できる。グループとユーザーは操作するユーザーと同じ組織でなければいけない
+func (*Group) RemoveMember(ctx *context.AppContext, actorID int, groupID int, userID int) error {
+	if err := checkGroupMemberChange(ctx, actorID, groupID, userID); err != nil {
+		return err
+	}
+
+	if err := ctx.GetDB().RemoveGroupMember(ctx.Context(), groupID, userID); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err := audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionGroupMemberRemove,
+		TargetType: AuditTargetGroup,
+		TargetID:   groupID,
+		Before:     map[string]any{"user_id": userID},
+	})
+	return err
+}
+
+// グループのメンバーを変更できるか確認する
+// 他の組織のグループ、ユーザーの場合は、存在を知られないようにdb.ErrGroupNotFound, db.ErrUserNotFoundを返す
+func checkGroupMemberChange(ctx *context.AppContext, actorID int, groupID int, userID int) error {
+	allowed, err := auth.Can(ctx, actorID, auth.PermissionGroupManage)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrForbidden
+	}
+
+	organizationID, err := organizationIDOf(ctx, actorID)
+	if err != nil {
+		return err
+	}
+	groupRec, err := ctx.GetDB().GetGroupByID(ctx.Context(), groupID)
+	if err != nil {
+		return err
+	}
+	if groupRec.OrganizationID != organizationID {
+		return db.ErrGroupNotFound
+	}
+	_, err = findUserInSameOrganization(ctx, actorID, userID)
+	return err
+}
+
+func newGroupFromRecord(groupRec db.Group) (Group, error) {
+	createdAt, err := NewDateTime(groupRec.CreatedAt)
+	if err != nil {
+		return Group{}, err
+	}
+	return Group{
+		ID:        groupRec.ID,
+		Name:      groupRec.Name,
+		CreatedAt: createdAt,
+	}, nil
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/db"
+	"errors"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "other_user", Password: "password", Name: "2号店ユーザー", Role: auth.RoleEmployee, OrganizationID: 2, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 4, LoginID: "other_manager", Password: "password", Name: "2号店マネージャー", Role: auth.RoleManager, OrganizationID: 2, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	if _, err := ctx.GetDB().CreateOrganization(ctx.Context(), "2号店"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var g Group
+
+	// マネージャー以外は作成、メンバーの変更ができない
+	if _, err := g.Create(ctx, 1, "キッチン"); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-manager user, got %v", err)
+	}
+
+	// 名前が空
+	if _, err := g.Create(ctx, 2, " "); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for empty name, got %v", err)
+	}
+
+	// 正常系
+	id, err := g.Create(ctx, 2, "キッチン")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := g.AddMember(ctx, 1, id, 1); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-manager user, got %v", err)
+	}
+	if err := g.AddMember(ctx, 2, id, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 追加済みの場合は何もしない
+	if err := g.AddMember(ctx, 2, id, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	groups, err := g.FindAll(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Name != "キッチン" || len(groups[0].Members) != 1 || groups[0].Members[0].ID != 1 {
+		t.Errorf("unexpected groups: %+v", groups)
+	}
+
+	// 他の組織のグループ、ユーザーは存在しないものとして扱う
+	if err := g.AddMember(ctx, 4, id, 3); !errors.Is(err, db.ErrGroupNotFound) {
+		t.Errorf("Expected ErrGroupNotFound, got %v", err)
+	}
+	if err := g.AddMember(ctx, 2, id, 3); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	groups, err = g.FindAll(ctx, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(groups) != 0 {
+		t.Errorf("groups of other organizations should not be listed: %+v", groups)
+	}
+
+	// メンバーから外す
+	if err := g.RemoveMember(ctx, 2, id, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	groups, _ = g.FindAll(ctx, 1)
+	if len(groups) != 1 || len(groups[0].Members) != 0 {
+		t.Errorf("unexpected groups: %+v", groups)
+	}
+
+	events, _ := ctx.GetDB().QueryAuditEvents(ctx.Context(), db.AuditQuery{OrganizationID: db.DefaultOrganizationID, TargetType: AuditTargetGroup})
+	if len(events) != 4 {
+		t.Errorf("want 4 audit events, got %+v", events)
+	}
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"backend/context"
+	"backend/db"
+	"time"
+)
+
+// 通知の種類
+const (
+	// シフトリクエストが作成された
+	NotificationKindRequestCreated = "request.created"
+	// シフトリクエストの締切が近づいている
+	NotificationKindDeadlineReminder = "request.deadline_reminder"
+)
+
+// ユーザーに送る通知
+type Notification struct {
+	Kind      string
+	Recipient User
+	Request   Request
+}
+
+// まだ送っていない通知を全ての組織から取得する
+// 締切前のシフトリクエストごとに、対象で未提出のユーザーへの次の通知を返す
+//   - 作成されたことを知らせる通知
+//   - 締切までreminderWindow以内になった場合は、締切が近いことを知らせる通知
+//
+// MarkSentで記録した通知は含めない
+func (*Notification) FindPending(ctx *context.AppContext, now time.Time, reminderWindow time.Duration) ([]Notification, error) {
+	organizationRecs, err := ctx.GetDB().GetOrganizations(ctx.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	nowDateTime := DateTime(now)
+	var notifications []Notification
+	for _, organizationRec := range organizationRecs {
+		requestRecs, err := ctx.GetDB().QueryRequests(ctx.Context(), db.RequestQuery{
+			OrganizationID: organizationRec.ID,
+			DeadlineAfter:  nowDateTime.Format(),
+			Sort:           db.RequestSortCreatedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, requestRec := range requestRecs {
+			pending, err := findPendingNotifications(ctx, requestRec, now, reminderWindow)
+			if err != nil {
+				return nil, err
+			}
+			notifications = append(notifications, pending...)
+		}
+	}
+	return notifications, nil
+}
+
+// 通知を送信済みとして記録する。以降はFindPendingで返さない
+func (n *Notification) MarkSent(ctx *context.AppContext, sentAt time.Time) error {
+	return ctx.GetDB().CreateNotificationDelivery(ctx.Context(), db.NotificationDelivery{
+		Kind:      n.Kind,
+		RequestID: n.Request.ID,
+		UserID:    n.Recipient.ID,
+		SentAt:    sentAt.Unix(),
+	})
+}
+
+// 1つのシフトリクエストについて、まだ送っていない通知を返す
+func findPendingNotifications(ctx *context.AppContext, requestRec db.Request, now time.Time, reminderWindow time.Duration) ([]Notification, error) {
+	kinds := []string{NotificationKindRequestCreated}
+	// DBの日時は文字列で保存しているので、同じ形式の文字列で比較する
+	windowEnd := DateTime(now.Add(reminderWindow))
+	if requestRec.Deadline <= windowEnd.Format() {
+		kinds = append(kinds, NotificationKindDeadlineReminder)
+	}
+
+	request, err := newRequestFromRecord(requestRec, User{})
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := findSubmissionStatuses(ctx, requestRec)
+	if err != nil {
+		return nil, err
+	}
+
+	var notifications []Notification
+	for _, kind := range kinds {
+		deliveries, err := ctx.GetDB().GetNotificationDeliveries(ctx.Context(), kind, requestRec.ID)
+		if err != nil {
+			return nil, err
+		}
+		sent := make(map[int]bool, len(deliveries))
+		for _, delivery := range deliveries {
+			sent[delivery.UserID] = true
+		}
+
+		for _, status := range statuses {
+			if status.Submission != nil || sent[status.User.ID] {
+				continue
+			}
+			notifications = append(notifications, Notification{
+				Kind:      kind,
+				Recipient: status.User,
+				Request:   request,
+			})
+		}
+	}
+	return notifications, nil
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/db"
+	"testing"
+	"time"
+)
+
+func TestFindPendingNotifications(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-05-01 00:00:00"},
+			{ID: 2, LoginID: "test_user_2", Password: "password", Name: "テストユーザー2", Role: auth.RoleEmployee, CreatedAt: "2024-05-01 00:00:00"},
+			{ID: 3, LoginID: "test_user_3", Password: "password", Name: "テストユーザー3", Role: auth.RoleEmployee, CreatedAt: "2024-05-01 00:00:00"},
+		},
+		[]db.Request{
+			{ID: 1, CreatorID: 1, StartDate: "2024-06-10", EndDate: "2024-06-16", Deadline: "2024-06-01 12:00:00", CreatedAt: "2024-05-20 00:00:00"},
+			{ID: 2, CreatorID: 1, StartDate: "2024-06-10", EndDate: "2024-06-16", Deadline: "2024-06-10 00:00:00", CreatedAt: "2024-05-20 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	// シフトリクエスト2はユーザー3だけが対象
+	if err := ctx.GetDB().CreateRequestTargets(ctx.Context(), 2, db.RequestTargets{UserIDs: []int{3}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type pending struct {
+		Kind      string
+		RequestID int
+		UserID    int
+	}
+	findPending := func(now time.Time) []pending {
+		t.Helper()
+		var n Notification
+		notifications, err := n.FindPending(ctx, now, 24*time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got := []pending{}
+		for _, notification := range notifications {
+			got = append(got, pending{notification.Kind, notification.Request.ID, notification.Recipient.ID})
+		}
+		return got
+	}
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	assert(t, findPending(now), []pending{
+		{NotificationKindRequestCreated, 1, 2},
+		{NotificationKindRequestCreated, 1, 3},
+		{NotificationKindDeadlineReminder, 1, 2},
+		{NotificationKindDeadlineReminder, 1, 3},
+		{NotificationKindRequestCreated, 2, 3},
+	})
+
+	// 送信済みとして記録した通知は返さない
+	notification := Notification{Kind: NotificationKindRequestCreated, Recipient: User{ID: 3}, Request: Request{ID: 2}}
+	if err := notification.MarkSent(ctx, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert(t, findPending(now), []pending{
+		{NotificationKindRequestCreated, 1, 2},
+		{NotificationKindRequestCreated, 1, 3},
+		{NotificationKindDeadlineReminder, 1, 2},
+		{NotificationKindDeadlineReminder, 1, 3},
+	})
+
+	// 無効化されたユーザーには通知しない
+	if err := ctx.GetDB().DeactivateUser(ctx.Context(), 3, now.Unix()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert(t, findPending(now), []pending{
+		{NotificationKindRequestCreated, 1, 2},
+		{NotificationKindDeadlineReminder, 1, 2},
+	})
+
+	// 締切後のシフトリクエストは通知しない
+	assert(t, findPending(time.Date(2024, 6, 2, 0, 0, 0, 0, time.Local)), []pending{})
+}
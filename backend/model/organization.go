@@ -0,0 +1,223 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/db"
+	"errors"
+	"strings"
+)
+
+// 組織(店舗)
+// ユーザーはいずれか1つの組織に所属し、シフトリクエストや提出は組織ごとに分かれる
+type Organization struct {
+	ID        int
+	Name      string
+	CreatedAt DateTime
+}
+
+// ユーザーが所属する組織を取得する
+func (*Organization) FindByUserID(ctx *context.AppContext, userID int) (Organization, error) {
+	organizationID, err := organizationIDOf(ctx, userID)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	organizationRec, err := ctx.GetDB().GetOrganizationByID(ctx.Context(), organizationID)
+	if err != nil {
+		return Organization{}, err
+	}
+	return newOrganizationFromRecord(organizationRec)
+}
+
+// 全ての組織を取得する
+// organization.manage権限を持つユーザーのみ実行できる
+func (*Organization) FindAll(ctx *context.AppContext, viewerID int) ([]Organization, error) {
+	allowed, err := auth.Can(ctx, viewerID, auth.PermissionOrganizationManage)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+
+	organizationRecs, err := ctx.GetDB().GetOrganizations(ctx.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	organizations := make([]Organization, 0, len(organizationRecs))
+	for _, organizationRec := range organizationRecs {
+		organization, err := newOrganizationFromRecord(organizationRec)
+		if err != nil {
+			return nil, err
+		}
+		organizations = append(organizations, organization)
+	}
+	return organizations, nil
+}
+
+// 組織を作成し、IDを返す
+// organization.manage権限を持つユーザーのみ実行できる
+func (*Organization) Create(ctx *context.AppContext, actorID int, name string) (int, error) {
+	allowed, err := auth.Can(ctx, actorID, auth.PermissionOrganizationManage)
+	if err != nil {
+		return -1, err
+	}
+	if !allowed {
+		return -1, ErrForbidden
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return -1, NewInputError(errors.New("empty name"), "組織の名前を入力してください")
+	}
+
+	organizationID, err := ctx.GetDB().CreateOrganization(ctx.Context(), name)
+	if err != nil {
+		return -1, err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionOrganizationCreate,
+		TargetType: AuditTargetOrganization,
+		TargetID:   organizationID,
+		After:      map[string]any{"name": name},
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return organizationID, nil
+}
+
+// ユーザーを組織に所属させる。元の組織からは外れる
+// organization.manage権限を持つユーザーのみ実行できる
+func (*Organization) AddMember(ctx *context.AppContext, actorID int, organizationID int, userID int) error {
+	allowed, err := auth.Can(ctx, actorID, auth.PermissionOrganizationManage)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrForbidden
+	}
+
+	if _, err := ctx.GetDB().GetOrganizationByID(ctx.Context(), organizationID); err != nil {
+		return err
+	}
+	userRec, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.GetDB().UpdateUserOrganization(ctx.Context(), userID, organizationID); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionOrganizationMemberAdd,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		Before:     map[string]any{"organization_id": userRec.OrganizationID},
+		After:      map[string]any{"organization_id": organizationID},
+	})
+	return err
+}
+
+// 組織に所属するユーザーを取得する
+// 同じ組織のユーザー、またはorganization.manage権限を持つユーザーのみ閲覧できる
+// それ以外の場合は、存在を知られないようにdb.ErrOrganizationNotFoundを返す
+func (*Organization) FindMembers(ctx *context.AppContext, viewerID int, organizationID int) ([]User, error) {
+	viewerOrganizationID, err := organizationIDOf(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if viewerOrganizationID != organizationID {
+		allowed, err := auth.Can(ctx, viewerID, auth.PermissionOrganizationManage)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, db.ErrOrganizationNotFound
+		}
+	}
+
+	if _, err := ctx.GetDB().GetOrganizationByID(ctx.Context(), organizationID); err != nil {
+		return nil, err
+	}
+	userRecs, err := ctx.GetDB().GetUsersByOrganizationID(ctx.Context(), organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0, len(userRecs))
+	for _, userRec := range userRecs {
+		user, err := newUserFromRecord(userRec)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func newOrganizationFromRecord(organizationRec db.Organization) (Organization, error) {
+	createdAt, err := NewDateTime(organizationRec.CreatedAt)
+	if err != nil {
+		return Organization{}, err
+	}
+	return Organization{
+		ID:        organizationRec.ID,
+		Name:      organizationRec.Name,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+// ユーザーが所属する組織のIDを返す
+func organizationIDOf(ctx *context.AppContext, userID int) (int, error) {
+	userRec, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return 0, err
+	}
+	return userRec.OrganizationID, nil
+}
+
+// 操作するユーザーと同じ組織のユーザーを取得する
+// 他の組織のユーザーの場合は、存在を知られないようにdb.ErrUserNotFoundを返す
+func findUserInSameOrganization(ctx *context.AppContext, actorID int, userID int) (db.User, error) {
+	organizationID, err := organizationIDOf(ctx, actorID)
+	if err != nil {
+		return db.User{}, err
+	}
+	userRec, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return db.User{}, err
+	}
+	if userRec.OrganizationID != organizationID {
+		return db.User{}, db.ErrUserNotFound
+	}
+	return userRec, nil
+}
+
+// 閲覧するユーザーと同じ組織のシフトリクエストを取得する
+// 他の組織のシフトリクエストの場合は、存在を知られないようにdb.ErrRequestNotFoundを返す
+func findRequestInSameOrganization(ctx *context.AppContext, viewerID int, requestID int) (db.Request, error) {
+	organizationID, err := organizationIDOf(ctx, viewerID)
+	if err != nil {
+		return db.Request{}, err
+	}
+	requestRec, err := ctx.GetDB().GetRequestByID(ctx.Context(), requestID)
+	if err != nil {
+		return db.Request{}, err
+	}
+	if requestRec.OrganizationID != organizationID {
+		return db.Request{}, db.ErrRequestNotFound
+	}
+	return requestRec, nil
+}
@@ -0,0 +1,202 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/db"
+	"errors"
+	"testing"
+)
+
+func TestOrganization(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "test_admin", Password: "password", Name: "テスト管理者", Role: auth.RoleAdmin, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+
+	var org Organization
+
+	// 管理者以外は作成、取得、所属の変更ができない
+	if _, err := org.Create(ctx, 2, "2号店"); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-admin user, got %v", err)
+	}
+	if _, err := org.FindAll(ctx, 2); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-admin user, got %v", err)
+	}
+	if err := org.AddMember(ctx, 2, db.DefaultOrganizationID, 1); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-admin user, got %v", err)
+	}
+
+	// 名前が空
+	if _, err := org.Create(ctx, 3, " "); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for empty name, got %v", err)
+	}
+
+	// 正常系
+	id, err := org.Create(ctx, 3, "2号店")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	organizations, err := org.FindAll(ctx, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(organizations) != 2 || organizations[1].ID != id || organizations[1].Name != "2号店" {
+		t.Errorf("unexpected organizations: %+v", organizations)
+	}
+
+	// 存在しない組織、ユーザー
+	if err := org.AddMember(ctx, 3, 999, 1); !errors.Is(err, db.ErrOrganizationNotFound) {
+		t.Errorf("Expected ErrOrganizationNotFound, got %v", err)
+	}
+	if err := org.AddMember(ctx, 3, id, 999); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	// ユーザー1を2号店に移す
+	if err := org.AddMember(ctx, 3, id, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := org.FindByUserID(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ID != id {
+		t.Errorf("user should belong to organization %d, got %d", id, got.ID)
+	}
+
+	// 同じ組織のユーザーは所属ユーザーを閲覧できる
+	members, err := org.FindMembers(ctx, 1, id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(members) != 1 || members[0].ID != 1 {
+		t.Errorf("unexpected members: %+v", members)
+	}
+
+	// 他の組織のユーザーには存在を知られない
+	if _, err := org.FindMembers(ctx, 2, id); !errors.Is(err, db.ErrOrganizationNotFound) {
+		t.Errorf("Expected ErrOrganizationNotFound, got %v", err)
+	}
+
+	// 管理者は他の組織の所属ユーザーも閲覧できる
+	if _, err := org.FindMembers(ctx, 3, id); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	events, _ := ctx.GetDB().QueryAuditEvents(ctx.Context(), db.AuditQuery{OrganizationID: db.DefaultOrganizationID, Action: AuditActionOrganizationMemberAdd})
+	if len(events) != 1 || events[0].ActorID != 3 || events[0].TargetID != 1 {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+}
+
+// 他の組織のデータはIDを指定しても取得できない
+func TestTenantIsolation(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "user_a", Password: "password", Name: "1号店ユーザー", Role: auth.RoleEmployee, OrganizationID: 1, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "manager_a", Password: "password", Name: "1号店マネージャー", Role: auth.RoleManager, OrganizationID: 1, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "user_b", Password: "password", Name: "2号店ユーザー", Role: auth.RoleEmployee, OrganizationID: 2, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 4, LoginID: "manager_b", Password: "password", Name: "2号店マネージャー", Role: auth.RoleManager, OrganizationID: 2, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{
+			{ID: 1, OrganizationID: 1, CreatorID: 2, StartDate: "2099-06-01", EndDate: "2099-06-07", Deadline: "2099-05-25 00:00:00", CreatedAt: "2024-05-01 00:00:00"},
+			{ID: 2, OrganizationID: 2, CreatorID: 4, StartDate: "2099-06-01", EndDate: "2099-06-07", Deadline: "2099-05-25 00:00:00", CreatedAt: "2024-05-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{
+			{ID: 1, RequestID: 2, SubmitterID: 3, CreatedAt: "2024-05-10 00:00:00", UpdatedAt: "2024-05-10 00:00:00"},
+		},
+	)
+	if _, err := ctx.GetDB().CreateOrganization(ctx.Context(), "2号店"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var r Request
+	if _, err := r.FindByID(ctx, 1, 2); !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound, got %v", err)
+	}
+	requests, err := r.FindAll(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(requests) != 1 || requests[0].ID != 1 {
+		t.Errorf("unexpected requests: %+v", requests)
+	}
+	page, err := r.FindPage(ctx, RequestFilter{ViewerID: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Requests) != 1 || page.Requests[0].ID != 2 {
+		t.Errorf("unexpected requests: %+v", page.Requests)
+	}
+
+	// 作成したシフトリクエストは作成者の組織に所属する
+	id, err := r.Create(ctx, NewRequest{CreatorID: 4, StartDate: mustNewDateOnly("2099-07-01"), EndDate: mustNewDateOnly("2099-07-01"), Deadline: mustNewDateTime("2099-06-01 00:00:00")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.FindByID(ctx, 2, id); !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound, got %v", err)
+	}
+
+	var s Submission
+	if _, err := s.FindByRequestID(ctx, 2, 2); !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound, got %v", err)
+	}
+	if _, err := s.FindByRequestIDAndSubmitterID(ctx, 2, 1); !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound, got %v", err)
+	}
+	if _, err := s.Create(ctx, NewSubmission{RequestID: 2, SubmitterID: 1}); !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound, got %v", err)
+	}
+
+	var u User
+	if err := u.Unlock(ctx, 2, 3); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := u.IssuePasswordReset(ctx, 2, 3); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	var apiToken APIToken
+	if _, _, err := apiToken.Issue(ctx, 2, NewAPIToken{UserID: 3, Name: "test", Scopes: []string{auth.ScopeRequestsRead}}); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	tokenID, _, err := apiToken.Issue(ctx, 4, NewAPIToken{UserID: 3, Name: "test", Scopes: []string{auth.ScopeRequestsRead}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tokens, err := apiToken.FindAll(ctx, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tokens) != 0 {
+		t.Errorf("tokens of other organizations should not be listed: %+v", tokens)
+	}
+	if err := apiToken.Revoke(ctx, 2, tokenID); !errors.Is(err, db.ErrAPITokenNotFound) {
+		t.Errorf("Expected ErrAPITokenNotFound, got %v", err)
+	}
+
+	// 監査ログは自分の組織のものだけ閲覧できる
+	var audit AuditEvent
+	auditPage, err := audit.FindPage(ctx, 2, AuditFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(auditPage.Events) != 0 {
+		t.Errorf("audit events of other organizations should not be listed: %+v", auditPage.Events)
+	}
+	auditPage, err = audit.FindPage(ctx, 4, AuditFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(auditPage.Events) != 2 {
+		t.Errorf("want 2 audit events, got %+v", auditPage.Events)
+	}
+}
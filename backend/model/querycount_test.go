@@ -0,0 +1,139 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/db"
+	stdcontext "context"
+	"fmt"
+	"testing"
+)
+
+// DBへの問い合わせ回数を数えるためのラッパー
+// 各メソッドの呼び出しを1クエリとして数える
+type countingDB struct {
+	db.DB
+	count int
+}
+
+func (c *countingDB) GetUserByID(ctx stdcontext.Context, id int) (db.User, error) {
+	c.count++
+	return c.DB.GetUserByID(ctx, id)
+}
+
+func (c *countingDB) GetUsersByIDs(ctx stdcontext.Context, ids []int) ([]db.User, error) {
+	c.count++
+	return c.DB.GetUsersByIDs(ctx, ids)
+}
+
+func (c *countingDB) GetUserByLoginID(ctx stdcontext.Context, loginID string) (db.User, error) {
+	c.count++
+	return c.DB.GetUserByLoginID(ctx, loginID)
+}
+
+func (c *countingDB) GetRequests(ctx stdcontext.Context, organizationID int) ([]db.Request, error) {
+	c.count++
+	return c.DB.GetRequests(ctx, organizationID)
+}
+
+func (c *countingDB) QueryRequests(ctx stdcontext.Context, query db.RequestQuery) ([]db.Request, error) {
+	c.count++
+	return c.DB.QueryRequests(ctx, query)
+}
+
+func (c *countingDB) GetRequestByID(ctx stdcontext.Context, id int) (db.Request, error) {
+	c.count++
+	return c.DB.GetRequestByID(ctx, id)
+}
+
+func (c *countingDB) GetEntriesBySubmissionID(ctx stdcontext.Context, submissionID int) ([]db.Entry, error) {
+	c.count++
+	return c.DB.GetEntriesBySubmissionID(ctx, submissionID)
+}
+
+func (c *countingDB) GetEntriesBySubmissionIDs(ctx stdcontext.Context, submissionIDs []int) ([]db.Entry, error) {
+	c.count++
+	return c.DB.GetEntriesBySubmissionIDs(ctx, submissionIDs)
+}
+
+func (c *countingDB) GetSubmissionsByRequestID(ctx stdcontext.Context, requestID int) ([]db.Submission, error) {
+	c.count++
+	return c.DB.GetSubmissionsByRequestID(ctx, requestID)
+}
+
+func (c *countingDB) GetSubmissionByRequestIDAndSubmitterID(ctx stdcontext.Context, requestID int, submitterID int) (*db.Submission, error) {
+	c.count++
+	return c.DB.GetSubmissionByRequestIDAndSubmitterID(ctx, requestID, submitterID)
+}
+
+// n人の従業員がm件のリクエストすべてに提出しているデータでコンテキストを作成
+func newCountingTestContext(n, m int) (*context.AppContext, *countingDB) {
+	users := []db.User{
+		{ID: 1, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+	}
+	for i := 0; i < n; i++ {
+		users = append(users, db.User{ID: i + 2, LoginID: fmt.Sprintf("test_user_%d", i), Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"})
+	}
+
+	var requests []db.Request
+	var submissions []db.Submission
+	var entries []db.Entry
+	for r := 0; r < m; r++ {
+		requests = append(requests, db.Request{ID: r + 1, CreatorID: 1, StartDate: "2024-06-01", EndDate: "2024-06-30", Deadline: "2024-05-25 00:00:00", CreatedAt: "2024-05-20 00:00:00"})
+		for i := 0; i < n; i++ {
+			submissionID := len(submissions) + 1
+			submissions = append(submissions, db.Submission{ID: submissionID, RequestID: r + 1, SubmitterID: i + 2, CreatedAt: "2024-05-21 00:00:00", UpdatedAt: "2024-05-21 00:00:00"})
+			for d := 1; d <= 30; d++ {
+				entries = append(entries, db.Entry{ID: len(entries) + 1, SubmissionID: submissionID, Date: fmt.Sprintf("2024-06-%02d", d), Hour: 9})
+			}
+		}
+	}
+
+	counter := &countingDB{DB: db.NewMockDB(requests, users, entries, submissions)}
+	return context.NewAppContext(counter, nil), counter
+}
+
+func TestFindAllQueryCount(t *testing.T) {
+	for _, m := range []int{1, 10, 50} {
+		ctx, counter := newCountingTestContext(1, m)
+
+		var r Request
+		requests, err := r.FindAll(ctx, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(requests) != m {
+			t.Fatalf("want %d requests, got %d", m, len(requests))
+		}
+
+		// 閲覧者の組織 + リクエスト一覧 + 作成者一覧
+		if counter.count != 3 {
+			t.Errorf("requests=%d: want 3 queries, got %d", m, counter.count)
+		}
+	}
+}
+
+func TestFindByRequestIDQueryCount(t *testing.T) {
+	for _, n := range []int{1, 10, 40} {
+		ctx, counter := newCountingTestContext(n, 1)
+
+		var s Submission
+		submissions, err := s.FindByRequestID(ctx, 1, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(submissions) != n {
+			t.Fatalf("want %d submissions, got %d", n, len(submissions))
+		}
+		for _, submission := range submissions {
+			if len(submission.Entries) != 30 {
+				t.Fatalf("want 30 entries, got %d", len(submission.Entries))
+			}
+		}
+
+		// 閲覧者の組織 + リクエストの存在確認 + 提出一覧 + 提出者一覧 + エントリー一覧
+		if counter.count != 5 {
+			t.Errorf("staff=%d: want 5 queries, got %d", n, counter.count)
+		}
+	}
+}
@@ -3,94 +3,461 @@ package model
 import (
 	"backend/auth"
 	"backend/context"
+	"backend/db"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"slices"
+	"time"
 )
 
 // シフトリクエスト
 type Request struct {
-	ID        int
-	Creator   User
-	StartDate DateOnly
-	EndDate   DateOnly
-	Deadline  DateTime
-	CreatedAt DateTime
+	ID             int
+	OrganizationID int
+	Creator        User
+	StartDate      DateOnly
+	EndDate        DateOnly
+	Deadline       DateTime
+	CreatedAt      DateTime
+
+	// 対象のユーザーとグループ。どちらも空の場合は組織の全員が対象
+	// FindByIDでのみ取得する
+	TargetUsers  []User
+	TargetGroups []Group
 }
 
-func (*Request) FindByID(ctx *context.AppContext, requestID int) (Request, error) {
+// 閲覧ユーザーと同じ組織の、閲覧ユーザーが対象のシフトリクエストを取得する
+// request.create権限を持つユーザーは対象でないシフトリクエストも取得できる
+// 他の組織や対象でないシフトリクエストの場合は、存在を知られないようにdb.ErrRequestNotFoundを返す
+func (*Request) FindByID(ctx *context.AppContext, viewerID int, requestID int) (Request, error) {
+	organizationID, canViewAll, err := requestViewerOf(ctx, viewerID)
+	if err != nil {
+		return Request{}, err
+	}
+
 	// シフトリクエストを取得
-	requestRec, err := ctx.GetDB().GetRequestByID(requestID)
+	requestRec, err := ctx.GetDB().GetRequestByID(ctx.Context(), requestID)
 	if err != nil {
 		return Request{}, err
 	}
+	if requestRec.OrganizationID != organizationID {
+		return Request{}, db.ErrRequestNotFound
+	}
+
+	// 対象を取得し、閲覧ユーザーが対象か確認する
+	targets, err := ctx.GetDB().GetRequestTargets(ctx.Context(), requestID)
+	if err != nil {
+		return Request{}, err
+	}
+	if !canViewAll {
+		isTarget, err := isRequestTarget(ctx, targets, viewerID)
+		if err != nil {
+			return Request{}, err
+		}
+		if !isTarget {
+			return Request{}, db.ErrRequestNotFound
+		}
+	}
 
 	// 作成者ユーザーを取得
-	userRec, err := ctx.GetDB().GetUserByID(requestRec.CreatorID)
+	var user User
+	creator, err := user.FindByID(ctx, requestRec.CreatorID)
 	if err != nil {
 		return Request{}, err
 	}
 
-	// 日付を適切な型に変換
-	start_date, err := NewDateOnly(requestRec.StartDate)
-	end_date, err := NewDateOnly(requestRec.EndDate)
-	deadline, err := NewDateTime(requestRec.Deadline)
-	reqCreated_at, err := NewDateTime(requestRec.CreatedAt)
-	userCreated_at, err := NewDateTime(userRec.CreatedAt)
+	request, err := newRequestFromRecord(requestRec, creator)
 	if err != nil {
 		return Request{}, err
 	}
 
-	return Request{
-		ID: requestRec.ID,
-		Creator: User{
-			ID:        userRec.ID,
-			LoginID:   userRec.LoginID,
-			Password:  userRec.Password,
-			Name:      userRec.Name,
-			Role:      userRec.Role,
-			CreatedAt: userCreated_at,
-		},
-		StartDate: start_date,
-		EndDate:   end_date,
-		Deadline:  deadline,
-		CreatedAt: reqCreated_at,
-	}, nil
+	// 対象のユーザーとグループを取得
+	if len(targets.UserIDs) > 0 {
+		users, err := user.findByIDs(ctx, targets.UserIDs)
+		if err != nil {
+			return Request{}, err
+		}
+		for _, userID := range targets.UserIDs {
+			request.TargetUsers = append(request.TargetUsers, users[userID])
+		}
+	}
+	if len(targets.GroupIDs) > 0 {
+		groupRecs, err := ctx.GetDB().GetGroupsByIDs(ctx.Context(), targets.GroupIDs)
+		if err != nil {
+			return Request{}, err
+		}
+		for _, groupRec := range groupRecs {
+			group, err := newGroupFromRecord(groupRec)
+			if err != nil {
+				return Request{}, err
+			}
+			request.TargetGroups = append(request.TargetGroups, group)
+		}
+	}
+
+	return request, nil
+}
+
+// 閲覧ユーザーと同じ組織の、閲覧ユーザーが対象のシフトリクエストを作成日時の順に全て取得する
+// request.create権限を持つユーザーは対象でないシフトリクエストも取得できる
+func (*Request) FindAll(ctx *context.AppContext, viewerID int) ([]Request, error) {
+	organizationID, canViewAll, err := requestViewerOf(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 組織のシフトリクエストを取得
+	query := db.RequestQuery{OrganizationID: organizationID, Sort: db.RequestSortCreatedAt}
+	if !canViewAll {
+		query.TargetUserID = viewerID
+	}
+	requestRecs, err := ctx.GetDB().QueryRequests(ctx.Context(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRequestsFromRecords(ctx, requestRecs)
+}
+
+// 組織のシフトリクエストを作成日時の新しい順に全て取得する
+// statusはRequestStatusOpen、RequestStatusClosed、または空(すべて)
+// サーバーの管理者がshiftctlから実行するので、権限は確認しない
+func (*Request) FindAllInOrganization(ctx *context.AppContext, organizationID int, status string) ([]Request, error) {
+	filter := RequestFilter{Status: status}
+	query, err := filter.toQuery()
+	if err != nil {
+		return nil, err
+	}
+	query.OrganizationID = organizationID
+	query.Limit = 0
+
+	requestRecs, err := ctx.GetDB().QueryRequests(ctx.Context(), query)
+	if err != nil {
+		return nil, err
+	}
+	return newRequestsFromRecords(ctx, requestRecs)
+}
+
+// シフトリクエストの締め切りを現在時刻にして、受付を終了する
+// 締め切りを過ぎている場合はInputErrorを返す
+// サーバーの管理者がshiftctlから実行するので、権限は確認しない
+func (*Request) Close(ctx *context.AppContext, requestID int) error {
+	requestRec, err := ctx.GetDB().GetRequestByID(ctx.Context(), requestID)
+	if err != nil {
+		return err
+	}
+
+	now := DateTime(time.Now())
+	if requestRec.Deadline <= now.Format() {
+		return NewInputError(errors.New("request already closed"), "既に締め切りを過ぎています")
+	}
+	if err := ctx.GetDB().UpdateRequestDeadline(ctx.Context(), requestID, now.Format()); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		Action:     AuditActionRequestClose,
+		TargetType: AuditTargetRequest,
+		TargetID:   requestID,
+		Before:     map[string]any{"deadline": requestRec.Deadline},
+		After:      map[string]any{"deadline": now.Format()},
+	})
+	return err
+}
+
+// シフトリクエストの状態
+const (
+	RequestStatusOpen   = "open"   // 締切前
+	RequestStatusClosed = "closed" // 締切後
+)
+
+// 並び順
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+const (
+	defaultRequestPageLimit = 20
+	maxRequestPageLimit     = 100
+)
+
+// シフトリクエスト一覧の検索条件
+// ゼロ値の条件は絞り込みに使われない
+type RequestFilter struct {
+	// 一覧を見ているユーザー。HasMySubmissionの判定に使う
+	// 常にこのユーザーの組織の、このユーザーが対象のシフトリクエストに絞り込む
+	// ただし、request.create権限を持つ場合は対象でないシフトリクエストも含める
+	ViewerID int
+
+	// 期間 [From, To] と重なるリクエストに絞り込む
+	From *DateOnly
+	To   *DateOnly
+
+	// RequestStatusOpen または RequestStatusClosed
+	Status string
+
+	CreatorID int
+
+	// 閲覧ユーザーが提出済みかどうかで絞り込む
+	HasMySubmission *bool
+
+	// db.RequestSort* のいずれか。空の場合は作成日時
+	Sort string
+	// OrderAsc または OrderDesc。空の場合は降順
+	Order string
+
+	// 前のページのNextCursor。空の場合は先頭から
+	Cursor string
+	// 1ページの件数。0の場合はデフォルト値
+	Limit int
+}
+
+// シフトリクエスト一覧の1ページ
+type RequestPage struct {
+	Requests []Request
+	// 次のページを取得するためのカーソル。最後のページの場合は空
+	NextCursor string
+}
+
+// ページングのカーソルの中身
+// ソート条件が変わった場合に古いカーソルを弾けるように、ソート条件も含める
+type requestCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func (c requestCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeRequestCursor(s string) (requestCursor, error) {
+	var c requestCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return requestCursor{}, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return requestCursor{}, err
+	}
+	return c, nil
+}
+
+// 検索条件に一致するシフトリクエストを1ページ分取得する
+func (*Request) FindPage(ctx *context.AppContext, filter RequestFilter) (RequestPage, error) {
+	query, err := filter.toQuery()
+	if err != nil {
+		return RequestPage{}, err
+	}
+	organizationID, canViewAll, err := requestViewerOf(ctx, filter.ViewerID)
+	if err != nil {
+		return RequestPage{}, err
+	}
+	query.OrganizationID = organizationID
+	if !canViewAll {
+		query.TargetUserID = filter.ViewerID
+	}
+
+	// 次のページがあるか判定するために1件多く取得する
+	limit := query.Limit
+	query.Limit++
+	requestRecs, err := ctx.GetDB().QueryRequests(ctx.Context(), query)
+	if err != nil {
+		return RequestPage{}, err
+	}
+
+	var nextCursor string
+	if len(requestRecs) > limit {
+		requestRecs = requestRecs[:limit]
+		last := requestRecs[len(requestRecs)-1]
+		nextCursor = requestCursor{
+			Sort:  query.Sort,
+			Order: filter.Order,
+			Value: last.SortValue(query.Sort),
+			ID:    last.ID,
+		}.encode()
+	}
+
+	requests, err := newRequestsFromRecords(ctx, requestRecs)
+	if err != nil {
+		return RequestPage{}, err
+	}
+	return RequestPage{Requests: requests, NextCursor: nextCursor}, nil
 }
 
-func (*Request) FindAll(ctx *context.AppContext) ([]Request, error) {
-	// すべてのシフトリクエストを取得
-	requestRecs, err := ctx.GetDB().GetRequests()
+// 検索条件を検証し、DBの検索条件に変換する
+// 省略された条件にはデフォルト値を入れる
+func (filter *RequestFilter) toQuery() (db.RequestQuery, error) {
+	var query db.RequestQuery
+
+	if filter.From != nil {
+		query.From = filter.From.Format()
+	}
+	if filter.To != nil {
+		query.To = filter.To.Format()
+	}
+	if filter.From != nil && filter.To != nil && !isBeforeOrEqual(*filter.From, *filter.To) {
+		return db.RequestQuery{}, NewInputError(
+			errors.New("must be from <= to"),
+			"期間の開始日 <= 終了日 でなければいけない",
+		)
+	}
+
+	now := DateTime(time.Now())
+	switch filter.Status {
+	case "":
+	case RequestStatusOpen:
+		query.DeadlineAfter = now.Format()
+	case RequestStatusClosed:
+		query.DeadlineNotAfter = now.Format()
+	default:
+		return db.RequestQuery{}, NewInputError(
+			errors.New("invalid status"),
+			"statusはopenまたはclosedでなければいけない",
+		)
+	}
+
+	query.CreatorID = filter.CreatorID
+
+	if filter.HasMySubmission != nil {
+		query.SubmitterID = filter.ViewerID
+		query.HasSubmission = *filter.HasMySubmission
+	}
+
+	switch filter.Sort {
+	case "":
+		filter.Sort = db.RequestSortCreatedAt
+	case db.RequestSortCreatedAt, db.RequestSortStartDate, db.RequestSortDeadline:
+	default:
+		return db.RequestQuery{}, NewInputError(
+			errors.New("invalid sort"),
+			"sortはcreated_at, start_date, deadlineのいずれかでなければいけない",
+		)
+	}
+	query.Sort = filter.Sort
+
+	switch filter.Order {
+	case "":
+		filter.Order = OrderDesc
+	case OrderAsc, OrderDesc:
+	default:
+		return db.RequestQuery{}, NewInputError(
+			errors.New("invalid order"),
+			"orderはascまたはdescでなければいけない",
+		)
+	}
+	query.Desc = filter.Order == OrderDesc
+
+	if filter.Cursor != "" {
+		cursor, err := decodeRequestCursor(filter.Cursor)
+		if err != nil || cursor.Sort != filter.Sort || cursor.Order != filter.Order {
+			return db.RequestQuery{}, NewInputError(
+				errors.New("invalid cursor"),
+				"カーソルが不正です",
+			)
+		}
+		query.After = &db.RequestCursor{SortValue: cursor.Value, ID: cursor.ID}
+	}
+
+	switch {
+	case filter.Limit == 0:
+		query.Limit = defaultRequestPageLimit
+	case 1 <= filter.Limit && filter.Limit <= maxRequestPageLimit:
+		query.Limit = filter.Limit
+	default:
+		return db.RequestQuery{}, NewInputError(
+			errors.New("invalid limit"),
+			"limitは1以上100以下でなければいけない",
+		)
+	}
+
+	return query, nil
+}
+
+// 作成者をまとめて取得し、DBのレコードからモデルを構築する
+func newRequestsFromRecords(ctx *context.AppContext, requestRecs []db.Request) ([]Request, error) {
+	creatorIDs := make([]int, 0, len(requestRecs))
+	for _, rec := range requestRecs {
+		creatorIDs = append(creatorIDs, rec.CreatorID)
+	}
+	var user User
+	creators, err := user.findByIDs(ctx, creatorIDs)
 	if err != nil {
 		return nil, err
 	}
 
 	var requests []Request
 	for _, rec := range requestRecs {
-		var request Request
-		foundRequest, err := request.FindByID(ctx, rec.ID)
+		creator, ok := creators[rec.CreatorID]
+		if !ok {
+			return nil, db.ErrUserNotFound
+		}
+
+		request, err := newRequestFromRecord(rec, creator)
 		if err != nil {
 			return nil, err
 		}
-		requests = append(requests, foundRequest)
+		requests = append(requests, request)
 	}
 
 	return requests, nil
 }
 
+// DBのレコードと作成者からモデルを構築する
+func newRequestFromRecord(requestRec db.Request, creator User) (Request, error) {
+	// 日付を適切な型に変換
+	startDate, err := NewDateOnly(requestRec.StartDate)
+	if err != nil {
+		return Request{}, err
+	}
+	endDate, err := NewDateOnly(requestRec.EndDate)
+	if err != nil {
+		return Request{}, err
+	}
+	deadline, err := NewDateTime(requestRec.Deadline)
+	if err != nil {
+		return Request{}, err
+	}
+	createdAt, err := NewDateTime(requestRec.CreatedAt)
+	if err != nil {
+		return Request{}, err
+	}
+
+	return Request{
+		ID:             requestRec.ID,
+		OrganizationID: requestRec.OrganizationID,
+		Creator:        creator,
+		StartDate:      startDate,
+		EndDate:        endDate,
+		Deadline:       deadline,
+		CreatedAt:      createdAt,
+	}, nil
+}
+
 // リクエスト作成用のコマンド構造体
+// TargetUserIDs, TargetGroupIDsがどちらも空の場合は組織の全員が対象
 type NewRequest struct {
-	CreatorID int
-	StartDate DateOnly
-	EndDate   DateOnly
-	Deadline  DateTime
+	CreatorID      int
+	StartDate      DateOnly
+	EndDate        DateOnly
+	Deadline       DateTime
+	TargetUserIDs  []int
+	TargetGroupIDs []int
 }
 
 func (*Request) Create(ctx *context.AppContext, newRequest NewRequest) (int, error) {
-	// 作成するユーザーがマネージャーであるか確認する
-	isUserManager, err := auth.IsManager(ctx, newRequest.CreatorID)
+	// 作成するユーザーがシフトリクエストを作成できるか確認する
+	allowed, err := auth.Can(ctx, newRequest.CreatorID, auth.PermissionRequestCreate)
 	if err != nil {
 		return -1, err
 	}
-	if !isUserManager {
+	if !allowed {
 		return -1, ErrForbidden
 	}
 
@@ -103,11 +470,137 @@ func (*Request) Create(ctx *context.AppContext, newRequest NewRequest) (int, err
 		)
 	}
 
+	// 作成者の組織のシフトリクエストとして作成する
+	organizationID, err := organizationIDOf(ctx, newRequest.CreatorID)
+	if err != nil {
+		return -1, err
+	}
+
+	// 対象のユーザーとグループは作成者と同じ組織でなければいけない
+	targets := db.RequestTargets{
+		UserIDs:  slices.Compact(slices.Sorted(slices.Values(newRequest.TargetUserIDs))),
+		GroupIDs: slices.Compact(slices.Sorted(slices.Values(newRequest.TargetGroupIDs))),
+	}
+	if len(targets.UserIDs) > 0 {
+		userRecs, err := ctx.GetDB().GetUsersByIDs(ctx.Context(), targets.UserIDs)
+		if err != nil {
+			return -1, err
+		}
+		// 無効化されたユーザーは対象にできない
+		if len(userRecs) != len(targets.UserIDs) || slices.ContainsFunc(userRecs, func(userRec db.User) bool {
+			return userRec.OrganizationID != organizationID || userRec.DeactivatedAt != 0
+		}) {
+			return -1, NewInputError(errors.New("target user not found"), "対象のユーザーが見つかりません")
+		}
+	}
+	if len(targets.GroupIDs) > 0 {
+		groupRecs, err := ctx.GetDB().GetGroupsByIDs(ctx.Context(), targets.GroupIDs)
+		if err != nil {
+			return -1, err
+		}
+		if len(groupRecs) != len(targets.GroupIDs) || slices.ContainsFunc(groupRecs, func(groupRec db.Group) bool { return groupRec.OrganizationID != organizationID }) {
+			return -1, NewInputError(errors.New("target group not found"), "対象のグループが見つかりません")
+		}
+	}
+
 	// dbに作成
-	requestID, err := ctx.GetDB().CreateRequest(newRequest.CreatorID, newRequest.StartDate.Format(), newRequest.EndDate.Format(), newRequest.Deadline.Format())
+	requestID, err := ctx.GetDB().CreateRequest(ctx.Context(), organizationID, newRequest.CreatorID, newRequest.StartDate.Format(), newRequest.EndDate.Format(), newRequest.Deadline.Format(), targets)
+	if err != nil {
+		return -1, err
+	}
+
+	// 監査ログに記録
+	after := map[string]any{
+		"start_date": newRequest.StartDate.Format(),
+		"end_date":   newRequest.EndDate.Format(),
+		"deadline":   newRequest.Deadline.Format(),
+	}
+	if len(targets.UserIDs) > 0 || len(targets.GroupIDs) > 0 {
+		after["target_user_ids"] = targets.UserIDs
+		after["target_group_ids"] = targets.GroupIDs
+	}
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    newRequest.CreatorID,
+		Action:     AuditActionRequestCreate,
+		TargetType: AuditTargetRequest,
+		TargetID:   requestID,
+		After:      after,
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	// Webhookで通知する
+	err = enqueueWebhookEvent(ctx, organizationID, WebhookEventRequestCreated, map[string]any{
+		"request": map[string]any{
+			"id":               requestID,
+			"creator_id":       newRequest.CreatorID,
+			"start_date":       newRequest.StartDate.Format(),
+			"end_date":         newRequest.EndDate.Format(),
+			"deadline":         newRequest.Deadline.Format(),
+			"target_user_ids":  append([]int{}, targets.UserIDs...),
+			"target_group_ids": append([]int{}, targets.GroupIDs...),
+		},
+	})
 	if err != nil {
 		return -1, err
 	}
 
 	return requestID, nil
 }
+
+// 閲覧ユーザーの組織と、対象でないシフトリクエストも閲覧できるかを返す
+// シフトリクエストを作成できるユーザーは、全てのシフトリクエストを閲覧できる
+func requestViewerOf(ctx *context.AppContext, viewerID int) (int, bool, error) {
+	userRec, err := ctx.GetDB().GetUserByID(ctx.Context(), viewerID)
+	if err != nil {
+		return 0, false, err
+	}
+	return userRec.OrganizationID, auth.HasPermission(userRec.Role, auth.PermissionRequestCreate), nil
+}
+
+// シフトリクエストの対象のユーザーIDを、グループのメンバーを展開して返す
+// 対象が指定されていない(全員が対象の)場合はnilを返す
+func expandRequestTargets(ctx *context.AppContext, targets db.RequestTargets) (map[int]bool, error) {
+	if len(targets.UserIDs) == 0 && len(targets.GroupIDs) == 0 {
+		return nil, nil
+	}
+
+	userIDs := make(map[int]bool, len(targets.UserIDs))
+	for _, userID := range targets.UserIDs {
+		userIDs[userID] = true
+	}
+	if len(targets.GroupIDs) > 0 {
+		memberRecs, err := ctx.GetDB().GetGroupMembers(ctx.Context(), targets.GroupIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, memberRec := range memberRecs {
+			userIDs[memberRec.UserID] = true
+		}
+	}
+	return userIDs, nil
+}
+
+// ユーザーがシフトリクエストの対象か判定する
+// 対象が指定されていない場合は全員が対象
+func isRequestTarget(ctx *context.AppContext, targets db.RequestTargets, userID int) (bool, error) {
+	if len(targets.UserIDs) == 0 && len(targets.GroupIDs) == 0 {
+		return true, nil
+	}
+	if slices.Contains(targets.UserIDs, userID) {
+		return true, nil
+	}
+	if len(targets.GroupIDs) == 0 {
+		return false, nil
+	}
+
+	groupIDs, err := ctx.GetDB().GetGroupIDsByUserID(ctx.Context(), userID)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(groupIDs, func(groupID int) bool {
+		return slices.Contains(targets.GroupIDs, groupID)
+	}), nil
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/event"
+	"cmp"
+	"slices"
+	"strconv"
+)
+
+// シフトリクエストの詳細画面に送るイベント
+const (
+	RequestEventSubmissionCreated = "submission.created"
+	RequestEventSubmissionUpdated = "submission.updated"
+	// 日時ごとの提出数の増減
+	RequestEventCoverageDelta = "coverage.delta"
+)
+
+// シフトリクエストのイベントを購読する
+// submission.view_all権限を持ち、シフトリクエストを閲覧できるユーザーのみ購読できる
+// 閲覧できないシフトリクエストの場合はdb.ErrRequestNotFoundを返す
+func (r *Request) Subscribe(ctx *context.AppContext, viewerID int, requestID int) (*event.Subscription, error) {
+	allowed, err := auth.Can(ctx, viewerID, auth.PermissionSubmissionViewAll)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+	if _, err := r.FindByID(ctx, viewerID, requestID); err != nil {
+		return nil, err
+	}
+	return ctx.GetEventHub().Subscribe(requestTopic(requestID)), nil
+}
+
+func requestTopic(requestID int) string {
+	return "request:" + strconv.Itoa(requestID)
+}
+
+// 提出の作成を、シフトリクエストの購読者に送る
+func publishSubmissionCreated(ctx *context.AppContext, requestID int, submissionID int, submitter User, newEntries []NewEntry) {
+	publishSubmission(ctx, RequestEventSubmissionCreated, requestID, submissionID, submitter, nil, newEntries)
+}
+
+// 提出の変更を、シフトリクエストの購読者に送る
+// 提出数の増減は変更前のエントリーとの差分
+func publishSubmissionUpdated(ctx *context.AppContext, requestID int, submissionID int, submitter User, before []NewEntry, after []NewEntry) {
+	publishSubmission(ctx, RequestEventSubmissionUpdated, requestID, submissionID, submitter, before, after)
+}
+
+func publishSubmission(ctx *context.AppContext, name string, requestID int, submissionID int, submitter User, before []NewEntry, after []NewEntry) {
+	entries := make([]map[string]any, 0, len(after))
+	for _, newEntry := range after {
+		entries = append(entries, map[string]any{
+			"date": newEntry.Date.Format(),
+			"hour": newEntry.Hour,
+		})
+	}
+	hub := ctx.GetEventHub()
+	topic := requestTopic(requestID)
+	hub.Publish(topic, event.Event{
+		Name: name,
+		Data: map[string]any{
+			"request_id": requestID,
+			"submission": map[string]any{
+				"id":        submissionID,
+				"submitter": map[string]any{"id": submitter.ID, "name": submitter.Name},
+				"entries":   entries,
+			},
+		},
+	})
+	hub.Publish(topic, event.Event{
+		Name: RequestEventCoverageDelta,
+		Data: map[string]any{
+			"request_id": requestID,
+			"changes":    coverageDelta(before, after),
+		},
+	})
+}
+
+// 提出のエントリーがbeforeからafterに変わったときの、日時ごとの提出数の増減を日時の順に返す
+// 増減がない日時は含めない
+func coverageDelta(before []NewEntry, after []NewEntry) []map[string]any {
+	type slot struct {
+		date string
+		hour int
+	}
+	deltas := make(map[slot]int)
+	for _, entry := range before {
+		deltas[slot{entry.Date.Format(), entry.Hour}]--
+	}
+	for _, entry := range after {
+		deltas[slot{entry.Date.Format(), entry.Hour}]++
+	}
+
+	slots := make([]slot, 0, len(deltas))
+	for s, delta := range deltas {
+		if delta != 0 {
+			slots = append(slots, s)
+		}
+	}
+	slices.SortFunc(slots, func(a, b slot) int {
+		return cmp.Or(cmp.Compare(a.date, b.date), cmp.Compare(a.hour, b.hour))
+	})
+
+	changes := make([]map[string]any, 0, len(slots))
+	for _, s := range slots {
+		changes = append(changes, map[string]any{"date": s.date, "hour": s.hour, "delta": deltas[s]})
+	}
+	return changes
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/db"
+	"errors"
+	"testing"
+)
+
+func TestRequestSubscribe(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "test_admin", Password: "password", Name: "テスト管理者", Role: auth.RoleAdmin, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 4, LoginID: "other_manager", Password: "password", Name: "2号店マネージャー", Role: auth.RoleManager, OrganizationID: 2, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{
+			{ID: 1, CreatorID: 2, StartDate: "2099-06-10", EndDate: "2099-06-16", Deadline: "2099-06-03 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	if _, err := ctx.GetDB().CreateOrganization(ctx.Context(), "2号店"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var r Request
+	// submission.view_all権限がない
+	if _, err := r.Subscribe(ctx, 3, 1); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for user without submission.view_all, got %v", err)
+	}
+	// 他の組織、存在しないシフトリクエスト
+	if _, err := r.Subscribe(ctx, 4, 1); !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound for other organization, got %v", err)
+	}
+	if _, err := r.Subscribe(ctx, 2, 999); !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound for nonexistent request, got %v", err)
+	}
+
+	sub, err := r.Subscribe(ctx, 2, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sub.Close()
+
+	// 提出すると、提出と日時ごとの提出数の増減が届く
+	var s Submission
+	submissionID, err := s.Create(ctx, NewSubmission{
+		RequestID:   1,
+		SubmitterID: 1,
+		NewEntries: []NewEntry{
+			{Date: mustNewDateOnly("2099-06-11"), Hour: 9},
+			{Date: mustNewDateOnly("2099-06-10"), Hour: 18},
+			{Date: mustNewDateOnly("2099-06-10"), Hour: 9},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	created := <-sub.Events()
+	if created.Name != RequestEventSubmissionCreated {
+		t.Fatalf("unexpected event: %+v", created)
+	}
+	submission := created.Data.(map[string]any)["submission"].(map[string]any)
+	assert(t, submission["id"], submissionID)
+	assert(t, submission["submitter"], map[string]any{"id": 1, "name": "テストユーザー"})
+
+	delta := <-sub.Events()
+	assert(t, delta.Name, RequestEventCoverageDelta)
+	assert(t, delta.Data, map[string]any{
+		"request_id": 1,
+		"changes": []map[string]any{
+			{"date": "2099-06-10", "hour": 9, "delta": 1},
+			{"date": "2099-06-10", "hour": 18, "delta": 1},
+			{"date": "2099-06-11", "hour": 9, "delta": 1},
+		},
+	})
+}
+
+func TestCoverageDelta(t *testing.T) {
+	before := []NewEntry{
+		{Date: mustNewDateOnly("2099-06-10"), Hour: 9},
+		{Date: mustNewDateOnly("2099-06-10"), Hour: 10},
+	}
+	after := []NewEntry{
+		{Date: mustNewDateOnly("2099-06-10"), Hour: 10},
+		{Date: mustNewDateOnly("2099-06-10"), Hour: 11},
+	}
+	// 変わらない日時は含めない
+	assert(t, coverageDelta(before, after), []map[string]any{
+		{"date": "2099-06-10", "hour": 9, "delta": -1},
+		{"date": "2099-06-10", "hour": 11, "delta": 1},
+	})
+	assert(t, coverageDelta(before, before), []map[string]any{})
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"backend/auth"
 	"backend/db"
+	"errors"
 	"testing"
 )
 
@@ -21,26 +22,27 @@ func TestGetRequestByID(t *testing.T) {
 	var r Request
 
 	// 正常系
-	got, err := r.FindByID(ctx, 1)
+	got, err := r.FindByID(ctx, 2, 1)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	want := Request{
-		ID:        1,
-		Creator:   User{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: mustNewDateTime("2024-06-01 00:00:00")},
-		StartDate: mustNewDateOnly("2024-06-01"),
-		EndDate:   mustNewDateOnly("2024-06-01"),
-		Deadline:  mustNewDateTime("2024-06-01 00:00:00"),
-		CreatedAt: mustNewDateTime("2024-06-01 00:00:00"),
+		ID:             1,
+		OrganizationID: db.DefaultOrganizationID,
+		Creator:        User{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, OrganizationID: db.DefaultOrganizationID, CreatedAt: mustNewDateTime("2024-06-01 00:00:00")},
+		StartDate:      mustNewDateOnly("2024-06-01"),
+		EndDate:        mustNewDateOnly("2024-06-01"),
+		Deadline:       mustNewDateTime("2024-06-01 00:00:00"),
+		CreatedAt:      mustNewDateTime("2024-06-01 00:00:00"),
 	}
 
 	assert(t, got, want)
 
 	// 異常系
-	_, err = r.FindByID(ctx, 999)
-	if err == nil {
-		t.Fatalf("expected error")
+	_, err = r.FindByID(ctx, 2, 999)
+	if !errors.Is(err, db.ErrRequestNotFound) {
+		t.Fatalf("expected ErrRequestNotFound, got %v", err)
 	}
 }
 
@@ -58,24 +60,78 @@ func TestGetRequests(t *testing.T) {
 	)
 
 	var r Request
-	got, err := r.FindAll(ctx)
+	got, err := r.FindAll(ctx, 2)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	want := []Request{
-		{ID: 1, Creator: User{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: mustNewDateTime("2024-06-01 00:00:00")}, StartDate: mustNewDateOnly("2024-06-01"), EndDate: mustNewDateOnly("2024-06-01"), Deadline: mustNewDateTime("2024-06-01 00:00:00"), CreatedAt: mustNewDateTime("2024-06-01 00:00:00")},
-		{ID: 2, Creator: User{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: mustNewDateTime("2024-06-01 00:00:00")}, StartDate: mustNewDateOnly("2024-06-01"), EndDate: mustNewDateOnly("2024-06-01"), Deadline: mustNewDateTime("2024-06-01 00:00:00"), CreatedAt: mustNewDateTime("2024-06-01 00:00:00")},
+		{ID: 1, OrganizationID: db.DefaultOrganizationID, Creator: User{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, OrganizationID: db.DefaultOrganizationID, CreatedAt: mustNewDateTime("2024-06-01 00:00:00")}, StartDate: mustNewDateOnly("2024-06-01"), EndDate: mustNewDateOnly("2024-06-01"), Deadline: mustNewDateTime("2024-06-01 00:00:00"), CreatedAt: mustNewDateTime("2024-06-01 00:00:00")},
+		{ID: 2, OrganizationID: db.DefaultOrganizationID, Creator: User{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, OrganizationID: db.DefaultOrganizationID, CreatedAt: mustNewDateTime("2024-06-01 00:00:00")}, StartDate: mustNewDateOnly("2024-06-01"), EndDate: mustNewDateOnly("2024-06-01"), Deadline: mustNewDateTime("2024-06-01 00:00:00"), CreatedAt: mustNewDateTime("2024-06-01 00:00:00")},
 	}
 
 	assert(t, got, want)
 }
 
+func TestCloseRequest(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{
+			{ID: 1, CreatorID: 2, StartDate: "2024-06-01", EndDate: "2024-06-01", Deadline: "2024-06-01 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, CreatorID: 2, StartDate: "2099-06-01", EndDate: "2099-06-07", Deadline: "2099-05-25 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+
+	var r Request
+
+	open, err := r.FindAllInOrganization(ctx, db.DefaultOrganizationID, RequestStatusOpen)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(open) != 1 || open[0].ID != 2 {
+		t.Errorf("unexpected open requests: %+v", open)
+	}
+
+	// 締め切りを過ぎたもの、存在しないもの
+	if err := r.Close(ctx, 1); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for closed request, got %v", err)
+	}
+	if err := r.Close(ctx, 999); !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound, got %v", err)
+	}
+
+	// 正常系
+	if err := r.Close(ctx, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	closed, err := r.FindAllInOrganization(ctx, db.DefaultOrganizationID, RequestStatusClosed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(closed) != 2 {
+		t.Errorf("all requests should be closed: %+v", closed)
+	}
+	events, _ := ctx.GetDB().QueryAuditEvents(ctx.Context(), db.AuditQuery{OrganizationID: db.DefaultOrganizationID, Action: AuditActionRequestClose})
+	if len(events) != 1 || events[0].ActorID != 0 || events[0].TargetID != 2 {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+
+	// 不正なstatus
+	if _, err := r.FindAllInOrganization(ctx, db.DefaultOrganizationID, "unknown"); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for invalid status, got %v", err)
+	}
+}
+
 func TestCreateRequest(t *testing.T) {
 	ctx := newTestContext(
 		[]db.User{
 			{ID: 1, LoginID: "test_user", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee},
 			{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager},
+			{ID: 3, LoginID: "test_leader", Password: "password", Name: "テストリーダー", Role: auth.RoleShiftLeader},
 		},
 		[]db.Request{},
 		[]db.Entry{},
@@ -93,6 +149,11 @@ func TestCreateRequest(t *testing.T) {
 	want := 1
 	assert(t, got, want)
 
+	// 正常系: シフトリーダーも作成できる
+	if _, err := r.Create(ctx, NewRequest{CreatorID: 3, StartDate: mustNewDateOnly("2024-06-01"), EndDate: mustNewDateOnly("2024-06-01"), Deadline: mustNewDateTime("2024-06-01 00:00:00")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
 	// 異常系
 
 	// 作成者が存在しない場合
@@ -118,4 +179,245 @@ func TestCreateRequest(t *testing.T) {
 	if err == nil {
 		t.Fatalf("expected error")
 	}
+
+	// 無効化されたユーザーを対象にした場合
+	if err := ctx.GetDB().DeactivateUser(ctx.Context(), 1, 1717200000); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = r.Create(ctx, NewRequest{CreatorID: 2, StartDate: mustNewDateOnly("2024-06-01"), EndDate: mustNewDateOnly("2024-06-01"), Deadline: mustNewDateTime("2024-06-01 00:00:00"), TargetUserIDs: []int{1}})
+	if _, ok := err.(InputError); !ok {
+		t.Fatalf("expected InputError, got %v", err)
+	}
+}
+
+func TestFindRequestPage(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager1", Password: "password", Name: "テストマネージャー1", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "test_manager2", Password: "password", Name: "テストマネージャー2", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{
+			{ID: 1, CreatorID: 2, StartDate: "2024-06-01", EndDate: "2024-06-07", Deadline: "2024-05-25 00:00:00", CreatedAt: "2024-05-01 00:00:00"},
+			{ID: 2, CreatorID: 3, StartDate: "2024-06-08", EndDate: "2024-06-14", Deadline: "2024-06-01 00:00:00", CreatedAt: "2024-05-03 00:00:00"},
+			{ID: 3, CreatorID: 2, StartDate: "2024-06-15", EndDate: "2024-06-21", Deadline: "2099-06-08 00:00:00", CreatedAt: "2024-05-02 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{
+			{ID: 1, RequestID: 2, SubmitterID: 1, CreatedAt: "2024-05-10 00:00:00", UpdatedAt: "2024-05-10 00:00:00"},
+		},
+	)
+
+	ids := func(page RequestPage) []int {
+		ids := []int{}
+		for _, request := range page.Requests {
+			ids = append(ids, request.ID)
+		}
+		return ids
+	}
+
+	from := mustNewDateOnly("2024-06-07")
+	to := mustNewDateOnly("2024-06-10")
+	yes, no := true, false
+
+	tests := []struct {
+		name   string
+		filter RequestFilter
+		want   []int
+	}{
+		{"デフォルトは作成日時の降順", RequestFilter{ViewerID: 1}, []int{2, 3, 1}},
+		{"開始日の昇順", RequestFilter{ViewerID: 1, Sort: db.RequestSortStartDate, Order: OrderAsc}, []int{1, 2, 3}},
+		{"締切の降順", RequestFilter{ViewerID: 1, Sort: db.RequestSortDeadline}, []int{3, 2, 1}},
+		{"期間が重なるもの", RequestFilter{ViewerID: 1, From: &from, To: &to}, []int{2, 1}},
+		{"期間の開始のみ", RequestFilter{ViewerID: 1, From: &to}, []int{2, 3}},
+		{"締切前", RequestFilter{ViewerID: 1, Status: RequestStatusOpen}, []int{3}},
+		{"締切後", RequestFilter{ViewerID: 1, Status: RequestStatusClosed}, []int{2, 1}},
+		{"作成者", RequestFilter{ViewerID: 1, CreatorID: 2}, []int{3, 1}},
+		{"提出済み", RequestFilter{ViewerID: 1, HasMySubmission: &yes}, []int{2}},
+		{"未提出", RequestFilter{ViewerID: 1, HasMySubmission: &no}, []int{3, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var r Request
+			page, err := r.FindPage(ctx, test.filter)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assert(t, ids(page), test.want)
+			if page.NextCursor != "" {
+				t.Errorf("want no next cursor, got %q", page.NextCursor)
+			}
+		})
+	}
+
+	t.Run("ページング", func(t *testing.T) {
+		var r Request
+		filter := RequestFilter{ViewerID: 1, Sort: db.RequestSortStartDate, Order: OrderAsc, Limit: 2}
+		page, err := r.FindPage(ctx, filter)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert(t, ids(page), []int{1, 2})
+		if page.NextCursor == "" {
+			t.Fatalf("want next cursor")
+		}
+
+		filter.Cursor = page.NextCursor
+		page, err = r.FindPage(ctx, filter)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert(t, ids(page), []int{3})
+		if page.NextCursor != "" {
+			t.Errorf("want no next cursor, got %q", page.NextCursor)
+		}
+
+		// ソート条件が変わった場合はカーソルを使えない
+		filter.Order = OrderDesc
+		_, err = r.FindPage(ctx, filter)
+		if _, ok := err.(InputError); !ok {
+			t.Errorf("Expected InputError for cursor with different order, got %v", err)
+		}
+	})
+
+	t.Run("不正な検索条件", func(t *testing.T) {
+		for _, filter := range []RequestFilter{
+			{From: &to, To: &from},
+			{Status: "unknown"},
+			{Sort: "id"},
+			{Order: "up"},
+			{Cursor: "!!"},
+			{Limit: -1},
+			{Limit: 101},
+		} {
+			var r Request
+			_, err := r.FindPage(ctx, filter)
+			if _, ok := err.(InputError); !ok {
+				t.Errorf("Expected InputError for %+v, got %v", filter, err)
+			}
+		}
+	})
+}
+
+func TestTargetedRequest(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "kitchen", Password: "password", Name: "キッチン担当", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "hall", Password: "password", Name: "ホール担当", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 4, LoginID: "other_user", Password: "password", Name: "2号店ユーザー", Role: auth.RoleEmployee, OrganizationID: 2, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+
+	var g Group
+	kitchenID, err := g.Create(ctx, 3, "キッチン")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := g.AddMember(ctx, 3, kitchenID, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var r Request
+	newRequest := func(userIDs []int, groupIDs []int) NewRequest {
+		return NewRequest{
+			CreatorID:      3,
+			StartDate:      mustNewDateOnly("2099-06-01"),
+			EndDate:        mustNewDateOnly("2099-06-07"),
+			Deadline:       mustNewDateTime("2099-05-25 00:00:00"),
+			TargetUserIDs:  userIDs,
+			TargetGroupIDs: groupIDs,
+		}
+	}
+
+	// 対象が存在しない、または他の組織の場合
+	for _, invalid := range []NewRequest{
+		newRequest([]int{999}, nil),
+		newRequest([]int{4}, nil),
+		newRequest(nil, []int{999}),
+	} {
+		if _, err := r.Create(ctx, invalid); !errors.As(err, new(InputError)) {
+			t.Errorf("Expected InputError for %+v, got %v", invalid, err)
+		}
+	}
+
+	// 全員が対象のリクエストと、キッチンだけが対象のリクエスト
+	allID, err := r.Create(ctx, newRequest(nil, nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kitchenRequestID, err := r.Create(ctx, newRequest(nil, []int{kitchenID}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hallRequestID, err := r.Create(ctx, newRequest([]int{2}, nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ids := func(requests []Request) []int {
+		ids := []int{}
+		for _, request := range requests {
+			ids = append(ids, request.ID)
+		}
+		return ids
+	}
+
+	// 対象でないリクエストは一覧に含まれない
+	for _, test := range []struct {
+		viewerID int
+		want     []int
+	}{
+		{1, []int{allID, kitchenRequestID}},
+		{2, []int{allID, hallRequestID}},
+		{3, []int{allID, kitchenRequestID, hallRequestID}},
+	} {
+		requests, err := r.FindAll(ctx, test.viewerID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert(t, ids(requests), test.want)
+
+		page, err := r.FindPage(ctx, RequestFilter{ViewerID: test.viewerID, Order: OrderAsc})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert(t, ids(page.Requests), test.want)
+	}
+
+	// 対象でないリクエストは存在しないものとして扱う
+	if _, err := r.FindByID(ctx, 2, kitchenRequestID); !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound, got %v", err)
+	}
+
+	// 詳細には対象が含まれる
+	request, err := r.FindByID(ctx, 1, kitchenRequestID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(request.TargetUsers) != 0 || len(request.TargetGroups) != 1 || request.TargetGroups[0].Name != "キッチン" {
+		t.Errorf("unexpected targets: %+v, %+v", request.TargetUsers, request.TargetGroups)
+	}
+	request, err = r.FindByID(ctx, 3, hallRequestID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(request.TargetUsers) != 1 || request.TargetUsers[0].ID != 2 || len(request.TargetGroups) != 0 {
+		t.Errorf("unexpected targets: %+v, %+v", request.TargetUsers, request.TargetGroups)
+	}
+
+	// 対象でないユーザーは提出できない
+	var s Submission
+	if _, err := s.Create(ctx, NewSubmission{RequestID: kitchenRequestID, SubmitterID: 2}); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+	if _, err := s.Create(ctx, NewSubmission{RequestID: kitchenRequestID, SubmitterID: 1}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := s.Create(ctx, NewSubmission{RequestID: hallRequestID, SubmitterID: 2}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
 }
@@ -3,7 +3,14 @@ package model
 import (
 	"backend/auth"
 	"backend/context"
+	"backend/db"
+	"encoding/csv"
 	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 type Submission struct {
@@ -16,16 +23,94 @@ type Submission struct {
 	UpdatedAt   DateTime
 }
 
-func (*Submission) FindByRequestID(ctx *context.AppContext, requestID int) ([]Submission, error) {
-	// シフトリクエストIDが存在するかチェック
-	var request Request
-	_, err := request.FindByID(ctx, requestID)
+// 閲覧ユーザーと同じ組織のシフトリクエストへの提出を全て取得する
+func (*Submission) FindByRequestID(ctx *context.AppContext, viewerID int, requestID int) ([]Submission, error) {
+	// シフトリクエストIDが閲覧ユーザーの組織に存在するかチェック
+	// 作成者は不要なのでDBから直接取得する
+	_, err := findRequestInSameOrganization(ctx, viewerID, requestID)
 	if err != nil {
 		return nil, err
 	}
+	return findSubmissionsByRequestID(ctx, requestID)
+}
+
+// シフトリクエストへの提出を全て取得する
+// サーバーの管理者がshiftctlでエクスポートするために使うので、権限は確認しない
+func (*Submission) FindAllByRequestID(ctx *context.AppContext, requestID int) ([]Submission, error) {
+	if _, err := ctx.GetDB().GetRequestByID(ctx.Context(), requestID); err != nil {
+		return nil, err
+	}
+	return findSubmissionsByRequestID(ctx, requestID)
+}
+
+// エクスポートするために、閲覧ユーザーと同じ組織のシフトリクエストへの提出を全て取得する
+// 全員の提出内容を含むので、submission.view_all権限を持つユーザーのみ取得できる
+func (s *Submission) FindForExport(ctx *context.AppContext, viewerID int, requestID int) ([]Submission, error) {
+	allowed, err := auth.Can(ctx, viewerID, auth.PermissionSubmissionViewAll)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+	return s.FindByRequestID(ctx, viewerID, requestID)
+}
 
+// 提出をCSVで書き出す. 1行に1エントリーを出力する
+// shiftctl exportとGET /requests/{id}/exportで同じ形式を使う
+func WriteSubmissionsCSV(w io.Writer, submissions []Submission) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"request_id", "submission_id", "login_id", "name", "date", "hour"})
+	for _, s := range submissions {
+		for _, e := range s.Entries {
+			cw.Write([]string{
+				strconv.Itoa(s.RequestID),
+				strconv.Itoa(s.ID),
+				escapeCSVFormula(s.Submitter.LoginID),
+				escapeCSVFormula(s.Submitter.Name),
+				e.Date.Format(),
+				strconv.Itoa(e.Hour),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// 表計算ソフトで数式として解釈される文字で始まる値の先頭に'を付ける
+func escapeCSVFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// シフトリクエストへの提出を、提出者とエントリーを合わせて全て取得する
+// 閲覧できるかのチェックは呼び出し元で行う
+func findSubmissionsByRequestID(ctx *context.AppContext, requestID int) ([]Submission, error) {
 	// DBから提出一覧を取得
-	submissionRecs, err := ctx.GetDB().GetSubmissionsByRequestID(requestID)
+	submissionRecs, err := ctx.GetDB().GetSubmissionsByRequestID(ctx.Context(), requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 提出者とエントリーをまとめて取得
+	submitterIDs := make([]int, 0, len(submissionRecs))
+	submissionIDs := make([]int, 0, len(submissionRecs))
+	for _, submissionRec := range submissionRecs {
+		submitterIDs = append(submitterIDs, submissionRec.SubmitterID)
+		submissionIDs = append(submissionIDs, submissionRec.ID)
+	}
+
+	var user User
+	submitters, err := user.findByIDs(ctx, submitterIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// entryは内部構造体なのでそのまま関数を使用
+	var e entry
+	entries, err := e.findBySubmissionIDs(ctx, submissionIDs)
 	if err != nil {
 		return nil, err
 	}
@@ -42,25 +127,17 @@ func (*Submission) FindByRequestID(ctx *context.AppContext, requestID int) ([]Su
 			return nil, err
 		}
 
-		var user User
-		user, err = user.FindByID(ctx, submissionRec.SubmitterID)
-		if err != nil {
-			return nil, err
-		}
-
-		// entryは内部構造体なのでそのまま関数を使用
-		var e entry
-		entries, err := e.findBySubmissionID(ctx, submissionRec.ID)
-		if err != nil {
-			return nil, err
+		submitter, ok := submitters[submissionRec.SubmitterID]
+		if !ok {
+			return nil, db.ErrUserNotFound
 		}
 
 		submissions = append(submissions, Submission{
 			ID:          submissionRec.ID,
 			RequestID:   submissionRec.RequestID,
 			SubmitterID: submissionRec.SubmitterID,
-			Submitter:   user,
-			Entries:     entries,
+			Submitter:   submitter,
+			Entries:     entries[submissionRec.ID],
 			CreatedAt:   createdAt,
 			UpdatedAt:   updatedAt,
 		})
@@ -69,33 +146,128 @@ func (*Submission) FindByRequestID(ctx *context.AppContext, requestID int) ([]Su
 	return submissions, nil
 }
 
+// シフトリクエストに対するユーザーごとの提出状況
+// 未提出の場合はSubmissionがnil
+type SubmissionStatus struct {
+	User       User
+	Submission *Submission
+}
+
+// シフトリクエストの対象で提出できるユーザー全員の提出状況を、ユーザーID順に取得する
+// 対象でなくなったユーザーや組織を移ったユーザーでも、提出済みの場合は含める
+// submission.view_status権限を持つユーザーのみ実行できる
+func (*Submission) FindStatusesByRequestID(ctx *context.AppContext, viewerID int, requestID int) ([]SubmissionStatus, error) {
+	allowed, err := auth.Can(ctx, viewerID, auth.PermissionSubmissionViewStatus)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+
+	requestRec, err := findRequestInSameOrganization(ctx, viewerID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	return findSubmissionStatuses(ctx, requestRec)
+}
+
+// シフトリクエストの提出状況を取得する
+// 閲覧できるかのチェックは呼び出し元で行う
+func findSubmissionStatuses(ctx *context.AppContext, requestRec db.Request) ([]SubmissionStatus, error) {
+	requestID := requestRec.ID
+	submissions, err := findSubmissionsByRequestID(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+	submissionBySubmitterID := make(map[int]*Submission, len(submissions))
+	for i := range submissions {
+		submissionBySubmitterID[submissions[i].SubmitterID] = &submissions[i]
+	}
+
+	targets, err := ctx.GetDB().GetRequestTargets(ctx.Context(), requestID)
+	if err != nil {
+		return nil, err
+	}
+	targetUserIDs, err := expandRequestTargets(ctx, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	userRecs, err := ctx.GetDB().GetUsersByOrganizationID(ctx.Context(), requestRec.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]SubmissionStatus, 0, len(userRecs))
+	for _, userRec := range userRecs {
+		submission, submitted := submissionBySubmitterID[userRec.ID]
+		// 無効化されたユーザーは、提出済みでなければ未提出として扱わない(通知も送らない)
+		isTarget := (targetUserIDs == nil || targetUserIDs[userRec.ID]) && userRec.DeactivatedAt == 0
+		if !submitted && !(isTarget && auth.HasPermission(userRec.Role, auth.PermissionSubmissionCreate)) {
+			continue
+		}
+
+		user, err := newUserFromRecord(userRec)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, SubmissionStatus{User: user, Submission: submission})
+		delete(submissionBySubmitterID, userRec.ID)
+	}
+
+	// 組織を移ったユーザーの提出
+	for i := range submissions {
+		if _, ok := submissionBySubmitterID[submissions[i].SubmitterID]; ok {
+			statuses = append(statuses, SubmissionStatus{User: submissions[i].Submitter, Submission: &submissions[i]})
+		}
+	}
+	slices.SortFunc(statuses, func(a, b SubmissionStatus) int {
+		return a.User.ID - b.User.ID
+	})
+
+	return statuses, nil
+}
+
 func (*Submission) FindByRequestIDAndSubmitterID(ctx *context.AppContext, requestID, submitterID int) (*Submission, error) {
-	// シフトリクエストIDが存在するかチェック
-	var request Request
-	_, err := request.FindByID(ctx, requestID)
+	// シフトリクエストIDが提出者の組織に存在するかチェック
+	_, err := findRequestInSameOrganization(ctx, submitterID, requestID)
 	if err != nil {
 		return nil, err
 	}
 
-	// 提出者が従業員であるか確認する
+	// 提出者がシフトを提出できるユーザーであるか確認する
 	var user User
 	user, err = user.FindByID(ctx, submitterID)
 	if err != nil {
 		return nil, err
 	}
-	if user.Role != auth.RoleEmployee {
+	if !auth.HasPermission(user.Role, auth.PermissionSubmissionCreate) {
 		return nil, ErrForbidden
 	}
 
-	// DBから提出を取得
-	submissionRec, err := ctx.GetDB().GetSubmissionByRequestIDAndSubmitterID(requestID, submitterID)
+	// 対象でないシフトリクエストは、存在を知られないようにdb.ErrRequestNotFoundを返す
+	targets, err := ctx.GetDB().GetRequestTargets(ctx.Context(), requestID)
+	if err != nil {
+		return nil, err
+	}
+	isTarget, err := isRequestTarget(ctx, targets, submitterID)
 	if err != nil {
 		return nil, err
 	}
+	if !isTarget {
+		return nil, db.ErrRequestNotFound
+	}
 
-	if submissionRec == nil {
+	// DBから提出を取得
+	// 未提出の場合はnilを返す
+	submissionRec, err := ctx.GetDB().GetSubmissionByRequestIDAndSubmitterID(ctx.Context(), requestID, submitterID)
+	if errors.Is(err, db.ErrSubmissionNotFound) {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	var e entry
 	entries, err := e.findBySubmissionID(ctx, submissionRec.ID)
@@ -130,37 +302,256 @@ type NewSubmission struct {
 }
 
 func (*Submission) Create(ctx *context.AppContext, newSubmission NewSubmission) (int, error) {
-	// 提出者が従業員であるか確認する
+	// 提出者がシフトを提出できるか確認する
+	// 複数のロールを持つユーザーもいるので、ロールの一致ではなく権限で判定する
 	var user User
 	foundUser, err := user.FindByID(ctx, newSubmission.SubmitterID)
 	if err != nil {
 		return 0, err
 	}
-	if foundUser.Role != auth.RoleEmployee {
+	if !auth.HasPermission(foundUser.Role, auth.PermissionSubmissionCreate) {
 		return 0, ErrForbidden
 	}
 
-	// シフトリクエストIDが存在するか確認する
-	var request Request
-	foundRequest, err := request.FindByID(ctx, newSubmission.RequestID)
+	// シフトリクエストIDが提出者の組織に存在するか確認する
+	// 作成者は不要なのでDBから直接取得する
+	requestRec, err := findRequestInSameOrganization(ctx, newSubmission.SubmitterID, newSubmission.RequestID)
+	if err != nil {
+		return 0, err
+	}
+	foundRequest, err := newRequestFromRecord(requestRec, User{})
 	if err != nil {
 		return 0, err
 	}
 
+	// 対象が指定されている場合は、対象のユーザーしか提出できない
+	targets, err := ctx.GetDB().GetRequestTargets(ctx.Context(), newSubmission.RequestID)
+	if err != nil {
+		return 0, err
+	}
+	isTarget, err := isRequestTarget(ctx, targets, newSubmission.SubmitterID)
+	if err != nil {
+		return 0, err
+	}
+	if !isTarget {
+		return 0, ErrForbidden
+	}
+
 	// 提出済みの場合はエラー
-	subRec, err := ctx.GetDB().GetSubmissionByRequestIDAndSubmitterID(newSubmission.RequestID, newSubmission.SubmitterID)
+	_, err = ctx.GetDB().GetSubmissionByRequestIDAndSubmitterID(ctx.Context(), newSubmission.RequestID, newSubmission.SubmitterID)
+	if err == nil {
+		return 0, ErrAlreadySubmitted
+	}
+	if !errors.Is(err, db.ErrSubmissionNotFound) {
+		return 0, err
+	}
+
+	// エントリーのvalidation
+	if err := validateNewEntries(foundRequest, newSubmission.NewEntries); err != nil {
+		return 0, err
+	}
+
+	// DBに提出を作成
+	submissionID, err := ctx.GetDB().CreateSubmission(ctx.Context(), newSubmission.SubmitterID, newSubmission.RequestID)
+	if err != nil {
+		return 0, err
+	}
+
+	// エントリーを作成
+	var e entry
+	_, err = e.create(ctx, submissionID, newSubmission.NewEntries)
+	if err != nil {
+		return 0, err
+	}
+
+	// 監査ログに記録
+	entries := entryStates(newSubmission.NewEntries)
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    newSubmission.SubmitterID,
+		Action:     AuditActionSubmissionCreate,
+		TargetType: AuditTargetSubmission,
+		TargetID:   submissionID,
+		After: map[string]any{
+			"request_id": newSubmission.RequestID,
+			"entries":    entries,
+		},
+	})
 	if err != nil {
 		return 0, err
 	}
-	if subRec != nil {
-		return 0, errors.New("already submitted")
+
+	// Webhookで通知する
+	err = enqueueWebhookEvent(ctx, requestRec.OrganizationID, WebhookEventSubmissionCreated, map[string]any{
+		"submission": map[string]any{
+			"id":           submissionID,
+			"request_id":   newSubmission.RequestID,
+			"submitter_id": newSubmission.SubmitterID,
+			"entries":      entries,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// 詳細画面を開いているユーザーに送る
+	publishSubmissionCreated(ctx, newSubmission.RequestID, submissionID, foundUser, newSubmission.NewEntries)
+
+	return submissionID, nil
+}
+
+type SubmissionUpdate struct {
+	RequestID    int
+	SubmissionID int
+	EditorID     int
+	NewEntries   []NewEntry
+}
+
+// 提出のエントリーを全て置き換える
+// 提出者本人は、提出と同じ条件(submission.create権限を持ち、シフトリクエストの対象)で変更できる
+// 他のユーザーの提出は、submission.edit_all権限を持つ同じ組織のユーザーだけが変更でき、提出者に通知する
+// 閲覧できないシフトリクエストの場合はdb.ErrRequestNotFound、シフトリクエストへの提出でない場合はdb.ErrSubmissionNotFoundを返す
+func (*Submission) Update(ctx *context.AppContext, update SubmissionUpdate) error {
+	// シフトリクエストIDが変更者の組織に存在するか確認する
+	requestRec, err := findRequestInSameOrganization(ctx, update.EditorID, update.RequestID)
+	if err != nil {
+		return err
+	}
+	foundRequest, err := newRequestFromRecord(requestRec, User{})
+	if err != nil {
+		return err
+	}
+
+	submissionRec, err := ctx.GetDB().GetSubmissionByID(ctx.Context(), update.SubmissionID)
+	if err != nil {
+		return err
+	}
+	if submissionRec.RequestID != update.RequestID {
+		return db.ErrSubmissionNotFound
+	}
+
+	var user User
+	editor, err := user.FindByID(ctx, update.EditorID)
+	if err != nil {
+		return err
+	}
+	submitter := editor
+	if submissionRec.SubmitterID == update.EditorID {
+		// 本人の場合は、提出できる条件を満たしているか確認する
+		if !auth.HasPermission(editor.Role, auth.PermissionSubmissionCreate) {
+			return ErrForbidden
+		}
+		targets, err := ctx.GetDB().GetRequestTargets(ctx.Context(), update.RequestID)
+		if err != nil {
+			return err
+		}
+		isTarget, err := isRequestTarget(ctx, targets, update.EditorID)
+		if err != nil {
+			return err
+		}
+		if !isTarget {
+			return ErrForbidden
+		}
+	} else {
+		if !auth.HasPermission(editor.Role, auth.PermissionSubmissionEditAll) {
+			return ErrForbidden
+		}
+		submitter, err = user.FindByID(ctx, submissionRec.SubmitterID)
+		if err != nil {
+			return err
+		}
 	}
 
 	// エントリーのvalidation
-	for _, entry := range newSubmission.NewEntries {
+	if err := validateNewEntries(foundRequest, update.NewEntries); err != nil {
+		return err
+	}
+
+	// 提出数の増減と監査ログのために、変更前のエントリーを取得する
+	var e entry
+	oldEntries, err := e.findBySubmissionID(ctx, update.SubmissionID)
+	if err != nil {
+		return err
+	}
+	before := make([]NewEntry, 0, len(oldEntries))
+	for _, oldEntry := range oldEntries {
+		before = append(before, NewEntry{Date: oldEntry.Date, Hour: oldEntry.Hour})
+	}
+
+	// DBのエントリーを置き換える
+	entryRecs := make([]db.Entry, 0, len(update.NewEntries))
+	for _, newEntry := range update.NewEntries {
+		entryRecs = append(entryRecs, db.Entry{
+			SubmissionID: update.SubmissionID,
+			Date:         newEntry.Date.Format(),
+			Hour:         newEntry.Hour,
+		})
+	}
+	if err := ctx.GetDB().ReplaceSubmissionEntries(ctx.Context(), update.SubmissionID, entryRecs); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	entries := entryStates(update.NewEntries)
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    update.EditorID,
+		Action:     AuditActionSubmissionUpdate,
+		TargetType: AuditTargetSubmission,
+		TargetID:   update.SubmissionID,
+		Before: map[string]any{
+			"entries": entryStates(before),
+		},
+		After: map[string]any{
+			"request_id": update.RequestID,
+			"entries":    entries,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Webhookで通知する
+	err = enqueueWebhookEvent(ctx, requestRec.OrganizationID, WebhookEventSubmissionUpdated, map[string]any{
+		"submission": map[string]any{
+			"id":           update.SubmissionID,
+			"request_id":   update.RequestID,
+			"submitter_id": submissionRec.SubmitterID,
+			"editor_id":    update.EditorID,
+			"entries":      entries,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 他のユーザーが変更した場合は、提出者に知らせる
+	if submissionRec.SubmitterID != update.EditorID {
+		var notification UserNotification
+		_, err = notification.Create(ctx, NewUserNotification{
+			UserID:    submissionRec.SubmitterID,
+			Kind:      UserNotificationKindSubmissionEdited,
+			RequestID: update.RequestID,
+			Title:     "提出したシフトが変更されました",
+			Body:      fmt.Sprintf("%sさんが%s〜%sのシフトの提出を変更しました", editor.Name, foundRequest.StartDate.Format(), foundRequest.EndDate.Format()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// 詳細画面を開いているユーザーに送る
+	publishSubmissionUpdated(ctx, update.RequestID, update.SubmissionID, submitter, before, update.NewEntries)
+
+	return nil
+}
+
+// エントリーがシフトリクエストの範囲内か確認する
+func validateNewEntries(request Request, newEntries []NewEntry) error {
+	for _, entry := range newEntries {
 		// 日付のvalidation
-		if !isBeforeOrEqual(foundRequest.StartDate, entry.Date) || !isBeforeOrEqual(entry.Date, foundRequest.EndDate) {
-			return 0, NewInputError(
+		if !isBeforeOrEqual(request.StartDate, entry.Date) || !isBeforeOrEqual(entry.Date, request.EndDate) {
+			return NewInputError(
 				errors.New("date must be within request range"),
 				"日付はリクエストの範囲内でなければいけない",
 			)
@@ -168,25 +559,23 @@ func (*Submission) Create(ctx *context.AppContext, newSubmission NewSubmission)
 
 		// 0 <= hour <= 23 でなければいけない
 		if !(0 <= entry.Hour && entry.Hour <= 23) {
-			return 0, NewInputError(
+			return NewInputError(
 				errors.New("must be 0 <= hour <= 23"),
 				"0 <= 時間 <= 23 でなければいけない",
 			)
 		}
 	}
+	return nil
+}
 
-	// DBに提出を作成
-	submissionID, err := ctx.GetDB().CreateSubmission(newSubmission.SubmitterID, newSubmission.RequestID)
-	if err != nil {
-		return 0, err
-	}
-
-	// エントリーを作成
-	var e entry
-	_, err = e.create(ctx, submissionID, newSubmission.NewEntries)
-	if err != nil {
-		return 0, err
+// 監査ログとWebhookに含めるエントリーの内容
+func entryStates(newEntries []NewEntry) []map[string]any {
+	entries := make([]map[string]any, 0, len(newEntries))
+	for _, newEntry := range newEntries {
+		entries = append(entries, map[string]any{
+			"date": newEntry.Date.Format(),
+			"hour": newEntry.Hour,
+		})
 	}
-
-	return submissionID, nil
+	return entries
 }
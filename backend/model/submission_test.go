@@ -4,6 +4,8 @@ import (
 	"backend/auth"
 	"backend/context"
 	"backend/db"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -30,13 +32,13 @@ func TestGetSubmissionsByRequestID(t *testing.T) {
 	var s Submission
 
 	// シフトリクエストIDが存在しない場合のテスト
-	_, err := s.FindByRequestID(ctx, 123)
-	if err == nil {
-		t.Errorf("Expected error for non-existent request ID, got nil")
+	_, err := s.FindByRequestID(ctx, 1, 123)
+	if !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound for non-existent request ID, got %v", err)
 	}
 
 	// シフトリクエストIDが存在する場合のテスト
-	submissions, err := s.FindByRequestID(ctx, 1)
+	submissions, err := s.FindByRequestID(ctx, 1, 1)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -47,12 +49,13 @@ func TestGetSubmissionsByRequestID(t *testing.T) {
 			RequestID:   1,
 			SubmitterID: 2,
 			Submitter: User{
-				ID:        2,
-				LoginID:   "test_user_2",
-				Password:  "password2",
-				Name:      "テストユーザー2",
-				Role:      auth.RoleEmployee,
-				CreatedAt: mustNewDateTime("2023-01-01 00:00:00"),
+				ID:             2,
+				LoginID:        "test_user_2",
+				Password:       "password2",
+				Name:           "テストユーザー2",
+				Role:           auth.RoleEmployee,
+				OrganizationID: db.DefaultOrganizationID,
+				CreatedAt:      mustNewDateTime("2023-01-01 00:00:00"),
 			},
 			Entries: []entry{
 				{ID: 1, SubmissionID: 1, Date: mustNewDateOnly("2024-06-01"), Hour: 8},
@@ -65,12 +68,13 @@ func TestGetSubmissionsByRequestID(t *testing.T) {
 			RequestID:   1,
 			SubmitterID: 3,
 			Submitter: User{
-				ID:        3,
-				LoginID:   "test_user_3",
-				Password:  "password3",
-				Name:      "テストユーザー3",
-				Role:      auth.RoleEmployee,
-				CreatedAt: mustNewDateTime("2023-01-03 00:00:00"),
+				ID:             3,
+				LoginID:        "test_user_3",
+				Password:       "password3",
+				Name:           "テストユーザー3",
+				Role:           auth.RoleEmployee,
+				OrganizationID: db.DefaultOrganizationID,
+				CreatedAt:      mustNewDateTime("2023-01-03 00:00:00"),
 			},
 			Entries: []entry{
 				{ID: 2, SubmissionID: 2, Date: mustNewDateOnly("2024-06-01"), Hour: 9},
@@ -89,6 +93,7 @@ func createSubmissionTestContext() *context.AppContext {
 			{ID: 1, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
 			{ID: 2, LoginID: "test_employee", Password: "password", Name: "テスト従業員", Role: auth.RoleEmployee, CreatedAt: "2023-01-01 00:00:00"},
 			{ID: 3, LoginID: "test_client", Password: "password", Name: "テストクライアント", Role: auth.RoleManager, CreatedAt: "2023-01-02 00:00:00"},
+			{ID: 4, LoginID: "test_both", Password: "password", Name: "テスト兼任", Role: auth.RoleEmployee | auth.RoleManager, CreatedAt: "2023-01-03 00:00:00"},
 		},
 		[]db.Request{
 			{ID: 1, CreatorID: 1, StartDate: "2024-06-01", EndDate: "2024-06-07", Deadline: "2024-05-30 00:00:00", CreatedAt: "2024-05-20 00:00:00"},
@@ -132,8 +137,8 @@ func TestCreateSubmission2(t *testing.T) {
 			{Date: mustNewDateOnly("2024-06-01"), Hour: 9},
 		},
 	})
-	if err == nil {
-		t.Errorf("Expected error for non-existent request ID, got nil")
+	if !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound for non-existent request ID, got %v", err)
 	}
 }
 
@@ -180,8 +185,8 @@ func TestCreateSubmission4(t *testing.T) {
 			{Date: mustNewDateOnly("2024-06-01"), Hour: 10},
 		},
 	})
-	if err == nil {
-		t.Errorf("Expected error for already submitted request, got nil")
+	if !errors.Is(err, ErrAlreadySubmitted) {
+		t.Errorf("Expected ErrAlreadySubmitted, got %v", err)
 	}
 }
 
@@ -219,6 +224,23 @@ func TestCreateSubmission6(t *testing.T) {
 	}
 }
 
+// TestCreateSubmission7 正常系: 従業員とマネージャーを兼ねるユーザーも提出できる
+func TestCreateSubmission7(t *testing.T) {
+	ctx := createSubmissionTestContext()
+
+	var s Submission
+	_, err := s.Create(ctx, NewSubmission{
+		RequestID:   1,
+		SubmitterID: 4,
+		NewEntries: []NewEntry{
+			{Date: mustNewDateOnly("2024-06-01"), Hour: 9},
+		},
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
 func TestFindByRequestIDAndSubmitterID(t *testing.T) {
 	// テスト用のコンテキストを作成
 	ctx := newTestContext(
@@ -257,12 +279,13 @@ func TestFindByRequestIDAndSubmitterID(t *testing.T) {
 			RequestID:   1,
 			SubmitterID: 2,
 			Submitter: User{
-				ID:        2,
-				LoginID:   "test_user_2",
-				Password:  "password2",
-				Name:      "テストユーザー2",
-				Role:      auth.RoleEmployee,
-				CreatedAt: mustNewDateTime("2023-01-01 00:00:00"),
+				ID:             2,
+				LoginID:        "test_user_2",
+				Password:       "password2",
+				Name:           "テストユーザー2",
+				Role:           auth.RoleEmployee,
+				OrganizationID: db.DefaultOrganizationID,
+				CreatedAt:      mustNewDateTime("2023-01-01 00:00:00"),
 			},
 			Entries: []entry{
 				{ID: 1, SubmissionID: 1, Date: mustNewDateOnly("2024-06-01"), Hour: 8},
@@ -277,8 +300,8 @@ func TestFindByRequestIDAndSubmitterID(t *testing.T) {
 	t.Run("存在しないリクエストID", func(t *testing.T) {
 		var s Submission
 		_, err := s.FindByRequestIDAndSubmitterID(ctx, 999, 2)
-		if err == nil {
-			t.Errorf("Expected error for non-existent request ID, got nil")
+		if !errors.Is(err, db.ErrRequestNotFound) {
+			t.Errorf("Expected ErrRequestNotFound for non-existent request ID, got %v", err)
 		}
 	})
 
@@ -323,3 +346,213 @@ func TestFindByRequestIDAndSubmitterID(t *testing.T) {
 		}
 	})
 }
+
+func TestFindStatusesByRequestID(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_user_2", Password: "password", Name: "テストユーザー2", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "test_user_3", Password: "password", Name: "テストユーザー3", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 4, LoginID: "test_leader", Password: "password", Name: "テストリーダー", Role: auth.RoleShiftLeader, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 5, LoginID: "other_user", Password: "password", Name: "2号店ユーザー", Role: auth.RoleEmployee, OrganizationID: 2, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{
+			{ID: 1, CreatorID: 1, StartDate: "2099-06-01", EndDate: "2099-06-07", Deadline: "2099-05-25 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, CreatorID: 1, StartDate: "2099-06-01", EndDate: "2099-06-07", Deadline: "2099-05-25 00:00:00", CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Entry{
+			{ID: 1, SubmissionID: 1, Date: "2099-06-01", Hour: 8},
+			{ID: 2, SubmissionID: 1, Date: "2099-06-01", Hour: 9},
+			{ID: 3, SubmissionID: 2, Date: "2099-06-02", Hour: 10},
+		},
+		[]db.Submission{
+			{ID: 1, RequestID: 1, SubmitterID: 2, CreatedAt: "2024-06-02 00:00:00", UpdatedAt: "2024-06-03 00:00:00"},
+			{ID: 2, RequestID: 2, SubmitterID: 2, CreatedAt: "2024-06-02 00:00:00", UpdatedAt: "2024-06-02 00:00:00"},
+		},
+	)
+	if _, err := ctx.GetDB().CreateOrganization(ctx.Context(), "2号店"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// シフトリクエスト2はユーザー3だけが対象
+	if err := ctx.GetDB().CreateRequestTargets(ctx.Context(), 2, db.RequestTargets{UserIDs: []int{3}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var s Submission
+
+	// 権限がない
+	if _, err := s.FindStatusesByRequestID(ctx, 2, 1); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+	// 存在しないシフトリクエスト
+	if _, err := s.FindStatusesByRequestID(ctx, 1, 999); !errors.Is(err, db.ErrRequestNotFound) {
+		t.Errorf("Expected ErrRequestNotFound, got %v", err)
+	}
+
+	type status struct {
+		UserID     int
+		Submitted  bool
+		EntryCount int
+	}
+	toStatuses := func(statuses []SubmissionStatus) []status {
+		got := []status{}
+		for _, st := range statuses {
+			s := status{UserID: st.User.ID}
+			if st.Submission != nil {
+				s.Submitted = true
+				s.EntryCount = len(st.Submission.Entries)
+			}
+			got = append(got, s)
+		}
+		return got
+	}
+
+	// 提出できるユーザー全員が含まれ、マネージャーと他の組織のユーザーは含まれない
+	statuses, err := s.FindStatusesByRequestID(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert(t, toStatuses(statuses), []status{{2, true, 2}, {3, false, 0}, {4, false, 0}})
+	if statuses[0].Submission.UpdatedAt.Format() != "2024-06-03 00:00:00" {
+		t.Errorf("unexpected updated at: %v", statuses[0].Submission.UpdatedAt.Format())
+	}
+
+	// 対象のユーザーだけが含まれ、対象でなくても提出済みのユーザーは含まれる
+	statuses, err = s.FindStatusesByRequestID(ctx, 4, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert(t, toStatuses(statuses), []status{{2, true, 1}, {3, false, 0}})
+
+	// 無効化されたユーザーは、提出済みの場合だけ含まれる
+	for _, userID := range []int{2, 3} {
+		if err := ctx.GetDB().DeactivateUser(ctx.Context(), userID, 1717200000); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	statuses, err = s.FindStatusesByRequestID(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert(t, toStatuses(statuses), []status{{2, true, 2}, {4, false, 0}})
+	statuses, err = s.FindStatusesByRequestID(ctx, 4, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert(t, toStatuses(statuses), []status{{2, true, 1}})
+}
+
+func TestUpdateSubmission(t *testing.T) {
+	ctx := createSubmissionTestContext()
+
+	var w Webhook
+	webhookID, _, err := w.Create(ctx, 1, NewWebhook{URL: "https://example.com/hook", Events: []string{WebhookEventSubmissionUpdated}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var s Submission
+	submissionID, err := s.Create(ctx, NewSubmission{
+		RequestID:   1,
+		SubmitterID: 2,
+		NewEntries:  []NewEntry{{Date: mustNewDateOnly("2024-06-01"), Hour: 9}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	otherID, err := s.Create(ctx, NewSubmission{RequestID: 1, SubmitterID: 4, NewEntries: []NewEntry{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var r Request
+	sub, err := r.Subscribe(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sub.Close()
+
+	// 本人が変更する
+	err = s.Update(ctx, SubmissionUpdate{
+		RequestID:    1,
+		SubmissionID: submissionID,
+		EditorID:     2,
+		NewEntries:   []NewEntry{{Date: mustNewDateOnly("2024-06-01"), Hour: 10}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated := <-sub.Events()
+	assert(t, updated.Name, RequestEventSubmissionUpdated)
+	delta := <-sub.Events()
+	assert(t, delta.Data, map[string]any{
+		"request_id": 1,
+		"changes": []map[string]any{
+			{"date": "2024-06-01", "hour": 9, "delta": -1},
+			{"date": "2024-06-01", "hour": 10, "delta": 1},
+		},
+	})
+
+	// 他のユーザーの提出はsubmission.edit_all権限が必要
+	if err := s.Update(ctx, SubmissionUpdate{RequestID: 1, SubmissionID: otherID, EditorID: 2}); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+	// シフトリクエストへの提出でない、範囲外の日付
+	if err := s.Update(ctx, SubmissionUpdate{RequestID: 999, SubmissionID: submissionID, EditorID: 2}); !errors.Is(err, db.ErrSubmissionNotFound) {
+		t.Errorf("Expected ErrSubmissionNotFound, got %v", err)
+	}
+	err = s.Update(ctx, SubmissionUpdate{RequestID: 1, SubmissionID: submissionID, EditorID: 2, NewEntries: []NewEntry{{Date: mustNewDateOnly("2024-06-08"), Hour: 9}}})
+	if !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError, got %v", err)
+	}
+
+	// マネージャーが変更すると、提出者に通知する
+	err = s.Update(ctx, SubmissionUpdate{
+		RequestID:    1,
+		SubmissionID: submissionID,
+		EditorID:     1,
+		NewEntries:   []NewEntry{{Date: mustNewDateOnly("2024-06-02"), Hour: 10}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated = <-sub.Events()
+	assert(t, updated.Data.(map[string]any)["submission"].(map[string]any)["submitter"], map[string]any{"id": 2, "name": "テスト従業員"})
+	<-sub.Events()
+
+	submission, err := s.FindByRequestIDAndSubmitterID(ctx, 1, 2)
+	if err != nil || len(submission.Entries) != 1 || submission.Entries[0].Date.Format() != "2024-06-02" {
+		t.Errorf("unexpected submission: %+v, %v", submission, err)
+	}
+
+	var n UserNotification
+	page, err := n.FindPage(ctx, 2, UserNotificationFilter{})
+	if err != nil || len(page.Notifications) != 1 || page.Notifications[0].Kind != UserNotificationKindSubmissionEdited || page.Notifications[0].RequestID != 1 {
+		t.Errorf("unexpected notifications: %+v, %v", page, err)
+	}
+
+	deliveries, err := w.FindDeliveries(ctx, 1, webhookID, WebhookDeliveryFilter{})
+	if err != nil || len(deliveries.Deliveries) != 2 || deliveries.Deliveries[0].Event != WebhookEventSubmissionUpdated {
+		t.Errorf("unexpected deliveries: %+v, %v", deliveries, err)
+	}
+}
+
+func TestWriteSubmissionsCSV(t *testing.T) {
+	submissions := []Submission{
+		{ID: 1, RequestID: 1, Submitter: User{LoginID: "test_user", Name: "=HYPERLINK(\"http://example.com\")"}, Entries: []entry{{Date: mustNewDateOnly("2024-06-01"), Hour: 8}}},
+		{ID: 2, RequestID: 1, Submitter: User{LoginID: "@user", Name: "-1+1"}, Entries: []entry{{Date: mustNewDateOnly("2024-06-01"), Hour: 9}}},
+		{ID: 3, RequestID: 1, Submitter: User{LoginID: "user3", Name: "\t+1"}, Entries: []entry{{Date: mustNewDateOnly("2024-06-02"), Hour: 10}}},
+	}
+
+	var b strings.Builder
+	if err := WriteSubmissionsCSV(&b, submissions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 数式として解釈される値は先頭に'を付ける
+	want := "request_id,submission_id,login_id,name,date,hour\n" +
+		"1,1,test_user,\"'=HYPERLINK(\"\"http://example.com\"\")\",2024-06-01,8\n" +
+		"1,2,'@user,'-1+1,2024-06-01,9\n" +
+		"1,3,user3,'\t+1,2024-06-02,10\n"
+	assert(t, b.String(), want)
+}
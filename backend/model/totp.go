@@ -0,0 +1,103 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/context"
+	"errors"
+)
+
+// 二要素認証の登録用の情報
+// Secretは認証アプリに手入力する場合に使う
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// 二要素認証の登録を開始する
+// 既に登録済みの場合はInputErrorを返す
+func (*User) BeginTOTPEnrollment(ctx *context.AppContext, userID int) (TOTPEnrollment, error) {
+	secret, uri, err := auth.BeginTOTPEnrollment(ctx, userID)
+	if err != nil {
+		return TOTPEnrollment{}, totpInputError(err)
+	}
+	return TOTPEnrollment{Secret: secret, URI: uri}, nil
+}
+
+// 二要素認証の登録を完了し、リカバリーコードを返す
+// コードが一致しない場合はInputErrorを返す
+func (*User) ConfirmTOTPEnrollment(ctx *context.AppContext, userID int, code string) ([]string, error) {
+	codes, err := auth.ConfirmTOTPEnrollment(ctx, userID, code)
+	if err != nil {
+		return nil, totpInputError(err)
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    userID,
+		Action:     AuditActionTOTPEnable,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// リカバリーコードを発行し直す
+// 発行済みのリカバリーコードは使えなくなる
+func (*User) RegenerateRecoveryCodes(ctx *context.AppContext, userID int, code string) ([]string, error) {
+	codes, err := auth.RegenerateRecoveryCodes(ctx, userID, code)
+	if err != nil {
+		return nil, totpInputError(err)
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    userID,
+		Action:     AuditActionRecoveryCodes,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// 二要素認証を解除する
+// 二要素認証が必須のユーザーの場合はErrForbiddenを返す
+func (*User) DisableTOTP(ctx *context.AppContext, userID int, code string) error {
+	err := auth.DisableTOTP(ctx, userID, code)
+	if errors.Is(err, auth.ErrTOTPRequired) {
+		return ErrForbidden
+	}
+	if err != nil {
+		return totpInputError(err)
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    userID,
+		Action:     AuditActionTOTPDisable,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+	})
+	return err
+}
+
+// 利用者の操作の誤りによるエラーをInputErrorに変換する
+func totpInputError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidTOTPCode):
+		return NewInputError(err, "認証コードが正しくありません")
+	case errors.Is(err, auth.ErrTOTPNotEnrolled):
+		return NewInputError(err, "二要素認証が登録されていません")
+	case errors.Is(err, auth.ErrTOTPAlreadyEnabled):
+		return NewInputError(err, "二要素認証は既に登録されています")
+	}
+	return err
+}
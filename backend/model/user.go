@@ -1,34 +1,423 @@
 package model
 
-import "backend/context"
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/db"
+	"errors"
+	"strings"
+	"time"
+)
 
 type User struct {
-	ID        int
-	LoginID   string
-	Password  string
-	Name      string
-	Role      int
+	ID             int
+	LoginID        string
+	Password       string
+	Name           string
+	Role           int
+	OrganizationID int
+	// 通知の送信先。未設定の場合は空文字
+	Email     string
 	CreatedAt DateTime
 }
 
 func (*User) FindByID(ctx *context.AppContext, userID int) (User, error) {
 	// ユーザーIDが見つからない時はエラーを返す
-	userRec, err := ctx.GetDB().GetUserByID(userID)
+	userRec, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
 	if err != nil {
 		return User{}, err
 	}
 
+	return newUserFromRecord(userRec)
+}
+
+// ログイン失敗によるアカウントのロックを解除する
+// user.manage権限を持つユーザーのみ実行できる
+func (*User) Unlock(ctx *context.AppContext, actorID int, userID int) error {
+	allowed, err := auth.Can(ctx, actorID, auth.PermissionUserManage)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrForbidden
+	}
+
+	// 他の組織のユーザーは操作できない
+	userRec, err := findUserInSameOrganization(ctx, actorID, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := auth.Unlock(ctx, userRec.LoginID); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionUserUnlock,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+	})
+	return err
+}
+
+// パスワードリセット用に発行したトークン
+// Tokenはこの時にしか取得できない
+type PasswordResetToken struct {
+	Token     string
+	ExpiresAt DateTime
+}
+
+// 自分のパスワードを変更する
+// 現在のパスワードが一致しない場合や、新しいパスワードが条件を満たさない場合はInputErrorを返す
+// 変更すると、このユーザーの既存のセッションはすべて無効になる
+func (*User) ChangePassword(ctx *context.AppContext, userID int, currentPassword string, newPassword string) error {
+	userRec, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	// 失敗が続いている場合は*auth.ThrottledErrorを返す
+	if err := auth.VerifyPassword(ctx, userRec, currentPassword); err != nil {
+		if errors.Is(err, auth.ErrIncorrectAuth) {
+			return NewInputError(err, "現在のパスワードが間違っています")
+		}
+		return err
+	}
+
+	if err := setPassword(ctx, userID, newPassword); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    userID,
+		Action:     AuditActionPasswordChange,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+	})
+	return err
+}
+
+// パスワードリセット用のワンタイムトークンを発行する
+// user.manage権限を持つユーザーのみ、自分の権限の範囲内のユーザーに対して実行できる
+func (*User) IssuePasswordReset(ctx *context.AppContext, actorID int, userID int) (PasswordResetToken, error) {
+	allowed, err := auth.Can(ctx, actorID, auth.PermissionUserManage)
+	if err != nil {
+		return PasswordResetToken{}, err
+	}
+	if !allowed {
+		return PasswordResetToken{}, ErrForbidden
+	}
+
+	// 他の組織のユーザーは操作できない
+	target, err := findUserInSameOrganization(ctx, actorID, userID)
+	if err != nil {
+		return PasswordResetToken{}, err
+	}
+	// 自分より多くの権限を持つユーザーを乗っ取れないように、自分の権限の範囲内のユーザーに限る
+	actor, err := ctx.GetDB().GetUserByID(ctx.Context(), actorID)
+	if err != nil {
+		return PasswordResetToken{}, err
+	}
+	if !auth.CanManageRole(actor.Role, target.Role) {
+		return PasswordResetToken{}, ErrForbidden
+	}
+
+	token, hash, expiresAt, err := auth.NewResetToken()
+	if err != nil {
+		return PasswordResetToken{}, err
+	}
+	err = ctx.GetDB().CreatePasswordReset(ctx.Context(), db.PasswordReset{
+		TokenHash: hash,
+		UserID:    userID,
+		CreatorID: actorID,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return PasswordResetToken{}, err
+	}
+
+	// 監査ログに記録。トークンは記録しない
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionPasswordIssue,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		After: map[string]any{
+			"expires_at": expiresAt.Unix(),
+		},
+	})
+	if err != nil {
+		return PasswordResetToken{}, err
+	}
+
+	return PasswordResetToken{
+		Token:     token,
+		ExpiresAt: DateTime(expiresAt),
+	}, nil
+}
+
+// パスワードリセット用のトークンを使ってパスワードを設定し直す
+// トークンが存在しない、期限切れ、使用済みの場合はInputErrorを返す
+// 成功すると、このユーザーの既存のセッションはすべて無効になり、ログインのロックも解除される
+func (*User) ResetPassword(ctx *context.AppContext, token string, newPassword string) error {
+	invalidToken := NewInputError(
+		errors.New("invalid password reset token"),
+		"トークンが無効か、有効期限が切れています",
+	)
+
+	hash := auth.HashResetToken(token)
+	reset, err := ctx.GetDB().GetPasswordReset(ctx.Context(), hash)
+	if errors.Is(err, db.ErrPasswordResetNotFound) {
+		return invalidToken
+	}
+	if err != nil {
+		return err
+	}
+	if reset.UsedAt != 0 || !time.Now().Before(time.Unix(reset.ExpiresAt, 0)) {
+		return invalidToken
+	}
+
+	// パスワードが条件を満たさない場合はトークンを消費しない
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	// 同じトークンが同時に使われた場合に備えて、使用済みへの更新に成功した場合だけ続ける
+	err = ctx.GetDB().UsePasswordReset(ctx.Context(), hash, time.Now().Unix())
+	if errors.Is(err, db.ErrPasswordResetNotFound) {
+		return invalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := setPassword(ctx, reset.UserID, newPassword); err != nil {
+		return err
+	}
+
+	userRec, err := ctx.GetDB().GetUserByID(ctx.Context(), reset.UserID)
+	if err != nil {
+		return err
+	}
+	if err := auth.Unlock(ctx, userRec.LoginID); err != nil {
+		return err
+	}
+
+	// 監査ログに記録。操作したのはトークンを受け取った本人とみなす
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    reset.UserID,
+		Action:     AuditActionPasswordReset,
+		TargetType: AuditTargetUser,
+		TargetID:   reset.UserID,
+		After: map[string]any{
+			"issued_by": reset.CreatorID,
+		},
+	})
+	return err
+}
+
+// ログインIDでユーザーを取得する
+func (*User) FindByLoginID(ctx *context.AppContext, loginID string) (User, error) {
+	userRec, err := ctx.GetDB().GetUserByLoginID(ctx.Context(), loginID)
+	if err != nil {
+		return User{}, err
+	}
+
+	return newUserFromRecord(userRec)
+}
+
+// ユーザー作成用のコマンド構造体
+type NewUser struct {
+	LoginID  string
+	Name     string
+	Password string
+	Role     int
+	// 0の場合はデフォルトの組織
+	OrganizationID int
+	// 未設定の場合は空文字
+	Email string
+}
+
+// ユーザーを作成する
+// サーバーの管理者がshiftctlから実行するので、権限は確認しない. 監査ログの操作したユーザーは0(不明)になる
+func (*User) Create(ctx *context.AppContext, newUser NewUser) (int, error) {
+	if newUser.LoginID == "" || newUser.Name == "" {
+		return -1, NewInputError(errors.New("login_id and name are required"), "ログインIDと名前は必須です")
+	}
+	if newUser.Role == 0 {
+		return -1, NewInputError(errors.New("role is required"), "ロールを1つ以上指定してください")
+	}
+	if err := validatePassword(newUser.Password); err != nil {
+		return -1, err
+	}
+
+	if newUser.OrganizationID == 0 {
+		newUser.OrganizationID = db.DefaultOrganizationID
+	}
+	if _, err := ctx.GetDB().GetOrganizationByID(ctx.Context(), newUser.OrganizationID); err != nil {
+		return -1, err
+	}
+
+	hash, err := auth.HashPassword(newUser.Password)
+	if err != nil {
+		return -1, err
+	}
+	userID, err := ctx.GetDB().CreateUser(ctx.Context(), db.User{
+		LoginID:        newUser.LoginID,
+		Password:       hash,
+		Name:           newUser.Name,
+		Role:           newUser.Role,
+		Email:          newUser.Email,
+		OrganizationID: newUser.OrganizationID,
+	})
+	if errors.Is(err, db.ErrUserAlreadyExists) {
+		return -1, NewInputError(err, "ログインIDかメールアドレスが既に使われています")
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	// 監査ログに記録。パスワードは記録しない
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		Action:     AuditActionUserCreate,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		After: map[string]any{
+			"login_id":        newUser.LoginID,
+			"name":            newUser.Name,
+			"roles":           auth.RoleNames(newUser.Role),
+			"organization_id": newUser.OrganizationID,
+		},
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return userID, nil
+}
+
+// ユーザーのパスワードを設定し直す
+// サーバーの管理者がshiftctlから実行するので、権限や現在のパスワードは確認しない
+// このユーザーの既存のセッションはすべて無効になり、ログインのロックも解除される
+func (*User) SetPassword(ctx *context.AppContext, userID int, password string) error {
+	userRec, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	if err := setPassword(ctx, userID, password); err != nil {
+		return err
+	}
+	if err := auth.Unlock(ctx, userRec.LoginID); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		Action:     AuditActionPasswordSet,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+	})
+	return err
+}
+
+// ユーザーを無効にする
+// 無効にしたユーザーはログインできず、既存のセッションとAPIトークンも使えなくなる. 提出済みのシフトは残す
+// サーバーの管理者がshiftctlから実行するので、権限は確認しない
+func (*User) Deactivate(ctx *context.AppContext, userID int) error {
+	userRec, err := ctx.GetDB().GetUserByID(ctx.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if userRec.DeactivatedAt != 0 {
+		return NewInputError(errors.New("user already deactivated"), "既に無効になっています")
+	}
+
+	deactivatedAt := time.Now().Unix()
+	if err := ctx.GetDB().DeactivateUser(ctx.Context(), userID, deactivatedAt); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		Action:     AuditActionUserDeactivate,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		After: map[string]any{
+			"deactivated_at": deactivatedAt,
+		},
+	})
+	return err
+}
+
+// 新しいパスワードを検証してから保存する
+func setPassword(ctx *context.AppContext, userID int, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return ctx.GetDB().UpdateUserPassword(ctx.Context(), userID, hash)
+}
+
+// パスワードの条件違反をInputErrorに変換する
+func validatePassword(password string) error {
+	err := auth.ValidatePassword(password)
+	var policyErr *auth.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		return NewInputError(err, strings.Join(policyErr.Violations, "、"))
+	}
+	return err
+}
+
+// 複数のユーザーをまとめて取得し、ユーザーIDをキーとするマップで返す
+// 存在しないユーザーIDはマップに含まれない
+func (*User) findByIDs(ctx *context.AppContext, userIDs []int) (map[int]User, error) {
+	userRecs, err := ctx.GetDB().GetUsersByIDs(ctx.Context(), userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[int]User, len(userRecs))
+	for _, userRec := range userRecs {
+		user, err := newUserFromRecord(userRec)
+		if err != nil {
+			return nil, err
+		}
+		users[user.ID] = user
+	}
+
+	return users, nil
+}
+
+// DBのレコードをモデルに変換する
+func newUserFromRecord(userRec db.User) (User, error) {
 	createdAt, err := NewDateTime(userRec.CreatedAt)
 	if err != nil {
 		return User{}, err
 	}
 
 	return User{
-		ID:        userRec.ID,
-		LoginID:   userRec.LoginID,
-		Password:  userRec.Password,
-		Name:      userRec.Name,
-		Role:      userRec.Role,
-		CreatedAt: createdAt,
+		ID:             userRec.ID,
+		LoginID:        userRec.LoginID,
+		Password:       userRec.Password,
+		Name:           userRec.Name,
+		Role:           userRec.Role,
+		OrganizationID: userRec.OrganizationID,
+		Email:          userRec.Email,
+		CreatedAt:      createdAt,
 	}, nil
 }
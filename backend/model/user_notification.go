@@ -0,0 +1,150 @@
+package model
+
+import (
+	"backend/context"
+	"backend/db"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// 通知の種類. シフトリクエストの作成と締切前の通知はNotificationKindを使う
+const (
+	// 他のユーザー(マネージャーなど)が自分の提出を変更した
+	UserNotificationKindSubmissionEdited = "submission.edited"
+)
+
+const (
+	defaultUserNotificationPageLimit = 20
+	maxUserNotificationPageLimit     = 100
+)
+
+// アプリ内でユーザーに表示する通知
+type UserNotification struct {
+	ID        int
+	Kind      string
+	RequestID int // 関連するシフトリクエスト。ない場合は0
+	Title     string
+	Body      string
+	CreatedAt DateTime
+	ReadAt    *DateTime // 未読の場合nil
+}
+
+type NewUserNotification struct {
+	UserID    int
+	Kind      string
+	RequestID int
+	Title     string
+	Body      string
+	// 同じキーの通知は1ユーザーに1件だけ作成する。空の場合は重複を確認しない
+	DedupKey string
+}
+
+type UserNotificationFilter struct {
+	UnreadOnly bool
+
+	// 前のページのNextCursor。空の場合は先頭から
+	Cursor string
+	// 1ページの件数。0の場合はデフォルト値
+	Limit int
+}
+
+// 通知の1ページ
+type UserNotificationPage struct {
+	Notifications []UserNotification
+	// 次のページを取得するためのカーソル。最後のページの場合は空
+	NextCursor string
+}
+
+// ユーザーに通知を作成し、IDを返す
+// DedupKeyが同じ通知を作成済みの場合は、作成せずにそのIDを返す
+func (*UserNotification) Create(ctx *context.AppContext, newNotification NewUserNotification) (int, error) {
+	return ctx.GetDB().CreateUserNotification(ctx.Context(), db.UserNotification{
+		UserID:    newNotification.UserID,
+		Kind:      newNotification.Kind,
+		RequestID: newNotification.RequestID,
+		Title:     newNotification.Title,
+		Body:      newNotification.Body,
+		DedupKey:  newNotification.DedupKey,
+	})
+}
+
+// ユーザーの通知を新しい順に1ページ分取得する
+func (*UserNotification) FindPage(ctx *context.AppContext, userID int, filter UserNotificationFilter) (UserNotificationPage, error) {
+	query := db.UserNotificationQuery{
+		UserID:     userID,
+		UnreadOnly: filter.UnreadOnly,
+	}
+
+	if filter.Cursor != "" {
+		beforeID, err := strconv.Atoi(filter.Cursor)
+		if err != nil || beforeID <= 0 {
+			return UserNotificationPage{}, NewInputError(
+				errors.New("invalid cursor"),
+				"カーソルが不正です",
+			)
+		}
+		query.BeforeID = beforeID
+	}
+
+	switch {
+	case filter.Limit == 0:
+		query.Limit = defaultUserNotificationPageLimit
+	case 1 <= filter.Limit && filter.Limit <= maxUserNotificationPageLimit:
+		query.Limit = filter.Limit
+	default:
+		return UserNotificationPage{}, NewInputError(
+			errors.New("invalid limit"),
+			"limitは1以上100以下でなければいけない",
+		)
+	}
+
+	// 次のページがあるか判定するために1件多く取得する
+	limit := query.Limit
+	query.Limit++
+	notificationRecs, err := ctx.GetDB().QueryUserNotifications(ctx.Context(), query)
+	if err != nil {
+		return UserNotificationPage{}, err
+	}
+
+	var nextCursor string
+	if len(notificationRecs) > limit {
+		notificationRecs = notificationRecs[:limit]
+		nextCursor = strconv.Itoa(notificationRecs[len(notificationRecs)-1].ID)
+	}
+
+	notifications := make([]UserNotification, 0, len(notificationRecs))
+	for _, notificationRec := range notificationRecs {
+		createdAt, err := NewDateTime(notificationRec.CreatedAt)
+		if err != nil {
+			return UserNotificationPage{}, err
+		}
+
+		notification := UserNotification{
+			ID:        notificationRec.ID,
+			Kind:      notificationRec.Kind,
+			RequestID: notificationRec.RequestID,
+			Title:     notificationRec.Title,
+			Body:      notificationRec.Body,
+			CreatedAt: createdAt,
+		}
+		if notificationRec.ReadAt != 0 {
+			readAt := DateTime(time.Unix(notificationRec.ReadAt, 0))
+			notification.ReadAt = &readAt
+		}
+		notifications = append(notifications, notification)
+	}
+
+	return UserNotificationPage{Notifications: notifications, NextCursor: nextCursor}, nil
+}
+
+// ユーザーの未読の通知の件数を返す
+func (*UserNotification) CountUnread(ctx *context.AppContext, userID int) (int, error) {
+	return ctx.GetDB().CountUnreadUserNotifications(ctx.Context(), userID)
+}
+
+// 通知を既読にする。既読の場合は何もしない
+// 他のユーザーの通知の場合はdb.ErrNotificationNotFoundを返す
+func (*UserNotification) MarkRead(ctx *context.AppContext, userID int, notificationID int) error {
+	return ctx.GetDB().ReadUserNotification(ctx.Context(), notificationID, userID, time.Now().Unix())
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/db"
+	"errors"
+	"testing"
+)
+
+func TestUserNotification(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_user_2", Password: "password", Name: "テストユーザー2", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+
+	var n UserNotification
+	for _, newNotification := range []NewUserNotification{
+		{UserID: 1, Kind: NotificationKindRequestCreated, RequestID: 1, Title: "1", DedupKey: "request.created:1"},
+		{UserID: 1, Kind: NotificationKindRequestCreated, RequestID: 1, Title: "1(重複)", DedupKey: "request.created:1"},
+		{UserID: 1, Kind: NotificationKindDeadlineReminder, RequestID: 1, Title: "2"},
+		{UserID: 1, Kind: NotificationKindDeadlineReminder, RequestID: 1, Title: "3"},
+		{UserID: 2, Kind: NotificationKindRequestCreated, RequestID: 1, Title: "他のユーザー", DedupKey: "request.created:1"},
+	} {
+		if _, err := n.Create(ctx, newNotification); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	titles := func(page UserNotificationPage) []string {
+		titles := []string{}
+		for _, notification := range page.Notifications {
+			titles = append(titles, notification.Title)
+		}
+		return titles
+	}
+
+	// 新しい順に1ページずつ取得する
+	page, err := n.FindPage(ctx, 1, UserNotificationFilter{Limit: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert(t, titles(page), []string{"3", "2"})
+	page, err = n.FindPage(ctx, 1, UserNotificationFilter{Limit: 2, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert(t, titles(page), []string{"1"})
+	if page.NextCursor != "" {
+		t.Errorf("last page should not have next cursor, got %q", page.NextCursor)
+	}
+
+	// 既読にする
+	if err := n.MarkRead(ctx, 1, page.Notifications[0].ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	count, err := n.CountUnread(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("want 2 unread notifications, got %d", count)
+	}
+	page, err = n.FindPage(ctx, 1, UserNotificationFilter{UnreadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert(t, titles(page), []string{"3", "2"})
+	page, _ = n.FindPage(ctx, 1, UserNotificationFilter{})
+	if page.Notifications[2].ReadAt == nil || page.Notifications[0].ReadAt != nil {
+		t.Errorf("unexpected read at: %+v", page.Notifications)
+	}
+
+	// 他のユーザーの通知は既読にできない
+	if err := n.MarkRead(ctx, 2, page.Notifications[0].ID); !errors.Is(err, db.ErrNotificationNotFound) {
+		t.Errorf("Expected ErrNotificationNotFound, got %v", err)
+	}
+
+	// 不正な条件
+	for _, filter := range []UserNotificationFilter{{Cursor: "abc"}, {Limit: 101}, {Limit: -1}} {
+		if _, err := n.FindPage(ctx, 1, filter); !errors.As(err, new(InputError)) {
+			t.Errorf("Expected InputError for %+v, got %v", filter, err)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package model
 import (
 	"backend/auth"
 	"backend/db"
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestGetUserByID(t *testing.T) {
@@ -25,12 +27,13 @@ func TestGetUserByID(t *testing.T) {
 	}
 
 	want := User{
-		ID:        1,
-		LoginID:   "testuser",
-		Password:  "password",
-		Name:      "テストユーザー",
-		Role:      auth.RoleEmployee,
-		CreatedAt: mustNewDateTime("2024-06-01 00:00:00"),
+		ID:             1,
+		LoginID:        "testuser",
+		Password:       "password",
+		Name:           "テストユーザー",
+		Role:           auth.RoleEmployee,
+		OrganizationID: db.DefaultOrganizationID,
+		CreatedAt:      mustNewDateTime("2024-06-01 00:00:00"),
 	}
 
 	assert(t, got, want)
@@ -41,3 +44,285 @@ func TestGetUserByID(t *testing.T) {
 		t.Fatalf("expected error")
 	}
 }
+
+func TestUnlockUser(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "testuser", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "testmanager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "othermanager", Password: "password", Name: "他のマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 4, LoginID: "testadmin", Password: "password", Name: "テスト管理者", Role: auth.RoleManager | auth.RoleAdmin, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	ctx.GetDB().SaveLoginThrottle(ctx.Context(), db.LoginThrottle{Key: "login_id:testuser", Failures: 10})
+
+	var u User
+
+	// マネージャー以外は解除できない
+	if err := u.Unlock(ctx, 1, 1); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-manager user, got %v", err)
+	}
+
+	// 存在しないユーザー
+	if err := u.Unlock(ctx, 2, 999); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	// 正常系
+	if err := u.Unlock(ctx, 2, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ctx.GetDB().GetLoginThrottle(ctx.Context(), "login_id:testuser"); !errors.Is(err, db.ErrThrottleNotFound) {
+		t.Errorf("throttle should be deleted, got %v", err)
+	}
+	events, _ := ctx.GetDB().QueryAuditEvents(ctx.Context(), db.AuditQuery{OrganizationID: db.DefaultOrganizationID, Action: AuditActionUserUnlock})
+	if len(events) != 1 || events[0].ActorID != 2 || events[0].TargetID != 1 {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	hash, _ := auth.HashPassword("oldpass123")
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "testuser", Password: hash, Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+
+	var u User
+
+	// 現在のパスワードが間違っている
+	if err := u.ChangePassword(ctx, 1, "wrongpass1", "newpass123"); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for incorrect current password, got %v", err)
+	}
+	// ログインと同じく、ログインIDごとに失敗回数を数える
+	throttle, err := ctx.GetDB().GetLoginThrottle(ctx.Context(), "login_id:testuser")
+	if err != nil || throttle.Failures != 1 {
+		t.Errorf("failure should be recorded, got %+v, %v", throttle, err)
+	}
+
+	// 失敗が続いている場合は、正しいパスワードでも受け付けない
+	ctx.GetDB().SaveLoginThrottle(ctx.Context(), db.LoginThrottle{Key: "login_id:testuser", Failures: 5, BlockedUntil: time.Now().Add(time.Minute).Unix()})
+	if err := u.ChangePassword(ctx, 1, "oldpass123", "newpass123"); !errors.As(err, new(*auth.ThrottledError)) {
+		t.Errorf("Expected ThrottledError, got %v", err)
+	}
+	ctx.GetDB().DeleteLoginThrottle(ctx.Context(), "login_id:testuser")
+
+	// 新しいパスワードが条件を満たさない
+	for _, password := range []string{"short1", "onlyletters", "12345678"} {
+		if err := u.ChangePassword(ctx, 1, "oldpass123", password); !errors.As(err, new(InputError)) {
+			t.Errorf("Expected InputError for password %q, got %v", password, err)
+		}
+	}
+
+	// 正常系
+	if err := u.ChangePassword(ctx, 1, "oldpass123", "newpass123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	userRec, _ := ctx.GetDB().GetUserByID(ctx.Context(), 1)
+	if !auth.ComparePassword(userRec.Password, "newpass123") {
+		t.Errorf("password should be updated")
+	}
+	if userRec.SessionVersion != 1 {
+		t.Errorf("session version should be incremented, got %d", userRec.SessionVersion)
+	}
+	events, _ := ctx.GetDB().QueryAuditEvents(ctx.Context(), db.AuditQuery{OrganizationID: db.DefaultOrganizationID, Action: AuditActionPasswordChange})
+	if len(events) != 1 || events[0].TargetID != 1 {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "testuser", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "testmanager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "othermanager", Password: "password", Name: "他のマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 4, LoginID: "testadmin", Password: "password", Name: "テスト管理者", Role: auth.RoleManager | auth.RoleAdmin, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	ctx.GetDB().SaveLoginThrottle(ctx.Context(), db.LoginThrottle{Key: "login_id:testuser", Failures: 10})
+
+	var u User
+
+	// マネージャー以外は発行できない
+	if _, err := u.IssuePasswordReset(ctx, 1, 1); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-manager user, got %v", err)
+	}
+
+	// 存在しないユーザー
+	if _, err := u.IssuePasswordReset(ctx, 2, 999); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	// 自分にない権限を持つユーザーには発行できない
+	if _, err := u.IssuePasswordReset(ctx, 2, 4); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for manager to admin, got %v", err)
+	}
+
+	// 同じ権限のユーザーには発行できる
+	if _, err := u.IssuePasswordReset(ctx, 2, 3); err != nil {
+		t.Errorf("unexpected error for manager to manager: %v", err)
+	}
+	if _, err := u.IssuePasswordReset(ctx, 4, 2); err != nil {
+		t.Errorf("unexpected error for admin to manager: %v", err)
+	}
+
+	token, err := u.IssuePasswordReset(ctx, 2, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// トークンはハッシュ値だけが保存される
+	if _, err := ctx.GetDB().GetPasswordReset(ctx.Context(), token.Token); !errors.Is(err, db.ErrPasswordResetNotFound) {
+		t.Errorf("raw token should not be stored, got %v", err)
+	}
+
+	// 存在しないトークン
+	if err := u.ResetPassword(ctx, "invalid", "newpass123"); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for invalid token, got %v", err)
+	}
+
+	// 条件を満たさないパスワードではトークンを消費しない
+	if err := u.ResetPassword(ctx, token.Token, "short"); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for weak password, got %v", err)
+	}
+
+	// 正常系
+	if err := u.ResetPassword(ctx, token.Token, "newpass123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	userRec, _ := ctx.GetDB().GetUserByID(ctx.Context(), 1)
+	if !auth.ComparePassword(userRec.Password, "newpass123") || userRec.SessionVersion != 1 {
+		t.Errorf("password should be reset: %+v", userRec)
+	}
+	if _, err := ctx.GetDB().GetLoginThrottle(ctx.Context(), "login_id:testuser"); !errors.Is(err, db.ErrThrottleNotFound) {
+		t.Errorf("throttle should be deleted, got %v", err)
+	}
+
+	// 使用済みのトークンは使えない
+	if err := u.ResetPassword(ctx, token.Token, "another123"); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for used token, got %v", err)
+	}
+
+	// 期限切れのトークンは使えない
+	ctx.GetDB().CreatePasswordReset(ctx.Context(), db.PasswordReset{
+		TokenHash: auth.HashResetToken("expired"),
+		UserID:    1,
+		CreatorID: 2,
+		ExpiresAt: 1,
+	})
+	if err := u.ResetPassword(ctx, "expired", "another123"); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for expired token, got %v", err)
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "testuser", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	orgID, _ := ctx.GetDB().CreateOrganization(ctx.Context(), "2号店")
+
+	var u User
+
+	// 入力の誤り
+	for _, newUser := range []NewUser{
+		{LoginID: "", Name: "名前", Password: "newpass123", Role: auth.RoleManager},
+		{LoginID: "boss", Name: "名前", Password: "newpass123"},
+		{LoginID: "boss", Name: "名前", Password: "short", Role: auth.RoleManager},
+		{LoginID: "testuser", Name: "名前", Password: "newpass123", Role: auth.RoleManager},
+	} {
+		if _, err := u.Create(ctx, newUser); !errors.As(err, new(InputError)) {
+			t.Errorf("Expected InputError for %+v, got %v", newUser, err)
+		}
+	}
+
+	// 存在しない組織
+	if _, err := u.Create(ctx, NewUser{LoginID: "boss", Name: "名前", Password: "newpass123", Role: auth.RoleManager, OrganizationID: 999}); !errors.Is(err, db.ErrOrganizationNotFound) {
+		t.Errorf("Expected ErrOrganizationNotFound, got %v", err)
+	}
+
+	// 正常系
+	userID, err := u.Create(ctx, NewUser{LoginID: "boss", Name: "店長", Password: "newpass123", Role: auth.RoleManager, OrganizationID: orgID})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := u.FindByLoginID(ctx, "boss")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ID != userID || got.Name != "店長" || got.Role != auth.RoleManager || got.OrganizationID != orgID || !auth.ComparePassword(got.Password, "newpass123") {
+		t.Errorf("unexpected user: %+v", got)
+	}
+
+	// 操作したユーザーは不明で、作成したユーザーの組織に記録する
+	events, _ := ctx.GetDB().QueryAuditEvents(ctx.Context(), db.AuditQuery{OrganizationID: orgID, Action: AuditActionUserCreate})
+	if len(events) != 1 || events[0].ActorID != 0 || events[0].TargetID != userID {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+}
+
+func TestSetPasswordAndDeactivate(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "testuser", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	ctx.GetDB().SaveLoginThrottle(ctx.Context(), db.LoginThrottle{Key: "login_id:testuser", Failures: 10})
+
+	var u User
+
+	// パスワードの設定
+	if err := u.SetPassword(ctx, 999, "newpass123"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if err := u.SetPassword(ctx, 1, "short"); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for weak password, got %v", err)
+	}
+	if err := u.SetPassword(ctx, 1, "newpass123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	userRec, _ := ctx.GetDB().GetUserByID(ctx.Context(), 1)
+	if !auth.ComparePassword(userRec.Password, "newpass123") || userRec.SessionVersion != 1 {
+		t.Errorf("password should be set: %+v", userRec)
+	}
+	if _, err := ctx.GetDB().GetLoginThrottle(ctx.Context(), "login_id:testuser"); !errors.Is(err, db.ErrThrottleNotFound) {
+		t.Errorf("throttle should be deleted, got %v", err)
+	}
+
+	// 無効にする
+	if err := u.Deactivate(ctx, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	userRec, _ = ctx.GetDB().GetUserByID(ctx.Context(), 1)
+	if userRec.DeactivatedAt == 0 || userRec.SessionVersion != 2 {
+		t.Errorf("user should be deactivated: %+v", userRec)
+	}
+	if err := u.Deactivate(ctx, 1); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for deactivated user, got %v", err)
+	}
+
+	for _, action := range []string{AuditActionPasswordSet, AuditActionUserDeactivate} {
+		events, _ := ctx.GetDB().QueryAuditEvents(ctx.Context(), db.AuditQuery{OrganizationID: db.DefaultOrganizationID, Action: action})
+		if len(events) != 1 || events[0].ActorID != 0 || events[0].TargetID != 1 {
+			t.Errorf("unexpected %s audit events: %+v", action, events)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/db"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhookで送るイベント
+const (
+	WebhookEventRequestCreated    = "request.created"
+	WebhookEventSubmissionCreated = "submission.created"
+	WebhookEventSubmissionUpdated = "submission.updated"
+)
+
+var WebhookEvents = []string{WebhookEventRequestCreated, WebhookEventSubmissionCreated, WebhookEventSubmissionUpdated}
+
+// trueの場合、ループバックやプライベートネットワークのアドレスのURLも登録できる
+var allowPrivateWebhookAddresses = false
+
+// 内部のネットワークのWebhookを登録できるかを変更する
+// サーバー起動時に呼び出す
+func SetAllowPrivateWebhookAddresses(allow bool) {
+	allowPrivateWebhookAddresses = allow
+}
+
+// サーバー内部のサービスにリクエストを送らせないように、Webhookの送信先として使えないアドレスか判定する
+// ループバック、プライベートネットワーク、リンクローカル(クラウドのメタデータサーバーなど)、未指定のアドレス
+func IsPrivateAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsUnspecified() || sharedAddressSpace.Contains(addr)
+}
+
+// キャリアグレードNAT用のアドレス(RFC 6598)
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+const (
+	defaultWebhookDeliveryPageLimit = 50
+	maxWebhookDeliveryPageLimit     = 200
+)
+
+// 組織のイベントを外部のサービスに送るWebhook
+// 署名用の秘密鍵は登録時にしか取得できない
+type Webhook struct {
+	ID        int
+	CreatorID int
+	URL       string
+	Events    []string
+	CreatedAt DateTime
+}
+
+type NewWebhook struct {
+	URL    string
+	Events []string
+}
+
+// Webhookで送るイベント1件の送信状況
+type WebhookDelivery struct {
+	ID             int
+	Event          string
+	Status         string // db.WebhookDelivery* のいずれか
+	Attempts       int
+	LastStatusCode int // レスポンスがなかった場合は0
+	LastError      string
+	CreatedAt      DateTime
+	NextAttemptAt  *DateTime // 送信待ちでない場合はnil
+	DeliveredAt    *DateTime // 送信済みでない場合はnil
+}
+
+type WebhookDeliveryFilter struct {
+	// 前のページのNextCursor。空の場合は先頭から
+	Cursor string
+	// 1ページの件数。0の場合はデフォルト値
+	Limit int
+}
+
+// Webhookの送信状況の1ページ
+type WebhookDeliveryPage struct {
+	Deliveries []WebhookDelivery
+	// 次のページを取得するためのカーソル。最後のページの場合は空
+	NextCursor string
+}
+
+// 操作するユーザーの組織にWebhookを登録し、IDと署名用の秘密鍵を返す
+// webhook.manage権限を持つユーザーのみ実行できる
+func (*Webhook) Create(ctx *context.AppContext, actorID int, newWebhook NewWebhook) (int, string, error) {
+	allowed, err := auth.Can(ctx, actorID, auth.PermissionWebhookManage)
+	if err != nil {
+		return 0, "", err
+	}
+	if !allowed {
+		return 0, "", ErrForbidden
+	}
+
+	parsed, err := url.Parse(newWebhook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return 0, "", NewInputError(errors.New("invalid url"), "URLはhttpまたはhttpsでなければいけない")
+	}
+	// 名前解決の結果は変わるので、送信時にも接続先のアドレスを確認する
+	if !allowPrivateWebhookAddresses {
+		host := parsed.Hostname()
+		addr, err := netip.ParseAddr(host)
+		if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") || (err == nil && IsPrivateAddress(addr)) {
+			return 0, "", NewInputError(errors.New("private url"), "内部のネットワークのURLは登録できません")
+		}
+	}
+	if len(newWebhook.Events) == 0 {
+		return 0, "", NewInputError(errors.New("empty events"), "イベントを1つ以上指定してください")
+	}
+	for _, event := range newWebhook.Events {
+		if !slices.Contains(WebhookEvents, event) {
+			return 0, "", NewInputError(errors.New("invalid event: "+event), "イベントが不正です: "+event)
+		}
+	}
+
+	organizationID, err := organizationIDOf(ctx, actorID)
+	if err != nil {
+		return 0, "", err
+	}
+	secret, err := auth.NewWebhookSecret()
+	if err != nil {
+		return 0, "", err
+	}
+	events := slices.Compact(slices.Sorted(slices.Values(newWebhook.Events)))
+	id, err := ctx.GetDB().CreateWebhookSubscription(ctx.Context(), db.WebhookSubscription{
+		OrganizationID: organizationID,
+		CreatorID:      actorID,
+		URL:            newWebhook.URL,
+		Secret:         secret,
+		Events:         strings.Join(events, " "),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	// 監査ログに記録(秘密鍵は記録しない)
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionWebhookCreate,
+		TargetType: AuditTargetWebhook,
+		TargetID:   id,
+		After:      map[string]any{"url": newWebhook.URL, "events": events},
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	return id, secret, nil
+}
+
+// 閲覧ユーザーの組織のWebhookを全て取得する
+// webhook.manage権限を持つユーザーのみ閲覧できる
+func (*Webhook) FindAll(ctx *context.AppContext, viewerID int) ([]Webhook, error) {
+	allowed, err := auth.Can(ctx, viewerID, auth.PermissionWebhookManage)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+
+	organizationID, err := organizationIDOf(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	subscriptionRecs, err := ctx.GetDB().GetWebhookSubscriptionsByOrganizationID(ctx.Context(), organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]Webhook, 0, len(subscriptionRecs))
+	for _, subscriptionRec := range subscriptionRecs {
+		webhook, err := newWebhookFromRecord(subscriptionRec)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// Webhookを送信状況と合わせて削除する。送信待ちのイベントは送らない
+// webhook.manage権限を持つユーザーのみ実行できる
+func (*Webhook) Delete(ctx *context.AppContext, actorID int, webhookID int) error {
+	subscriptionRec, err := findWebhookInSameOrganization(ctx, actorID, webhookID)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.GetDB().DeleteWebhookSubscription(ctx.Context(), webhookID); err != nil {
+		return err
+	}
+
+	// 監査ログに記録
+	var audit AuditEvent
+	_, err = audit.Record(ctx, NewAuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionWebhookDelete,
+		TargetType: AuditTargetWebhook,
+		TargetID:   webhookID,
+		Before:     map[string]any{"url": subscriptionRec.URL, "events": strings.Fields(subscriptionRec.Events)},
+	})
+	return err
+}
+
+// Webhookの送信状況を新しい順に1ページ分取得する
+// webhook.manage権限を持つユーザーのみ閲覧できる
+func (*Webhook) FindDeliveries(ctx *context.AppContext, viewerID int, webhookID int, filter WebhookDeliveryFilter) (WebhookDeliveryPage, error) {
+	if _, err := findWebhookInSameOrganization(ctx, viewerID, webhookID); err != nil {
+		return WebhookDeliveryPage{}, err
+	}
+
+	query := db.WebhookDeliveryQuery{SubscriptionID: webhookID}
+	if filter.Cursor != "" {
+		beforeID, err := strconv.Atoi(filter.Cursor)
+		if err != nil || beforeID <= 0 {
+			return WebhookDeliveryPage{}, NewInputError(
+				errors.New("invalid cursor"),
+				"カーソルが不正です",
+			)
+		}
+		query.BeforeID = beforeID
+	}
+
+	switch {
+	case filter.Limit == 0:
+		query.Limit = defaultWebhookDeliveryPageLimit
+	case 1 <= filter.Limit && filter.Limit <= maxWebhookDeliveryPageLimit:
+		query.Limit = filter.Limit
+	default:
+		return WebhookDeliveryPage{}, NewInputError(
+			errors.New("invalid limit"),
+			"limitは1以上200以下でなければいけない",
+		)
+	}
+
+	// 次のページがあるか判定するために1件多く取得する
+	limit := query.Limit
+	query.Limit++
+	deliveryRecs, err := ctx.GetDB().QueryWebhookDeliveries(ctx.Context(), query)
+	if err != nil {
+		return WebhookDeliveryPage{}, err
+	}
+
+	var nextCursor string
+	if len(deliveryRecs) > limit {
+		deliveryRecs = deliveryRecs[:limit]
+		nextCursor = strconv.Itoa(deliveryRecs[len(deliveryRecs)-1].ID)
+	}
+
+	deliveries := make([]WebhookDelivery, 0, len(deliveryRecs))
+	for _, deliveryRec := range deliveryRecs {
+		createdAt, err := NewDateTime(deliveryRec.CreatedAt)
+		if err != nil {
+			return WebhookDeliveryPage{}, err
+		}
+		delivery := WebhookDelivery{
+			ID:             deliveryRec.ID,
+			Event:          deliveryRec.Event,
+			Status:         deliveryRec.Status,
+			Attempts:       deliveryRec.Attempts,
+			LastStatusCode: deliveryRec.LastStatusCode,
+			LastError:      deliveryRec.LastError,
+			CreatedAt:      createdAt,
+		}
+		if deliveryRec.Status == db.WebhookDeliveryPending {
+			nextAttemptAt := DateTime(time.Unix(deliveryRec.NextAttemptAt, 0))
+			delivery.NextAttemptAt = &nextAttemptAt
+		}
+		if deliveryRec.DeliveredAt != 0 {
+			deliveredAt := DateTime(time.Unix(deliveryRec.DeliveredAt, 0))
+			delivery.DeliveredAt = &deliveredAt
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return WebhookDeliveryPage{Deliveries: deliveries, NextCursor: nextCursor}, nil
+}
+
+func newWebhookFromRecord(subscriptionRec db.WebhookSubscription) (Webhook, error) {
+	createdAt, err := NewDateTime(subscriptionRec.CreatedAt)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return Webhook{
+		ID:        subscriptionRec.ID,
+		CreatorID: subscriptionRec.CreatorID,
+		URL:       subscriptionRec.URL,
+		Events:    strings.Fields(subscriptionRec.Events),
+		CreatedAt: createdAt,
+	}, nil
+}
+
+// webhook.manage権限を確認し、操作するユーザーと同じ組織のWebhookを取得する
+// 他の組織のWebhookの場合は、存在を知られないようにdb.ErrWebhookNotFoundを返す
+func findWebhookInSameOrganization(ctx *context.AppContext, actorID int, webhookID int) (db.WebhookSubscription, error) {
+	allowed, err := auth.Can(ctx, actorID, auth.PermissionWebhookManage)
+	if err != nil {
+		return db.WebhookSubscription{}, err
+	}
+	if !allowed {
+		return db.WebhookSubscription{}, ErrForbidden
+	}
+
+	organizationID, err := organizationIDOf(ctx, actorID)
+	if err != nil {
+		return db.WebhookSubscription{}, err
+	}
+	subscriptionRec, err := ctx.GetDB().GetWebhookSubscriptionByID(ctx.Context(), webhookID)
+	if err != nil {
+		return db.WebhookSubscription{}, err
+	}
+	if subscriptionRec.OrganizationID != organizationID {
+		return db.WebhookSubscription{}, db.ErrWebhookNotFound
+	}
+	return subscriptionRec, nil
+}
+
+// Webhookで送るJSON
+type webhookPayload struct {
+	Event          string `json:"event"`
+	OrganizationID int    `json:"organization_id"`
+	OccurredAt     string `json:"occurred_at"`
+	Data           any    `json:"data"`
+}
+
+// 組織のWebhookのうち、イベントを受け取るものの送信待ちにイベントを追加する
+// 送信はwebhookパッケージのDispatcherがバックグラウンドで行う
+func enqueueWebhookEvent(ctx *context.AppContext, organizationID int, event string, data any) error {
+	subscriptionRecs, err := ctx.GetDB().GetWebhookSubscriptionsByOrganizationID(ctx.Context(), organizationID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	occurredAt := DateTime(now)
+	payload, err := json.Marshal(webhookPayload{
+		Event:          event,
+		OrganizationID: organizationID,
+		OccurredAt:     occurredAt.Format(),
+		Data:           data,
+	})
+	if err != nil {
+		return err
+	}
+
+	var deliveries []db.WebhookDelivery
+	for _, subscriptionRec := range subscriptionRecs {
+		if !slices.Contains(strings.Fields(subscriptionRec.Events), event) {
+			continue
+		}
+		deliveries = append(deliveries, db.WebhookDelivery{
+			SubscriptionID: subscriptionRec.ID,
+			Event:          event,
+			Payload:        string(payload),
+			Status:         db.WebhookDeliveryPending,
+			NextAttemptAt:  now.Unix(),
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return ctx.GetDB().CreateWebhookDeliveries(ctx.Context(), deliveries)
+}
+
+// 送信待ちのWebhookのイベント1件
+type WebhookDispatch struct {
+	DeliveryID int
+	Event      string
+	Payload    []byte
+	URL        string
+	Secret     string
+	// これまでに送信を試みた回数
+	Attempts int
+
+	record db.WebhookDelivery
+}
+
+// 送信時刻を過ぎた送信待ちのイベントを古い順に最大limit件取得する
+func (*WebhookDispatch) FindDue(ctx *context.AppContext, now time.Time, limit int) ([]WebhookDispatch, error) {
+	deliveryRecs, err := ctx.GetDB().GetDueWebhookDeliveries(ctx.Context(), now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptionRecs := make(map[int]db.WebhookSubscription)
+	dispatches := make([]WebhookDispatch, 0, len(deliveryRecs))
+	for _, deliveryRec := range deliveryRecs {
+		subscriptionRec, ok := subscriptionRecs[deliveryRec.SubscriptionID]
+		if !ok {
+			subscriptionRec, err = ctx.GetDB().GetWebhookSubscriptionByID(ctx.Context(), deliveryRec.SubscriptionID)
+			if err != nil {
+				return nil, err
+			}
+			subscriptionRecs[deliveryRec.SubscriptionID] = subscriptionRec
+		}
+		dispatches = append(dispatches, WebhookDispatch{
+			DeliveryID: deliveryRec.ID,
+			Event:      deliveryRec.Event,
+			Payload:    []byte(deliveryRec.Payload),
+			URL:        subscriptionRec.URL,
+			Secret:     subscriptionRec.Secret,
+			Attempts:   deliveryRec.Attempts,
+			record:     deliveryRec,
+		})
+	}
+	return dispatches, nil
+}
+
+// 送信に成功したことを記録する
+func (d *WebhookDispatch) MarkSucceeded(ctx *context.AppContext, statusCode int, deliveredAt time.Time) error {
+	rec := d.record
+	rec.Status = db.WebhookDeliverySucceeded
+	rec.Attempts++
+	rec.NextAttemptAt = 0
+	rec.LastStatusCode = statusCode
+	rec.LastError = ""
+	rec.DeliveredAt = deliveredAt.Unix()
+	return ctx.GetDB().UpdateWebhookDelivery(ctx.Context(), rec)
+}
+
+// 送信に失敗したことを記録する。レスポンスがなかった場合、statusCodeは0
+// nextAttemptAtがnilの場合は再送しない
+func (d *WebhookDispatch) MarkFailed(ctx *context.AppContext, statusCode int, message string, nextAttemptAt *time.Time) error {
+	rec := d.record
+	rec.Attempts++
+	rec.LastStatusCode = statusCode
+	rec.LastError = message
+	if nextAttemptAt != nil {
+		rec.Status = db.WebhookDeliveryPending
+		rec.NextAttemptAt = nextAttemptAt.Unix()
+	} else {
+		rec.Status = db.WebhookDeliveryFailed
+		rec.NextAttemptAt = 0
+	}
+	return ctx.GetDB().UpdateWebhookDelivery(ctx.Context(), rec)
+}
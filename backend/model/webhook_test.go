@@ -0,0 +1,169 @@
+package model
+
+import (
+	"backend/auth"
+	"backend/db"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	ctx := newTestContext(
+		[]db.User{
+			{ID: 1, LoginID: "test_user", Password: "password", Name: "テストユーザー", Role: auth.RoleEmployee, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 2, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-06-01 00:00:00"},
+			{ID: 3, LoginID: "other_manager", Password: "password", Name: "2号店マネージャー", Role: auth.RoleManager, OrganizationID: 2, CreatedAt: "2024-06-01 00:00:00"},
+		},
+		[]db.Request{},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	if _, err := ctx.GetDB().CreateOrganization(ctx.Context(), "2号店"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var w Webhook
+
+	// マネージャー以外は登録できない
+	if _, _, err := w.Create(ctx, 1, NewWebhook{URL: "https://example.com/hook", Events: []string{WebhookEventRequestCreated}}); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-manager user, got %v", err)
+	}
+
+	// 入力が不正
+	for _, newWebhook := range []NewWebhook{
+		{URL: "ftp://example.com/hook", Events: []string{WebhookEventRequestCreated}},
+		{URL: "https://example.com/hook"},
+		{URL: "https://example.com/hook", Events: []string{"user.created"}},
+		// 内部のネットワーク
+		{URL: "http://127.0.0.1:8080/hook", Events: []string{WebhookEventRequestCreated}},
+		{URL: "http://localhost/hook", Events: []string{WebhookEventRequestCreated}},
+		{URL: "http://169.254.169.254/latest/meta-data", Events: []string{WebhookEventRequestCreated}},
+		{URL: "http://10.0.0.1/hook", Events: []string{WebhookEventRequestCreated}},
+		{URL: "http://[::1]/hook", Events: []string{WebhookEventRequestCreated}},
+	} {
+		if _, _, err := w.Create(ctx, 2, newWebhook); !errors.As(err, new(InputError)) {
+			t.Errorf("Expected InputError for %+v, got %v", newWebhook, err)
+		}
+	}
+
+	// 正常系
+	id, secret, err := w.Create(ctx, 2, NewWebhook{URL: "https://example.com/hook", Events: []string{WebhookEventSubmissionCreated, WebhookEventRequestCreated}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(secret, "whsec_") {
+		t.Errorf("unexpected secret: %q", secret)
+	}
+	otherID, _, err := w.Create(ctx, 3, NewWebhook{URL: "https://example.com/other", Events: []string{WebhookEventRequestCreated}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	webhooks, err := w.FindAll(ctx, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(webhooks) != 1 || webhooks[0].ID != id {
+		t.Fatalf("unexpected webhooks: %+v", webhooks)
+	}
+	assert(t, webhooks[0].Events, []string{WebhookEventRequestCreated, WebhookEventSubmissionCreated})
+
+	// 他の組織のWebhookは見つからない
+	if _, err := w.FindDeliveries(ctx, 2, otherID, WebhookDeliveryFilter{}); !errors.Is(err, db.ErrWebhookNotFound) {
+		t.Errorf("Expected ErrWebhookNotFound for other organization, got %v", err)
+	}
+	if err := w.Delete(ctx, 2, otherID); !errors.Is(err, db.ErrWebhookNotFound) {
+		t.Errorf("Expected ErrWebhookNotFound for other organization, got %v", err)
+	}
+
+	// シフトリクエストの作成と提出で、組織のWebhookの送信待ちにイベントが追加される
+	var r Request
+	requestID, err := r.Create(ctx, NewRequest{
+		CreatorID: 2,
+		StartDate: mustNewDateOnly("2099-06-10"),
+		EndDate:   mustNewDateOnly("2099-06-16"),
+		Deadline:  mustNewDateTime("2099-06-03 00:00:00"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var s Submission
+	submissionID, err := s.Create(ctx, NewSubmission{
+		RequestID:   requestID,
+		SubmitterID: 1,
+		NewEntries:  []NewEntry{{Date: mustNewDateOnly("2099-06-10"), Hour: 9}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	page, err := w.FindDeliveries(ctx, 2, id, WebhookDeliveryFilter{Limit: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Deliveries) != 1 || page.Deliveries[0].Event != WebhookEventSubmissionCreated || page.Deliveries[0].Status != db.WebhookDeliveryPending || page.NextCursor == "" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	page, err = w.FindDeliveries(ctx, 2, id, WebhookDeliveryFilter{Limit: 1, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Deliveries) != 1 || page.Deliveries[0].Event != WebhookEventRequestCreated || page.NextCursor != "" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	if _, err := w.FindDeliveries(ctx, 2, id, WebhookDeliveryFilter{Limit: 201}); !errors.As(err, new(InputError)) {
+		t.Errorf("Expected InputError for too large limit, got %v", err)
+	}
+
+	// 送信待ちのイベントは秘密鍵と合わせて取得できる
+	var dispatch WebhookDispatch
+	dispatches, err := dispatch.FindDue(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dispatches) != 2 || dispatches[0].Secret != secret || dispatches[0].URL != "https://example.com/hook" {
+		t.Fatalf("unexpected dispatches: %+v", dispatches)
+	}
+	var payload struct {
+		Event          string `json:"event"`
+		OrganizationID int    `json:"organization_id"`
+		Data           struct {
+			Submission struct {
+				ID          int `json:"id"`
+				RequestID   int `json:"request_id"`
+				SubmitterID int `json:"submitter_id"`
+			} `json:"submission"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(dispatches[1].Payload, &payload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if payload.Event != WebhookEventSubmissionCreated || payload.OrganizationID != db.DefaultOrganizationID || payload.Data.Submission.ID != submissionID || payload.Data.Submission.RequestID != requestID || payload.Data.Submission.SubmitterID != 1 {
+		t.Errorf("unexpected payload: %s", dispatches[1].Payload)
+	}
+
+	// 削除すると一覧に含まれず、監査ログに記録される
+	if err := w.Delete(ctx, 1, id); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden for non-manager user, got %v", err)
+	}
+	if err := w.Delete(ctx, 2, id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	webhooks, err = w.FindAll(ctx, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(webhooks) != 0 {
+		t.Errorf("unexpected webhooks: %+v", webhooks)
+	}
+	events, _ := ctx.GetDB().QueryAuditEvents(ctx.Context(), db.AuditQuery{OrganizationID: db.DefaultOrganizationID, TargetType: AuditTargetWebhook})
+	if len(events) != 2 || events[0].Action != AuditActionWebhookDelete || events[1].Action != AuditActionWebhookCreate {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+	if strings.Contains(events[1].After, secret) {
+		t.Errorf("secret should not be recorded in audit log: %s", events[1].After)
+	}
+}
@@ -0,0 +1,31 @@
+package notify
+
+import (
+	"backend/context"
+	"backend/model"
+	stdcontext "context"
+	"fmt"
+)
+
+// アプリ内の通知(GET /me/notifications)として保存するNotifier
+// 送り直しても同じ通知は1件だけ作成する
+type InboxNotifier struct {
+	appCtx *context.AppContext
+}
+
+func NewInboxNotifier(appCtx *context.AppContext) *InboxNotifier {
+	return &InboxNotifier{appCtx: appCtx}
+}
+
+func (n *InboxNotifier) Notify(ctx stdcontext.Context, message Message) error {
+	var notification model.UserNotification
+	_, err := notification.Create(n.appCtx.WithContext(ctx), model.NewUserNotification{
+		UserID:    message.UserID,
+		Kind:      message.Kind,
+		RequestID: message.RequestID,
+		Title:     message.Subject,
+		Body:      message.Body,
+		DedupKey:  fmt.Sprintf("%s:%d", message.Kind, message.RequestID),
+	})
+	return err
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	stdcontext "context"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// ログに出力するだけのNotifier
+// 開発環境で送信内容を確認するために使う
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx stdcontext.Context, message Message) error {
+	slog.InfoContext(ctx, "通知",
+		slog.Group("notification",
+			slog.String("kind", message.Kind),
+			slog.Int("request_id", message.RequestID),
+			slog.Int("user_id", message.UserID),
+			slog.String("subject", message.Subject),
+		),
+	)
+	return nil
+}
+
+// 送ったメッセージをメモリに保存するNotifier
+// テストで送信内容を確認するために使う
+type MemoryNotifier struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (n *MemoryNotifier) Notify(ctx stdcontext.Context, message Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+// これまでに送ったメッセージを送った順に返す
+func (n *MemoryNotifier) Messages() []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Clone(n.messages)
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"backend/model"
+	stdcontext "context"
+	"errors"
+	"fmt"
+)
+
+// ユーザーに送るメッセージ
+type Message struct {
+	Kind      string // model.NotificationKind* のいずれか
+	RequestID int
+	UserID    int
+	UserName  string
+	Email     string // 未設定の場合は空文字
+	Subject   string
+	Body      string
+}
+
+// メッセージの送信方法
+// 送信に失敗した場合はエラーを返す。スケジューラーは送信済みとして記録せず、次回送り直す
+type Notifier interface {
+	Notify(ctx stdcontext.Context, message Message) error
+}
+
+// 通知からユーザーに送るメッセージを作成する
+func NewMessage(notification model.Notification) Message {
+	request := notification.Request
+	period := request.StartDate.Format() + "〜" + request.EndDate.Format()
+
+	message := Message{
+		Kind:      notification.Kind,
+		RequestID: request.ID,
+		UserID:    notification.Recipient.ID,
+		UserName:  notification.Recipient.Name,
+		Email:     notification.Recipient.Email,
+	}
+	switch notification.Kind {
+	case model.NotificationKindRequestCreated:
+		message.Subject = "新しいシフトリクエストが作成されました"
+		message.Body = fmt.Sprintf("%sさん\n\n%sのシフトリクエストが作成されました。\n%sまでにシフトを提出してください。\n",
+			notification.Recipient.Name, period, request.Deadline.Format())
+	case model.NotificationKindDeadlineReminder:
+		message.Subject = "シフトの提出期限が近づいています"
+		message.Body = fmt.Sprintf("%sさん\n\n%sのシフトの提出期限は%sです。\nまだシフトが提出されていません。\n",
+			notification.Recipient.Name, period, request.Deadline.Format())
+	}
+	return message
+}
+
+// 複数の方法で送るNotifier
+// 全ての方法で送り、失敗したものがあればまとめてエラーを返す
+type multiNotifier []Notifier
+
+func Multi(notifiers ...Notifier) Notifier {
+	return multiNotifier(notifiers)
+}
+
+func (notifiers multiNotifier) Notify(ctx stdcontext.Context, message Message) error {
+	var errs []error
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(ctx, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
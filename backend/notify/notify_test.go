@@ -0,0 +1,201 @@
+package notify
+
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/db"
+	"backend/model"
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(notifier Notifier) (*Scheduler, db.DB) {
+	database := db.NewMockDB(
+		[]db.Request{
+			// 締切まで2日
+			{ID: 1, CreatorID: 1, StartDate: "2024-06-10", EndDate: "2024-06-16", Deadline: "2024-06-03 00:00:00", CreatedAt: "2024-05-20 00:00:00"},
+			// 締切済み
+			{ID: 2, CreatorID: 1, StartDate: "2024-06-01", EndDate: "2024-06-07", Deadline: "2024-05-31 00:00:00", CreatedAt: "2024-05-10 00:00:00"},
+		},
+		[]db.User{
+			{ID: 1, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-05-01 00:00:00"},
+			{ID: 2, LoginID: "test_user_2", Password: "password", Name: "テストユーザー2", Role: auth.RoleEmployee, Email: "user2@example.com", CreatedAt: "2024-05-01 00:00:00"},
+			{ID: 3, LoginID: "test_user_3", Password: "password", Name: "テストユーザー3", Role: auth.RoleEmployee, CreatedAt: "2024-05-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{
+			{ID: 1, RequestID: 1, SubmitterID: 3, CreatedAt: "2024-05-21 00:00:00", UpdatedAt: "2024-05-21 00:00:00"},
+		},
+	)
+	return NewScheduler(context.NewAppContext(database, nil), notifier), database
+}
+
+type sent struct {
+	Kind      string
+	RequestID int
+	UserID    int
+}
+
+func sentMessages(messages []Message) []sent {
+	got := []sent{}
+	for _, message := range messages {
+		got = append(got, sent{message.Kind, message.RequestID, message.UserID})
+	}
+	return got
+}
+
+func TestScheduler(t *testing.T) {
+	notifier := &MemoryNotifier{}
+	scheduler, _ := newTestScheduler(notifier)
+	ctx := stdcontext.Background()
+
+	// 締切前のシフトリクエストについて、未提出のユーザーにだけ作成を知らせる
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	if err := scheduler.RunOnce(ctx, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []sent{{model.NotificationKindRequestCreated, 1, 2}}
+	if got := sentMessages(notifier.Messages()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// 送信済みの通知は送らない
+	if err := scheduler.RunOnce(ctx, now.Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := sentMessages(notifier.Messages()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// 締切まで24時間以内になったらリマインドを送る
+	if err := scheduler.RunOnce(ctx, time.Date(2024, 6, 2, 0, 0, 0, 0, time.Local)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want = append(want, sent{model.NotificationKindDeadlineReminder, 1, 2})
+	if got := sentMessages(notifier.Messages()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	message := notifier.Messages()[1]
+	if message.Email != "user2@example.com" || message.Subject != "シフトの提出期限が近づいています" || !strings.Contains(message.Body, "2024-06-10〜2024-06-16") {
+		t.Errorf("unexpected message: %+v", message)
+	}
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(ctx stdcontext.Context, message Message) error {
+	return errors.New("failed")
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	scheduler, database := newTestScheduler(failingNotifier{})
+	ctx := stdcontext.Background()
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+
+	// 失敗した通知は記録しない
+	if err := scheduler.RunOnce(ctx, now); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// 再起動後(別のスケジューラー)に送り直す
+	notifier := &MemoryNotifier{}
+	scheduler = NewScheduler(context.NewAppContext(database, nil), notifier)
+	if err := scheduler.RunOnce(ctx, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(notifier.Messages()) != 1 {
+		t.Errorf("want 1 message, got %+v", notifier.Messages())
+	}
+
+	// 再起動しても送信済みの通知は送らない
+	notifier = &MemoryNotifier{}
+	scheduler = NewScheduler(context.NewAppContext(database, nil), notifier)
+	if err := scheduler.RunOnce(ctx, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(notifier.Messages()) != 0 {
+		t.Errorf("want no messages, got %+v", notifier.Messages())
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got map[string]any
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected content type: %s", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL}
+	message := Message{Kind: model.NotificationKindRequestCreated, RequestID: 1, UserID: 2, UserName: "テストユーザー2", Subject: "件名", Body: "本文"}
+	if err := notifier.Notify(stdcontext.Background(), message); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]any{
+		"kind":       "request.created",
+		"request_id": float64(1),
+		"user":       map[string]any{"id": float64(2), "name": "テストユーザー2", "email": ""},
+		"subject":    "件名",
+		"body":       "本文",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// 2xx以外は失敗
+	status = http.StatusInternalServerError
+	if err := notifier.Notify(stdcontext.Background(), message); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestBuildMail(t *testing.T) {
+	mail := string(buildMail("shift@example.com", Message{Email: "user@example.com", Subject: "件名", Body: "本文"}, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)))
+	for _, want := range []string{
+		"From: shift@example.com\r\n",
+		"To: user@example.com\r\n",
+		"Subject: =?UTF-8?b?5Lu25ZCN?=\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n",
+		"\r\n\r\n5pys5paH\r\n",
+	} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("mail should contain %q, got %q", want, mail)
+		}
+	}
+}
+
+func TestInboxNotifier(t *testing.T) {
+	_, database := newTestScheduler(nil)
+	appCtx := context.NewAppContext(database, nil)
+	scheduler := NewScheduler(appCtx, Multi(NewInboxNotifier(appCtx), failingNotifier{}))
+	ctx := stdcontext.Background()
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+
+	// 他の方法での送信に失敗して送り直しても、アプリ内の通知は1件だけ作成する
+	for i := 0; i < 2; i++ {
+		if err := scheduler.RunOnce(ctx, now); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+
+	var n model.UserNotification
+	page, err := n.FindPage(appCtx, 2, model.UserNotificationFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Notifications) != 1 || page.Notifications[0].Kind != model.NotificationKindRequestCreated || page.Notifications[0].RequestID != 1 {
+		t.Errorf("unexpected notifications: %+v", page.Notifications)
+	}
+}
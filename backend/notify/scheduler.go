@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"backend/context"
+	"backend/model"
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// 定期的にまだ送っていない通知を探して送る
+// 送信済みの通知はDBに記録するので、再起動しても同じ通知は送らない
+type Scheduler struct {
+	appCtx   *context.AppContext
+	notifier Notifier
+
+	// 通知を探す間隔
+	Interval time.Duration
+	// 締切までこの時間以内になったら、未提出のユーザーにリマインドを送る
+	ReminderWindow time.Duration
+}
+
+// NewSchedulerで設定する既定値
+const (
+	DefaultInterval       = 5 * time.Minute
+	DefaultReminderWindow = 24 * time.Hour
+)
+
+func NewScheduler(appCtx *context.AppContext, notifier Notifier) *Scheduler {
+	return &Scheduler{
+		appCtx:         appCtx,
+		notifier:       notifier,
+		Interval:       DefaultInterval,
+		ReminderWindow: DefaultReminderWindow,
+	}
+}
+
+// ctxがキャンセルされるまで、Intervalごとに通知を送る
+// 起動直後にも1回送る. キャンセルされても、送信中のものは送り終えてから戻る
+func (s *Scheduler) Run(ctx stdcontext.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RunOnce(stdcontext.WithoutCancel(ctx), time.Now()); err != nil {
+			slog.ErrorContext(ctx, "通知の送信に失敗しました", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 現在時刻をnowとして、まだ送っていない通知を全て送る
+// 送信に失敗した通知は記録しないので、次回送り直す
+func (s *Scheduler) RunOnce(ctx stdcontext.Context, now time.Time) error {
+	appCtx := s.appCtx.WithContext(ctx)
+
+	var n model.Notification
+	notifications, err := n.FindPending(appCtx, now, s.ReminderWindow)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, notification := range notifications {
+		if err := s.notifier.Notify(ctx, NewMessage(notification)); err != nil {
+			errs = append(errs, fmt.Errorf("%s request_id=%d user_id=%d: %w", notification.Kind, notification.Request.ID, notification.Recipient.ID, err))
+			continue
+		}
+		if err := notification.MarkSent(appCtx, now); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	stdcontext "context"
+	"encoding/base64"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// メールで送るNotifier
+// メールアドレスが未設定のユーザーには送らない
+type SMTPNotifier struct {
+	Addr string // host:port
+	From string
+	// 空の場合は認証しない
+	Username string
+	Password string
+}
+
+func (n *SMTPNotifier) Notify(ctx stdcontext.Context, message Message) error {
+	if message.Email == "" {
+		slog.InfoContext(ctx, "メールアドレスが未設定のため、通知をメールで送りません", slog.Int("user_id", message.UserID))
+		return nil
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	return smtp.SendMail(n.Addr, auth, n.From, []string{message.Email}, buildMail(n.From, message, time.Now()))
+}
+
+// ヘッダーと本文を含むメールを作成する
+// 件名と本文は日本語を含むので、それぞれMIMEエンコード、Base64エンコードする
+func buildMail(from string, message Message, date time.Time) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + message.Email + "\r\n")
+	b.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", message.Subject) + "\r\n")
+	b.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("\r\n")
+
+	// 1行は76文字まで
+	encoded := base64.StdEncoding.EncodeToString([]byte(message.Body))
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return []byte(b.String())
+}
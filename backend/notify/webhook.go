@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"bytes"
+	stdcontext "context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// 指定したURLにJSONをPOSTするNotifier
+// チャットツールなど、受け取った側で配信する場合に使う
+type WebhookNotifier struct {
+	URL string
+	// nilの場合はhttp.DefaultClient
+	Client *http.Client
+}
+
+// WebhookNotifierが送るJSON
+type webhookPayload struct {
+	Kind      string      `json:"kind"`
+	RequestID int         `json:"request_id"`
+	User      webhookUser `json:"user"`
+	Subject   string      `json:"subject"`
+	Body      string      `json:"body"`
+}
+
+type webhookUser struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (n *WebhookNotifier) Notify(ctx stdcontext.Context, message Message) error {
+	body, err := json.Marshal(webhookPayload{
+		Kind:      message.Kind,
+		RequestID: message.RequestID,
+		User:      webhookUser{ID: message.UserID, Name: message.UserName, Email: message.Email},
+		Subject:   message.Subject,
+		Body:      message.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	return nil
+}
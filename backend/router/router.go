@@ -1,6 +1,7 @@
 package router
 
 import (
+	"backend/auth"
 	"backend/context"
 	"backend/handler"
 	"backend/middleware"
@@ -15,14 +16,47 @@ type route struct {
 	handlerFn handler.HandlerFuncWithContext
 }
 
+// CSRFトークンを検証しないルート
+// ログイン前はトークンを持っていないため
+var csrfExemptRoutes = map[string]bool{
+	"POST /login":          true,
+	"POST /password-reset": true,
+}
+
+// 接続を維持してイベントを送り続けるルート。リクエストのタイムアウトを設定しない
+var streamRoutes = map[string]bool{
+	"GET /requests/{id}/events": true,
+}
+
+// APIトークンで呼び出せるルートと、必要なスコープ
+// ここにないルートはセッションでしか呼び出せない
+// シフトリクエストの詳細は全員の提出内容を含むので、submissions:readが必要
+var apiTokenScopes = map[string]string{
+	"GET /requests":      auth.ScopeRequestsRead,
+	"GET /requests/{id}": auth.ScopeSubmissionsRead,
+	"GET /requests/{request_id}/submissions/mine": auth.ScopeSubmissionsRead,
+	"GET /requests/{id}/status":                   auth.ScopeSubmissionsRead,
+	"GET /requests/{id}/export":                   auth.ScopeExport,
+}
+
 // ミドルウェアを適用してルーティングを設定するヘルパー関数
 func applyRoutes(ctx *context.AppContext, mux *http.ServeMux, routes []route) {
 	basePath := "/api"
 	for _, r := range routes {
-		if r.method == "POST" {
+		if r.method == "POST" || r.method == "PUT" {
 			r.handlerFn = middleware.ValidateContentType(r.handlerFn)
 		}
-		handler := handler.NewHandler(ctx, r.handlerFn)
+		if r.method != "GET" && !csrfExemptRoutes[r.method+" "+r.pattern] {
+			r.handlerFn = middleware.ValidateCSRFToken(r.handlerFn)
+		}
+		if scope, ok := apiTokenScopes[r.method+" "+r.pattern]; ok {
+			r.handlerFn = middleware.RequireScope(scope, r.handlerFn)
+		}
+		newHandler := handler.NewHandler
+		if streamRoutes[r.method+" "+r.pattern] {
+			newHandler = handler.NewStreamHandler
+		}
+		handler := newHandler(ctx, r.handlerFn)
 		path := filepath.Join(basePath, r.pattern)
 		mux.Handle(r.method+" "+path, handler)
 	}
@@ -31,14 +65,52 @@ func applyRoutes(ctx *context.AppContext, mux *http.ServeMux, routes []route) {
 func Routes(mux *http.ServeMux, ctx *context.AppContext) {
 	routes := []route{
 		{"POST", "/login", handler.LoginRequest},
+		{"GET", "/login/pending", handler.GetLoginPendingRequest},
+		{"POST", "/login/totp", handler.PostLoginTOTPRequest},
+		{"GET", "/auth/oidc/start", handler.GetOIDCStartRequest},
+		{"GET", "/auth/oidc/callback", handler.GetOIDCCallbackRequest},
 		{"GET", "/session", handler.GetSessionRequest},
 		{"DELETE", "/session", handler.LogoutRequest},
 		{"GET", "/requests", handler.GetRequestsRequest},
 		{"GET", "/requests/{id}", handler.GetRequestRequest},
 		{"POST", "/requests", handler.PostRequestsRequest},
 		{"POST", "/requests/{id}/submissions", handler.PostSubmissionsRequest},
+		{"PUT", "/requests/{id}/submissions/{submission_id}", handler.PutSubmissionRequest},
 		{"GET", "/requests/{request_id}/submissions/mine", handler.GetMySubmissionRequest},
+		{"GET", "/requests/{id}/status", handler.GetRequestStatusRequest},
+		{"GET", "/requests/{id}/export", handler.GetRequestExportRequest},
+		{"GET", "/requests/{id}/events", handler.GetRequestEventsRequest},
+		{"GET", "/audit", handler.GetAuditRequest},
+		{"POST", "/users/{id}/unlock", handler.PostUnlockUserRequest},
+		{"POST", "/users/{id}/password-reset", handler.PostPasswordResetTokenRequest},
+		{"POST", "/me/password", handler.PostMyPasswordRequest},
+		{"POST", "/password-reset", handler.PostPasswordResetRequest},
+		{"POST", "/me/totp", handler.PostTOTPRequest},
+		{"POST", "/me/totp/confirm", handler.PostTOTPConfirmRequest},
+		{"POST", "/me/totp/recovery-codes", handler.PostTOTPRecoveryCodesRequest},
+		{"POST", "/me/totp/disable", handler.PostTOTPDisableRequest},
+		{"GET", "/me/notifications", handler.GetMyNotificationsRequest},
+		{"POST", "/me/notifications/{id}/read", handler.PostMyNotificationReadRequest},
+		{"POST", "/api-tokens", handler.PostAPITokensRequest},
+		{"GET", "/api-tokens", handler.GetAPITokensRequest},
+		{"DELETE", "/api-tokens/{id}", handler.DeleteAPITokenRequest},
+		{"GET", "/organizations", handler.GetOrganizationsRequest},
+		{"POST", "/organizations", handler.PostOrganizationsRequest},
+		{"GET", "/organizations/{id}/members", handler.GetOrganizationMembersRequest},
+		{"POST", "/organizations/{id}/members", handler.PostOrganizationMembersRequest},
+		{"GET", "/groups", handler.GetGroupsRequest},
+		{"POST", "/groups", handler.PostGroupsRequest},
+		{"POST", "/groups/{id}/members", handler.PostGroupMembersRequest},
+		{"DELETE", "/groups/{id}/members/{user_id}", handler.DeleteGroupMemberRequest},
+		{"GET", "/webhooks", handler.GetWebhooksRequest},
+		{"POST", "/webhooks", handler.PostWebhooksRequest},
+		{"DELETE", "/webhooks/{id}", handler.DeleteWebhookRequest},
+		{"GET", "/webhooks/{id}/deliveries", handler.GetWebhookDeliveriesRequest},
 	}
 
 	applyRoutes(ctx, mux, routes)
+
+	// ロードバランサーなどから死活監視に使うので、/apiの外に置く
+	mux.Handle("GET /healthz", handler.NewHandler(ctx, handler.GetHealthzRequest))
+	mux.Handle("GET /readyz", handler.NewHandler(ctx, handler.GetReadyzRequest))
 }
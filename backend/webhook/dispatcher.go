@@ -0,0 +1,210 @@
+package webhook
+
+import (
+	"backend/context"
+	"backend/model"
+	"bytes"
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 定期的に送信待ちのWebhookのイベントを送る
+// 送信待ちのイベントはDBに保存しているので、再起動しても失われない
+// 送信に失敗した場合は、指数バックオフで間隔を空けてMaxAttempts回まで送り直す
+type Dispatcher struct {
+	appCtx *context.AppContext
+
+	// nilの場合はTimeoutを設定し、送信先のアドレスを確認するクライアントを使う
+	Client *http.Client
+	// trueの場合、ループバックやプライベートネットワークのアドレスにも送る
+	AllowPrivateAddresses bool
+	// 送信待ちのイベントを探す間隔
+	Interval time.Duration
+	// 1回の実行で送るイベントの最大数
+	BatchSize int
+	// 同時に送信するイベントの最大数
+	Concurrency int
+	// 送信を試みる最大回数。これに達したら送信を諦める
+	MaxAttempts int
+	// 1回目の失敗後の再送までの間隔。失敗するごとに2倍にする
+	BaseBackoff time.Duration
+	// 再送までの間隔の上限
+	MaxBackoff time.Duration
+}
+
+// NewDispatcherで設定する既定値
+const (
+	DefaultInterval    = 10 * time.Second
+	DefaultMaxAttempts = 8
+)
+
+func NewDispatcher(appCtx *context.AppContext) *Dispatcher {
+	return &Dispatcher{
+		appCtx:      appCtx,
+		Interval:    DefaultInterval,
+		BatchSize:   100,
+		Concurrency: 4,
+		MaxAttempts: DefaultMaxAttempts,
+		BaseBackoff: 30 * time.Second,
+		MaxBackoff:  6 * time.Hour,
+	}
+}
+
+// 送信先のアドレスが内部のネットワークの場合のエラー
+var ErrPrivateAddress = errors.New("webhook destination is a private address")
+
+// 名前解決した後の接続先のアドレスを確認するので、DNSで内部のアドレスを返すURLやリダイレクトでも送らない
+var defaultClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if model.IsPrivateAddress(addrPort.Addr()) {
+					return fmt.Errorf("%w: %s", ErrPrivateAddress, addrPort.Addr())
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+var privateClient = &http.Client{Timeout: 10 * time.Second}
+
+// ctxがキャンセルされるまで、Intervalごとにイベントを送る
+// 起動直後にも1回送る. キャンセルされても、送信中のものは送り終えてから戻る
+func (d *Dispatcher) Run(ctx stdcontext.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.RunOnce(stdcontext.WithoutCancel(ctx), time.Now()); err != nil {
+			slog.ErrorContext(ctx, "Webhookの送信に失敗しました", slog.String("error", err.Error()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 現在時刻をnowとして、送信時刻を過ぎたイベントを送る
+// 送信の成否はDBに記録する。送信に失敗したイベントはエラーにまとめて返す
+func (d *Dispatcher) RunOnce(ctx stdcontext.Context, now time.Time) error {
+	appCtx := d.appCtx.WithContext(ctx)
+
+	var dispatch model.WebhookDispatch
+	dispatches, err := dispatch.FindDue(appCtx, now, d.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	// 送信はConcurrency件ずつ並行して行い、結果はまとめてDBに記録する
+	type result struct {
+		statusCode int
+		err        error
+	}
+	results := make([]result, len(dispatches))
+	sem := make(chan struct{}, max(d.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, dispatch := range dispatches {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			statusCode, err := d.send(ctx, dispatch)
+			results[i] = result{statusCode, err}
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for i, dispatch := range dispatches {
+		statusCode, err := results[i].statusCode, results[i].err
+		if err == nil {
+			if err := dispatch.MarkSucceeded(appCtx, statusCode, now); err != nil {
+				return err
+			}
+			continue
+		}
+
+		errs = append(errs, fmt.Errorf("%s delivery_id=%d: %w", dispatch.Event, dispatch.DeliveryID, err))
+		var nextAttemptAt *time.Time
+		if dispatch.Attempts+1 < d.MaxAttempts {
+			next := now.Add(d.backoff(dispatch.Attempts + 1))
+			nextAttemptAt = &next
+		}
+		if err := dispatch.MarkFailed(appCtx, statusCode, err.Error(), nextAttemptAt); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// attempts回目の失敗の後、再送までの間隔
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= d.MaxBackoff {
+			return d.MaxBackoff
+		}
+	}
+	return min(backoff, d.MaxBackoff)
+}
+
+// 署名を付けてイベントをPOSTし、レスポンスのステータスコードを返す
+// レスポンスがなかった場合、ステータスコードは0
+// 待たされた場合でも受け取った側で古すぎると判定されないように、タイムスタンプは送る直前の時刻にする
+func (d *Dispatcher) send(ctx stdcontext.Context, dispatch model.WebhookDispatch) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dispatch.URL, bytes.NewReader(dispatch.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, dispatch.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(dispatch.DeliveryID))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(dispatch.Secret, timestamp, dispatch.Payload))
+
+	client := d.Client
+	if client == nil {
+		client = defaultClient
+		if d.AllowPrivateAddresses {
+			client = privateClient
+		}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// コネクションを再利用できるように本文を読み捨てる
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
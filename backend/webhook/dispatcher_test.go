@@ -0,0 +1,281 @@
+package webhook
+
+import (
+	"backend/auth"
+	"backend/context"
+	"backend/db"
+	"backend/model"
+	stdcontext "context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 受け取ったリクエストを記録し、statusesの順にステータスコードを返すサーバー
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+}
+
+type received struct {
+	Event     string
+	Delivery  string
+	Timestamp int64
+	Body      []byte
+	Signature string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	rc.requests = append(rc.requests, received{
+		Event:     r.Header.Get(HeaderEvent),
+		Delivery:  r.Header.Get(HeaderDelivery),
+		Timestamp: timestamp,
+		Body:      body,
+		Signature: r.Header.Get(HeaderSignature),
+	})
+
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status = rc.statuses[0]
+		rc.statuses = rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) received() []received {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]received{}, rc.requests...)
+}
+
+// receiverに送るWebhookを登録し、シフトリクエストを1件作成する
+// httptestのサーバーはループバックアドレスなので、内部のネットワークにも送るようにする
+func newTestDispatcher(t *testing.T, url string) (*Dispatcher, db.DB, int, string) {
+	t.Helper()
+	model.SetAllowPrivateWebhookAddresses(true)
+	t.Cleanup(func() { model.SetAllowPrivateWebhookAddresses(false) })
+	database := db.NewMockDB(
+		[]db.Request{},
+		[]db.User{
+			{ID: 1, LoginID: "test_manager", Password: "password", Name: "テストマネージャー", Role: auth.RoleManager, CreatedAt: "2024-05-01 00:00:00"},
+		},
+		[]db.Entry{},
+		[]db.Submission{},
+	)
+	appCtx := context.NewAppContext(database, nil)
+
+	var w model.Webhook
+	webhookID, secret, err := w.Create(appCtx, 1, model.NewWebhook{URL: url, Events: []string{model.WebhookEventRequestCreated}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	startDate, _ := model.NewDateOnly("2099-06-10")
+	endDate, _ := model.NewDateOnly("2099-06-16")
+	deadline, _ := model.NewDateTime("2099-06-03 00:00:00")
+	var r model.Request
+	if _, err := r.Create(appCtx, model.NewRequest{CreatorID: 1, StartDate: startDate, EndDate: endDate, Deadline: deadline}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dispatcher := NewDispatcher(appCtx)
+	dispatcher.AllowPrivateAddresses = true
+	dispatcher.MaxAttempts = 3
+	dispatcher.BaseBackoff = time.Minute
+	dispatcher.MaxBackoff = 90 * time.Second
+	return dispatcher, database, webhookID, secret
+}
+
+func deliveries(t *testing.T, database db.DB, webhookID int) []db.WebhookDelivery {
+	t.Helper()
+	deliveries, err := database.QueryWebhookDeliveries(stdcontext.Background(), db.WebhookDeliveryQuery{SubscriptionID: webhookID, Limit: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return deliveries
+}
+
+func TestDispatcher(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	dispatcher, database, webhookID, secret := newTestDispatcher(t, server.URL)
+	ctx := stdcontext.Background()
+
+	// タイムスタンプはnowではなく送信した時刻
+	now := time.Now().Add(time.Hour)
+	sentAfter := time.Now().Unix()
+	if err := dispatcher.RunOnce(ctx, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	requests := rc.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+	got := requests[0]
+	if got.Event != model.WebhookEventRequestCreated || got.Timestamp < sentAfter || got.Timestamp > time.Now().Unix() {
+		t.Errorf("unexpected headers: %+v", got)
+	}
+	if !Verify(secret, got.Timestamp, got.Body, got.Signature) {
+		t.Errorf("invalid signature: %s", got.Signature)
+	}
+	if Verify("whsec_wrong", got.Timestamp, got.Body, got.Signature) || Verify(secret, got.Timestamp+1, got.Body, got.Signature) {
+		t.Errorf("signature should depend on secret and timestamp")
+	}
+
+	delivery := deliveries(t, database, webhookID)[0]
+	if delivery.Status != db.WebhookDeliverySucceeded || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusOK || delivery.DeliveredAt != now.Unix() {
+		t.Errorf("unexpected delivery: %+v", delivery)
+	}
+
+	// 送信済みのイベントは送らない
+	if err := dispatcher.RunOnce(ctx, now.Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rc.received()) != 1 {
+		t.Errorf("succeeded delivery should not be sent again")
+	}
+}
+
+func TestDispatcherRetry(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusBadGateway}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	dispatcher, database, webhookID, _ := newTestDispatcher(t, server.URL)
+	ctx := stdcontext.Background()
+
+	// 失敗したら BaseBackoff 後に再送する
+	now := time.Now().Add(time.Second)
+	if err := dispatcher.RunOnce(ctx, now); err == nil {
+		t.Fatalf("expected error")
+	}
+	delivery := deliveries(t, database, webhookID)[0]
+	if delivery.Status != db.WebhookDeliveryPending || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusInternalServerError || delivery.NextAttemptAt != now.Add(time.Minute).Unix() {
+		t.Errorf("unexpected delivery: %+v", delivery)
+	}
+
+	// 再送時刻の前は送らない
+	if err := dispatcher.RunOnce(ctx, now.Add(30*time.Second)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rc.received()) != 1 {
+		t.Errorf("delivery should not be sent before next attempt")
+	}
+
+	// 再送の間隔は2倍にするが、MaxBackoffを超えない
+	now = now.Add(time.Minute)
+	if err := dispatcher.RunOnce(ctx, now); err == nil {
+		t.Fatalf("expected error")
+	}
+	delivery = deliveries(t, database, webhookID)[0]
+	if delivery.Status != db.WebhookDeliveryPending || delivery.Attempts != 2 || delivery.NextAttemptAt != now.Add(90*time.Second).Unix() {
+		t.Errorf("unexpected delivery: %+v", delivery)
+	}
+
+	// MaxAttempts回失敗したら送信を諦める
+	now = now.Add(90 * time.Second)
+	if err := dispatcher.RunOnce(ctx, now); err == nil {
+		t.Fatalf("expected error")
+	}
+	delivery = deliveries(t, database, webhookID)[0]
+	if delivery.Status != db.WebhookDeliveryFailed || delivery.Attempts != 3 || delivery.LastStatusCode != http.StatusBadGateway || delivery.LastError == "" {
+		t.Errorf("unexpected delivery: %+v", delivery)
+	}
+	if err := dispatcher.RunOnce(ctx, now.Add(24*time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rc.received()) != 3 {
+		t.Errorf("expected 3 requests, got %d", len(rc.received()))
+	}
+}
+
+func TestDispatcherConnectionError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+	dispatcher, database, webhookID, _ := newTestDispatcher(t, url)
+
+	if err := dispatcher.RunOnce(stdcontext.Background(), time.Now().Add(time.Second)); err == nil {
+		t.Fatalf("expected error")
+	}
+	delivery := deliveries(t, database, webhookID)[0]
+	if delivery.Status != db.WebhookDeliveryPending || delivery.Attempts != 1 || delivery.LastStatusCode != 0 || delivery.LastError == "" {
+		t.Errorf("unexpected delivery: %+v", delivery)
+	}
+}
+
+func TestDispatcherConcurrency(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	dispatcher, database, webhookID, _ := newTestDispatcher(t, server.URL)
+	dispatcher.Concurrency = 2
+
+	// BatchSizeを超える分は次の実行で送る
+	appCtx := context.NewAppContext(database, nil)
+	startDate, _ := model.NewDateOnly("2099-07-10")
+	endDate, _ := model.NewDateOnly("2099-07-16")
+	deadline, _ := model.NewDateTime("2099-07-03 00:00:00")
+	var r model.Request
+	for range 4 {
+		if _, err := r.Create(appCtx, model.NewRequest{CreatorID: 1, StartDate: startDate, EndDate: endDate, Deadline: deadline}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	dispatcher.BatchSize = 3
+
+	now := time.Now().Add(time.Second)
+	if err := dispatcher.RunOnce(stdcontext.Background(), now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rc.received()) != 3 {
+		t.Errorf("expected 3 requests, got %d", len(rc.received()))
+	}
+	if err := dispatcher.RunOnce(stdcontext.Background(), now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, delivery := range deliveries(t, database, webhookID) {
+		if delivery.Status != db.WebhookDeliverySucceeded {
+			t.Errorf("unexpected delivery: %+v", delivery)
+		}
+	}
+}
+
+func TestDispatcherPrivateAddress(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	dispatcher, database, webhookID, _ := newTestDispatcher(t, server.URL)
+	dispatcher.AllowPrivateAddresses = false
+
+	// 名前解決した後のアドレスがループバックなので送らない
+	url := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	for _, url := range []string{server.URL, url} {
+		dispatch := model.WebhookDispatch{URL: url, Event: model.WebhookEventRequestCreated, Payload: []byte("{}")}
+		if _, err := dispatcher.send(stdcontext.Background(), dispatch); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("%s: want ErrPrivateAddress, got %v", url, err)
+		}
+	}
+	if err := dispatcher.RunOnce(stdcontext.Background(), time.Now().Add(time.Second)); err == nil {
+		t.Fatalf("expected error")
+	}
+	if len(rc.received()) != 0 {
+		t.Errorf("private address should not receive webhooks")
+	}
+	delivery := deliveries(t, database, webhookID)[0]
+	if delivery.Status != db.WebhookDeliveryPending || !strings.Contains(delivery.LastError, ErrPrivateAddress.Error()) {
+		t.Errorf("unexpected delivery: %+v", delivery)
+	}
+}
@@ -1,54 +0,0 @@
--- TODO: unique制約
-
--- ユーザーテーブル
-CREATE TABLE IF NOT EXISTS users (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    login_id TEXT NOT NULL UNIQUE,
-    password TEXT NOT NULL,
-    name TEXT NOT NULL,
-    role INTEGER NOT NULL,
-    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime'))
-);
-
--- セッションテーブル
-CREATE TABLE IF NOT EXISTS sessions (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    token TEXT NOT NULL UNIQUE,
-    expires_at INTEGER NOT NULL,
-    user_id INTEGER NOT NULL,
-    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
-
-    FOREIGN KEY (user_id) REFERENCES users(id)
-);
-
--- リクエストテーブル
-CREATE TABLE IF NOT EXISTS requests (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    creator_id INTEGER NOT NULL,
-    start_date TEXT NOT NULL,
-    end_date TEXT NOT NULL,
-    deadline TEXT NOT NULL,
-    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime'))
-);
-
--- シフトエントリーテーブル
-CREATE TABLE IF NOT EXISTS entries (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    submission_id INTEGER NOT NULL,
-    date TEXT NOT NULL,
-    hour INTEGER NOT NULL,
-
-    FOREIGN KEY (submission_id) REFERENCES submissions(id)
-);
-
--- シフト提出テーブル
-CREATE TABLE IF NOT EXISTS submissions (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    request_id INTEGER NOT NULL,
-    submitter_id INTEGER NOT NULL,
-    created_at TEXT NOT NULL DEFAULT (DATETIME('now', 'localtime')),
-    updated_at TEXT NOT NULL,
-
-    FOREIGN KEY (submitter_id) REFERENCES users(id),
-    FOREIGN KEY (request_id) REFERENCES requests(id)
-);
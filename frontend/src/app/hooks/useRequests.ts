@@ -35,7 +35,7 @@ export function useRequests(): UseRequestsResult {
             const res = await get(`/requests`);
             if (res && res.ok) {
                 const data = await res.json();
-                setRequests(data);
+                setRequests(data.requests);
             } else if (res) {
                 const data = await res.json();
                 setError(data.error || "取得に失敗しました");
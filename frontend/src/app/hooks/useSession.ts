@@ -7,6 +7,7 @@ import { User, SessionData } from '@/app/types';
 interface UseSessionResult {
     user: User | null;
     roles: string[];
+    permissions: string[];
     isLoading: boolean;
     error: string | null;
     refetch: () => Promise<void>;
@@ -15,6 +16,7 @@ interface UseSessionResult {
 export function useSession(): UseSessionResult {
     const [user, setUser] = useState<User | null>(null);
     const [roles, setRoles] = useState<string[]>([]);
+    const [permissions, setPermissions] = useState<string[]>([]);
     const [isLoading, setIsLoading] = useState<boolean>(true);
     const [error, setError] = useState<string | null>(null);
 
@@ -29,14 +31,17 @@ export function useSession(): UseSessionResult {
                 const data: SessionData = await response.json();
                 setUser(data.user);
                 setRoles(data.user?.roles || []);
+                setPermissions(data.user?.permissions || []);
             } else {
                 setUser(null);
                 setRoles([]);
+                setPermissions([]);
                 setError('セッション情報の取得に失敗しました');
             }
         } catch (err) {
             setUser(null);
             setRoles([]);
+            setPermissions([]);
             setError('セッション情報の取得中にエラーが発生しました');
         } finally {
             setIsLoading(false);
@@ -50,6 +55,7 @@ export function useSession(): UseSessionResult {
     return {
         user,
         roles,
+        permissions,
         isLoading,
         error,
         refetch: fetchSession
@@ -19,6 +19,35 @@ export class ApiError extends Error {
     }
 }
 
+// CSRFトークン
+// GET /session で取得し、状態を変更するリクエストの X-CSRF-Token ヘッダーに付与する
+let csrfToken: string | null = null;
+
+/**
+ * CSRFトークンを取得する
+ * 未取得の場合は GET /session から取得します
+ */
+const getCsrfToken = async (): Promise<string | null> => {
+    if (csrfToken) {
+        return csrfToken;
+    }
+
+    const response = await fetch(`${API_BASE_URL}/session`, { credentials: 'include' });
+    if (response.ok) {
+        const data = await response.json();
+        csrfToken = data.csrf_token ?? null;
+    }
+    return csrfToken;
+};
+
+/**
+ * ログインのレスポンスで受け取ったCSRFトークンを保存する
+ * 二段階目の認証待ちの間は GET /session から取得できないため
+ */
+export const setCsrfToken = (token: string | null) => {
+    csrfToken = token;
+};
+
 /**
  * 共通のAPI呼び出し関数
  * 401エラー時に自動的にログインページにリダイレクトします
@@ -26,17 +55,36 @@ export class ApiError extends Error {
 export const fetchWithAuth = async <T>(endpoint: string, options: RequestInit = {}): Promise<Response> => {
     // API ベースURLとエンドポイントを結合
     const url = `${API_BASE_URL}${endpoint}`;
+    const method = (options.method || 'GET').toUpperCase();
+
+    // 状態を変更するリクエストにはCSRFトークンを付与する(ログイン前に使うものを除く)
+    const headers = new Headers(options.headers);
+    if (!['GET', 'HEAD', 'OPTIONS'].includes(method) && !['/login', '/password-reset'].includes(endpoint)) {
+        const token = await getCsrfToken();
+        if (token) {
+            headers.set('X-CSRF-Token', token);
+        }
+    }
+
     // クッキーを含めるためのデフォルトオプション
     const defaultOptions: RequestInit = {
         credentials: 'include',
         ...options,
+        headers,
     };
 
     try {
         const response = await fetch(url, defaultOptions);
 
+        // ログイン、ログアウト、パスワード変更でセッションが変わるとトークンも変わる
+        if (endpoint === '/login' || endpoint === '/me/password' || (endpoint.endsWith('/session') && method === 'DELETE')) {
+            csrfToken = null;
+        }
+
         // 401エラーが発生したらログインページにリダイレクト
-        if (response.status === 401) {
+        // ログイン処理中の401は入力の誤りなので、ログインページで表示する
+        if (response.status === 401 && !endpoint.startsWith('/login')) {
+            csrfToken = null;
             console.error('Authentication failed, redirecting to login page');
             window.location.href = '/login';
             return null as any;
@@ -1,18 +1,88 @@
 "use client";
-import React, { useState } from "react";
+import React, { useEffect, useState } from "react";
 import { useRouter } from "next/navigation";
-import { post } from "../lib/api";
+import { API_BASE_URL, get, post, setCsrfToken } from "../lib/api";
 import { Button } from "../components/ui";
 
-// TODO: ログイン失敗時の挙動おかしい
+// ログインの段階
+// password: ログインIDとパスワード
+// totp: 認証アプリのコード(またはリカバリーコード)
+// enroll: 二要素認証が必須で未登録の場合の登録
+// recovery: 登録完了後のリカバリーコードの表示
+type Step = "password" | "totp" | "enroll" | "recovery";
 
 export default function LoginPage() {
     const [loginId, setLoginId] = useState("");
     const [password, setPassword] = useState("");
+    const [code, setCode] = useState("");
+    const [step, setStep] = useState<Step>("password");
+    const [enrollment, setEnrollment] = useState<{ secret: string; otpauth_uri: string } | null>(null);
+    const [recoveryCodes, setRecoveryCodes] = useState<string[]>([]);
     const [error, setError] = useState("");
     const [loading, setLoading] = useState(false);
     const router = useRouter();
 
+    // 二要素認証の登録を開始する
+    const startEnrollment = async () => {
+        const res = await post(`/me/totp`, {});
+        const data = await res.json();
+        if (!res.ok) {
+            setError(data.error || "二要素認証の登録に失敗しました");
+            return;
+        }
+        setEnrollment(data);
+        setStep("enroll");
+    };
+
+    // OIDCでのログインで二要素認証が必要な場合は、mfa=pendingを付けてリダイレクトされる
+    useEffect(() => {
+        if (new URLSearchParams(window.location.search).get("mfa") !== "pending") {
+            return;
+        }
+        const resumePendingLogin = async () => {
+            const res = await get(`/login/pending`);
+            const data = await res.json();
+            if (!res.ok) {
+                setError(data.error || "ログインに失敗しました");
+                return;
+            }
+            setCsrfToken(data.csrf_token ?? null);
+            if (data.mfa_enrollment_required) {
+                await startEnrollment();
+            } else {
+                setStep("totp");
+            }
+        };
+        resumePendingLogin().catch(() => setError("通信エラーが発生しました"));
+    }, []);
+
+    const handleCodeSubmit = async (e: React.FormEvent) => {
+        e.preventDefault();
+        setError("");
+        setLoading(true);
+        try {
+            const endpoint = step === "enroll" ? `/me/totp/confirm` : `/login/totp`;
+            const res = await post(endpoint, { code });
+            const data = await res.json();
+            if (!res.ok) {
+                setError(data.error || "認証に失敗しました");
+                return;
+            }
+
+            if (step === "enroll") {
+                setRecoveryCodes(data.recovery_codes);
+                setStep("recovery");
+            } else {
+                setCsrfToken(data.csrf_token ?? null);
+                router.push("/requests");
+            }
+        } catch (err) {
+            setError("通信エラーが発生しました");
+        } finally {
+            setLoading(false);
+        }
+    };
+
     const handleSubmit = async (e: React.FormEvent) => {
         e.preventDefault();
         setError("");
@@ -24,7 +94,15 @@ export default function LoginPage() {
             });
 
             if (res && res.ok) {
-                router.push("/requests");
+                const data = await res.json();
+                setCsrfToken(data.csrf_token ?? null);
+                if (data.mfa_enrollment_required) {
+                    await startEnrollment();
+                } else if (data.mfa_required) {
+                    setStep("totp");
+                } else {
+                    router.push("/requests");
+                }
             } else if (res) {
                 const data = await res.json();
                 setError(data.error || "ログインに失敗しました");
@@ -36,6 +114,72 @@ export default function LoginPage() {
         }
     };
 
+    if (step === "recovery") {
+        return (
+            <div className="flex flex-col items-center justify-center min-h-screen bg-gray-50">
+                <div className="w-full max-w-sm p-8 bg-white rounded shadow-md">
+                    <h1 className="text-2xl font-bold mb-6 text-center">リカバリーコード</h1>
+                    <p className="text-sm mb-4">
+                        認証アプリを使えない場合に、一度だけ使えるコードです。この画面でしか表示されないので、安全な場所に保管してください。
+                    </p>
+                    <ul className="font-mono text-sm mb-6 grid grid-cols-1 gap-1">
+                        {recoveryCodes.map((c) => (
+                            <li key={c}>{c}</li>
+                        ))}
+                    </ul>
+                    <Button type="button" fullWidth={true} onClick={() => router.push("/requests")}>
+                        保管しました
+                    </Button>
+                </div>
+            </div>
+        );
+    }
+
+    if (step === "totp" || step === "enroll") {
+        return (
+            <div className="flex flex-col items-center justify-center min-h-screen bg-gray-50">
+                <div className="w-full max-w-sm p-8 bg-white rounded shadow-md">
+                    <h1 className="text-2xl font-bold mb-6 text-center">二要素認証</h1>
+                    {step === "enroll" && enrollment && (
+                        <div className="text-sm mb-4 flex flex-col gap-2">
+                            <p>二要素認証の登録が必要です。認証アプリに次のシークレットを登録してください。</p>
+                            <code className="font-mono break-all bg-gray-100 p-2 rounded">{enrollment.secret}</code>
+                            <a className="text-blue-600 underline break-all" href={enrollment.otpauth_uri}>
+                                認証アプリで開く
+                            </a>
+                        </div>
+                    )}
+                    <form className="flex flex-col gap-4" onSubmit={handleCodeSubmit}>
+                        <label className="flex flex-col gap-1">
+                            <span className="text-sm">
+                                {step === "enroll" ? "認証アプリのコード" : "認証アプリのコード、またはリカバリーコード"}
+                            </span>
+                            <input
+                                type="text"
+                                className="border rounded px-3 py-2 focus:outline-none focus:ring-2 focus:ring-blue-500"
+                                value={code}
+                                onChange={(e: React.ChangeEvent<HTMLInputElement>) => setCode(e.target.value)}
+                                autoComplete="one-time-code"
+                                required
+                                disabled={loading}
+                            />
+                        </label>
+                        {error && (
+                            <div className="text-red-600 text-sm text-center">{error}</div>
+                        )}
+                        <Button
+                            type="submit"
+                            disabled={loading}
+                            fullWidth={true}
+                        >
+                            {loading ? "確認中..." : "確認"}
+                        </Button>
+                    </form>
+                </div>
+            </div>
+        );
+    }
+
     return (
         <div className="flex flex-col items-center justify-center min-h-screen bg-gray-50">
             <div className="w-full max-w-sm p-8 bg-white rounded shadow-md">
@@ -76,6 +220,15 @@ export default function LoginPage() {
                         {loading ? "ログイン中..." : "ログイン"}
                     </Button>
                 </form>
+                {process.env.NEXT_PUBLIC_OIDC_ENABLED === "true" && (
+                    // IDプロバイダーへのリダイレクトを伴うので、fetchではなくページ遷移で開始する
+                    <a
+                        className="block mt-4 text-center text-sm text-blue-600 underline"
+                        href={`${API_BASE_URL}/auth/oidc/start`}
+                    >
+                        社内アカウントでログイン
+                    </a>
+                )}
             </div>
         </div>
     );
@@ -42,7 +42,7 @@ export default function RequestDetailPage() {
     const [requestDetail, setRequestDetail] = useState<RequestDetail | null>(null);
     const [loading, setLoading] = useState(true);
     const [error, setError] = useState("");
-    const [userRoles, setUserRoles] = useState<string[]>([]);
+    const [userPermissions, setUserPermissions] = useState<string[]>([]);
     const [userLoaded, setUserLoaded] = useState(false);
 
     async function fetchRequestDetail() {
@@ -72,12 +72,12 @@ export default function RequestDetailPage() {
                 const res = await get(`/session`);
                 if (res && res.ok) {
                     const data = await res.json();
-                    setUserRoles(data.user?.roles || []);
+                    setUserPermissions(data.user?.permissions || []);
                 } else {
-                    setUserRoles([]);
+                    setUserPermissions([]);
                 }
             } catch {
-                setUserRoles([]);
+                setUserPermissions([]);
             } finally {
                 setUserLoaded(true);
             }
@@ -183,7 +183,7 @@ export default function RequestDetailPage() {
                     >
                         リクエスト一覧へ戻る
                     </a>
-                    {userLoaded && userRoles.includes("employee") && (
+                    {userLoaded && userPermissions.includes("submission.create") && (
                         <a
                             href={`/requests/${requestId}/submit${startDate && endDate ? `?start_date=${startDate}&end_date=${endDate}` : ''}`}
                             className="inline-block bg-blue-500 text-white px-4 py-2 rounded hover:bg-blue-600 transition mr-2"
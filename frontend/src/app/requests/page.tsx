@@ -25,7 +25,7 @@ export default function RequestsPage() {
     const [deadlineTime, setDeadlineTime] = useState("00:00");
     const [createError, setCreateError] = useState("");
     const [createLoading, setCreateLoading] = useState(false);
-    const [userRoles, setUserRoles] = useState<string[]>([]);
+    const [userPermissions, setUserPermissions] = useState<string[]>([]);
     const [userLoaded, setUserLoaded] = useState(false);
     const router = useRouter();
 
@@ -36,12 +36,12 @@ export default function RequestsPage() {
                 const res = await get(`/session`);
                 if (res && res.ok) {
                     const data = await res.json();
-                    setUserRoles(data.user?.roles || []);
+                    setUserPermissions(data.user?.permissions || []);
                 } else {
-                    setUserRoles([]);
+                    setUserPermissions([]);
                 }
             } catch {
-                setUserRoles([]);
+                setUserPermissions([]);
             } finally {
                 setUserLoaded(true);
             }
@@ -87,7 +87,7 @@ export default function RequestsPage() {
             const res = await get(`/requests`);
             if (res && res.ok) {
                 const data = await res.json();
-                setRequests(data);
+                setRequests(data.requests);
             } else if (res) {
                 const data = await res.json();
                 setError(data.error || "取得に失敗しました");
@@ -107,8 +107,8 @@ export default function RequestsPage() {
         <div className="flex flex-col items-center justify-center min-h-screen bg-gray-50">
             <div className="w-full max-w-2xl p-8 bg-white rounded shadow-md">
                 <h1 className="text-2xl font-bold mb-6 text-center">リクエスト一覧</h1>
-                {/* manager権限のみ追加フォームを表示 */}
-                {userLoaded && userRoles.includes("manager") && (
+                {/* request.create権限を持つ場合のみ追加フォームを表示 */}
+                {userLoaded && userPermissions.includes("request.create") && (
                     <form className="flex flex-col sm:flex-row gap-2 mb-6 items-end" onSubmit={handleCreate}>
                         <div className="flex flex-col">
                             <label className="text-sm">開始日</label>
@@ -129,7 +129,7 @@ export default function RequestsPage() {
                         <button type="submit" className="bg-blue-600 text-white rounded px-4 py-2 font-semibold hover:bg-blue-700 transition disabled:opacity-50" disabled={createLoading}>追加</button>
                     </form>
                 )}
-                {createError && userRoles.includes("manager") && <div className="text-red-600 text-center mb-2">{createError}</div>}
+                {createError && userPermissions.includes("request.create") && <div className="text-red-600 text-center mb-2">{createError}</div>}
                 {loading ? (
                     <div className="text-center">読み込み中...</div>
                 ) : error ? (
@@ -3,6 +3,13 @@ export type User = {
     id: number;
     name: string;
     roles?: string[];
+    permissions?: string[];
+    organization?: Organization;
+};
+
+export type Organization = {
+    id: number;
+    name: string;
 };
 
 export type Request = {
@@ -14,7 +21,19 @@ export type Request = {
     created_at: string;
 };
 
+export type Group = {
+    id: number;
+    name: string;
+    members?: User[];
+};
+
+export type RequestTargets = {
+    users: User[];
+    groups: Group[];
+};
+
 export type RequestDetail = Request & {
+    targets: RequestTargets;
     submissions: Submission[];
     entries: Entry[];
 };
@@ -37,4 +56,15 @@ export type APIResponse<T = any> = {
 
 export type SessionData = {
     user: User;
+    unread_notification_count?: number;
+};
+
+export type Notification = {
+    id: number;
+    kind: string;
+    request_id: number | null;
+    title: string;
+    body: string;
+    created_at: string;
+    read_at: string | null;
 };